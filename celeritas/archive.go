@@ -0,0 +1,68 @@
+package celeritas
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/djedjethai/celeritas/filesystems"
+)
+
+// ArchiveOptions tune ServeArchive
+type ArchiveOptions struct {
+	// Filename is the name the archive is saved as, download by default; the extension
+	// of the format is added when it is missing
+	Filename string
+	// TarGz sends a tar.gz rather than a zip
+	TarGz bool
+	// MaxSize is the limit of the total size of the files, none when 0
+	MaxSize int64
+}
+
+// ServeArchive streams a zip, or a tar.gz, of keys of disk, the default disk when empty,
+// to w. A key can be a folder, whose files are archived below its name. Nothing is
+// buffered: the files are read and compressed one after the other as the response is
+// written, and the download stops when the client goes away.
+//
+// A missing key gets a 404 and a selection past MaxSize a 413, before anything is
+// sent. The error is returned as well, along with the ones which cut the archive short
+func (c *Celeritas) ServeArchive(w http.ResponseWriter, r *http.Request, disk string, keys []string, opts ArchiveOptions) error {
+	fs, err := c.Disk(disk)
+	if err != nil {
+		http.NotFound(w, r)
+		return err
+	}
+
+	entries, err := filesystems.ArchiveEntries(r.Context(), fs, keys, opts.MaxSize)
+	switch {
+	case err == nil:
+	case errors.Is(err, filesystems.ErrNotFound):
+		http.NotFound(w, r)
+		return err
+	case errors.Is(err, filesystems.ErrArchiveTooLarge):
+		http.Error(w, "selection too large", http.StatusRequestEntityTooLarge)
+		return err
+	default:
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return err
+	}
+
+	ext, contentType, write := ".zip", "application/zip", filesystems.WriteZip
+	if opts.TarGz {
+		ext, contentType, write = ".tar.gz", "application/gzip", filesystems.WriteTarGz
+	}
+	filename := opts.Filename
+	if filename == "" {
+		filename = "download"
+	}
+	if !strings.HasSuffix(filename, ext) {
+		filename += ext
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", filesystems.AttachmentDisposition(filename))
+	if r.Method == http.MethodHead {
+		return nil
+	}
+	return write(r.Context(), w, fs, entries)
+}
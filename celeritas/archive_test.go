@@ -0,0 +1,51 @@
+package celeritas
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/djedjethai/celeritas/filesystems"
+)
+
+func TestCeleritas_ServeArchive(t *testing.T) {
+	c, disk := newSignedFilesApp(t)
+	for key, content := range map[string]string{"docs/a.txt": "first", "docs/sub/b.txt": "second"} {
+		_ = disk.PutStream(context.Background(), key, strings.NewReader(content), filesystems.PutOptions{})
+	}
+
+	serve := func(keys []string, opts ArchiveOptions) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		_ = c.ServeArchive(w, httptest.NewRequest(http.MethodGet, "/archive", nil), "", keys, opts)
+		return w
+	}
+
+	w := serve([]string{"docs"}, ArchiveOptions{Filename: "my docs"})
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/zip" {
+		t.Fatalf("archive not served: %d %v", w.Code, w.Header())
+	}
+	if disposition := w.Header().Get("Content-Disposition"); disposition != `attachment; filename="my docs.zip"` {
+		t.Errorf("unexpected disposition %s", disposition)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(zr.File) != 2 {
+		t.Errorf("expected 2 files, got %d", len(zr.File))
+	}
+
+	if w := serve([]string{"docs"}, ArchiveOptions{TarGz: true}); w.Header().Get("Content-Disposition") != "attachment; filename=download.tar.gz" {
+		t.Errorf("unexpected tar.gz headers %v", w.Header())
+	}
+	if w := serve([]string{"docs"}, ArchiveOptions{MaxSize: 8}); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected 413, got %d", w.Code)
+	}
+	if w := serve([]string{"docs/missing.txt"}, ArchiveOptions{}); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404, got %d", w.Code)
+	}
+}
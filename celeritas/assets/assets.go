@@ -0,0 +1,299 @@
+package assets
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ManifestFile is the name of the optional build manifest, located in the root of
+// the assets folder. It maps logical names to fingerprinted ones, e.g.
+// {"css/app.css": "css/app.3f2a1b9c.css"}
+const ManifestFile = "manifest.json"
+
+// Assets fingerprints the files of a folder (usually public), and serves them
+type Assets struct {
+	// Root is the folder holding the assets on disk
+	Root string
+	// Prefix is the url path the assets are served under, e.g. /public
+	Prefix string
+	// Dev disables fingerprinting; URLs are returned unchanged, unless the build
+	// manifest lists them, and files are served without long-lived caching, so
+	// changes show up on reload
+	Dev bool
+
+	mu      sync.RWMutex
+	files   map[string]*asset
+	hashed  map[string]*asset
+	fileSrv http.Handler
+}
+
+// asset is a file on disk, and the fingerprinted url path it is served under
+type asset struct {
+	file string
+	url  string
+	etag string
+}
+
+// New returns an Assets for the folder root, served under prefix
+func New(root, prefix string) *Assets {
+	return &Assets{
+		Root:   root,
+		Prefix: "/" + strings.Trim(prefix, "/"),
+	}
+}
+
+// Load fingerprints the assets. If a build manifest exists it is used as is, in Dev
+// mode too, and nothing is hashed: the files it lists are fingerprinted already.
+// Otherwise every file under Root is hashed, except in Dev mode
+func (a *Assets) Load() error {
+	files := make(map[string]*asset)
+	hashed := make(map[string]*asset)
+
+	manifest, err := a.readManifest()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case manifest != nil:
+		for name, hashedName := range manifest {
+			info, err := os.Stat(filepath.Join(a.Root, filepath.FromSlash(hashedName)))
+			if err != nil || info.IsDir() {
+				return fmt.Errorf("assets: %s listed in %s does not exist", hashedName, ManifestFile)
+			}
+			// the build already fingerprinted the file, so it is served as is
+			files[name] = &asset{
+				file: hashedName,
+				url:  hashedName,
+				etag: fmt.Sprintf(`"%s"`, hashedName),
+			}
+		}
+	case !a.Dev:
+		err = filepath.Walk(a.Root, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() || isVariant(p) {
+				return nil
+			}
+
+			rel, err := filepath.Rel(a.Root, p)
+			if err != nil {
+				return err
+			}
+			name := filepath.ToSlash(rel)
+
+			item, err := a.hash(name)
+			if err != nil {
+				return err
+			}
+			files[name] = item
+			return nil
+		})
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	for _, item := range files {
+		hashed[item.url] = item
+	}
+
+	a.mu.Lock()
+	a.files = files
+	a.hashed = hashed
+	a.fileSrv = http.FileServer(http.Dir(a.Root))
+	a.mu.Unlock()
+
+	return nil
+}
+
+// hash fingerprints the file name
+func (a *Assets) hash(name string) (*asset, error) {
+	sum, err := hashFile(filepath.Join(a.Root, filepath.FromSlash(name)))
+	if err != nil {
+		return nil, err
+	}
+	return &asset{
+		file: name,
+		url:  fingerprint(name, sum[:8]),
+		etag: fmt.Sprintf(`"%s"`, sum[:16]),
+	}, nil
+}
+
+// URL returns the fingerprinted url of an asset, e.g. asset("css/app.css") gives
+// /public/css/app.3f2a1b9c.css. Unknown assets get their plain url
+func (a *Assets) URL(name string) string {
+	name = strings.TrimPrefix(name, "/")
+
+	a.mu.RLock()
+	item, ok := a.files[name]
+	a.mu.RUnlock()
+	if ok {
+		name = item.url
+	}
+
+	return path.Join(a.Prefix, name)
+}
+
+// ServeHTTP serves the assets. It is meant to be mounted with the prefix
+// stripped, e.g. http.StripPrefix("/public", c.Assets). Fingerprinted urls are
+// cached forever; gzip and brotli variants (app.css.gz, app.css.br) are served
+// when they exist on disk and the client accepts them
+func (a *Assets) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+
+	a.mu.RLock()
+	item, fingerprinted := a.hashed[name]
+	if !fingerprinted {
+		item = a.files[name]
+	}
+	fileSrv := a.fileSrv
+	a.mu.RUnlock()
+
+	if item == nil || a.Dev {
+		w.Header().Set("Cache-Control", "no-cache")
+		if fileSrv == nil {
+			fileSrv = http.FileServer(http.Dir(a.Root))
+		}
+		fileSrv.ServeHTTP(w, r)
+		return
+	}
+
+	if fingerprinted {
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+	w.Header().Add("Vary", "Accept-Encoding")
+
+	if ct := mime.TypeByExtension(path.Ext(item.file)); ct != "" {
+		w.Header().Set("Content-Type", ct)
+	}
+
+	f, encoding, err := a.open(item.file, r.Header.Get("Accept-Encoding"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	// each encoding is a different representation, so it gets its own etag
+	if encoding != "" {
+		w.Header().Set("Content-Encoding", encoding)
+		w.Header().Set("ETag", fmt.Sprintf(`%s-%s"`, strings.TrimSuffix(item.etag, `"`), encoding))
+	} else {
+		w.Header().Set("ETag", item.etag)
+	}
+
+	http.ServeContent(w, r, item.file, info.ModTime(), f)
+}
+
+// open opens the best variant of the file name accepted by the client
+func (a *Assets) open(name, acceptEncoding string) (*os.File, string, error) {
+	full := filepath.Join(a.Root, filepath.FromSlash(name))
+
+	variants := []struct {
+		encoding string
+		ext      string
+	}{
+		{"br", ".br"},
+		{"gzip", ".gz"},
+	}
+
+	for _, v := range variants {
+		if !accepts(acceptEncoding, v.encoding) {
+			continue
+		}
+		if f, err := os.Open(full + v.ext); err == nil {
+			return f, v.encoding, nil
+		}
+	}
+
+	f, err := os.Open(full)
+	return f, "", err
+}
+
+// readManifest returns the build manifest, nil when there is none
+func (a *Assets) readManifest() (map[string]string, error) {
+	content, err := os.ReadFile(filepath.Join(a.Root, ManifestFile))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	manifest := make(map[string]string)
+	if err = json.Unmarshal(content, &manifest); err != nil {
+		return nil, fmt.Errorf("assets: invalid %s: %w", ManifestFile, err)
+	}
+	return manifest, nil
+}
+
+func hashFile(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// fingerprint inserts the hash before the extension: css/app.css -> css/app.<hash>.css
+func fingerprint(name, hash string) string {
+	ext := path.Ext(name)
+	return fmt.Sprintf("%s.%s%s", strings.TrimSuffix(name, ext), hash, ext)
+}
+
+// isVariant reports whether p is a precompressed copy of another asset
+func isVariant(p string) bool {
+	ext := filepath.Ext(p)
+	return ext == ".gz" || ext == ".br"
+}
+
+// accepts reports whether the Accept-Encoding header value allows encoding
+func accepts(acceptEncoding, encoding string) bool {
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		fields := strings.Split(part, ";")
+		name := strings.TrimSpace(fields[0])
+		if name != encoding && name != "*" {
+			continue
+		}
+
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil && q == 0 {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,132 @@
+package assets
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestAssets_URL(t *testing.T) {
+	u := testAssets.URL("css/app.css")
+	if !regexp.MustCompile(`^/public/css/app\.[0-9a-f]{8}\.css$`).MatchString(u) {
+		t.Errorf("unexpected fingerprinted url %s", u)
+	}
+
+	if u := testAssets.URL("/img/missing.png"); u != "/public/img/missing.png" {
+		t.Errorf("expected plain url for unknown asset, got %s", u)
+	}
+}
+
+func TestAssets_ServeFingerprinted(t *testing.T) {
+	u := strings.TrimPrefix(testAssets.URL("css/app.css"), "/public")
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", u, nil)
+	testAssets.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	if !strings.Contains(w.Header().Get("Cache-Control"), "immutable") {
+		t.Errorf("expected immutable cache-control, got %q", w.Header().Get("Cache-Control"))
+	}
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "text/css") {
+		t.Errorf("unexpected content type %q", w.Header().Get("Content-Type"))
+	}
+	if w.Body.String() != "body { color: #333; }\n" {
+		t.Errorf("unexpected body %q", w.Body.String())
+	}
+
+	etag := w.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("expected an etag")
+	}
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("GET", u, nil)
+	r.Header.Set("If-None-Match", etag)
+	testAssets.ServeHTTP(w, r)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("expected status 304 for matching etag, got %d", w.Code)
+	}
+}
+
+func TestAssets_ServePrecompressed(t *testing.T) {
+	u := strings.TrimPrefix(testAssets.URL("css/app.css"), "/public")
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", u, nil)
+	r.Header.Set("Accept-Encoding", "br;q=0, gzip, deflate")
+	testAssets.ServeHTTP(w, r)
+
+	if w.Header().Get("Content-Encoding") != "gzip" {
+		t.Errorf("expected gzip content encoding, got %q", w.Header().Get("Content-Encoding"))
+	}
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "text/css") {
+		t.Errorf("unexpected content type %q", w.Header().Get("Content-Type"))
+	}
+}
+
+func TestAssets_ServePlain(t *testing.T) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/css/app.css", nil)
+	testAssets.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	if w.Header().Get("Cache-Control") != "no-cache" {
+		t.Errorf("expected no-cache for plain url, got %q", w.Header().Get("Cache-Control"))
+	}
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("GET", "/../assets.go", nil)
+	testAssets.ServeHTTP(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 outside of root, got %d", w.Code)
+	}
+}
+
+func TestAssets_Manifest(t *testing.T) {
+	a := New("./testdata/manifest", "/public")
+	if err := a.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	if u := a.URL("js/app.js"); u != "/public/js/app.0a1b2c3d.js" {
+		t.Errorf("expected url from manifest, got %s", u)
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/js/app.0a1b2c3d.js", nil)
+	a.ServeHTTP(w, r)
+	if !strings.Contains(w.Header().Get("Cache-Control"), "immutable") {
+		t.Errorf("expected immutable cache-control, got %q", w.Header().Get("Cache-Control"))
+	}
+}
+
+func TestAssets_Dev(t *testing.T) {
+	a := New("./testdata/public", "/public")
+	a.Dev = true
+	if err := a.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if len(a.files) != 0 {
+		t.Errorf("expected nothing hashed in dev mode, got %d files", len(a.files))
+	}
+	if u := a.URL("css/app.css"); u != "/public/css/app.css" {
+		t.Errorf("expected plain url in dev mode, got %s", u)
+	}
+
+	// the bundler wrote the names of its manifest only
+	a = New("./testdata/manifest", "/public")
+	a.Dev = true
+	if err := a.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if u := a.URL("js/app.js"); u != "/public/js/app.0a1b2c3d.js" {
+		t.Errorf("expected url from manifest in dev mode, got %s", u)
+	}
+}
@@ -0,0 +1,17 @@
+package assets
+
+import (
+	"log"
+	"os"
+	"testing"
+)
+
+var testAssets = New("./testdata/public", "/public")
+
+func TestMain(m *testing.M) {
+	if err := testAssets.Load(); err != nil {
+		log.Fatal(err)
+	}
+
+	os.Exit(m.Run())
+}
@@ -1,10 +1,13 @@
 package celeritas
 
 import (
+	"errors"
 	"fmt"
+	"html/template"
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -12,14 +15,21 @@ import (
 	"github.com/CloudyKit/jet/v6"
 	"github.com/alexedwards/scs/v2"
 	"github.com/dgraph-io/badger/v3"
+	"github.com/djedjethai/celeritas/assets"
 	"github.com/djedjethai/celeritas/cache"
+	"github.com/djedjethai/celeritas/filesystems"
+	"github.com/djedjethai/celeritas/filesystems/localfilesystem"
 	"github.com/djedjethai/celeritas/filesystems/miniofilesystem"
 	"github.com/djedjethai/celeritas/filesystems/s3filesystem"
 	"github.com/djedjethai/celeritas/filesystems/sftpfilesystem"
 	"github.com/djedjethai/celeritas/filesystems/webdavfilesystem"
+	"github.com/djedjethai/celeritas/i18n"
+	"github.com/djedjethai/celeritas/images"
 	"github.com/djedjethai/celeritas/mailer"
 	"github.com/djedjethai/celeritas/render"
+	"github.com/djedjethai/celeritas/scanner"
 	"github.com/djedjethai/celeritas/session"
+	"github.com/djedjethai/celeritas/urlsigner"
 	"github.com/go-chi/chi/v5"
 	"github.com/gomodule/redigo/redis"
 	"github.com/joho/godotenv"
@@ -53,11 +63,21 @@ type Celeritas struct {
 	Scheduler     *cron.Cron
 	Mail          mailer.Mail
 	Server        Server
-	FileSystems   map[string]interface{}
-	S3            s3filesystem.S3
-	SFTP          sftpfilesystem.SFTP
-	WebDAV        webdavfilesystem.WebDAV
-	Minio         miniofilesystem.Minio
+	FileSystems   *filesystems.Registry
+	Assets        *assets.Assets
+	I18n          *i18n.Translator
+	Images        *images.Pipeline
+	Scanner       *scanner.Policy
+
+	// S3, SFTP, WebDAV and Minio are the disks of the same names set in .env, the
+	// very values FileSystems holds.
+	//
+	// Deprecated: use c.Disk("S3") and the other names, which also reach the disks
+	// of config/disks.yml
+	S3     s3filesystem.S3
+	SFTP   sftpfilesystem.SFTP
+	WebDAV webdavfilesystem.WebDAV
+	Minio  miniofilesystem.Minio
 }
 
 type Server struct {
@@ -87,7 +107,7 @@ type uploadConfig struct {
 func (c *Celeritas) New(rootPath string) error {
 	pathConfig := initPaths{
 		rootPath:    rootPath,
-		folderNames: []string{"handlers", "migrations", "views", "mail", "data", "public", "tmp", "logs", "middleware"},
+		folderNames: []string{"handlers", "migrations", "views", "mail", "data", "public", "tmp", "logs", "middleware", "lang", "storage"},
 	}
 
 	err := c.Init(pathConfig)
@@ -149,7 +169,16 @@ func (c *Celeritas) New(rootPath string) error {
 	c.Debug, _ = strconv.ParseBool(os.Getenv("DEBUG"))
 	c.Version = version
 	c.RootPath = rootPath
-	c.Mail = c.createMailer()
+	c.Mail, err = c.createMailer()
+	if err != nil {
+		return err
+	}
+
+	// the Locale middleware of the routes uses the translator
+	err = c.createTranslator()
+	if err != nil {
+		return err
+	}
 	c.Routes = c.routes().(*chi.Mux)
 
 	// file uploads
@@ -239,11 +268,44 @@ func (c *Celeritas) New(rootPath string) error {
 		c.JetViews = views
 	}
 
+	err = c.createAssets()
+	if err != nil {
+		return err
+	}
+
 	c.createRenderer()
-	c.FileSystems = c.createFileSystems()
+	c.FileSystems, err = c.createFileSystems()
+	if err != nil {
+		return err
+	}
+
+	c.Images, err = c.createImages()
+	if err != nil {
+		return err
+	}
+
+	c.Scanner, err = c.createScanner()
+	if err != nil {
+		return err
+	}
+
+	c.Mail.Queue, err = c.createMailQueue()
+	if err != nil {
+		return err
+	}
+
+	c.Mail.DKIM, err = c.createDKIM()
+	if err != nil {
+		return err
+	}
 
 	go c.Mail.ListenForMail()
 
+	err = c.scheduleMailQueue()
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -316,29 +378,197 @@ func (c *Celeritas) createRenderer() {
 		Port:     c.config.port,
 		JetViews: c.JetViews,
 		Session:  c.Session,
+		Funcs:    template.FuncMap{},
+	}
+	for name, fn := range c.templateGlobals(false) {
+		myRenderer.Funcs[name] = fn
+		c.JetViews.AddGlobal(name, fn)
+	}
+	// mail clients need absolute urls; t is set per message by the mailer
+	for name, fn := range c.templateGlobals(true) {
+		c.Mail.JetViews.AddGlobal(name, fn)
+	}
+
+	if c.I18n != nil {
+		myRenderer.AddFuncProvider("t", func(r *http.Request) interface{} {
+			return func(key string, args ...interface{}) string {
+				return c.T(r, key, args...)
+			}
+		})
+		myRenderer.AddDataProvider("locale", func(r *http.Request) interface{} {
+			return c.LocaleOf(r)
+		})
 	}
 	c.Render = &myRenderer
 }
 
-func (c *Celeritas) createMailer() mailer.Mail {
+// createAssets fingerprints the files in public, for the asset helper of the templates
+func (c *Celeritas) createAssets() error {
+	a := assets.New(c.RootPath+"/public", "/public")
+	a.Dev = c.Debug
+	err := a.Load()
+	if err != nil {
+		return err
+	}
+
+	c.Assets = a
+	return nil
+}
+
+// templateGlobals returns the helpers of the views and of the mail templates, with
+// absolute urls for mail
+func (c *Celeritas) templateGlobals(absolute bool) map[string]interface{} {
+	globals := map[string]interface{}{
+		// derived images of the default disk, c.ImageURL links to the other disks
+		"imageURL": func(preset, key string) string {
+			return c.ImageURL(preset, "", key)
+		},
+	}
+	if a := c.Assets; a != nil {
+		globals["asset"] = a.URL
+		if absolute {
+			globals["asset"] = func(name string) string {
+				return c.Server.URL + a.URL(name)
+			}
+		}
+	}
+	return globals
+}
+
+// createTranslator loads the translations found in the lang folder
+func (c *Celeritas) createTranslator() error {
+	t := i18n.New(os.Getenv("DEFAULT_LOCALE"))
+	err := t.LoadDir(c.RootPath + "/lang")
+	if err != nil {
+		return err
+	}
+
+	c.I18n = t
+	c.Mail.Translator = t
+	return nil
+}
+
+func (c *Celeritas) createMailer() (mailer.Mail, error) {
 	port, _ := strconv.Atoi(os.Getenv("SMTP_PORT"))
+	workers, _ := strconv.Atoi(os.Getenv("MAIL_WORKERS"))
+	maxAttempts, _ := strconv.Atoi(os.Getenv("MAIL_MAX_ATTEMPTS"))
+	retryDelay, _ := strconv.Atoi(os.Getenv("MAIL_RETRY_DELAY"))
 	m := mailer.Mail{
-		Domain:      os.Getenv("MAIL_DOMAIN"),
-		Templates:   c.RootPath + "/mail",
-		Host:        os.Getenv("SMTP_HOST"),
-		Port:        port,
-		Username:    os.Getenv("SMTP_USERNAME"),
-		Password:    os.Getenv("SMTP_PASSWORD"),
-		Encryption:  os.Getenv("SMTP_ENCRYPTION"),
-		FromName:    os.Getenv("FROM_NAME"),
-		FromAddress: os.Getenv("FROM_ADDRESS"),
-		Jobs:        make(chan mailer.Message, 20),
-		Results:     make(chan mailer.Result, 20),
-		API:         os.Getenv("MAILER_API"),
-		APIKey:      os.Getenv("MAILER_KEY"),
-		APIUrl:      os.Getenv("MAILER_URL"),
-	}
-	return m
+		Domain:       os.Getenv("MAIL_DOMAIN"),
+		Templates:    c.RootPath + "/mail",
+		Host:         os.Getenv("SMTP_HOST"),
+		Port:         port,
+		Username:     os.Getenv("SMTP_USERNAME"),
+		Password:     os.Getenv("SMTP_PASSWORD"),
+		Encryption:   os.Getenv("SMTP_ENCRYPTION"),
+		FromName:     os.Getenv("FROM_NAME"),
+		FromAddress:  os.Getenv("FROM_ADDRESS"),
+		Jobs:         make(chan mailer.Job, 20),
+		Workers:      workers,
+		API:          os.Getenv("MAILER_API"),
+		APIKey:       os.Getenv("MAILER_KEY"),
+		APIUrl:       os.Getenv("MAILER_URL"),
+		APISecret:    os.Getenv("MAILER_SECRET"),
+		Region:       os.Getenv("MAILER_REGION"),
+		SendmailPath: os.Getenv("SENDMAIL_PATH"),
+		CaptureDir:   c.RootPath + "/tmp/mail",
+		MaxAttempts:  maxAttempts,
+		RetryDelay:   time.Duration(retryDelay) * time.Second,
+		Layout:       os.Getenv("MAIL_LAYOUT"),
+	}
+
+	if c.Debug {
+		m.JetViews = jet.NewSet(jet.NewOSFileSystemLoader(m.Templates), jet.InDevelopmentMode())
+	} else {
+		m.JetViews = jet.NewSet(jet.NewOSFileSystemLoader(m.Templates))
+	}
+
+	if os.Getenv("MAIL_RATE_LIMITS") != "" {
+		limits, err := mailer.ParseRateLimits(os.Getenv("MAIL_RATE_LIMITS"))
+		if err != nil {
+			return m, err
+		}
+		m.RateLimits = limits
+	}
+
+	if os.Getenv("MAIL_UNSUBSCRIBE_URL") != "" {
+		m.UnsubscribeURL = os.Getenv("MAIL_UNSUBSCRIBE_URL")
+		m.Signer = &urlsigner.Signer{Secret: []byte(os.Getenv("KEY"))}
+	}
+	return m, nil
+}
+
+// createDKIM returns the DKIM signer set by DKIM_DOMAIN, DKIM_SELECTOR and
+// DKIM_PRIVATE_KEY, a key file relative to the root path
+func (c *Celeritas) createDKIM() (*mailer.DKIM, error) {
+	if os.Getenv("DKIM_DOMAIN") == "" {
+		return nil, nil
+	}
+
+	keyFile := os.Getenv("DKIM_PRIVATE_KEY")
+	if !filepath.IsAbs(keyFile) {
+		keyFile = filepath.Join(c.RootPath, keyFile)
+	}
+	return mailer.NewDKIM(os.Getenv("DKIM_DOMAIN"), os.Getenv("DKIM_SELECTOR"), keyFile)
+}
+
+// createMailQueue returns the persistent mail queue set by MAIL_QUEUE, which can be
+// database, redis or badger. Without it, queued mail only lives in memory
+func (c *Celeritas) createMailQueue() (mailer.Queue, error) {
+	switch os.Getenv("MAIL_QUEUE") {
+	case "":
+		return nil, nil
+
+	case "database":
+		if c.DB.Pool == nil {
+			return nil, errors.New("MAIL_QUEUE=database requires a DATABASE_TYPE")
+		}
+		return &mailer.SQLQueue{DB: c.DB.Pool, DBType: c.DB.DataType}, nil
+
+	case "redis":
+		if redisPool == nil {
+			redisPool = c.createRedisPool()
+		}
+		return &mailer.RedisQueue{Conn: redisPool, Prefix: c.config.redis.prefix}, nil
+
+	case "badger":
+		if badgerConn == nil {
+			badgerConn = c.createBadgerConn()
+		}
+		if badgerConn == nil {
+			return nil, errors.New("could not open badger for the mail queue")
+		}
+		return &mailer.BadgerQueue{Conn: badgerConn}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown MAIL_QUEUE %s; only database, redis or badger accepted", os.Getenv("MAIL_QUEUE"))
+	}
+}
+
+// scheduleMailQueue dispatches the due messages of the mail queue, scheduled and
+// retried ones, from the scheduler, so they are sent after a restart
+func (c *Celeritas) scheduleMailQueue() error {
+	if c.Mail.Queue == nil {
+		return nil
+	}
+
+	interval := c.Mail.PollInterval
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+
+	job := cron.NewChain(cron.SkipIfStillRunning(cron.DiscardLogger)).Then(cron.FuncJob(func() {
+		if err := c.Mail.DispatchDue(); err != nil {
+			c.ErrorLog.Println("could not read mail queue:", err)
+		}
+	}))
+	_, err := c.Scheduler.AddJob(fmt.Sprintf("@every %s", interval), job)
+	if err != nil {
+		return err
+	}
+
+	c.Scheduler.Start()
+	return nil
 }
 
 func (c *Celeritas) createClientRedisCache() *cache.RedisCache {
@@ -417,8 +647,11 @@ func (c *Celeritas) BuildDSN() string {
 	return dsn
 }
 
-func (c *Celeritas) createFileSystems() map[string]interface{} {
-	fileSystems := make(map[string]interface{})
+// createFileSystems registers the disks: the MINIO, SFTP, WEBDAV and S3 file systems set
+// in .env, local, rooted in the storage folder, and the ones of config/disks.yml.
+// The default disk is the one named by DEFAULT_DISK or the config file, or else local
+func (c *Celeritas) createFileSystems() (*filesystems.Registry, error) {
+	fileSystems := filesystems.NewRegistry()
 
 	if os.Getenv("MINIO_SECRET") != "" {
 		useSSL := false
@@ -435,8 +668,8 @@ func (c *Celeritas) createFileSystems() map[string]interface{} {
 			Bucket:   os.Getenv("MINIO_BUCKET"),
 		}
 
-		fileSystems["MINIO"] = minio
 		c.Minio = minio
+		fileSystems.Add("MINIO", &c.Minio)
 	}
 
 	if os.Getenv("SFTP_HOST") != "" {
@@ -445,9 +678,17 @@ func (c *Celeritas) createFileSystems() map[string]interface{} {
 			User: os.Getenv("SFTP_USER"),
 			Pass: os.Getenv("SFTP_PASS"),
 			Port: os.Getenv("SFTP_PORT"),
+			Root: os.Getenv("SFTP_ROOT"),
+			// host key: pinned fingerprint, or else a known_hosts file
+			Fingerprint:         os.Getenv("SFTP_FINGERPRINT"),
+			KnownHosts:          os.Getenv("SFTP_KNOWN_HOSTS"),
+			InsecureSkipHostKey: strings.ToLower(os.Getenv("SFTP_INSECURE_SKIP_HOST_KEY")) == "true",
+			KeyFile:             c.rootRelative(os.Getenv("SFTP_KEY_FILE")),
+			KeyPassphrase:       os.Getenv("SFTP_KEY_PASSPHRASE"),
+			UseAgent:            strings.ToLower(os.Getenv("SFTP_USE_AGENT")) == "true",
 		}
-		fileSystems["SFTP"] = sftp
 		c.SFTP = sftp
+		fileSystems.Add("SFTP", &c.SFTP)
 	}
 
 	if os.Getenv("WEBDAV_HOST") != "" {
@@ -456,8 +697,8 @@ func (c *Celeritas) createFileSystems() map[string]interface{} {
 			User: os.Getenv("WEBDAV_USER"),
 			Pass: os.Getenv("WEBDAV_PASS"),
 		}
-		fileSystems["WEBDAV"] = webdav
 		c.WebDAV = webdav
+		fileSystems.Add("WEBDAV", &c.WebDAV)
 	}
 
 	if os.Getenv("S3_KEY") != "" {
@@ -468,9 +709,25 @@ func (c *Celeritas) createFileSystems() map[string]interface{} {
 			Endpoint: os.Getenv("S3_ENDPOINT"),
 			Bucket:   os.Getenv("S3_BUCKET"),
 		}
-		fileSystems["S3"] = s3
 		c.S3 = s3
+		fileSystems.Add("S3", &c.S3)
+	}
+
+	fileSystems.Add("local", &localfilesystem.Local{Root: filepath.Join(c.RootPath, "storage")})
+
+	defaultDisk, err := c.loadDisks(fileSystems)
+	if err != nil {
+		return nil, err
+	}
+	if os.Getenv("DEFAULT_DISK") != "" {
+		defaultDisk = os.Getenv("DEFAULT_DISK")
+	}
+	if defaultDisk == "" {
+		defaultDisk = "local"
+	}
+	if err := fileSystems.SetDefault(defaultDisk); err != nil {
+		return nil, err
 	}
 
-	return fileSystems
+	return fileSystems, nil
 }
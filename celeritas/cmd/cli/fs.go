@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/djedjethai/celeritas/filesystems"
+	"github.com/fatih/color"
+)
+
+// location is a disk:path argument; a path without a disk is on the default disk
+type location struct {
+	disk string
+	key  string
+	fs   filesystems.FS
+}
+
+func (l location) String() string {
+	return l.disk + ":" + l.key
+}
+
+func parseLocation(arg string) (location, error) {
+	l := location{key: arg}
+	if i := strings.Index(arg, ":"); i >= 0 {
+		l.disk, l.key = arg[:i], arg[i+1:]
+	}
+	if l.disk == "" {
+		l.disk = cel.FileSystems.Default()
+	}
+
+	fs, err := cel.Disk(l.disk)
+	if err != nil {
+		return l, err
+	}
+	l.fs = fs
+	l.key = filesystems.CleanKey(l.key)
+	return l, nil
+}
+
+func doFS(args []string) error {
+	if len(args) == 0 {
+		return errors.New("fs requires a subcommand: (ls|cp|mv|rm|sync)")
+	}
+
+	flags := flag.NewFlagSet("fs "+args[0], flag.ContinueOnError)
+	recursive := flags.Bool("r", false, "recursive")
+	dryRun := flags.Bool("dry-run", false, "report the changes without making them")
+	deleteMissing := flags.Bool("delete", false, "delete the files missing from the source")
+	workers := flags.Int("workers", 4, "parallel transfers")
+	force := flags.Bool("force", false, "delete every file of a disk")
+	if err := flags.Parse(flagsFirst(flags, args[1:])); err != nil {
+		return err
+	}
+
+	if err := cel.OpenDisks(); err != nil {
+		return err
+	}
+
+	var locations []location
+	for _, arg := range flags.Args() {
+		l, err := parseLocation(arg)
+		if err != nil {
+			return err
+		}
+		locations = append(locations, l)
+	}
+
+	ctx := context.Background()
+	switch args[0] {
+	case "ls":
+		if len(locations) == 0 {
+			l, err := parseLocation("")
+			if err != nil {
+				return err
+			}
+			locations = append(locations, l)
+		}
+		return fsList(ctx, locations[0], *recursive)
+
+	case "cp", "mv":
+		if len(locations) != 2 {
+			return errors.New("fs " + args[0] + " requires a source and a destination: disk:path disk:path")
+		}
+		return fsCopy(ctx, locations[0], locations[1], args[0] == "mv", *dryRun)
+
+	case "rm":
+		if len(locations) == 0 {
+			return errors.New("fs rm requires the files to delete: disk:path")
+		}
+		return fsRemove(ctx, locations, *recursive, *force, *dryRun)
+
+	case "sync":
+		if len(locations) != 2 {
+			return errors.New("fs sync requires a source and a destination: disk:path disk:path")
+		}
+		return fsSync(ctx, locations[0], locations[1], filesystems.SyncOptions{
+			DryRun:  *dryRun,
+			Delete:  *deleteMissing,
+			Workers: *workers,
+		})
+
+	default:
+		return errors.New("fs requires a subcommand: (ls|cp|mv|rm|sync)")
+	}
+}
+
+// flagsFirst moves the flags of set before the arguments, as the flag package stops at
+// the first argument, keeping the value of a flag which is not a bool with it, as in
+// -workers 8. The arguments after -- are not flags, such as keys starting with a dash
+func flagsFirst(set *flag.FlagSet, args []string) []string {
+	var flags, rest []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--":
+			rest = append(rest, args[i+1:]...)
+			i = len(args)
+		case strings.HasPrefix(arg, "-") && arg != "-":
+			flags = append(flags, arg)
+			name := strings.TrimLeft(arg, "-")
+			if strings.Contains(name, "=") {
+				continue
+			}
+			if f := set.Lookup(name); f != nil && !isBoolFlag(f) && i+1 < len(args) {
+				i++
+				flags = append(flags, args[i])
+			}
+		default:
+			rest = append(rest, arg)
+		}
+	}
+	return append(append(flags, "--"), rest...)
+}
+
+// isBoolFlag reports whether f is set without a value, as -r
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+func fsList(ctx context.Context, l location, recursive bool) error {
+	listing, err := filesystems.ListAll(ctx, l.fs, l.key, recursive)
+	if err != nil {
+		return err
+	}
+
+	for _, item := range listing {
+		if item.IsDir {
+			color.Cyan("%10s  %16s  %s/", "-", "", item.Key)
+			continue
+		}
+		fmt.Printf("%10s  %16s  %s\n", formatSize(item.Size), item.LastModified.Local().Format("2006-01-02 15:04"), item.Key)
+	}
+	color.Yellow("%d item(s) in %s", len(listing), l)
+	return nil
+}
+
+func fsCopy(ctx context.Context, src, dst location, move, dryRun bool) error {
+	// copying to a folder keeps the name of the file
+	if stat, err := dst.fs.Stat(ctx, dst.key); err == nil && stat.IsDir || dst.key == "" {
+		dst.key = path.Join(dst.key, path.Base(src.key))
+	}
+
+	verb := "copy"
+	if move {
+		verb = "move"
+	}
+	if dryRun {
+		color.Yellow("would %s %s to %s", verb, src, dst)
+		return nil
+	}
+
+	var err error
+	switch {
+	case src.fs == dst.fs && move:
+		err = src.fs.Move(ctx, src.key, dst.key)
+	case move:
+		if err = filesystems.Transfer(ctx, src.fs, src.key, dst.fs, dst.key); err == nil {
+			err = src.fs.Delete([]string{src.key})
+		}
+	default:
+		err = filesystems.Transfer(ctx, src.fs, src.key, dst.fs, dst.key)
+	}
+	if err != nil {
+		return err
+	}
+
+	color.Green("%s: %s to %s", verb, src, dst)
+	return nil
+}
+
+func fsRemove(ctx context.Context, locations []location, recursive, force, dryRun bool) error {
+	for _, l := range locations {
+		if l.key == "" && !force {
+			return fmt.Errorf("fs rm %s would delete the whole disk; add -force to do so", l)
+		}
+
+		keys := []string{l.key}
+		if recursive {
+			listing, err := filesystems.ListAll(ctx, l.fs, l.key, true)
+			if err != nil {
+				return err
+			}
+			keys = keys[:0]
+			for _, item := range listing {
+				keys = append(keys, item.Key)
+			}
+		}
+
+		for _, key := range keys {
+			if dryRun {
+				color.Yellow("would delete %s:%s", l.disk, key)
+				continue
+			}
+			if err := l.fs.Delete([]string{key}); err != nil {
+				return err
+			}
+			color.Green("deleted %s:%s", l.disk, key)
+		}
+	}
+	return nil
+}
+
+func fsSync(ctx context.Context, src, dst location, opts filesystems.SyncOptions) error {
+	// the journal of an interrupted sync of the same folders lets it resume
+	if err := os.MkdirAll(filepath.Join(cel.RootPath, "tmp"), 0755); err != nil {
+		return err
+	}
+	opts.Journal = filepath.Join(cel.RootPath, "tmp", fmt.Sprintf("fs-sync-%x.journal", md5.Sum([]byte(src.String()+" "+dst.String()))))
+	if fileExists(opts.Journal) && !opts.DryRun {
+		color.Yellow("resuming the interrupted sync of %s to %s", src, dst)
+	}
+
+	prefix := ""
+	if opts.DryRun {
+		prefix = "would "
+	}
+	opts.Progress = func(e filesystems.SyncEvent) {
+		switch {
+		case e.Err != nil:
+			color.Red("failed %s: %s", e.Key, e.Err)
+		case e.Action == filesystems.SyncCopy:
+			fmt.Printf("%scopy %s (%s)\n", prefix, e.Key, formatSize(e.Size))
+		case e.Action == filesystems.SyncDelete:
+			fmt.Printf("%sdelete %s\n", prefix, e.Key)
+		}
+	}
+
+	result, err := filesystems.Sync(ctx, src.fs, src.key, dst.fs, dst.key, opts)
+	if opts.DryRun {
+		prefix = "dry run: "
+	}
+	color.Yellow("%s%d copied (%s), %d unchanged, %d deleted, %d failed",
+		prefix, result.Copied, formatSize(result.Bytes), result.Skipped, result.Deleted, result.Failed)
+	return err
+}
+
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(size)/float64(div), "KMGTPE"[exp])
+}
@@ -86,7 +86,22 @@ func showHelp() {
 	make handler <name>   		- creates a stub handler in the handlers directory
 	make model <name>     		- creates a new model in the data directory
 	make session          		- creates a table in the database as a session store
-	make mail <name>      		- creates two starter mail templates in the mail directory
+	make mail <name> <format> 	- creates starter mail templates in the mail directory; format=tmpl/md (default tmpl)
+	make mail-queue       		- creates the tables of the database mail queue (MAIL_QUEUE=database)
+	make tus-table        		- creates the table of the database store of tus uploads (TUS_STORE=database)
+	mail failed           		- lists the mail which could not be sent after all its attempts
+	mail retry <id|all>   		- queues failed mail to be sent again
+	mail purge <id|all>   		- deletes failed mail
+	fs ls <disk:path> [-r]		- lists a folder of a disk (MINIO, S3, SFTP, WEBDAV, local or config/disks.yml)
+	fs cp <disk:path> <disk:path>	- copies a file, between any disks
+	fs mv <disk:path> <disk:path>	- moves a file, between any disks
+	fs rm <disk:path>... [-r]	- deletes files, or every file of a folder with -r; -force deletes the
+	                      		  files of a whole disk, as in fs rm -r -force disk:
+	fs sync <disk:path> <disk:path>	- copies the new and changed files of a folder; --delete removes the
+	                      		  files missing from the source, --workers=4 sets the parallel transfers,
+	                      		  and an interrupted sync resumes where it stopped
+	                      		  (--dry-run on cp, mv, rm and sync reports the changes without making them;
+	                      		  the arguments after -- are paths, even when they start with a dash)
 	
 	`)
 }
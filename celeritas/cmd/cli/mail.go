@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/djedjethai/celeritas/mailer"
+	"github.com/fatih/color"
+	"github.com/gomodule/redigo/redis"
+)
+
+func doMail(arg2, arg3 string) error {
+	queue, closeQueue, err := openMailQueue()
+	if err != nil {
+		return err
+	}
+	defer closeQueue()
+
+	switch arg2 {
+	case "failed":
+		failed, err := queue.Failed()
+		if err != nil {
+			return err
+		}
+		if len(failed) == 0 {
+			color.Green("No failed mail")
+			return nil
+		}
+		for _, qm := range failed {
+			color.Yellow("%s  %s  to: %s  subject: %s", qm.ID, qm.CreatedAt.Local().Format(time.RFC822), qm.Message.To, qm.Message.Subject)
+			fmt.Printf("    %d attempts, last error: %s\n", qm.Attempts, qm.LastError)
+		}
+
+	case "retry", "purge":
+		if arg3 == "" {
+			return errors.New("mail " + arg2 + " requires a message id, or all")
+		}
+
+		ids := []string{arg3}
+		if arg3 == "all" {
+			failed, err := queue.Failed()
+			if err != nil {
+				return err
+			}
+			ids = ids[:0]
+			for _, qm := range failed {
+				ids = append(ids, qm.ID)
+			}
+		}
+
+		for _, id := range ids {
+			if arg2 == "retry" {
+				err = queue.Retry(id)
+			} else {
+				err = queue.Purge(id)
+			}
+			if err != nil {
+				return fmt.Errorf("%s: %w", id, err)
+			}
+		}
+		color.Green("%d message(s): %s done", len(ids), arg2)
+
+	default:
+		return errors.New("mail requires a subcommand: (failed|retry|purge)")
+	}
+
+	return nil
+}
+
+// openMailQueue connects to the queue set by MAIL_QUEUE in .env
+func openMailQueue() (mailer.Queue, func(), error) {
+	switch os.Getenv("MAIL_QUEUE") {
+	case "database":
+		db, err := cel.OpenDB(cel.DB.DataType, cel.BuildDSN())
+		if err != nil {
+			return nil, nil, err
+		}
+		return &mailer.SQLQueue{DB: db, DBType: cel.DB.DataType}, func() { _ = db.Close() }, nil
+
+	case "redis":
+		pool := &redis.Pool{
+			MaxIdle: 1,
+			Dial: func() (redis.Conn, error) {
+				return redis.Dial("tcp",
+					os.Getenv("REDIS_HOST"),
+					redis.DialPassword(os.Getenv("REDIS_PASSWORD")))
+			},
+		}
+		return &mailer.RedisQueue{Conn: pool, Prefix: os.Getenv("REDIS_PREFIX")}, func() { _ = pool.Close() }, nil
+
+	case "badger":
+		// badger allows a single process, so the application must be stopped
+		db, err := badger.Open(badger.DefaultOptions(cel.RootPath + "/tmp/badger").WithLogger(nil))
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not open badger, is the application running? %w", err)
+		}
+		return &mailer.BadgerQueue{Conn: db}, func() { _ = db.Close() }, nil
+
+	case "":
+		return nil, nil, errors.New("no MAIL_QUEUE set in .env; queued mail only lives in memory")
+
+	default:
+		return nil, nil, errors.New("unknown MAIL_QUEUE " + os.Getenv("MAIL_QUEUE"))
+	}
+}
+
+func doMailQueueTables() error {
+	checkForDB()
+
+	dbType := cel.DB.DataType
+	if dbType == "mariadb" {
+		dbType = "mysql"
+	}
+	if dbType == "postgresql" || dbType == "pgx" {
+		dbType = "postgres"
+	}
+
+	tx, err := cel.PopConnect()
+	if err != nil {
+		return err
+	}
+	defer tx.Close()
+
+	upBytes, err := templateFS.ReadFile("templates/migrations/mail_queue_tables." + dbType + ".sql")
+	if err != nil {
+		return err
+	}
+	downBytes := []byte("drop table if exists mail_queue; drop table if exists mail_failed;")
+
+	err = cel.CreatePopMigration(upBytes, downBytes, "mail_queue", "sql")
+	if err != nil {
+		return err
+	}
+
+	return cel.RunPopMigrations(tx)
+}
@@ -53,6 +53,18 @@ func main() {
 			exitGracefully(err)
 		}
 
+	case "mail":
+		err = doMail(arg2, arg3)
+		if err != nil {
+			exitGracefully(err)
+		}
+
+	case "fs":
+		err = doFS(os.Args[2:])
+		if err != nil {
+			exitGracefully(err)
+		}
+
 	default:
 		showHelp()
 	}
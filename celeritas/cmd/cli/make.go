@@ -134,6 +134,15 @@ func doMake(arg2, arg3, arg4 string) error {
 		if arg3 == "" {
 			exitGracefully(errors.New("you must give the mail template a name"))
 		}
+		// markdown renders both the html and the plain text versions
+		if arg4 == "md" {
+			err := copyFilefromTemplate("templates/mailer/mail.md", cel.RootPath+"/mail/"+strings.ToLower(arg3)+".md")
+			if err != nil {
+				exitGracefully(err)
+			}
+			break
+		}
+
 		htmlMail := cel.RootPath + "/mail/" + strings.ToLower(arg3) + ".html.tmpl"
 		plainMail := cel.RootPath + "/mail/" + strings.ToLower(arg3) + ".plain.tmpl"
 
@@ -152,6 +161,18 @@ func doMake(arg2, arg3, arg4 string) error {
 		if err != nil {
 			exitGracefully(err)
 		}
+
+	case "mail-queue":
+		err := doMailQueueTables()
+		if err != nil {
+			exitGracefully(err)
+		}
+
+	case "tus-table":
+		err := doTusTable()
+		if err != nil {
+			exitGracefully(err)
+		}
 	}
 
 	return nil
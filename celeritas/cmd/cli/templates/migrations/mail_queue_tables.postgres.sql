@@ -0,0 +1,23 @@
+drop table if exists mail_queue;
+
+CREATE TABLE mail_queue (
+    id VARCHAR(64) PRIMARY KEY,
+    payload TEXT NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt TIMESTAMPTZ NOT NULL,
+    last_error TEXT,
+    created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX mail_queue_next_attempt_idx ON mail_queue (next_attempt);
+
+drop table if exists mail_failed;
+
+CREATE TABLE mail_failed (
+    id VARCHAR(64) PRIMARY KEY,
+    payload TEXT NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt TIMESTAMPTZ NOT NULL,
+    last_error TEXT,
+    created_at TIMESTAMPTZ NOT NULL
+);
@@ -0,0 +1,9 @@
+drop table if exists tus_uploads;
+
+CREATE TABLE tus_uploads (
+    id VARCHAR(64) PRIMARY KEY,
+    payload TEXT NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX tus_uploads_expires_at_idx ON tus_uploads (expires_at);
@@ -0,0 +1,33 @@
+package main
+
+// doTusTable creates the table of the database store of the tus uploads (TUS_STORE=database)
+func doTusTable() error {
+	checkForDB()
+
+	dbType := cel.DB.DataType
+	if dbType == "mariadb" {
+		dbType = "mysql"
+	}
+	if dbType == "postgresql" || dbType == "pgx" {
+		dbType = "postgres"
+	}
+
+	tx, err := cel.PopConnect()
+	if err != nil {
+		return err
+	}
+	defer tx.Close()
+
+	upBytes, err := templateFS.ReadFile("templates/migrations/tus_uploads_table." + dbType + ".sql")
+	if err != nil {
+		return err
+	}
+	downBytes := []byte("drop table if exists tus_uploads;")
+
+	err = cel.CreatePopMigration(upBytes, downBytes, "tus_uploads", "sql")
+	if err != nil {
+		return err
+	}
+
+	return cel.RunPopMigrations(tx)
+}
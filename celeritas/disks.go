@@ -0,0 +1,173 @@
+package celeritas
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/djedjethai/celeritas/filesystems"
+	"github.com/djedjethai/celeritas/filesystems/localfilesystem"
+	"github.com/djedjethai/celeritas/filesystems/memfilesystem"
+	"github.com/djedjethai/celeritas/filesystems/miniofilesystem"
+	"github.com/djedjethai/celeritas/filesystems/s3filesystem"
+	"github.com/djedjethai/celeritas/filesystems/sftpfilesystem"
+	"github.com/djedjethai/celeritas/filesystems/webdavfilesystem"
+	"gopkg.in/yaml.v2"
+)
+
+// disksFile configures the named disks, relative to the root path
+const disksFile = "config/disks.yml"
+
+// disksConfig is the content of config/disks.yml, such as
+//
+//	default: avatars
+//	disks:
+//	  avatars:
+//	    driver: s3
+//	    key: ${S3_KEY}
+//	    secret: ${S3_SECRET}
+//	    region: eu-west-1
+//	    bucket: avatars
+//
+// Values are expanded from the environment, so secrets can stay in .env
+type disksConfig struct {
+	Default string                `yaml:"default"`
+	Disks   map[string]diskConfig `yaml:"disks"`
+}
+
+type diskConfig struct {
+	// Driver is local, memory, minio, s3, sftp or webdav
+	Driver   string `yaml:"driver"`
+	Root     string `yaml:"root"`
+	Endpoint string `yaml:"endpoint"`
+	Key      string `yaml:"key"`
+	Secret   string `yaml:"secret"`
+	Region   string `yaml:"region"`
+	Bucket   string `yaml:"bucket"`
+	UseSSL   bool   `yaml:"use_ssl"`
+	// ACL is the canned ACL of the objects written on s3, such as public-read; they
+	// are private when it is empty
+	ACL  string `yaml:"acl"`
+	Host string `yaml:"host"`
+	Port string `yaml:"port"`
+	User string `yaml:"user"`
+	Pass string `yaml:"pass"`
+	// sftp host key checks and authentication
+	Fingerprint         string `yaml:"fingerprint"`
+	KnownHosts          string `yaml:"known_hosts"`
+	InsecureSkipHostKey bool   `yaml:"insecure_skip_host_key"`
+	KeyFile             string `yaml:"key_file"`
+	KeyPassphrase       string `yaml:"key_passphrase"`
+	UseAgent            bool   `yaml:"use_agent"`
+}
+
+// Disk returns the file system registered as name, or the default disk when name is
+// empty. It returns an error wrapping filesystems.ErrUnknownDisk for unknown disks
+func (c *Celeritas) Disk(name string) (filesystems.FS, error) {
+	if c.FileSystems == nil {
+		return nil, fmt.Errorf("%w %q", filesystems.ErrUnknownDisk, name)
+	}
+	return c.FileSystems.Disk(name)
+}
+
+// OpenDisks registers the disks of .env and config/disks.yml, for the tools which do
+// not start the application, like the celeritas command
+func (c *Celeritas) OpenDisks() error {
+	disks, err := c.createFileSystems()
+	if err != nil {
+		return err
+	}
+	c.FileSystems = disks
+	return nil
+}
+
+// loadDisks registers the disks of config/disks.yml, when it exists
+func (c *Celeritas) loadDisks(disks *filesystems.Registry) (string, error) {
+	content, err := ioutil.ReadFile(filepath.Join(c.RootPath, disksFile))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	var cfg disksConfig
+	if err := yaml.Unmarshal([]byte(os.ExpandEnv(string(content))), &cfg); err != nil {
+		return "", fmt.Errorf("%s: %w", disksFile, err)
+	}
+
+	for name, d := range cfg.Disks {
+		fs, err := c.newDisk(d)
+		if err != nil {
+			return "", fmt.Errorf("%s: disk %s: %w", disksFile, name, err)
+		}
+		disks.Add(name, fs)
+	}
+	return cfg.Default, nil
+}
+
+// newDisk creates the file system of a disk
+func (c *Celeritas) newDisk(d diskConfig) (filesystems.FS, error) {
+	switch strings.ToLower(d.Driver) {
+	case "local":
+		return &localfilesystem.Local{Root: c.rootRelative(d.Root)}, nil
+
+	case "memory":
+		return &memfilesystem.Mem{}, nil
+
+	case "minio":
+		return &miniofilesystem.Minio{
+			Endpoint: d.Endpoint,
+			Key:      d.Key,
+			Secret:   d.Secret,
+			UseSSL:   d.UseSSL,
+			Region:   d.Region,
+			Bucket:   d.Bucket,
+		}, nil
+
+	case "s3":
+		return &s3filesystem.S3{
+			Key:      d.Key,
+			Secret:   d.Secret,
+			Region:   d.Region,
+			Endpoint: d.Endpoint,
+			Bucket:   d.Bucket,
+			ACL:      d.ACL,
+		}, nil
+
+	case "sftp":
+		return &sftpfilesystem.SFTP{
+			Host:                d.Host,
+			User:                d.User,
+			Pass:                d.Pass,
+			Port:                d.Port,
+			Root:                d.Root,
+			Fingerprint:         d.Fingerprint,
+			KnownHosts:          d.KnownHosts,
+			InsecureSkipHostKey: d.InsecureSkipHostKey,
+			KeyFile:             c.rootRelative(d.KeyFile),
+			KeyPassphrase:       d.KeyPassphrase,
+			UseAgent:            d.UseAgent,
+		}, nil
+
+	case "webdav":
+		return &webdavfilesystem.WebDAV{
+			Host: d.Host,
+			User: d.User,
+			Pass: d.Pass,
+		}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown driver %q; only local, memory, minio, s3, sftp or webdav accepted", d.Driver)
+	}
+}
+
+// rootRelative returns name relative to the root path, unless it is absolute or empty
+func (c *Celeritas) rootRelative(name string) string {
+	if name == "" || filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(c.RootPath, name)
+}
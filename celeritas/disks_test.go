@@ -0,0 +1,68 @@
+package celeritas
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/djedjethai/celeritas/filesystems"
+	"github.com/djedjethai/celeritas/filesystems/localfilesystem"
+	"github.com/djedjethai/celeritas/filesystems/s3filesystem"
+)
+
+func TestCeleritas_Disk(t *testing.T) {
+	root := t.TempDir()
+	_ = os.Mkdir(filepath.Join(root, "config"), 0755)
+	config := `default: avatars
+disks:
+  avatars:
+    driver: s3
+    key: ${TEST_DISK_KEY}
+    bucket: avatars
+  backups:
+    driver: s3
+    bucket: backups
+  uploads:
+    driver: local
+    root: public/uploads
+`
+	if err := ioutil.WriteFile(filepath.Join(root, disksFile), []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TEST_DISK_KEY", "secret-key")
+
+	c := &Celeritas{RootPath: root}
+	var err error
+	c.FileSystems, err = c.createFileSystems()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fs, err := c.Disk("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s3, ok := fs.(*s3filesystem.S3); !ok || s3.Bucket != "avatars" || s3.Key != "secret-key" {
+		t.Errorf("wrong default disk: %+v", fs)
+	}
+
+	fs, _ = c.Disk("backups")
+	if s3, ok := fs.(*s3filesystem.S3); !ok || s3.Bucket != "backups" {
+		t.Errorf("wrong backups disk: %+v", fs)
+	}
+
+	fs, _ = c.Disk("uploads")
+	if local, ok := fs.(*localfilesystem.Local); !ok || local.Root != filepath.Join(root, "public", "uploads") {
+		t.Errorf("wrong uploads disk: %+v", fs)
+	}
+
+	if _, err := c.Disk("local"); err != nil {
+		t.Error("local disk not registered")
+	}
+
+	if _, err := c.Disk("missing"); !errors.Is(err, filesystems.ErrUnknownDisk) {
+		t.Errorf("expected ErrUnknownDisk, got %v", err)
+	}
+}
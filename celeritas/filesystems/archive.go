@@ -0,0 +1,190 @@
+package filesystems
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// ErrArchiveTooLarge is returned when the files of an archive are past its size limit
+var ErrArchiveTooLarge = errors.New("filesystems: archive too large")
+
+// ArchiveEntry is a file of an archive
+type ArchiveEntry struct {
+	// Name is the path of the file within the archive
+	Name string
+	Listing
+}
+
+// ArchiveEntries returns the files of keys, and the files below the folders among
+// them, named in the archive from the folder of their key: selecting docs/reports
+// archives reports/q1.pdf. It fails with ErrArchiveTooLarge once their total size is
+// past maxSize, when it is not 0, before anything is read
+func ArchiveEntries(ctx context.Context, fs FS, keys []string, maxSize int64) ([]ArchiveEntry, error) {
+	var entries []ArchiveEntry
+	var total int64
+	names := make(map[string]bool)
+
+	add := func(name string, item Listing) error {
+		if total += item.Size; maxSize > 0 && total > maxSize {
+			return ErrArchiveTooLarge
+		}
+		entries = append(entries, ArchiveEntry{Name: uniqueName(names, name), Listing: item})
+		return nil
+	}
+
+	for _, key := range keys {
+		key = CleanKey(key)
+		stat, err := fs.Stat(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		base := path.Dir(key)
+
+		if !stat.IsDir {
+			if err := add(path.Base(key), stat); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		listing, err := ListAll(ctx, fs, key, true)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range listing {
+			name := item.Key
+			if base != "." {
+				name = strings.TrimPrefix(name, base+"/")
+			}
+			if err := add(name, item); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return entries, nil
+}
+
+// uniqueName numbers name when an entry has it already, as a (2).txt
+func uniqueName(names map[string]bool, name string) string {
+	unique := name
+	ext := path.Ext(name)
+	for i := 2; names[unique]; i++ {
+		unique = fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(name, ext), i, ext)
+	}
+	names[unique] = true
+	return unique
+}
+
+// WriteZip streams a zip of entries of fs to w, one file after the other. The files
+// which are compressed already, as images and videos, are stored rather than deflated
+func WriteZip(ctx context.Context, w io.Writer, fs FS, entries []ArchiveEntry) error {
+	zw := zip.NewWriter(w)
+	for _, entry := range entries {
+		header := &zip.FileHeader{
+			Name:     entry.Name,
+			Method:   zip.Deflate,
+			Modified: entry.LastModified,
+		}
+		if compressed(entry.Key) {
+			header.Method = zip.Store
+		}
+
+		fw, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		if _, err := copyEntry(ctx, fw, fs, entry); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// WriteTarGz streams a gzipped tar of entries of fs to w, one file after the other.
+// A file whose size changed since it was listed fails the archive
+func WriteTarGz(ctx context.Context, w io.Writer, fs FS, entries []ArchiveEntry) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	for _, entry := range entries {
+		err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     entry.Name,
+			Size:     entry.Size,
+			Mode:     0644,
+			ModTime:  entry.LastModified,
+		})
+		if err != nil {
+			return err
+		}
+
+		n, err := copyEntry(ctx, tw, fs, entry)
+		if err != nil {
+			return err
+		}
+		if n != entry.Size {
+			return NewError("archive", entry.Key, fmt.Errorf("size changed from %d to %d", entry.Size, n), nil)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+// copyEntry copies the file of entry to w, stopping once ctx is done, and past the
+// size it was listed with so the archive stays within its limit
+func copyEntry(ctx context.Context, w io.Writer, fs FS, entry ArchiveEntry) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	r, err := fs.Open(ctx, entry.Key)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	n, err := io.Copy(w, io.LimitReader(contextReader{ctx: ctx, r: r}, entry.Size+1))
+	if err != nil {
+		return n, NewError("archive", entry.Key, err, nil)
+	}
+	if n > entry.Size {
+		return n, NewError("archive", entry.Key, ErrArchiveTooLarge, nil)
+	}
+	return n, nil
+}
+
+// contextReader stops reading once ctx is done
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+// compressed reports whether the type of key is compressed already
+func compressed(key string) bool {
+	contentType := ContentType(key, "")
+	for _, prefix := range []string{"image/", "video/", "audio/"} {
+		if strings.HasPrefix(contentType, prefix) && contentType != "image/svg+xml" && contentType != "image/bmp" {
+			return true
+		}
+	}
+	switch contentType {
+	case "application/zip", "application/gzip", "application/x-gzip", "application/x-7z-compressed", "application/pdf":
+		return true
+	}
+	return false
+}
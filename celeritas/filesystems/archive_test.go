@@ -0,0 +1,140 @@
+package filesystems_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/djedjethai/celeritas/filesystems"
+	"github.com/djedjethai/celeritas/filesystems/memfilesystem"
+)
+
+func archiveDisk(t *testing.T) *memfilesystem.Mem {
+	fs := &memfilesystem.Mem{}
+	put(t, fs, "docs/reports/q1.pdf", "first quarter")
+	put(t, fs, "docs/reports/2021/q4.pdf", "last quarter")
+	put(t, fs, "docs/notes.txt", "some notes")
+	put(t, fs, "photos/notes.txt", "other notes")
+	return fs
+}
+
+func TestArchiveEntries(t *testing.T) {
+	ctx := context.Background()
+	fs := archiveDisk(t)
+
+	entries, err := filesystems.ArchiveEntries(ctx, fs, []string{"docs/reports", "/docs/notes.txt", "photos/notes.txt"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name)
+	}
+	sort.Strings(names)
+	expected := "notes (2).txt,notes.txt,reports/2021/q4.pdf,reports/q1.pdf"
+	if strings.Join(names, ",") != expected {
+		t.Errorf("expected %s, got %s", expected, strings.Join(names, ","))
+	}
+
+	// the limit is checked before anything is read
+	if _, err := filesystems.ArchiveEntries(ctx, fs, []string{"docs"}, 20); !errors.Is(err, filesystems.ErrArchiveTooLarge) {
+		t.Errorf("expected ErrArchiveTooLarge, got %v", err)
+	}
+	if _, err := filesystems.ArchiveEntries(ctx, fs, []string{"docs/missing.txt"}, 0); !errors.Is(err, filesystems.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestWriteZip(t *testing.T) {
+	ctx := context.Background()
+	fs := archiveDisk(t)
+	entries, err := filesystems.ArchiveEntries(ctx, fs, []string{"docs"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	if err := filesystems.WriteZip(ctx, &b, fs, entries); err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(b.Bytes()), int64(b.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]string)
+	for _, f := range zr.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := ioutil.ReadAll(r)
+		r.Close()
+		files[f.Name] = string(content)
+
+		if strings.HasSuffix(f.Name, ".pdf") != (f.Method == zip.Store) {
+			t.Errorf("%s: unexpected method %d", f.Name, f.Method)
+		}
+	}
+	if len(files) != 3 || files["docs/reports/q1.pdf"] != "first quarter" || files["docs/notes.txt"] != "some notes" {
+		t.Errorf("unexpected archive %v", files)
+	}
+}
+
+func TestWriteTarGz(t *testing.T) {
+	ctx := context.Background()
+	fs := archiveDisk(t)
+	entries, err := filesystems.ArchiveEntries(ctx, fs, []string{"docs/reports/q1.pdf", "photos"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	if err := filesystems.WriteTarGz(ctx, &b, fs, entries); err != nil {
+		t.Fatal(err)
+	}
+
+	gr, err := gzip.NewReader(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gr)
+	files := make(map[string]string)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := ioutil.ReadAll(tr)
+		files[header.Name] = string(content)
+	}
+	if len(files) != 2 || files["q1.pdf"] != "first quarter" || files["photos/notes.txt"] != "other notes" {
+		t.Errorf("unexpected archive %v", files)
+	}
+}
+
+func TestWriteZip_cancelled(t *testing.T) {
+	fs := archiveDisk(t)
+	entries, err := filesystems.ArchiveEntries(context.Background(), fs, []string{"docs"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the client went away
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := filesystems.WriteZip(ctx, ioutil.Discard, fs, entries); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the archive cancelled, got %v", err)
+	}
+}
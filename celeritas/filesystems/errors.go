@@ -0,0 +1,59 @@
+package filesystems
+
+import (
+	"errors"
+	"io/fs"
+)
+
+var (
+	// ErrNotFound is matched by the errors of every driver for a missing key. It is
+	// fs.ErrNotExist, so errors.Is(err, os.ErrNotExist) holds as well
+	ErrNotFound = fs.ErrNotExist
+	// ErrPermission is matched by the errors of every driver for a refused key
+	ErrPermission = fs.ErrPermission
+)
+
+// Error records the operation and the key which failed. errors.Is matches its Kind,
+// ErrNotFound or ErrPermission, whatever the error of the driver
+type Error struct {
+	Op   string
+	Key  string
+	Kind error
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return "filesystems: " + e.Op + " " + e.Key + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (e *Error) Is(target error) bool {
+	return e.Kind != nil && target == e.Kind
+}
+
+// NewError returns err as an *Error of op on key. Its kind is the one given, or else
+// ErrNotFound or ErrPermission when err already matches them. It returns nil when err
+// is nil, and err itself when it is an *Error already
+func NewError(op, key string, err, kind error) error {
+	if err == nil {
+		return nil
+	}
+
+	var e *Error
+	if errors.As(err, &e) {
+		return err
+	}
+
+	if kind == nil {
+		switch {
+		case errors.Is(err, ErrNotFound):
+			kind = ErrNotFound
+		case errors.Is(err, ErrPermission):
+			kind = ErrPermission
+		}
+	}
+	return &Error{Op: op, Key: key, Kind: kind, Err: err}
+}
@@ -1,22 +1,154 @@
 package filesystems
 
 import (
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"strings"
 	"time"
 )
 
-// FS is the interface for file systems
+// ErrTemporaryURLNotSupported is returned by the file systems which can not presign urls
+var ErrTemporaryURLNotSupported = errors.New("filesystems: temporary urls not supported")
+
+// FS is the interface for file systems. Keys are slash separated paths, without a
+// leading slash, and folders are the prefixes of the keys up to a slash. Every driver
+// reports a missing key with ErrNotFound and a refused one with ErrPermission
 type FS interface {
 	Put(fileName, folder string) error
 	Get(destination string, items ...string) error
+	// List returns the files and the folders directly within the folder prefix
 	List(prefix string) ([]Listing, error)
-	Delete(itemsToDelete []string) bool
+	// ListPage returns a page of the listing of the folder prefix, sorted by key
+	ListPage(ctx context.Context, prefix string, opts ListOptions) (Page, error)
+	// Delete removes the files, stopping at the first one which can not be deleted
+	Delete(itemsToDelete []string) error
+	// Stat returns the size, type and metadata of key, a file or a folder
+	Stat(ctx context.Context, key string) (Listing, error)
+	// Exists reports whether key is a file or a folder
+	Exists(ctx context.Context, key string) (bool, error)
+	// Copy copies the file src, with its metadata, to dst, replacing it
+	Copy(ctx context.Context, src, dst string) error
+	// Move renames the file src to dst, replacing it
+	Move(ctx context.Context, src, dst string) error
+	// MkdirAll creates the folder dir with its parents; the object stores keep an
+	// empty dir/ marker, so the folder is listed before any file is put in it
+	MkdirAll(ctx context.Context, dir string) error
+	// PutStream writes the content of r to key, without a local copy
+	PutStream(ctx context.Context, key string, r io.Reader, opts PutOptions) error
+	// Open returns a reader of the content of key, which must be closed
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	// OpenRange returns a reader of length bytes of key from offset, or of the rest of
+	// it when length is negative, which must be closed
+	OpenRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+	// TemporaryURL returns a presigned url to download or upload key directly, valid
+	// for expiry, or ErrTemporaryURLNotSupported
+	TemporaryURL(key string, expiry time.Duration, opts URLOptions) (string, error)
+}
+
+// PutOptions describe the content written by PutStream
+type PutOptions struct {
+	// ContentType is the mime type of the content, for the file systems storing it
+	ContentType string
+	// Size is the length of the content when known, which saves the object stores
+	// from buffering it; 0 when unknown
+	Size int64
+	// Metadata is stored with the content. Its keys are case insensitive, Stat
+	// returns them in lower case
+	Metadata map[string]string
 }
 
 // Listing describe one file on the remote file system
 type Listing struct {
+	// Etag is set by the file systems computing one
 	Etag         string
 	LastModified time.Time
-	Key          string
-	Size         float64
-	IsDir        bool
+	// Key is the full key, to pass back to Open, Stat or Delete
+	Key   string
+	Size  int64
+	IsDir bool
+	// ContentType and Metadata are only set by Stat
+	ContentType string
+	Metadata    map[string]string
+}
+
+// ListOptions select a page of a listing
+type ListOptions struct {
+	// Recursive lists every file below the folder, and no folders, instead of the
+	// files and folders directly within it
+	Recursive bool
+	// Limit is the maximum number of items of the page, 0 for no limit
+	Limit int
+	// Cursor is the Next of the previous page, empty for the first page
+	Cursor string
+}
+
+// Page is a page of a listing
+type Page struct {
+	Items []Listing
+	// Next is the cursor of the next page, empty on the last page
+	Next string
+}
+
+// URLOptions describe the request a temporary url is for
+type URLOptions struct {
+	// Method is GET, the default, to download or PUT to upload
+	Method string
+	// ContentType overrides the type of the download, or is the type of the upload
+	ContentType string
+	// Filename, when set, has the download saved under that name
+	Filename string
+}
+
+// AttachmentDisposition returns the Content-Disposition header saving a download as filename
+func AttachmentDisposition(filename string) string {
+	return ContentDisposition("attachment", filename)
+}
+
+// ContentDisposition returns the Content-Disposition header of RFC 6266, disposition
+// being inline or attachment. A filename beyond ASCII is sent in filename*, after an
+// ASCII fallback for the browsers which do not read it
+func ContentDisposition(disposition, filename string) string {
+	if filename == "" {
+		return disposition
+	}
+
+	fallback := asciiFilename(filename)
+	header := mime.FormatMediaType(disposition, map[string]string{"filename": fallback})
+	if fallback != filename {
+		header += "; filename*=UTF-8''" + encodeExtValue(filename)
+	}
+	return header
+}
+
+// asciiFilename replaces the characters of filename which are not printable ASCII,
+// and the quotes and backslashes the browsers mishandle
+func asciiFilename(filename string) string {
+	var b strings.Builder
+	for _, r := range filename {
+		if r < ' ' || r > '~' || r == '"' || r == '\\' {
+			b.WriteByte('_')
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// encodeExtValue percent-encodes s as the ext-value of RFC 8187
+func encodeExtValue(s string) string {
+	const hex = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.IndexByte("!#$&+-.^_`|~", c) >= 0 {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteByte('%')
+		b.WriteByte(hex[c>>4])
+		b.WriteByte(hex[c&15])
+	}
+	return b.String()
 }
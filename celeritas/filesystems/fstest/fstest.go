@@ -0,0 +1,421 @@
+// Package fstest is the conformance suite of the filesystems.FS drivers
+package fstest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/djedjethai/celeritas/filesystems"
+)
+
+// TestFS checks that fs behaves like every other driver. It writes its files under
+// folder, a unique one when empty, and deletes them when it is done
+func TestFS(t *testing.T, fs filesystems.FS, folder string) {
+	t.Helper()
+	if folder == "" {
+		folder = fmt.Sprintf("celeritas-fstest-%d", time.Now().UnixNano())
+	}
+
+	ctx := context.Background()
+	content := []byte("streamed through the celeritas file system")
+	streamed := path.Join(folder, "nested", "streamed.txt")
+	typed := path.Join(folder, "data.json")
+	copied := path.Join(folder, "nested", "copied.txt")
+	moved := path.Join(folder, "moved.txt")
+	missing := path.Join(folder, "missing.txt")
+
+	put := func(t *testing.T, key string, opts filesystems.PutOptions) {
+		t.Helper()
+		opts.Size = int64(len(content))
+		if err := fs.PutStream(ctx, key, bytes.NewReader(content), opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	read := func(t *testing.T, key string) []byte {
+		t.Helper()
+		r, err := fs.Open(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+
+		got, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+	notFound := func(t *testing.T, op string, err error) {
+		t.Helper()
+		if !errors.Is(err, filesystems.ErrNotFound) {
+			t.Errorf("%s: expected ErrNotFound, got %v", op, err)
+		}
+	}
+
+	t.Run("PutStream and Open", func(t *testing.T) {
+		put(t, streamed, filesystems.PutOptions{ContentType: "text/plain"})
+		if got := read(t, streamed); !bytes.Equal(got, content) {
+			t.Errorf("expected %q but got %q", content, got)
+		}
+	})
+
+	t.Run("Open a missing key", func(t *testing.T) {
+		r, err := fs.Open(ctx, missing)
+		if err == nil {
+			r.Close()
+		}
+		notFound(t, "open", err)
+	})
+
+	t.Run("OpenRange", func(t *testing.T) {
+		put(t, streamed, filesystems.PutOptions{})
+		ranges := []struct {
+			offset, length int64
+			expected       []byte
+		}{
+			{0, -1, content},
+			{9, 7, content[9:16]},
+			{9, -1, content[9:]},
+			{int64(len(content)) - 6, 100, content[len(content)-6:]},
+		}
+		for _, rg := range ranges {
+			r, err := fs.OpenRange(ctx, streamed, rg.offset, rg.length)
+			if err != nil {
+				t.Fatalf("range %d+%d: %s", rg.offset, rg.length, err)
+			}
+			got, err := ioutil.ReadAll(r)
+			r.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, rg.expected) {
+				t.Errorf("range %d+%d: expected %q but got %q", rg.offset, rg.length, rg.expected, got)
+			}
+		}
+
+		r, err := fs.OpenRange(ctx, missing, 2, 2)
+		if err == nil {
+			r.Close()
+		}
+		notFound(t, "open range", err)
+	})
+
+	t.Run("Stat", func(t *testing.T) {
+		put(t, streamed, filesystems.PutOptions{
+			ContentType: "text/plain",
+			Metadata:    map[string]string{"Owner": "celeritas"},
+		})
+		put(t, typed, filesystems.PutOptions{})
+
+		item, err := fs.Stat(ctx, streamed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if item.Key != streamed || item.IsDir || item.Size != int64(len(content)) {
+			t.Errorf("wrong stat of %s: %+v", streamed, item)
+		}
+		if !strings.HasPrefix(item.ContentType, "text/plain") {
+			t.Errorf("expected the content type text/plain, got %q", item.ContentType)
+		}
+		if item.Metadata["owner"] != "celeritas" {
+			t.Errorf("expected the metadata owner, got %v", item.Metadata)
+		}
+
+		// the type defaults to the one of the extension
+		item, err = fs.Stat(ctx, typed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if item.ContentType != "application/json" || len(item.Metadata) != 0 {
+			t.Errorf("wrong type or metadata of %s: %+v", typed, item)
+		}
+
+		item, err = fs.Stat(ctx, path.Dir(streamed))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !item.IsDir {
+			t.Errorf("%s not a folder", path.Dir(streamed))
+		}
+
+		_, err = fs.Stat(ctx, missing)
+		notFound(t, "stat", err)
+	})
+
+	t.Run("Exists", func(t *testing.T) {
+		for key, expected := range map[string]bool{streamed: true, path.Dir(streamed): true, missing: false} {
+			exists, err := fs.Exists(ctx, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if exists != expected {
+				t.Errorf("%s: expected exists %v", key, expected)
+			}
+		}
+	})
+
+	t.Run("Put and Get", func(t *testing.T) {
+		local := filepath.Join(t.TempDir(), "put.txt")
+		if err := ioutil.WriteFile(local, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := fs.Put(local, folder); err != nil {
+			t.Fatal(err)
+		}
+
+		destination := t.TempDir()
+		if err := fs.Get(destination, path.Join(folder, "put.txt")); err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadFile(filepath.Join(destination, "put.txt"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, content) {
+			t.Errorf("expected %q but got %q", content, got)
+		}
+	})
+
+	t.Run("Copy", func(t *testing.T) {
+		if err := fs.Copy(ctx, streamed, copied); err != nil {
+			t.Fatal(err)
+		}
+		if got := read(t, copied); !bytes.Equal(got, content) {
+			t.Errorf("expected %q but got %q", content, got)
+		}
+
+		item, err := fs.Stat(ctx, copied)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if item.Metadata["owner"] != "celeritas" {
+			t.Errorf("metadata not copied: %v", item.Metadata)
+		}
+		if exists, _ := fs.Exists(ctx, streamed); !exists {
+			t.Error("source removed by the copy")
+		}
+
+		notFound(t, "copy", fs.Copy(ctx, missing, copied))
+	})
+
+	t.Run("Move", func(t *testing.T) {
+		if err := fs.Move(ctx, copied, moved); err != nil {
+			t.Fatal(err)
+		}
+		if got := read(t, moved); !bytes.Equal(got, content) {
+			t.Errorf("expected %q but got %q", content, got)
+		}
+
+		item, err := fs.Stat(ctx, moved)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if item.Metadata["owner"] != "celeritas" {
+			t.Errorf("metadata not moved: %v", item.Metadata)
+		}
+		if exists, _ := fs.Exists(ctx, copied); exists {
+			t.Error("source kept by the move")
+		}
+
+		notFound(t, "move", fs.Move(ctx, missing, moved))
+	})
+
+	t.Run("MkdirAll", func(t *testing.T) {
+		if err := fs.MkdirAll(ctx, path.Join(folder, "empty", "sub")); err != nil {
+			t.Fatal(err)
+		}
+		for _, dir := range []string{path.Join(folder, "empty"), path.Join(folder, "empty", "sub")} {
+			item, err := fs.Stat(ctx, dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !item.IsDir {
+				t.Errorf("%s not a folder", dir)
+			}
+		}
+	})
+
+	t.Run("List", func(t *testing.T) {
+		// hidden files are never listed
+		put(t, path.Join(folder, ".hidden"), filesystems.PutOptions{})
+
+		expected := []string{"data.json", "empty/", "moved.txt", "nested/", "put.txt"}
+
+		listing, err := fs.List(folder)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkListing(t, folder, listing, expected)
+
+		// a trailing slash and a leading one name the same folder
+		listing, err = fs.List("/" + folder + "/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkListing(t, folder, listing, expected)
+
+		listing, err = fs.List(path.Join(folder, "missing"))
+		if err != nil || len(listing) != 0 {
+			t.Errorf("expected an empty listing of a missing folder, got %+v, %v", listing, err)
+		}
+	})
+
+	t.Run("ListPage recursive", func(t *testing.T) {
+		listing, err := filesystems.ListAll(ctx, fs, folder, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkListing(t, folder, listing, []string{"data.json", "moved.txt", "nested/streamed.txt", "put.txt"})
+	})
+
+	t.Run("ListPage pagination", func(t *testing.T) {
+		all, err := fs.List(folder)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var paged []filesystems.Listing
+		opts := filesystems.ListOptions{Limit: 2}
+		for pages := 0; ; pages++ {
+			if pages > len(all) {
+				t.Fatal("pagination does not end")
+			}
+
+			page, err := fs.ListPage(ctx, folder, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(page.Items) > opts.Limit {
+				t.Fatalf("%d items in a page of %d", len(page.Items), opts.Limit)
+			}
+			paged = append(paged, page.Items...)
+
+			if page.Next == "" {
+				break
+			}
+			opts.Cursor = page.Next
+		}
+
+		if keys(paged) != keys(all) {
+			t.Errorf("paged listing %s differs from %s", keys(paged), keys(all))
+		}
+	})
+
+	t.Run("TemporaryURL", func(t *testing.T) {
+		link, err := fs.TemporaryURL(streamed, time.Minute, filesystems.URLOptions{})
+		if errors.Is(err, filesystems.ErrTemporaryURLNotSupported) {
+			t.Skip("served by a signed Celeritas route instead")
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := http.Get(link)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		got, _ := ioutil.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK || !bytes.Equal(got, content) {
+			t.Errorf("presigned download failed: %d %q", resp.StatusCode, got)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		files := []string{streamed, typed, moved, path.Join(folder, "put.txt"), path.Join(folder, ".hidden")}
+		if err := fs.Delete(files); err != nil {
+			t.Fatal(err)
+		}
+		if r, err := fs.Open(ctx, streamed); err == nil {
+			r.Close()
+			t.Error("deleted file can still be opened")
+		}
+
+		notFound(t, "delete", fs.Delete([]string{streamed}))
+
+		// the metadata goes with the file
+		put(t, streamed, filesystems.PutOptions{})
+		item, err := fs.Stat(ctx, streamed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(item.Metadata) != 0 {
+			t.Errorf("metadata of a deleted file kept: %v", item.Metadata)
+		}
+		if err := fs.Delete([]string{streamed}); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+// checkListing checks the keys of listing, relative to folder and followed by a slash
+// for the folders, and the sizes of the files
+func checkListing(t *testing.T, folder string, listing []filesystems.Listing, expected []string) {
+	t.Helper()
+
+	var got []string
+	for _, item := range listing {
+		got = append(got, strings.TrimPrefix(filesystems.Cursor(item), folder+"/"))
+		if !item.IsDir && item.Size <= 0 {
+			t.Errorf("no size listed for %s", item.Key)
+		}
+	}
+	if strings.Join(got, " ") != strings.Join(expected, " ") {
+		t.Errorf("expected the listing %v, got %v", expected, got)
+	}
+}
+
+func keys(listing []filesystems.Listing) string {
+	var k []string
+	for _, item := range listing {
+		k = append(k, filesystems.Cursor(item))
+	}
+	return strings.Join(k, " ")
+}
+
+// TestStreams checks PutStream and Open alone, for the drivers whose test server
+// only stores and serves objects. The files are written under folder
+func TestStreams(t *testing.T, fs filesystems.FS, folder string) {
+	t.Helper()
+	ctx := context.Background()
+	content := []byte("streamed through the celeritas file system")
+
+	for name, opts := range map[string]filesystems.PutOptions{
+		"sized":   {Size: int64(len(content)), ContentType: "text/plain", Metadata: map[string]string{"owner": "jane"}},
+		"unsized": {},
+	} {
+		key := path.Join(folder, name+".txt")
+		// a plain reader, so the driver cannot seek or size it
+		r := ioutil.NopCloser(bytes.NewReader(content))
+		if err := fs.PutStream(ctx, key, r, opts); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+
+		rc, err := fs.Open(ctx, key)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		got, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if !bytes.Equal(got, content) {
+			t.Errorf("%s: expected %q, got %q", name, content, got)
+		}
+	}
+
+	if _, err := fs.Open(ctx, path.Join(folder, "missing.txt")); !errors.Is(err, filesystems.ErrNotFound) {
+		t.Errorf("open: expected ErrNotFound, got %v", err)
+	}
+}
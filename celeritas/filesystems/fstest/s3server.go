@@ -0,0 +1,224 @@
+package fstest
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// S3Server is an in-memory S3 endpoint, for the MinIO and S3 drivers. It answers the
+// path style object requests: single and multipart uploads, possibly aws-chunked, and
+// downloads with ranges. Listings, copies and deletions are not supported
+type S3Server struct {
+	// URL is the endpoint, such as http://127.0.0.1:38765
+	URL string
+
+	mu      sync.Mutex
+	objects map[string]*s3Object
+	uploads map[string]*s3Object
+}
+
+// s3Object is a stored object, or a multipart upload in progress
+type s3Object struct {
+	data     []byte
+	header   http.Header
+	modified time.Time
+	parts    map[int][]byte
+}
+
+// NewS3Server starts an S3Server, stopped at the end of the test
+func NewS3Server(t *testing.T) *S3Server {
+	s := &S3Server{
+		objects: make(map[string]*s3Object),
+		uploads: make(map[string]*s3Object),
+	}
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+	s.URL = srv.URL
+	return s
+}
+
+// Object returns the content of the object key of bucket and its headers, such as
+// Content-Type and X-Amz-Meta-*, or nil when there is none
+func (s *S3Server) Object(bucket, key string) ([]byte, http.Header) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, ok := s.objects[bucket+"/"+key]
+	if !ok {
+		return nil, nil
+	}
+	return o.data, o.header
+}
+
+func (s *S3Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/")
+	query := r.URL.Query()
+
+	switch {
+	case r.Method == http.MethodGet && !strings.Contains(strings.TrimSuffix(name, "/"), "/"):
+		if _, ok := query["location"]; ok {
+			s.xml(w, struct {
+				XMLName xml.Name `xml:"LocationConstraint"`
+			}{})
+			return
+		}
+		s.error(w, http.StatusNotImplemented, "NotImplemented")
+
+	case r.Method == http.MethodPost && query.Get("uploadId") == "":
+		id := fmt.Sprintf("upload-%d", time.Now().UnixNano())
+		s.mu.Lock()
+		s.uploads[id] = &s3Object{header: objectHeader(r.Header), parts: make(map[int][]byte)}
+		s.mu.Unlock()
+		s.xml(w, struct {
+			XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+			UploadID string   `xml:"UploadId"`
+		}{UploadID: id})
+
+	case r.Method == http.MethodPost:
+		s.mu.Lock()
+		upload, ok := s.uploads[query.Get("uploadId")]
+		delete(s.uploads, query.Get("uploadId"))
+		s.mu.Unlock()
+		if !ok {
+			s.error(w, http.StatusNotFound, "NoSuchUpload")
+			return
+		}
+		var numbers []int
+		for n := range upload.parts {
+			numbers = append(numbers, n)
+		}
+		sort.Ints(numbers)
+		for _, n := range numbers {
+			upload.data = append(upload.data, upload.parts[n]...)
+		}
+		s.store(name, upload)
+		exploded := strings.SplitN(name, "/", 2)
+		s.xml(w, struct {
+			XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+			Bucket  string
+			Key     string
+			ETag    string
+		}{Bucket: exploded[0], Key: exploded[1], ETag: etagOf(upload.data)})
+
+	case r.Method == http.MethodPut:
+		data, err := readBody(r)
+		if err != nil {
+			s.error(w, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		w.Header().Set("ETag", etagOf(data))
+
+		if id := query.Get("uploadId"); id != "" {
+			n, _ := strconv.Atoi(query.Get("partNumber"))
+			s.mu.Lock()
+			upload, ok := s.uploads[id]
+			if ok {
+				upload.parts[n] = data
+			}
+			s.mu.Unlock()
+			if !ok {
+				s.error(w, http.StatusNotFound, "NoSuchUpload")
+			}
+			return
+		}
+		s.store(name, &s3Object{data: data, header: objectHeader(r.Header)})
+
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		s.mu.Lock()
+		o, ok := s.objects[name]
+		s.mu.Unlock()
+		if !ok {
+			s.error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		for k, v := range o.header {
+			w.Header()[k] = v
+		}
+		w.Header().Set("ETag", etagOf(o.data))
+		http.ServeContent(w, r, "", o.modified, bytes.NewReader(o.data))
+
+	default:
+		s.error(w, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+func (s *S3Server) store(name string, o *s3Object) {
+	o.modified = time.Now().UTC().Truncate(time.Second)
+	o.parts = nil
+	s.mu.Lock()
+	s.objects[name] = o
+	s.mu.Unlock()
+}
+
+func (s *S3Server) xml(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	_ = xml.NewEncoder(w).Encode(v)
+}
+
+func (s *S3Server) error(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	_ = xml.NewEncoder(w).Encode(struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string
+		Message string
+	}{Code: code, Message: code})
+}
+
+// objectHeader returns the headers of an upload kept with the object, with its acl
+func objectHeader(h http.Header) http.Header {
+	kept := make(http.Header)
+	for k, v := range h {
+		if k == "Content-Type" || k == "X-Amz-Acl" || strings.HasPrefix(k, "X-Amz-Meta-") {
+			kept[k] = v
+		}
+	}
+	return kept
+}
+
+// readBody reads the body of an upload, decoding the aws-chunked encoding of the
+// streaming signatures
+func readBody(r *http.Request) ([]byte, error) {
+	if r.Header.Get("X-Amz-Content-Sha256") != "STREAMING-AWS4-HMAC-SHA256-PAYLOAD" {
+		return ioutil.ReadAll(r.Body)
+	}
+
+	var data []byte
+	br := bufio.NewReader(r.Body)
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.ParseInt(strings.SplitN(strings.TrimSpace(line), ";", 2)[0], 16, 64)
+		if err != nil {
+			return nil, err
+		}
+		chunk := make([]byte, size+2)
+		if _, err := io.ReadFull(br, chunk); err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			return data, nil
+		}
+		data = append(data, chunk[:size]...)
+	}
+}
+
+func etagOf(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
@@ -0,0 +1,110 @@
+package filesystems
+
+import (
+	"context"
+	"mime"
+	"path"
+	"sort"
+	"strings"
+)
+
+// CleanKey returns the canonical form of key, without leading, trailing or duplicate slashes
+func CleanKey(key string) string {
+	return strings.Trim(path.Clean("/"+key), "/")
+}
+
+// FolderPrefix returns the prefix of the keys within folder: the folder followed by a
+// slash, or an empty string for the root
+func FolderPrefix(folder string) string {
+	folder = CleanKey(folder)
+	if folder == "" {
+		return ""
+	}
+	return folder + "/"
+}
+
+// Hidden reports whether the key, relative to the listed folder, is left out of the
+// listings: when one of its parts is a dotfile (like .env or the metadata sidecars),
+// or is empty, as the dir/ markers of the object stores
+func Hidden(rel string) bool {
+	for _, part := range strings.Split(rel, "/") {
+		if part == "" || strings.HasPrefix(part, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+// Cursor returns the position of item in a listing: its key, followed by a slash for a
+// folder, so the items sort the way the object stores list them
+func Cursor(item Listing) string {
+	if item.IsDir {
+		return item.Key + "/"
+	}
+	return item.Key
+}
+
+// Paginate sorts items and returns the page of opts, for the drivers which read a
+// whole folder at once
+func Paginate(items []Listing, opts ListOptions) Page {
+	sort.Slice(items, func(i, j int) bool {
+		return Cursor(items[i]) < Cursor(items[j])
+	})
+
+	var page Page
+	for _, item := range items {
+		if opts.Cursor != "" && Cursor(item) <= opts.Cursor {
+			continue
+		}
+		if opts.Limit > 0 && len(page.Items) == opts.Limit {
+			page.Next = Cursor(page.Items[len(page.Items)-1])
+			break
+		}
+		page.Items = append(page.Items, item)
+	}
+	return page
+}
+
+// ListAll returns every page of the listing of the folder prefix
+func ListAll(ctx context.Context, fs FS, prefix string, recursive bool) ([]Listing, error) {
+	var listing []Listing
+
+	opts := ListOptions{Recursive: recursive}
+	for {
+		page, err := fs.ListPage(ctx, prefix, opts)
+		if err != nil {
+			return listing, err
+		}
+		listing = append(listing, page.Items...)
+
+		if page.Next == "" {
+			return listing, nil
+		}
+		opts.Cursor = page.Next
+	}
+}
+
+// ContentType returns contentType, or else the type of the extension of key, or else
+// application/octet-stream
+func ContentType(key, contentType string) string {
+	if contentType != "" {
+		return contentType
+	}
+	if byExt := mime.TypeByExtension(path.Ext(key)); byExt != "" {
+		return byExt
+	}
+	return "application/octet-stream"
+}
+
+// CleanMetadata returns metadata with its keys in lower case, or nil when it is empty
+func CleanMetadata(metadata map[string]string) map[string]string {
+	if len(metadata) == 0 {
+		return nil
+	}
+
+	clean := make(map[string]string, len(metadata))
+	for k, v := range metadata {
+		clean[strings.ToLower(k)] = v
+	}
+	return clean
+}
@@ -0,0 +1,75 @@
+package filesystems_test
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/djedjethai/celeritas/filesystems"
+)
+
+func TestPaginate(t *testing.T) {
+	items := []filesystems.Listing{
+		{Key: "docs/sub", IsDir: true},
+		{Key: "docs/b.txt"},
+		{Key: "docs/sub.txt"},
+		{Key: "docs/a.txt"},
+	}
+
+	var got []string
+	opts := filesystems.ListOptions{Limit: 3}
+	for {
+		page := filesystems.Paginate(items, opts)
+		for _, item := range page.Items {
+			got = append(got, filesystems.Cursor(item))
+		}
+		if page.Next == "" {
+			break
+		}
+		opts.Cursor = page.Next
+	}
+
+	// folders sort as the object stores list them, after the files sharing their name
+	expected := "docs/a.txt docs/b.txt docs/sub.txt docs/sub/"
+	if strings.Join(got, " ") != expected {
+		t.Errorf("expected %s, got %s", expected, strings.Join(got, " "))
+	}
+}
+
+func TestHidden(t *testing.T) {
+	for rel, hidden := range map[string]bool{
+		"a.txt":           false,
+		"sub/a.txt":       false,
+		".env":            true,
+		"sub/.a.txt.meta": true,
+		".git/config":     true,
+		"sub/":            true,
+	} {
+		if filesystems.Hidden(rel) != hidden {
+			t.Errorf("%s: expected hidden %v", rel, hidden)
+		}
+	}
+}
+
+func TestNewError(t *testing.T) {
+	err := filesystems.NewError("open", "a.txt", &os.PathError{Op: "open", Path: "a.txt", Err: os.ErrNotExist}, nil)
+	if !errors.Is(err, filesystems.ErrNotFound) || errors.Is(err, filesystems.ErrPermission) {
+		t.Errorf("expected a not found error, got %v", err)
+	}
+
+	// the kind of the driver wins over the cause
+	refused := errors.New("403 Forbidden")
+	err = filesystems.NewError("get", "a.txt", refused, filesystems.ErrPermission)
+	if !errors.Is(err, filesystems.ErrPermission) || !errors.Is(err, refused) {
+		t.Errorf("expected a permission error wrapping the cause, got %v", err)
+	}
+
+	// errors are wrapped once
+	if again := filesystems.NewError("copy", "b.txt", err, nil); again != err {
+		t.Errorf("error wrapped twice: %v", again)
+	}
+	if filesystems.NewError("put", "a.txt", nil, nil) != nil {
+		t.Error("expected no error")
+	}
+}
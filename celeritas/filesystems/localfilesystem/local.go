@@ -0,0 +1,401 @@
+package localfilesystem
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/djedjethai/celeritas/filesystems"
+)
+
+// ErrPathTraversal is returned for keys which would escape the root directory
+var ErrPathTraversal = errors.New("localfilesystem: key outside of the root directory")
+
+// Local stores the files in the Root directory of the local disk. Keys are slash
+// separated paths relative to Root, and can not reach outside of it
+type Local struct {
+	Root string
+}
+
+// path returns the path on disk of key
+func (l *Local) path(key string) (string, error) {
+	for _, part := range strings.Split(filepath.ToSlash(key), "/") {
+		if part == ".." {
+			return "", ErrPathTraversal
+		}
+	}
+
+	root := l.Root
+	if root == "" {
+		root = "."
+	}
+	return filepath.Join(root, filepath.FromSlash(path.Clean("/"+key))), nil
+}
+
+// wrap returns err as a filesystems.Error, refusing the keys outside of the root
+func wrap(op, key string, err error) error {
+	if errors.Is(err, ErrPathTraversal) {
+		return filesystems.NewError(op, key, err, filesystems.ErrPermission)
+	}
+	return filesystems.NewError(op, key, err, nil)
+}
+
+func (l *Local) Put(fileName, folder string) error {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return l.PutStream(context.Background(), path.Join(folder, path.Base(fileName)), f, filesystems.PutOptions{})
+}
+
+// PutStream writes the content of r to key, creating its folders. The file is
+// written aside and renamed, so readers never see a partial file
+func (l *Local) PutStream(ctx context.Context, key string, r io.Reader, opts filesystems.PutOptions) error {
+	name, err := l.path(key)
+	if err != nil {
+		return wrap("put", key, err)
+	}
+
+	if err := l.write(ctx, name, r); err != nil {
+		return wrap("put", key, err)
+	}
+	return wrap("put", key, l.writeMeta(key, filesystems.NewMeta(opts)))
+}
+
+func (l *Local) write(ctx context.Context, name string, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(name), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = io.Copy(tmp, contextReader{ctx: ctx, r: r})
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+// readMeta returns the content of the sidecar of key, nil when there is none
+func (l *Local) readMeta(key string) (*filesystems.Meta, error) {
+	name, err := l.path(filesystems.MetaKey(key))
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(name)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return filesystems.DecodeMeta(data)
+}
+
+// writeMeta writes the sidecar of key, or removes it when meta is nil
+func (l *Local) writeMeta(key string, meta *filesystems.Meta) error {
+	name, err := l.path(filesystems.MetaKey(key))
+	if err != nil {
+		return err
+	}
+
+	if meta == nil {
+		if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return ioutil.WriteFile(name, meta.Encode(), 0644)
+}
+
+// file returns the path on disk of key, which must be a file
+func (l *Local) file(key string) (string, error) {
+	name, err := l.path(key)
+	if err != nil {
+		return "", err
+	}
+
+	info, err := os.Stat(name)
+	if err != nil {
+		return "", err
+	}
+	if info.IsDir() {
+		return "", filesystems.ErrNotFound
+	}
+	return name, nil
+}
+
+// Open returns a reader of key
+func (l *Local) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	name, err := l.file(key)
+	if err != nil {
+		return nil, wrap("open", key, err)
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, wrap("open", key, err)
+	}
+	return f, nil
+}
+
+// OpenRange returns a reader of length bytes of key from offset
+func (l *Local) OpenRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	f, err := l.Open(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	r, err := filesystems.SeekRange(f, offset, length)
+	if err != nil {
+		return nil, wrap("open", key, err)
+	}
+	return r, nil
+}
+
+func (l *Local) Get(destination string, items ...string) error {
+	for _, item := range items {
+		err := func() error {
+			src, err := l.Open(context.Background(), item)
+			if err != nil {
+				return err
+			}
+			defer src.Close()
+
+			dst, err := os.Create(fmt.Sprintf("%s/%s", destination, path.Base(item)))
+			if err != nil {
+				return err
+			}
+			defer dst.Close()
+
+			_, err = io.Copy(dst, src)
+			return err
+		}()
+
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// List returns the files and the folders directly within the folder prefix
+func (l *Local) List(prefix string) ([]filesystems.Listing, error) {
+	return filesystems.ListAll(context.Background(), l, prefix, false)
+}
+
+// ListPage returns a page of the listing of the folder prefix
+func (l *Local) ListPage(ctx context.Context, prefix string, opts filesystems.ListOptions) (filesystems.Page, error) {
+	var listing []filesystems.Listing
+
+	dir, err := l.path(prefix)
+	if err != nil {
+		return filesystems.Page{}, wrap("list", prefix, err)
+	}
+	folder := filesystems.FolderPrefix(prefix)
+
+	if !opts.Recursive {
+		infos, err := ioutil.ReadDir(dir)
+		if os.IsNotExist(err) {
+			return filesystems.Page{}, nil
+		}
+		if err != nil {
+			return filesystems.Page{}, wrap("list", prefix, err)
+		}
+
+		for _, info := range infos {
+			// we don't want any file starting with a .(like .env)
+			if filesystems.Hidden(info.Name()) {
+				continue
+			}
+			listing = append(listing, item(folder+info.Name(), info))
+		}
+		return filesystems.Paginate(listing, opts), nil
+	}
+
+	err = filepath.Walk(dir, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if filesystems.Hidden(info.Name()) && name != dir {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, name)
+		if err != nil {
+			return err
+		}
+		listing = append(listing, item(folder+filepath.ToSlash(rel), info))
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return filesystems.Page{}, nil
+	}
+	if err != nil {
+		return filesystems.Page{}, wrap("list", prefix, err)
+	}
+	return filesystems.Paginate(listing, opts), nil
+}
+func item(key string, info os.FileInfo) filesystems.Listing {
+	if info.IsDir() {
+		return filesystems.Listing{Key: key, LastModified: info.ModTime(), IsDir: true}
+	}
+	return filesystems.Listing{Key: key, LastModified: info.ModTime(), Size: info.Size()}
+}
+
+// Stat returns the size, type and metadata of key
+func (l *Local) Stat(ctx context.Context, key string) (filesystems.Listing, error) {
+	name, err := l.path(key)
+	if err != nil {
+		return filesystems.Listing{}, wrap("stat", key, err)
+	}
+
+	info, err := os.Stat(name)
+	if err != nil {
+		return filesystems.Listing{}, wrap("stat", key, err)
+	}
+
+	stat := item(filesystems.CleanKey(key), info)
+	if stat.IsDir {
+		return stat, nil
+	}
+
+	meta, err := l.readMeta(key)
+	if err != nil {
+		return filesystems.Listing{}, wrap("stat", key, err)
+	}
+	meta.Apply(&stat)
+	return stat, nil
+}
+
+// Exists reports whether key is a file or a folder
+func (l *Local) Exists(ctx context.Context, key string) (bool, error) {
+	_, err := l.Stat(ctx, key)
+	if errors.Is(err, filesystems.ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Copy copies the file src, with its metadata, to dst
+func (l *Local) Copy(ctx context.Context, src, dst string) error {
+	meta, err := l.readMeta(src)
+	if err != nil {
+		return wrap("copy", src, err)
+	}
+
+	r, err := l.Open(ctx, src)
+	if err != nil {
+		return wrap("copy", src, err)
+	}
+	defer r.Close()
+
+	var opts filesystems.PutOptions
+	if meta != nil {
+		opts = filesystems.PutOptions{ContentType: meta.ContentType, Metadata: meta.Metadata}
+	}
+	return wrap("copy", dst, l.PutStream(ctx, dst, r, opts))
+}
+
+// Move renames the file src, with its metadata, to dst
+func (l *Local) Move(ctx context.Context, src, dst string) error {
+	from, err := l.file(src)
+	if err != nil {
+		return wrap("move", src, err)
+	}
+	to, err := l.path(dst)
+	if err != nil {
+		return wrap("move", dst, err)
+	}
+
+	meta, err := l.readMeta(src)
+	if err != nil {
+		return wrap("move", src, err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+		return wrap("move", dst, err)
+	}
+	if err := os.Rename(from, to); err != nil {
+		return wrap("move", src, err)
+	}
+
+	if err := l.writeMeta(dst, meta); err != nil {
+		return wrap("move", dst, err)
+	}
+	return wrap("move", src, l.writeMeta(src, nil))
+}
+
+// MkdirAll creates the folder dir and its parents
+func (l *Local) MkdirAll(ctx context.Context, dir string) error {
+	name, err := l.path(dir)
+	if err != nil {
+		return wrap("mkdir", dir, err)
+	}
+	return wrap("mkdir", dir, os.MkdirAll(name, 0755))
+}
+
+// Delete removes the files with their metadata, stopping at the first error
+func (l *Local) Delete(itemsToDelete []string) error {
+	for _, item := range itemsToDelete {
+		name, err := l.file(item)
+		if err != nil {
+			return wrap("delete", item, err)
+		}
+		if err := os.Remove(name); err != nil {
+			return wrap("delete", item, err)
+		}
+		if err := l.writeMeta(item, nil); err != nil {
+			return wrap("delete", item, err)
+		}
+	}
+	return nil
+}
+
+// contextReader stops reading once ctx is done
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+// TemporaryURL is not supported, the files are served by a signed Celeritas route instead
+func (l *Local) TemporaryURL(key string, expiry time.Duration, opts filesystems.URLOptions) (string, error) {
+	return "", filesystems.ErrTemporaryURLNotSupported
+}
@@ -0,0 +1,44 @@
+package localfilesystem
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/djedjethai/celeritas/filesystems"
+	"github.com/djedjethai/celeritas/filesystems/fstest"
+)
+
+func TestLocal(t *testing.T) {
+	fstest.TestFS(t, &Local{Root: t.TempDir()}, "")
+}
+
+func TestLocal_pathTraversal(t *testing.T) {
+	root := t.TempDir()
+	l := &Local{Root: filepath.Join(root, "disk")}
+	ctx := context.Background()
+
+	for _, key := range []string{"../outside.txt", "a/../../outside.txt"} {
+		err := l.PutStream(ctx, key, bytes.NewReader([]byte("x")), filesystems.PutOptions{})
+		if !errors.Is(err, ErrPathTraversal) || !errors.Is(err, filesystems.ErrPermission) {
+			t.Errorf("%s: expected ErrPathTraversal, got %v", key, err)
+		}
+		if _, err := l.Open(ctx, key); !errors.Is(err, ErrPathTraversal) {
+			t.Errorf("%s: expected ErrPathTraversal on open, got %v", key, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, "outside.txt")); err == nil {
+		t.Error("file written outside of the root")
+	}
+
+	// absolute keys stay within the root
+	if err := l.PutStream(ctx, "/inside.txt", bytes.NewReader([]byte("x")), filesystems.PutOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "disk", "inside.txt")); err != nil {
+		t.Error("absolute key not written within the root")
+	}
+}
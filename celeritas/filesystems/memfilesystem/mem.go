@@ -0,0 +1,282 @@
+package memfilesystem
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/djedjethai/celeritas/filesystems"
+)
+
+// Mem keeps the files in memory, for tests. The zero value is an empty file system,
+// safe for concurrent use
+type Mem struct {
+	mu    sync.RWMutex
+	files map[string]file
+	dirs  map[string]bool
+}
+
+type file struct {
+	data         []byte
+	lastModified time.Time
+	meta         *filesystems.Meta
+}
+
+// key returns the canonical form of a key, without leading or duplicate slashes
+func key(k string) string {
+	return filesystems.CleanKey(k)
+}
+
+func (m *Mem) Put(fileName, folder string) error {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return err
+	}
+	m.write(path.Join(folder, path.Base(fileName)), data, nil)
+	return nil
+}
+
+// PutStream stores the content of r as key
+func (m *Mem) PutStream(ctx context.Context, k string, r io.Reader, opts filesystems.PutOptions) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return filesystems.NewError("put", k, err, nil)
+	}
+	if err := ctx.Err(); err != nil {
+		return filesystems.NewError("put", k, err, nil)
+	}
+	m.write(k, data, filesystems.NewMeta(opts))
+	return nil
+}
+
+func (m *Mem) write(k string, data []byte, meta *filesystems.Meta) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.files == nil {
+		m.files = make(map[string]file)
+	}
+	m.files[key(k)] = file{data: data, lastModified: time.Now(), meta: meta}
+}
+
+// Open returns a reader of key
+func (m *Mem) Open(ctx context.Context, k string) (io.ReadCloser, error) {
+	m.mu.RLock()
+	f, ok := m.files[key(k)]
+	m.mu.RUnlock()
+
+	if !ok {
+		return nil, filesystems.NewError("open", k, filesystems.ErrNotFound, nil)
+	}
+	return ioutil.NopCloser(bytes.NewReader(f.data)), nil
+}
+
+// OpenRange returns a reader of length bytes of k from offset
+func (m *Mem) OpenRange(ctx context.Context, k string, offset, length int64) (io.ReadCloser, error) {
+	m.mu.RLock()
+	f, ok := m.files[key(k)]
+	m.mu.RUnlock()
+
+	if !ok {
+		return nil, filesystems.NewError("open", k, filesystems.ErrNotFound, nil)
+	}
+	data := f.data
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	data = data[offset:]
+	if length >= 0 && length < int64(len(data)) {
+		data = data[:length]
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (m *Mem) Get(destination string, items ...string) error {
+	for _, item := range items {
+		r, err := m.Open(context.Background(), item)
+		if err != nil {
+			return err
+		}
+
+		data, _ := ioutil.ReadAll(r)
+		err = ioutil.WriteFile(fmt.Sprintf("%s/%s", destination, path.Base(item)), data, 0644)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// List returns the files and the folders directly within the folder prefix
+func (m *Mem) List(prefix string) ([]filesystems.Listing, error) {
+	return filesystems.ListAll(context.Background(), m, prefix, false)
+}
+
+// ListPage returns a page of the listing of the folder prefix
+func (m *Mem) ListPage(ctx context.Context, prefix string, opts filesystems.ListOptions) (filesystems.Page, error) {
+	var listing []filesystems.Listing
+	dirs := make(map[string]bool)
+	folder := filesystems.FolderPrefix(prefix)
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for k, f := range m.files {
+		rest, ok := within(k, folder)
+		if !ok {
+			continue
+		}
+		if i := strings.Index(rest, "/"); i >= 0 && !opts.Recursive {
+			dirs[folder+rest[:i]] = true
+			continue
+		}
+		listing = append(listing, f.listing(k))
+	}
+
+	if !opts.Recursive {
+		for dir := range m.dirs {
+			rest, ok := within(dir, folder)
+			if !ok {
+				continue
+			}
+			if i := strings.Index(rest, "/"); i >= 0 {
+				rest = rest[:i]
+			}
+			dirs[folder+rest] = true
+		}
+		for dir := range dirs {
+			listing = append(listing, filesystems.Listing{Key: dir, IsDir: true})
+		}
+	}
+
+	return filesystems.Paginate(listing, opts), nil
+}
+
+// within returns k relative to folder, when it is within it and not hidden
+func within(k, folder string) (string, bool) {
+	if !strings.HasPrefix(k, folder) || k == folder {
+		return "", false
+	}
+
+	rest := strings.TrimPrefix(k, folder)
+	return rest, !filesystems.Hidden(rest)
+}
+
+func (f file) listing(k string) filesystems.Listing {
+	sum := md5.Sum(f.data)
+	return filesystems.Listing{
+		Etag:         hex.EncodeToString(sum[:]),
+		LastModified: f.lastModified,
+		Key:          k,
+		Size:         int64(len(f.data)),
+	}
+}
+
+// Stat returns the size, type and metadata of key
+func (m *Mem) Stat(ctx context.Context, k string) (filesystems.Listing, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	k = key(k)
+	if f, ok := m.files[k]; ok {
+		item := f.listing(k)
+		f.meta.Apply(&item)
+		if item.Metadata != nil {
+			metadata := make(map[string]string, len(item.Metadata))
+			for name, value := range item.Metadata {
+				metadata[name] = value
+			}
+			item.Metadata = metadata
+		}
+		return item, nil
+	}
+
+	if k == "" || m.dirs[k] {
+		return filesystems.Listing{Key: k, IsDir: true}, nil
+	}
+	for name := range m.files {
+		if strings.HasPrefix(name, k+"/") {
+			return filesystems.Listing{Key: k, IsDir: true}, nil
+		}
+	}
+	return filesystems.Listing{}, filesystems.NewError("stat", k, filesystems.ErrNotFound, nil)
+}
+
+// Exists reports whether key is a file or a folder
+func (m *Mem) Exists(ctx context.Context, k string) (bool, error) {
+	_, err := m.Stat(ctx, k)
+	if errors.Is(err, filesystems.ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Copy copies the file src to dst
+func (m *Mem) Copy(ctx context.Context, src, dst string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, ok := m.files[key(src)]
+	if !ok {
+		return filesystems.NewError("copy", src, filesystems.ErrNotFound, nil)
+	}
+	f.lastModified = time.Now()
+	m.files[key(dst)] = f
+	return nil
+}
+
+// Move renames the file src to dst
+func (m *Mem) Move(ctx context.Context, src, dst string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, ok := m.files[key(src)]
+	if !ok {
+		return filesystems.NewError("move", src, filesystems.ErrNotFound, nil)
+	}
+	delete(m.files, key(src))
+	m.files[key(dst)] = f
+	return nil
+}
+
+// MkdirAll creates the folder dir and its parents
+func (m *Mem) MkdirAll(ctx context.Context, dir string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.dirs == nil {
+		m.dirs = make(map[string]bool)
+	}
+	for dir = key(dir); dir != "" && dir != "."; dir = path.Dir(dir) {
+		m.dirs[dir] = true
+	}
+	return nil
+}
+
+// Delete removes the files, stopping at a missing one
+func (m *Mem) Delete(itemsToDelete []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, item := range itemsToDelete {
+		if _, ok := m.files[key(item)]; !ok {
+			return filesystems.NewError("delete", item, filesystems.ErrNotFound, nil)
+		}
+		delete(m.files, key(item))
+	}
+	return nil
+}
+
+// TemporaryURL is not supported, the files are served by a signed Celeritas route instead
+func (m *Mem) TemporaryURL(k string, expiry time.Duration, opts filesystems.URLOptions) (string, error) {
+	return "", filesystems.ErrTemporaryURLNotSupported
+}
@@ -0,0 +1,11 @@
+package memfilesystem
+
+import (
+	"testing"
+
+	"github.com/djedjethai/celeritas/filesystems/fstest"
+)
+
+func TestMem(t *testing.T) {
+	fstest.TestFS(t, &Mem{}, "")
+}
@@ -0,0 +1,54 @@
+package filesystems
+
+import (
+	"encoding/json"
+	"path"
+)
+
+// Meta is the content type and the metadata of a file. The drivers without native
+// metadata keep it in a hidden sidecar next to the file, see MetaKey
+type Meta struct {
+	ContentType string            `json:"content_type,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+}
+
+// NewMeta returns the meta of a file put with opts, or nil when there is none to keep
+func NewMeta(opts PutOptions) *Meta {
+	if opts.ContentType == "" && len(opts.Metadata) == 0 {
+		return nil
+	}
+	return &Meta{ContentType: opts.ContentType, Metadata: CleanMetadata(opts.Metadata)}
+}
+
+// MetaKey returns the key of the sidecar of key: .<name>.meta in the same folder, which
+// the listings leave out
+func MetaKey(key string) string {
+	dir, name := path.Split(CleanKey(key))
+	return dir + "." + name + ".meta"
+}
+
+// Encode returns the content of the sidecar
+func (m *Meta) Encode() []byte {
+	data, _ := json.Marshal(m)
+	return data
+}
+
+// DecodeMeta parses the content of a sidecar
+func DecodeMeta(data []byte) (*Meta, error) {
+	var m Meta
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// Apply sets the content type and the metadata of item, with the defaults of ContentType
+// when m is nil
+func (m *Meta) Apply(item *Listing) {
+	if m == nil {
+		item.ContentType = ContentType(item.Key, "")
+		return
+	}
+	item.ContentType = ContentType(item.Key, m.ContentType)
+	item.Metadata = m.Metadata
+}
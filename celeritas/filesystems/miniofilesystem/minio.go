@@ -1,11 +1,18 @@
 package miniofilesystem
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
+	"net/http"
+	"net/url"
 	"path"
 	"strings"
+	"time"
 
 	"github.com/djedjethai/celeritas/filesystems"
 
@@ -34,63 +41,194 @@ func (m *Minio) getCredentials() *minio.Client {
 	return client
 }
 
+// kind returns the filesystems error matching the status of err
+func kind(err error) error {
+	switch minio.ToErrorResponse(err).StatusCode {
+	case http.StatusNotFound:
+		return filesystems.ErrNotFound
+	case http.StatusForbidden:
+		return filesystems.ErrPermission
+	}
+	return nil
+}
+
+func wrap(op, key string, err error) error {
+	return filesystems.NewError(op, key, err, kind(err))
+}
+
 func (m *Minio) Put(fileName, folder string) error {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	// .Base() split the path on "/" and return the last element
-	objectName := path.Base(fileName)
+	objectName := filesystems.CleanKey(path.Join(folder, path.Base(fileName)))
 	// client in order to make the connection to the endpoint
 	client := m.getCredentials()
-	uploadInfo, err := client.FPutObject(ctx, m.Bucket, fmt.Sprintf("%s/%s", folder, objectName), fileName, minio.PutObjectOptions{})
-	if err != nil {
-		log.Println("failed with FPutObject")
-		log.Println(err)
-		log.Println("uploadInfo: ", uploadInfo)
-		return err
-	}
-
-	return nil
+	_, err := client.FPutObject(ctx, m.Bucket, objectName, fileName, minio.PutObjectOptions{
+		ContentType: filesystems.ContentType(objectName, ""),
+	})
+	return wrap("put", objectName, err)
 }
 
+// List returns the files and the folders directly within the folder prefix
 func (m *Minio) List(prefix string) ([]filesystems.Listing, error) {
+	return filesystems.ListAll(context.Background(), m, prefix, false)
+}
+
+// ListPage returns a page of the listing of the folder prefix. The bucket is read
+// page by page until opts.Limit items are found
+func (m *Minio) ListPage(ctx context.Context, prefix string, opts filesystems.ListOptions) (filesystems.Page, error) {
 	var listing []filesystems.Listing
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	core := minio.Core{Client: m.getCredentials()}
+	folder := filesystems.FolderPrefix(prefix)
+	delimiter := "/"
+	if opts.Recursive {
+		delimiter = ""
+	}
+	maxKeys := 1000
+	if opts.Limit > 0 && opts.Limit < maxKeys {
+		maxKeys = opts.Limit + 1
+	}
 
-	client := m.getCredentials()
+	add := func(item filesystems.Listing) {
+		if filesystems.Hidden(strings.TrimPrefix(item.Key, folder)) {
+			return
+		}
+		if opts.Cursor != "" && filesystems.Cursor(item) <= opts.Cursor {
+			return
+		}
+		listing = append(listing, item)
+	}
 
-	objectCh := client.ListObjects(ctx, m.Bucket, minio.ListObjectsOptions{
-		Prefix:    prefix,
-		Recursive: true,
-	})
+	token := ""
+	for {
+		if err := ctx.Err(); err != nil {
+			return filesystems.Page{}, wrap("list", prefix, err)
+		}
 
-	for object := range objectCh {
-		if object.Err != nil {
-			fmt.Println(object.Err)
-			return listing, object.Err
+		result, err := core.ListObjectsV2(m.Bucket, folder, opts.Cursor, token, delimiter, maxKeys)
+		if err != nil {
+			return filesystems.Page{}, wrap("list", prefix, err)
 		}
 
-		if !strings.HasPrefix(object.Key, ".") {
-			b := float64(object.Size)
-			kb := b / 1024
-			mb := kb / 1024
-			item := filesystems.Listing{
+		for _, object := range result.Contents {
+			add(filesystems.Listing{
 				Etag:         object.ETag,
 				LastModified: object.LastModified,
 				Key:          object.Key,
-				Size:         mb,
-			}
+				Size:         object.Size,
+			})
+		}
+		for _, p := range result.CommonPrefixes {
+			add(filesystems.Listing{Key: strings.TrimSuffix(p.Prefix, "/"), IsDir: true})
+		}
+
+		if !result.IsTruncated || (opts.Limit > 0 && len(listing) > opts.Limit) {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+
+	return filesystems.Paginate(listing, opts), nil
+}
+
+// Stat returns the size, type and metadata of key. A key is a folder when there are
+// objects within it
+func (m *Minio) Stat(ctx context.Context, key string) (filesystems.Listing, error) {
+	key = filesystems.CleanKey(key)
+	if key == "" {
+		return filesystems.Listing{IsDir: true}, nil
+	}
+
+	client := m.getCredentials()
+	info, err := client.StatObject(ctx, m.Bucket, key, minio.StatObjectOptions{})
+	if err == nil {
+		return filesystems.Listing{
+			Etag:         info.ETag,
+			LastModified: info.LastModified,
+			Key:          key,
+			Size:         info.Size,
+			ContentType:  info.ContentType,
+			Metadata:     filesystems.CleanMetadata(info.UserMetadata),
+		}, nil
+	}
+	if kind(err) != filesystems.ErrNotFound {
+		return filesystems.Listing{}, wrap("stat", key, err)
+	}
 
-			listing = append(listing, item)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
+	for object := range client.ListObjects(ctx, m.Bucket, minio.ListObjectsOptions{Prefix: key + "/", MaxKeys: 1}) {
+		if object.Err != nil {
+			return filesystems.Listing{}, wrap("stat", key, object.Err)
 		}
+		return filesystems.Listing{Key: key, IsDir: true}, nil
 	}
-	return listing, nil
+	return filesystems.Listing{}, wrap("stat", key, err)
+}
+
+// Exists reports whether key is a file or a folder
+func (m *Minio) Exists(ctx context.Context, key string) (bool, error) {
+	_, err := m.Stat(ctx, key)
+	if errors.Is(err, filesystems.ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// file checks that key is an object
+func (m *Minio) file(ctx context.Context, client *minio.Client, key string) error {
+	_, err := client.StatObject(ctx, m.Bucket, filesystems.CleanKey(key), minio.StatObjectOptions{})
+	return err
+}
+
+// Copy copies the object src, with its metadata, to dst on the server
+func (m *Minio) Copy(ctx context.Context, src, dst string) error {
+	client := m.getCredentials()
+	if err := m.file(ctx, client, src); err != nil {
+		return wrap("copy", src, err)
+	}
+	if filesystems.CleanKey(src) == filesystems.CleanKey(dst) {
+		return nil
+	}
+
+	_, err := client.CopyObject(ctx,
+		minio.CopyDestOptions{Bucket: m.Bucket, Object: filesystems.CleanKey(dst)},
+		minio.CopySrcOptions{Bucket: m.Bucket, Object: filesystems.CleanKey(src)},
+	)
+	return wrap("copy", src, err)
 }
 
-func (m *Minio) Delete(itemsToDelete []string) bool {
+// Move copies the object src to dst, then deletes src
+func (m *Minio) Move(ctx context.Context, src, dst string) error {
+	if err := m.Copy(ctx, src, dst); err != nil {
+		return err
+	}
+	if filesystems.CleanKey(src) == filesystems.CleanKey(dst) {
+		return nil
+	}
+
+	client := m.getCredentials()
+	err := client.RemoveObject(ctx, m.Bucket, filesystems.CleanKey(src), minio.RemoveObjectOptions{})
+	return wrap("move", src, err)
+}
+
+// MkdirAll puts the empty marker dir/, so the folder is listed while it is empty
+func (m *Minio) MkdirAll(ctx context.Context, dir string) error {
+	dir = filesystems.CleanKey(dir)
+	if dir == "" {
+		return nil
+	}
+
+	client := m.getCredentials()
+	_, err := client.PutObject(ctx, m.Bucket, dir+"/", bytes.NewReader(nil), 0, minio.PutObjectOptions{})
+	return wrap("mkdir", dir, err)
+}
+
+// Delete removes the objects, stopping at the first one missing or failing
+func (m *Minio) Delete(itemsToDelete []string) error {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
@@ -101,13 +239,17 @@ func (m *Minio) Delete(itemsToDelete []string) bool {
 	}
 
 	for _, item := range itemsToDelete {
-		err := client.RemoveObject(ctx, m.Bucket, item, opts)
+		// RemoveObject succeeds for a missing object
+		if err := m.file(ctx, client, item); err != nil {
+			return wrap("delete", item, err)
+		}
+
+		err := client.RemoveObject(ctx, m.Bucket, filesystems.CleanKey(item), opts)
 		if err != nil {
-			fmt.Println(err)
-			return false
+			return wrap("delete", item, err)
 		}
 	}
-	return true
+	return nil
 }
 
 func (m *Minio) Get(destination string, items ...string) error {
@@ -117,11 +259,104 @@ func (m *Minio) Get(destination string, items ...string) error {
 	client := m.getCredentials()
 
 	for _, item := range items {
-		err := client.FGetObject(ctx, m.Bucket, item, fmt.Sprintf("%s/%s", destination, path.Base(item)), minio.GetObjectOptions{})
+		err := client.FGetObject(ctx, m.Bucket, filesystems.CleanKey(item), fmt.Sprintf("%s/%s", destination, path.Base(item)), minio.GetObjectOptions{})
 		if err != nil {
-			fmt.Println(err)
-			return err
+			return wrap("get", item, err)
 		}
 	}
 	return nil
 }
+
+// PutStream uploads the content of r to key
+func (m *Minio) PutStream(ctx context.Context, key string, r io.Reader, opts filesystems.PutOptions) error {
+	size := opts.Size
+	if size <= 0 {
+		// unknown size, minio uploads it in parts
+		size = -1
+	}
+
+	key = filesystems.CleanKey(key)
+	client := m.getCredentials()
+	_, err := client.PutObject(ctx, m.Bucket, key, r, size, minio.PutObjectOptions{
+		ContentType:  filesystems.ContentType(key, opts.ContentType),
+		UserMetadata: filesystems.CleanMetadata(opts.Metadata),
+	})
+	return wrap("put", key, err)
+}
+
+// Open returns a reader of key
+func (m *Minio) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	client := m.getCredentials()
+	object, err := client.GetObject(ctx, m.Bucket, filesystems.CleanKey(key), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, wrap("open", key, err)
+	}
+
+	// GetObject does not request the object until it is read,
+	// stat it so a missing key is reported here
+	if _, err := object.Stat(); err != nil {
+		object.Close()
+		return nil, wrap("open", key, err)
+	}
+	return object, nil
+}
+
+// OpenRange returns a reader of length bytes of key from offset, requested as a range
+func (m *Minio) OpenRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	if offset == 0 && length < 0 {
+		return m.Open(ctx, key)
+	}
+
+	var opts minio.GetObjectOptions
+	end := int64(0)
+	if length >= 0 {
+		if length == 0 {
+			return ioutil.NopCloser(strings.NewReader("")), nil
+		}
+		end = offset + length - 1
+	}
+	if err := opts.SetRange(offset, end); err != nil {
+		return nil, wrap("open", key, err)
+	}
+
+	client := m.getCredentials()
+	object, err := client.GetObject(ctx, m.Bucket, filesystems.CleanKey(key), opts)
+	if err != nil {
+		return nil, wrap("open", key, err)
+	}
+	if _, err := object.Stat(); err != nil {
+		object.Close()
+		return nil, wrap("open", key, err)
+	}
+	return object, nil
+}
+
+// TemporaryURL returns a presigned url to get or put key
+func (m *Minio) TemporaryURL(key string, expiry time.Duration, opts filesystems.URLOptions) (string, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := m.getCredentials()
+
+	var u *url.URL
+	var err error
+	switch opts.Method {
+	case "", http.MethodGet:
+		params := make(url.Values)
+		if opts.Filename != "" {
+			params.Set("response-content-disposition", filesystems.AttachmentDisposition(opts.Filename))
+		}
+		if opts.ContentType != "" {
+			params.Set("response-content-type", opts.ContentType)
+		}
+		u, err = client.PresignedGetObject(ctx, m.Bucket, key, expiry, params)
+	case http.MethodPut:
+		u, err = client.PresignedPutObject(ctx, m.Bucket, key, expiry)
+	default:
+		return "", fmt.Errorf("unsupported method %s for a temporary url", opts.Method)
+	}
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
@@ -0,0 +1,45 @@
+package miniofilesystem
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/djedjethai/celeritas/filesystems/fstest"
+)
+
+// TestMinio runs against the bucket set by the FSTEST_MINIO_* variables
+func TestMinio(t *testing.T) {
+	if os.Getenv("FSTEST_MINIO_ENDPOINT") == "" {
+		t.Skip("FSTEST_MINIO_ENDPOINT not set")
+	}
+
+	fstest.TestFS(t, &Minio{
+		Endpoint: os.Getenv("FSTEST_MINIO_ENDPOINT"),
+		Key:      os.Getenv("FSTEST_MINIO_KEY"),
+		Secret:   os.Getenv("FSTEST_MINIO_SECRET"),
+		UseSSL:   os.Getenv("FSTEST_MINIO_USESSL") == "true",
+		Region:   os.Getenv("FSTEST_MINIO_REGION"),
+		Bucket:   os.Getenv("FSTEST_MINIO_BUCKET"),
+	}, "")
+}
+
+func TestMinio_streams(t *testing.T) {
+	srv := fstest.NewS3Server(t)
+	fs := &Minio{
+		Endpoint: strings.TrimPrefix(srv.URL, "http://"),
+		Key:      "key",
+		Secret:   "secret",
+		Bucket:   "fstest",
+	}
+	fstest.TestStreams(t, fs, "streams")
+
+	// the unsized stream went up in parts
+	if data, _ := srv.Object("fstest", "streams/unsized.txt"); len(data) == 0 {
+		t.Error("unsized stream not stored")
+	}
+	_, header := srv.Object("fstest", "streams/sized.txt")
+	if header.Get("Content-Type") != "text/plain" || header.Get("X-Amz-Meta-Owner") != "jane" {
+		t.Errorf("unexpected object headers %v", header)
+	}
+}
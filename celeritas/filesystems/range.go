@@ -0,0 +1,37 @@
+package filesystems
+
+import (
+	"io"
+	"io/ioutil"
+)
+
+// SeekRange limits rc, a file just opened, to length bytes from offset, or to the rest
+// of it when length is negative. The files which can seek do, the others skip offset
+// bytes. rc is closed on error
+func SeekRange(rc io.ReadCloser, offset, length int64) (io.ReadCloser, error) {
+	if offset > 0 {
+		var err error
+		if seeker, ok := rc.(io.Seeker); ok {
+			_, err = seeker.Seek(offset, io.SeekStart)
+		} else {
+			_, err = io.CopyN(ioutil.Discard, rc, offset)
+			if err == io.EOF {
+				err = nil
+			}
+		}
+		if err != nil {
+			rc.Close()
+			return nil, err
+		}
+	}
+
+	if length < 0 {
+		return rc, nil
+	}
+	return limitedReadCloser{Reader: io.LimitReader(rc, length), Closer: rc}, nil
+}
+
+type limitedReadCloser struct {
+	io.Reader
+	io.Closer
+}
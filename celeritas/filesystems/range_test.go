@@ -0,0 +1,63 @@
+package filesystems_test
+
+import (
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/djedjethai/celeritas/filesystems"
+)
+
+// seekCloser is a file which can seek
+type seekCloser struct {
+	*strings.Reader
+}
+
+func (seekCloser) Close() error { return nil }
+
+func TestSeekRange(t *testing.T) {
+	tests := []struct {
+		name           string
+		offset, length int64
+		expected       string
+	}{
+		{"whole", 0, -1, "0123456789"},
+		{"middle", 2, 3, "234"},
+		{"rest", 7, -1, "789"},
+		{"past the end", 8, 10, "89"},
+	}
+	for _, tt := range tests {
+		files := map[string]io.ReadCloser{
+			"seeker":   seekCloser{strings.NewReader("0123456789")},
+			"skipping": ioutil.NopCloser(strings.NewReader("0123456789")),
+		}
+		for kind, file := range files {
+			r, err := filesystems.SeekRange(file, tt.offset, tt.length)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, _ := ioutil.ReadAll(r)
+			if string(got) != tt.expected {
+				t.Errorf("%s, %s: expected %q, got %q", tt.name, kind, tt.expected, got)
+			}
+		}
+	}
+}
+
+func TestContentDisposition(t *testing.T) {
+	tests := []struct {
+		disposition, filename, expected string
+	}{
+		{"attachment", "report.pdf", "attachment; filename=report.pdf"},
+		{"inline", "my report.pdf", `inline; filename="my report.pdf"`},
+		{"attachment", `say "hi".txt`, `attachment; filename="say _hi_.txt"; filename*=UTF-8''say%20%22hi%22.txt`},
+		{"attachment", "café €.pdf", `attachment; filename="caf_ _.pdf"; filename*=UTF-8''caf%C3%A9%20%E2%82%AC.pdf`},
+		{"inline", "", "inline"},
+	}
+	for _, tt := range tests {
+		if got := filesystems.ContentDisposition(tt.disposition, tt.filename); got != tt.expected {
+			t.Errorf("%s: expected %s, got %s", tt.filename, tt.expected, got)
+		}
+	}
+}
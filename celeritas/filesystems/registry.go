@@ -0,0 +1,82 @@
+package filesystems
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// ErrUnknownDisk is returned for a disk which is not registered
+var ErrUnknownDisk = errors.New("filesystems: unknown disk")
+
+// Registry holds the file systems of the application, the disks, by name
+type Registry struct {
+	mu          sync.RWMutex
+	disks       map[string]FS
+	defaultDisk string
+}
+
+// NewRegistry returns an empty registry
+func NewRegistry() *Registry {
+	return &Registry{disks: make(map[string]FS)}
+}
+
+// Add registers fs as the disk name, replacing the disk with the same name. The
+// first disk added is the default one, until SetDefault is called
+func (r *Registry) Add(name string, fs FS) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.disks[name] = fs
+	if r.defaultDisk == "" {
+		r.defaultDisk = name
+	}
+}
+
+// SetDefault makes name the disk returned for an empty name
+func (r *Registry) SetDefault(name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.disks[name]; !ok {
+		return fmt.Errorf("%w %q", ErrUnknownDisk, name)
+	}
+	r.defaultDisk = name
+	return nil
+}
+
+// Disk returns the disk registered as name, or the default disk when name is empty
+func (r *Registry) Disk(name string) (FS, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if name == "" {
+		name = r.defaultDisk
+	}
+	fs, ok := r.disks[name]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownDisk, name)
+	}
+	return fs, nil
+}
+
+// Default returns the name of the default disk
+func (r *Registry) Default() string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.defaultDisk
+}
+
+// Names returns the names of the disks, sorted
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.disks))
+	for name := range r.disks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
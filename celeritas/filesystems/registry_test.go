@@ -0,0 +1,46 @@
+package filesystems_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/djedjethai/celeritas/filesystems"
+	"github.com/djedjethai/celeritas/filesystems/memfilesystem"
+)
+
+func TestRegistry(t *testing.T) {
+	r := filesystems.NewRegistry()
+	if _, err := r.Disk(""); !errors.Is(err, filesystems.ErrUnknownDisk) {
+		t.Errorf("expected ErrUnknownDisk without disks, got %v", err)
+	}
+
+	local := &memfilesystem.Mem{}
+	avatars := &memfilesystem.Mem{}
+	r.Add("local", local)
+	r.Add("avatars", avatars)
+
+	if fs, err := r.Disk(""); err != nil || fs != local {
+		t.Errorf("the first disk is not the default one: %v", err)
+	}
+	if fs, err := r.Disk("avatars"); err != nil || fs != avatars {
+		t.Errorf("wrong avatars disk: %v", err)
+	}
+	if _, err := r.Disk("backups"); !errors.Is(err, filesystems.ErrUnknownDisk) {
+		t.Errorf("expected ErrUnknownDisk, got %v", err)
+	}
+
+	if err := r.SetDefault("avatars"); err != nil {
+		t.Fatal(err)
+	}
+	if fs, _ := r.Disk(""); fs != avatars || r.Default() != "avatars" {
+		t.Error("default disk not changed")
+	}
+	if err := r.SetDefault("backups"); err == nil {
+		t.Error("expected an error for an unknown default disk")
+	}
+
+	if got := strings.Join(r.Names(), ","); got != "avatars,local" {
+		t.Errorf("wrong names: %s", got)
+	}
+}
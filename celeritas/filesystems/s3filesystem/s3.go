@@ -2,14 +2,22 @@ package s3filesystem
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
@@ -22,6 +30,9 @@ type S3 struct {
 	Region   string
 	Endpoint string
 	Bucket   string
+	// ACL is the canned ACL, such as public-read, of the objects written by PutStream
+	// and Copy. They are private when it is empty
+	ACL string
 }
 
 func (s *S3) getCredentials() *credentials.Credentials {
@@ -30,15 +41,6 @@ func (s *S3) getCredentials() *credentials.Credentials {
 }
 
 func (s *S3) Put(fileName, folder string) error {
-	c := s.getCredentials()
-	sess := session.Must(session.NewSession(&aws.Config{
-		Endpoint:    &s.Endpoint,
-		Region:      &s.Region,
-		Credentials: c,
-	}))
-
-	uploader := s3manager.NewUploader(sess)
-
 	f, err := os.Open(fileName)
 	if err != nil {
 		return err
@@ -50,37 +52,37 @@ func (s *S3) Put(fileName, folder string) error {
 		return err
 	}
 
-	var size = fileInfo.Size()
+	return s.upload(context.Background(), path.Join(folder, path.Base(fileName)), f, filesystems.PutOptions{
+		Size: fileInfo.Size(),
+	}, "public-read")
+}
 
-	buffer := make([]byte, size)
-	_, err = f.Read(buffer)
-	if err != nil {
-		return err
+// kind returns the filesystems error matching the status of err
+func kind(err error) error {
+	if reqErr, ok := err.(awserr.RequestFailure); ok {
+		switch reqErr.StatusCode() {
+		case http.StatusNotFound:
+			return filesystems.ErrNotFound
+		case http.StatusForbidden:
+			return filesystems.ErrPermission
+		}
 	}
-
-	fileBytes := bytes.NewReader(buffer)
-	fileType := http.DetectContentType(buffer)
-
-	_, err = uploader.Upload(&s3manager.UploadInput{
-		Bucket:      aws.String(s.Bucket),
-		Key:         aws.String(fmt.Sprintf("%s/%s", folder, path.Base(fileName))),
-		Body:        fileBytes,
-		ACL:         aws.String("public-read"),
-		ContentType: aws.String(fileType),
-		Metadata: map[string]*string{
-			"key": aws.String("MetadataValue"),
-		},
-	})
-	if err != nil {
-		return err
+	if aerr, ok := err.(awserr.Error); ok {
+		switch aerr.Code() {
+		case s3.ErrCodeNoSuchKey, "NotFound":
+			return filesystems.ErrNotFound
+		case "AccessDenied":
+			return filesystems.ErrPermission
+		}
 	}
-
 	return nil
 }
 
-func (s *S3) List(prefix string) ([]filesystems.Listing, error) {
-	var listing []filesystems.Listing
+func wrap(op, key string, err error) error {
+	return filesystems.NewError(op, key, err, kind(err))
+}
 
+func (s *S3) client() *s3.S3 {
 	c := s.getCredentials()
 	sess := session.Must(session.NewSession(&aws.Config{
 		Endpoint:    &s.Endpoint,
@@ -88,84 +90,198 @@ func (s *S3) List(prefix string) ([]filesystems.Listing, error) {
 		Credentials: c,
 	}))
 
-	svc := s3.New(sess)
-	input := &s3.ListObjectsInput{
+	return s3.New(sess)
+}
+
+// List returns the files and the folders directly within the folder prefix
+func (s *S3) List(prefix string) ([]filesystems.Listing, error) {
+	return filesystems.ListAll(context.Background(), s, prefix, false)
+}
+
+// ListPage returns a page of the listing of the folder prefix. The bucket is read
+// page by page until opts.Limit items are found
+func (s *S3) ListPage(ctx context.Context, prefix string, opts filesystems.ListOptions) (filesystems.Page, error) {
+	var listing []filesystems.Listing
+
+	svc := s.client()
+	folder := filesystems.FolderPrefix(prefix)
+	input := &s3.ListObjectsV2Input{
 		Bucket: aws.String(s.Bucket),
-		Prefix: aws.String(prefix),
+		Prefix: aws.String(folder),
+	}
+	if !opts.Recursive {
+		input.Delimiter = aws.String("/")
+	}
+	if opts.Cursor != "" {
+		input.StartAfter = aws.String(opts.Cursor)
+	}
+	if opts.Limit > 0 && opts.Limit < 1000 {
+		input.MaxKeys = aws.Int64(int64(opts.Limit + 1))
 	}
 
-	result, err := svc.ListObjects(input)
-	if err != nil {
-		// check if err comes from aws or not
-		// aerr == aws err
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			case s3.ErrCodeNoSuchBucket:
-				fmt.Println(s3.ErrCodeNoSuchBucket, aerr.Error())
-			default:
-				fmt.Println(aerr.Error())
-			}
-		} else {
-			fmt.Println(aerr.Error())
+	add := func(item filesystems.Listing) {
+		if filesystems.Hidden(strings.TrimPrefix(item.Key, folder)) {
+			return
 		}
-		return nil, err
-	}
-
-	for _, key := range result.Contents {
-		b := float64(*key.Size)
-		kb := b / 1024
-		mb := kb / 1024
-		current := filesystems.Listing{
-			Etag:         *key.ETag,
-			LastModified: *key.LastModified,
-			Key:          *key.Key,
-			Size:         mb,
+		if opts.Cursor != "" && filesystems.Cursor(item) <= opts.Cursor {
+			return
 		}
-		listing = append(listing, current)
+		listing = append(listing, item)
+	}
 
+	err := svc.ListObjectsV2PagesWithContext(ctx, input, func(page *s3.ListObjectsV2Output, last bool) bool {
+		for _, key := range page.Contents {
+			add(filesystems.Listing{
+				Etag:         aws.StringValue(key.ETag),
+				LastModified: aws.TimeValue(key.LastModified),
+				Key:          aws.StringValue(key.Key),
+				Size:         aws.Int64Value(key.Size),
+			})
+		}
+		for _, p := range page.CommonPrefixes {
+			add(filesystems.Listing{Key: strings.TrimSuffix(aws.StringValue(p.Prefix), "/"), IsDir: true})
+		}
+
+		// stop once the page is full
+		return opts.Limit == 0 || len(listing) <= opts.Limit
+	})
+	if err != nil {
+		return filesystems.Page{}, wrap("list", prefix, err)
 	}
 
-	return listing, nil
+	return filesystems.Paginate(listing, opts), nil
 }
 
-func (s *S3) Delete(itemsToDelete []string) bool {
-	c := s.getCredentials()
-	sess := session.Must(session.NewSession(&aws.Config{
-		Endpoint:    &s.Endpoint,
-		Region:      &s.Region,
-		Credentials: c,
-	}))
+// Stat returns the size, type and metadata of key. A key is a folder when there are
+// objects within it
+func (s *S3) Stat(ctx context.Context, key string) (filesystems.Listing, error) {
+	key = filesystems.CleanKey(key)
+	if key == "" {
+		return filesystems.Listing{IsDir: true}, nil
+	}
+
+	svc := s.client()
+	out, err := svc.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+	})
+	if err == nil {
+		return filesystems.Listing{
+			Etag:         aws.StringValue(out.ETag),
+			LastModified: aws.TimeValue(out.LastModified),
+			Key:          key,
+			Size:         aws.Int64Value(out.ContentLength),
+			ContentType:  aws.StringValue(out.ContentType),
+			Metadata:     filesystems.CleanMetadata(aws.StringValueMap(out.Metadata)),
+		}, nil
+	}
+	if kind(err) != filesystems.ErrNotFound {
+		return filesystems.Listing{}, wrap("stat", key, err)
+	}
+
+	list, listErr := svc.ListObjectsV2WithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket:  aws.String(s.Bucket),
+		Prefix:  aws.String(key + "/"),
+		MaxKeys: aws.Int64(1),
+	})
+	if listErr != nil {
+		return filesystems.Listing{}, wrap("stat", key, listErr)
+	}
+	if len(list.Contents) > 0 {
+		return filesystems.Listing{Key: key, IsDir: true}, nil
+	}
+	return filesystems.Listing{}, wrap("stat", key, err)
+}
+
+// Exists reports whether key is a file or a folder
+func (s *S3) Exists(ctx context.Context, key string) (bool, error) {
+	_, err := s.Stat(ctx, key)
+	if errors.Is(err, filesystems.ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// file checks that key is an object
+func (s *S3) file(ctx context.Context, svc *s3.S3, key string) error {
+	_, err := svc.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(filesystems.CleanKey(key)),
+	})
+	return err
+}
+
+// Copy copies the object src, with its metadata, to dst on the server
+func (s *S3) Copy(ctx context.Context, src, dst string) error {
+	svc := s.client()
+	if err := s.file(ctx, svc, src); err != nil {
+		return wrap("copy", src, err)
+	}
+	if filesystems.CleanKey(src) == filesystems.CleanKey(dst) {
+		return nil
+	}
+
+	_, err := svc.CopyObjectWithContext(ctx, &s3.CopyObjectInput{
+		Bucket:     aws.String(s.Bucket),
+		CopySource: aws.String((&url.URL{Path: s.Bucket + "/" + filesystems.CleanKey(src)}).EscapedPath()),
+		Key:        aws.String(filesystems.CleanKey(dst)),
+		ACL:        cannedACL(s.ACL),
+	})
+	return wrap("copy", src, err)
+}
+
+// Move copies the object src to dst, then deletes src
+func (s *S3) Move(ctx context.Context, src, dst string) error {
+	if err := s.Copy(ctx, src, dst); err != nil {
+		return err
+	}
+	if filesystems.CleanKey(src) == filesystems.CleanKey(dst) {
+		return nil
+	}
+
+	_, err := s.client().DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(filesystems.CleanKey(src)),
+	})
+	return wrap("move", src, err)
+}
+
+// MkdirAll puts the empty marker dir/, so the folder is listed while it is empty
+func (s *S3) MkdirAll(ctx context.Context, dir string) error {
+	dir = filesystems.CleanKey(dir)
+	if dir == "" {
+		return nil
+	}
+
+	_, err := s.client().PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(dir + "/"),
+		Body:   bytes.NewReader(nil),
+	})
+	return wrap("mkdir", dir, err)
+}
+
+// Delete removes the objects, stopping at the first one missing or failing
+func (s *S3) Delete(itemsToDelete []string) error {
+	ctx := context.Background()
+	svc := s.client()
 
-	svc := s3.New(sess)
 	for _, item := range itemsToDelete {
-		input := &s3.DeleteObjectsInput{
-			Bucket: aws.String(s.Bucket),
-			Delete: &s3.Delete{
-				Objects: []*s3.ObjectIdentifier{
-					{
-						Key: aws.String(item),
-					},
-				},
-				Quiet: aws.Bool(false),
-			},
+		// DeleteObject succeeds for a missing object
+		if err := s.file(ctx, svc, item); err != nil {
+			return wrap("delete", item, err)
 		}
 
-		_, err := svc.DeleteObjects(input)
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			// here should switch on an aws error code
-			default:
-				fmt.Println("aws error: ", aerr.Error())
-				return false
-			}
-		} else {
-			fmt.Println("Other error: ", err)
-			return false
+		_, err := svc.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+			Bucket: aws.String(s.Bucket),
+			Key:    aws.String(filesystems.CleanKey(item)),
+		})
+		if err != nil {
+			return wrap("delete", item, err)
 		}
-
 	}
 
-	return true
+	return nil
 }
 
 func (s *S3) Get(destination string, items ...string) error {
@@ -178,7 +294,7 @@ func (s *S3) Get(destination string, items ...string) error {
 
 	for _, item := range items {
 		err := func() error {
-			file, err := os.Create(fmt.Sprintf("%s/%s", destination, item))
+			file, err := os.Create(fmt.Sprintf("%s/%s", destination, path.Base(item)))
 			if err != nil {
 				return err
 			}
@@ -188,7 +304,7 @@ func (s *S3) Get(destination string, items ...string) error {
 			_, err = downloader.Download(file,
 				&s3.GetObjectInput{
 					Bucket: aws.String(s.Bucket),
-					Key:    aws.String(item),
+					Key:    aws.String(filesystems.CleanKey(item)),
 				},
 			)
 
@@ -200,9 +316,120 @@ func (s *S3) Get(destination string, items ...string) error {
 		}()
 
 		if err != nil {
-			return err
+			return wrap("get", item, err)
 		}
 	}
 
 	return nil
 }
+
+// PutStream uploads the content of r to key, in parts when it is large
+func (s *S3) PutStream(ctx context.Context, key string, r io.Reader, opts filesystems.PutOptions) error {
+	return s.upload(ctx, key, r, opts, s.ACL)
+}
+
+// upload uploads the content of r to key with the canned acl, none when empty
+func (s *S3) upload(ctx context.Context, key string, r io.Reader, opts filesystems.PutOptions, acl string) error {
+	c := s.getCredentials()
+	sess := session.Must(session.NewSession(&aws.Config{
+		Endpoint:    &s.Endpoint,
+		Region:      &s.Region,
+		Credentials: c,
+	}))
+
+	key = filesystems.CleanKey(key)
+	input := &s3manager.UploadInput{
+		Bucket:      aws.String(s.Bucket),
+		Key:         aws.String(key),
+		Body:        r,
+		ACL:         cannedACL(acl),
+		ContentType: aws.String(filesystems.ContentType(key, opts.ContentType)),
+		Metadata:    aws.StringMap(filesystems.CleanMetadata(opts.Metadata)),
+	}
+
+	uploader := s3manager.NewUploader(sess)
+	_, err := uploader.UploadWithContext(ctx, input)
+	return wrap("put", key, err)
+}
+
+// cannedACL returns the canned acl of a request, nil for the default private one
+func cannedACL(acl string) *string {
+	if acl == "" {
+		return nil
+	}
+	return aws.String(acl)
+}
+
+// Open returns a reader of key
+func (s *S3) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	out, err := s.client().GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(filesystems.CleanKey(key)),
+	})
+	if err != nil {
+		return nil, wrap("open", key, err)
+	}
+	return out.Body, nil
+}
+
+// OpenRange returns a reader of length bytes of key from offset, requested as a range
+func (s *S3) OpenRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(filesystems.CleanKey(key)),
+	}
+	switch {
+	case length == 0:
+		return ioutil.NopCloser(strings.NewReader("")), nil
+	case length > 0:
+		input.Range = aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	case offset > 0:
+		input.Range = aws.String(fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	out, err := s.client().GetObjectWithContext(ctx, input)
+	if err != nil {
+		return nil, wrap("open", key, err)
+	}
+	return out.Body, nil
+}
+
+// TemporaryURL returns a presigned url to get or put key
+func (s *S3) TemporaryURL(key string, expiry time.Duration, opts filesystems.URLOptions) (string, error) {
+	c := s.getCredentials()
+	sess := session.Must(session.NewSession(&aws.Config{
+		Endpoint:    &s.Endpoint,
+		Region:      &s.Region,
+		Credentials: c,
+	}))
+
+	svc := s3.New(sess)
+	var req *request.Request
+	switch opts.Method {
+	case "", http.MethodGet:
+		input := &s3.GetObjectInput{
+			Bucket: aws.String(s.Bucket),
+			Key:    aws.String(key),
+		}
+		if opts.Filename != "" {
+			input.ResponseContentDisposition = aws.String(filesystems.AttachmentDisposition(opts.Filename))
+		}
+		if opts.ContentType != "" {
+			input.ResponseContentType = aws.String(opts.ContentType)
+		}
+		req, _ = svc.GetObjectRequest(input)
+	case http.MethodPut:
+		input := &s3.PutObjectInput{
+			Bucket: aws.String(s.Bucket),
+			Key:    aws.String(key),
+		}
+		if opts.ContentType != "" {
+			input.ContentType = aws.String(opts.ContentType)
+		}
+		req, _ = svc.PutObjectRequest(input)
+	default:
+		return "", fmt.Errorf("unsupported method %s for a temporary url", opts.Method)
+	}
+
+	return req.Presign(expiry)
+}
@@ -0,0 +1,56 @@
+package s3filesystem
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/djedjethai/celeritas/filesystems"
+	"github.com/djedjethai/celeritas/filesystems/fstest"
+)
+
+// TestS3 runs against the bucket set by the FSTEST_S3_* variables
+func TestS3(t *testing.T) {
+	if os.Getenv("FSTEST_S3_BUCKET") == "" {
+		t.Skip("FSTEST_S3_BUCKET not set")
+	}
+
+	fstest.TestFS(t, &S3{
+		Key:      os.Getenv("FSTEST_S3_KEY"),
+		Secret:   os.Getenv("FSTEST_S3_SECRET"),
+		Region:   os.Getenv("FSTEST_S3_REGION"),
+		Endpoint: os.Getenv("FSTEST_S3_ENDPOINT"),
+		Bucket:   os.Getenv("FSTEST_S3_BUCKET"),
+	}, "")
+}
+
+func TestS3_streams(t *testing.T) {
+	srv := fstest.NewS3Server(t)
+	fs := &S3{
+		Endpoint: srv.URL,
+		Key:      "key",
+		Secret:   "secret",
+		Region:   "us-east-1",
+		// not a dns name, so the sdk puts it in the path
+		Bucket: "FSTest",
+	}
+	fstest.TestStreams(t, fs, "streams")
+
+	_, header := srv.Object("FSTest", "streams/sized.txt")
+	if header.Get("Content-Type") != "text/plain" || header.Get("X-Amz-Meta-Owner") != "jane" {
+		t.Errorf("unexpected object headers %v", header)
+	}
+	// the objects are private unless the disk says otherwise
+	if acl := header.Get("X-Amz-Acl"); acl != "" {
+		t.Errorf("expected no acl, got %s", acl)
+	}
+
+	fs.ACL = "public-read"
+	if err := fs.PutStream(context.Background(), "streams/public.txt", strings.NewReader("shared"), filesystems.PutOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, header := srv.Object("FSTest", "streams/public.txt"); header.Get("X-Amz-Acl") != "public-read" {
+		t.Errorf("expected the acl of the disk, got %v", header)
+	}
+}
@@ -0,0 +1,321 @@
+package sftpfilesystem
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const keepAliveInterval = 30 * time.Second
+
+// pooled is the connection shared by every SFTP with the same user, host and port
+type pooled struct {
+	ssh  *ssh.Client
+	sftp *sftp.Client
+	done chan struct{}
+	once sync.Once
+}
+
+func (p *pooled) close() {
+	p.once.Do(func() {
+		close(p.done)
+		_ = p.sftp.Close()
+		_ = p.ssh.Close()
+	})
+}
+
+// dialing is a connection being opened, which the other callers of the same pool key
+// wait for rather than opening their own
+type dialing struct {
+	done chan struct{}
+	p    *pooled
+	err  error
+}
+
+var (
+	// poolMu guards pool and dials, and is never held while connecting
+	poolMu sync.Mutex
+	pool   = make(map[string]*pooled)
+	dials  = make(map[string]*dialing)
+)
+
+// poolKey identifies the connection of s: two SFTP share it only when they log in the
+// same way and check the host key the same way. The secrets are hashed
+func (s *SFTP) poolKey() string {
+	h := sha256.New()
+	for _, setting := range []string{
+		s.Pass, s.KeyFile, s.KeyPassphrase, strconv.FormatBool(s.UseAgent),
+		s.Fingerprint, s.KnownHosts, strconv.FormatBool(s.InsecureSkipHostKey),
+	} {
+		h.Write([]byte(setting))
+		h.Write([]byte{0})
+	}
+	return fmt.Sprintf("%s@%s:%s/%x", s.User, s.Host, s.Port, h.Sum(nil))
+}
+
+// getCredentials returns the pooled client of the server, connecting when there is none.
+// The callers asking for the same connection while it is opened wait for it, and the
+// others are not held up by a slow server
+func (s *SFTP) getCredentials() (*sftp.Client, error) {
+	key := s.poolKey()
+
+	poolMu.Lock()
+	if p, ok := pool[key]; ok {
+		poolMu.Unlock()
+		return p.sftp, nil
+	}
+	if d, ok := dials[key]; ok {
+		poolMu.Unlock()
+		<-d.done
+		if d.err != nil {
+			return nil, d.err
+		}
+		return d.p.sftp, nil
+	}
+	d := &dialing{done: make(chan struct{})}
+	dials[key] = d
+	poolMu.Unlock()
+
+	d.p, d.err = s.dial()
+
+	poolMu.Lock()
+	delete(dials, key)
+	if d.err == nil {
+		pool[key] = d.p
+	}
+	poolMu.Unlock()
+	close(d.done)
+
+	if d.err != nil {
+		return nil, d.err
+	}
+	go s.keepAlive(key, d.p)
+	return d.p.sftp, nil
+}
+
+func (s *SFTP) dial() (*pooled, error) {
+	auth, closeAgent, err := s.authMethods()
+	if err != nil {
+		return nil, err
+	}
+	defer closeAgent()
+
+	hostKeyCallback, err := s.hostKeyCallback()
+	if err != nil {
+		return nil, err
+	}
+
+	addr := fmt.Sprintf("%s:%s", s.Host, s.Port)
+	config := &ssh.ClientConfig{
+		User:            s.User,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         10 * time.Second,
+	}
+	conn, err := ssh.Dial("tcp", addr, config)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &pooled{ssh: conn, sftp: client, done: make(chan struct{})}, nil
+}
+
+// keepAlive pings the server so idle connections are not dropped, and removes the
+// connection from the pool once it is lost
+func (s *SFTP) keepAlive(key string, p *pooled) {
+	ticker := time.NewTicker(keepAliveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+			if _, _, err := p.ssh.SendRequest("keepalive@openssh.com", true, nil); err != nil {
+				drop(key, p)
+				return
+			}
+		}
+	}
+}
+
+func drop(key string, p *pooled) {
+	poolMu.Lock()
+	if pool[key] == p {
+		delete(pool, key)
+	}
+	poolMu.Unlock()
+	p.close()
+}
+
+// lost reports whether err comes from the connection of client being gone, and then
+// removes it from the pool. The errors the server answered with, such as a missing
+// file, tell the connection works, so only the transport errors are probed
+func (s *SFTP) lost(client *sftp.Client, err error) bool {
+	if !transportError(err) {
+		return false
+	}
+	key := s.poolKey()
+
+	poolMu.Lock()
+	p, ok := pool[key]
+	poolMu.Unlock()
+	if !ok || p.sftp != client {
+		// already replaced
+		return true
+	}
+
+	if _, _, err := p.ssh.SendRequest("keepalive@openssh.com", true, nil); err != nil {
+		drop(key, p)
+		return true
+	}
+	return false
+}
+
+// do runs fn with the pooled client. When fn fails because the connection was lost,
+// the next call reconnects, and fn is run again on a new connection if retry is set
+func (s *SFTP) do(retry bool, fn func(client *sftp.Client) error) error {
+	client, err := s.getCredentials()
+	if err != nil {
+		return err
+	}
+
+	err = fn(client)
+	if err == nil || !s.lost(client, err) || !retry {
+		return err
+	}
+
+	client, err = s.getCredentials()
+	if err != nil {
+		return err
+	}
+	return fn(client)
+}
+
+// transportError reports whether err may come from a lost connection rather than from
+// an answer of the server
+func transportError(err error) bool {
+	var status *sftp.StatusError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &status):
+		return false
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, net.ErrClosed),
+		errors.Is(err, sftp.ErrSSHFxConnectionLost), errors.Is(err, sftp.ErrSSHFxNoConnection),
+		errors.As(err, &netErr):
+		return true
+	}
+	return false
+}
+
+// Close closes the pooled connection to the server; the next call reconnects
+func (s *SFTP) Close() error {
+	key := s.poolKey()
+
+	poolMu.Lock()
+	p, ok := pool[key]
+	poolMu.Unlock()
+
+	if ok {
+		drop(key, p)
+	}
+	return nil
+}
+
+// authMethods returns the ssh agent, the private key and the password, the ones set.
+// The agent signs during the handshake, so its connection is closed by the returned func
+func (s *SFTP) authMethods() ([]ssh.AuthMethod, func(), error) {
+	var methods []ssh.AuthMethod
+	closeAgent := func() {}
+
+	if s.UseAgent {
+		sock := os.Getenv("SSH_AUTH_SOCK")
+		if sock == "" {
+			return nil, closeAgent, errors.New("sftp: UseAgent requires SSH_AUTH_SOCK")
+		}
+		conn, err := net.Dial("unix", sock)
+		if err != nil {
+			return nil, closeAgent, err
+		}
+		closeAgent = func() { conn.Close() }
+		methods = append(methods, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+	}
+
+	if s.KeyFile != "" {
+		pem, err := ioutil.ReadFile(s.KeyFile)
+		if err != nil {
+			closeAgent()
+			return nil, func() {}, err
+		}
+
+		var signer ssh.Signer
+		if s.KeyPassphrase != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(pem, []byte(s.KeyPassphrase))
+		} else {
+			signer, err = ssh.ParsePrivateKey(pem)
+		}
+		if err != nil {
+			closeAgent()
+			return nil, func() {}, fmt.Errorf("sftp: %s: %w", s.KeyFile, err)
+		}
+		methods = append(methods, ssh.PublicKeys(signer))
+	}
+
+	if s.Pass != "" {
+		methods = append(methods, ssh.Password(s.Pass))
+	}
+
+	if len(methods) == 0 {
+		return nil, closeAgent, errors.New("sftp: no authentication set; use a password, a key file or the ssh agent")
+	}
+	return methods, closeAgent, nil
+}
+
+// hostKeyCallback checks the key of the server against Fingerprint when it is set,
+// or else the KnownHosts file, ~/.ssh/known_hosts by default
+func (s *SFTP) hostKeyCallback() (ssh.HostKeyCallback, error) {
+	if s.InsecureSkipHostKey {
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+
+	if s.Fingerprint != "" {
+		expected := strings.TrimPrefix(s.Fingerprint, "SHA256:")
+		return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			got := strings.TrimPrefix(ssh.FingerprintSHA256(key), "SHA256:")
+			if got != expected {
+				return fmt.Errorf("sftp: host key of %s is SHA256:%s, expected SHA256:%s", hostname, got, expected)
+			}
+			return nil
+		}, nil
+	}
+
+	file := s.KnownHosts
+	if file == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		file = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	return knownhosts.New(file)
+}
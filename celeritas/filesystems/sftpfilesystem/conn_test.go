@@ -0,0 +1,360 @@
+package sftpfilesystem
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/djedjethai/celeritas/filesystems/fstest"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// testServer is an in-process sftp server, accepting the password "secret" of the
+// user "test" and the authorized key
+type testServer struct {
+	host     string
+	port     string
+	hostKey  ssh.PublicKey
+	accepted int32
+
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+func newTestServer(t *testing.T, authorized ssh.PublicKey) *testServer {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostKey, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if c.User() == "test" && string(pass) == "secret" {
+				return nil, nil
+			}
+			return nil, errors.New("access denied")
+		},
+		PublicKeyCallback: func(c ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if authorized != nil && bytes.Equal(key.Marshal(), authorized.Marshal()) {
+				return nil, nil
+			}
+			return nil, errors.New("unknown key")
+		},
+	}
+	config.AddHostKey(hostKey)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	srv := &testServer{hostKey: hostKey.PublicKey()}
+	srv.host, srv.port, _ = net.SplitHostPort(l.Addr().String())
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&srv.accepted, 1)
+			srv.mu.Lock()
+			srv.conns = append(srv.conns, conn)
+			srv.mu.Unlock()
+			go srv.serve(conn, config)
+		}
+	}()
+	return srv
+}
+
+func (srv *testServer) serve(conn net.Conn, config *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			_ = newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			for req := range requests {
+				ok := req.Type == "subsystem" && string(req.Payload[4:]) == "sftp"
+				_ = req.Reply(ok, nil)
+				if ok {
+					server, err := sftp.NewServer(channel)
+					if err != nil {
+						return
+					}
+					go func() {
+						_ = server.Serve()
+						server.Close()
+					}()
+				}
+			}
+		}()
+	}
+}
+
+// dropConnections closes every connection, as a server restart would
+func (srv *testServer) dropConnections() {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	for _, conn := range srv.conns {
+		conn.Close()
+	}
+	srv.conns = nil
+}
+
+func (srv *testServer) sftp(t *testing.T) *SFTP {
+	s := &SFTP{
+		Host:        srv.host,
+		Port:        srv.port,
+		User:        "test",
+		Pass:        "secret",
+		Root:        t.TempDir(),
+		Fingerprint: ssh.FingerprintSHA256(srv.hostKey),
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestSFTP_conformance(t *testing.T) {
+	srv := newTestServer(t, nil)
+	s := srv.sftp(t)
+
+	fstest.TestFS(t, s, "")
+
+	if n := atomic.LoadInt32(&srv.accepted); n != 1 {
+		t.Errorf("expected one pooled connection, got %d", n)
+	}
+}
+
+func TestSFTP_streams(t *testing.T) {
+	srv := newTestServer(t, nil)
+	fstest.TestStreams(t, srv.sftp(t), "streams")
+}
+
+func TestSFTP_reconnect(t *testing.T) {
+	srv := newTestServer(t, nil)
+	s := srv.sftp(t)
+
+	if _, err := s.List(""); err != nil {
+		t.Fatal(err)
+	}
+
+	srv.dropConnections()
+
+	if _, err := s.List(""); err != nil {
+		t.Fatalf("not reconnected: %s", err)
+	}
+	if n := atomic.LoadInt32(&srv.accepted); n != 2 {
+		t.Errorf("expected a second connection, got %d", n)
+	}
+}
+
+func TestSFTP_hostKey(t *testing.T) {
+	srv := newTestServer(t, nil)
+
+	wrong := srv.sftp(t)
+	wrong.Fingerprint = "SHA256:bm90IHRoZSBob3N0IGtleQ"
+	if _, err := wrong.List(""); err == nil || !strings.Contains(err.Error(), "host key") {
+		t.Errorf("expected a host key error, got %v", err)
+	}
+
+	empty := filepath.Join(t.TempDir(), "known_hosts")
+	_ = ioutil.WriteFile(empty, nil, 0600)
+	unknown := srv.sftp(t)
+	unknown.Fingerprint = ""
+	unknown.KnownHosts = empty
+	if _, err := unknown.List(""); err == nil {
+		t.Error("expected an error for a host missing from known_hosts")
+	}
+
+	known := filepath.Join(t.TempDir(), "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(net.JoinHostPort(srv.host, srv.port))}, srv.hostKey)
+	_ = ioutil.WriteFile(known, []byte(line+"\n"), 0600)
+	trusted := srv.sftp(t)
+	trusted.Fingerprint = ""
+	trusted.KnownHosts = known
+	if _, err := trusted.List(""); err != nil {
+		t.Errorf("host in known_hosts rejected: %s", err)
+	}
+}
+
+func TestSFTP_keyAuth(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, _ := ssh.NewSignerFromKey(key)
+	srv := newTestServer(t, signer.PublicKey())
+
+	//nolint:staticcheck // legacy encrypted pem, still read by ssh-keygen and x/crypto/ssh
+	block, err := x509.EncryptPEMBlock(rand.Reader, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key), []byte("passphrase"), x509.PEMCipherAES256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "id_rsa")
+	_ = ioutil.WriteFile(keyFile, pem.EncodeToMemory(block), 0600)
+
+	s := srv.sftp(t)
+	s.Pass = ""
+	s.KeyFile = keyFile
+	s.KeyPassphrase = "wrong"
+	if _, err := s.List(""); err == nil {
+		t.Error("expected an error for a wrong passphrase")
+	}
+
+	s.KeyPassphrase = "passphrase"
+	if _, err := s.List(""); err != nil {
+		t.Fatalf("key authentication failed: %s", err)
+	}
+
+	// the ssh agent holds the same key
+	s.Close()
+	keyring := agent.NewKeyring()
+	_ = keyring.Add(agent.AddedKey{PrivateKey: key})
+	sock := filepath.Join(t.TempDir(), "agent.sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go agent.ServeAgent(keyring, conn)
+		}
+	}()
+	t.Setenv("SSH_AUTH_SOCK", sock)
+
+	s.KeyFile = ""
+	s.UseAgent = true
+	if _, err := s.List(""); err != nil {
+		t.Fatalf("agent authentication failed: %s", err)
+	}
+
+	s.Close()
+	s.UseAgent = false
+	if _, err := s.List(""); err == nil || !strings.Contains(err.Error(), "no authentication") {
+		t.Errorf("expected an error without authentication, got %v", err)
+	}
+}
+
+func TestSFTP_slowServer(t *testing.T) {
+	// a server which never answers the handshake
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		if conn, err := l.Accept(); err == nil {
+			accepted <- conn
+		}
+	}()
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+	slow := &SFTP{Host: host, Port: port, User: "test", Pass: "secret", InsecureSkipHostKey: true}
+
+	dialed := make(chan error, 1)
+	go func() {
+		_, err := slow.getCredentials()
+		dialed <- err
+	}()
+	conn := <-accepted
+
+	// the other servers are reached meanwhile
+	srv := newTestServer(t, nil)
+	listed := make(chan error, 1)
+	go func() {
+		_, err := srv.sftp(t).List("")
+		listed <- err
+	}()
+	select {
+	case err := <-listed:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("held up by the slow server")
+	}
+
+	conn.Close()
+	l.Close()
+	if err := <-dialed; err == nil {
+		t.Error("expected the handshake to fail")
+	}
+}
+
+func TestSFTP_poolKey(t *testing.T) {
+	base := SFTP{Host: "example.com", Port: "22", User: "test", Pass: "secret", Fingerprint: "SHA256:abc"}
+	if base.poolKey() != base.poolKey() {
+		t.Fatal("unstable pool key")
+	}
+	if strings.Contains(base.poolKey(), "secret") {
+		t.Error("the password shows in the pool key")
+	}
+
+	for name, change := range map[string]func(s *SFTP){
+		"password":    func(s *SFTP) { s.Pass = "other" },
+		"key file":    func(s *SFTP) { s.KeyFile = "id_ed25519" },
+		"agent":       func(s *SFTP) { s.UseAgent = true },
+		"fingerprint": func(s *SFTP) { s.Fingerprint = "SHA256:def" },
+		"known hosts": func(s *SFTP) { s.KnownHosts = "known_hosts" },
+		"insecure":    func(s *SFTP) { s.InsecureSkipHostKey = true },
+	} {
+		other := base
+		change(&other)
+		if other.poolKey() == base.poolKey() {
+			t.Errorf("%s: the connection is shared", name)
+		}
+	}
+}
+
+func TestTransportError(t *testing.T) {
+	for err, expected := range map[error]bool{
+		io.EOF:                      true,
+		sftp.ErrSSHFxConnectionLost: true,
+		&net.OpError{Op: "read", Err: errors.New("reset")}: true,
+		os.ErrNotExist:                  false,
+		&sftp.StatusError{Code: 3}:      false,
+		errors.New("sftp: unsupported"): false,
+	} {
+		if transportError(err) != expected {
+			t.Errorf("%v: expected %v", err, expected)
+		}
+	}
+}
@@ -1,142 +1,355 @@
 package sftpfilesystem
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"io/ioutil"
 	"os"
 	"path"
 	"strings"
+	"time"
 
 	"github.com/djedjethai/celeritas/filesystems"
 	"github.com/pkg/sftp"
-	"golang.org/x/crypto/ssh"
 )
 
+// SFTP stores the files on an sftp server. The connection is shared by every SFTP of
+// the same user, host and port, kept alive, and reconnected once it is lost
 type SFTP struct {
 	Host string
 	User string
 	Pass string
 	Port string
+	// Root is the folder of the keys on the server, the login folder when empty
+	Root string
+	// KeyFile is a private key to authenticate with, protected by KeyPassphrase if set
+	KeyFile       string
+	KeyPassphrase string
+	// UseAgent authenticates with the keys of the ssh agent at SSH_AUTH_SOCK
+	UseAgent bool
+	// Fingerprint pins the host key of the server, as printed by ssh-keygen -l:
+	// SHA256:<base64>. Otherwise the key must be in KnownHosts, ~/.ssh/known_hosts by default
+	Fingerprint string
+	KnownHosts  string
+	// InsecureSkipHostKey accepts any host key, for local development only
+	InsecureSkipHostKey bool
 }
 
-func (s *SFTP) getCredentials() (*sftp.Client, error) {
-	addr := fmt.Sprintf("%s:%s", s.Host, s.Port)
-	config := &ssh.ClientConfig{
-		User: s.User,
-		Auth: []ssh.AuthMethod{
-			ssh.Password(s.Pass),
-		},
-		// allow us to not get error key ..., make it easier
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+// path returns the path on the server of key, within Root
+func (s *SFTP) path(key string) string {
+	key = filesystems.CleanKey(key)
+	if s.Root != "" {
+		return path.Join(s.Root, key)
+	}
+	if key == "" {
+		return "."
+	}
+	return key
+}
+
+func (s *SFTP) Put(filename, folder string) error {
+	key := path.Join(folder, path.Base(filename))
+	err := s.do(true, func(client *sftp.Client) error {
+		f, err := os.Open(filename)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		if err := s.mkdirParent(client, key); err != nil {
+			return err
+		}
+
+		f2, err := client.Create(s.path(key))
+		if err != nil {
+			return err
+		}
+		defer f2.Close()
+
+		// destination id f2
+		if _, err := io.Copy(f2, f); err != nil {
+			return err
+		}
+
+		return s.writeMeta(client, key, nil)
+	})
+	return filesystems.NewError("put", key, err, nil)
+}
+
+// mkdirParent creates the folder of key
+func (s *SFTP) mkdirParent(client *sftp.Client, key string) error {
+	if dir := path.Dir(filesystems.CleanKey(key)); dir != "." {
+		return client.MkdirAll(s.path(dir))
+	}
+	return nil
+}
+
+// readMeta returns the content of the sidecar of key, nil when there is none
+func (s *SFTP) readMeta(client *sftp.Client, key string) (*filesystems.Meta, error) {
+	f, err := client.Open(s.path(filesystems.MetaKey(key)))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
 	}
-	conn, err := ssh.Dial("tcp", addr, config)
 	if err != nil {
 		return nil, err
 	}
+	defer f.Close()
 
-	client, err := sftp.NewClient(conn)
+	data, err := ioutil.ReadAll(f)
 	if err != nil {
 		return nil, err
 	}
-
-	// set the current dir on the server
-	cwd, err := client.Getwd()
-	log.Println("current working directory: ", cwd)
-
-	return client, nil
+	return filesystems.DecodeMeta(data)
 }
 
-func (s *SFTP) Put(filename, folder string) error {
-	client, err := s.getCredentials()
-	if err != nil {
-		return err
+// writeMeta writes the sidecar of key, or removes it when meta is nil
+func (s *SFTP) writeMeta(client *sftp.Client, key string, meta *filesystems.Meta) error {
+	name := s.path(filesystems.MetaKey(key))
+	if meta == nil {
+		if err := client.Remove(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
 	}
-	defer client.Close()
 
-	f, err := os.Open(filename)
+	f, err := client.Create(name)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
 
-	log.Println("the path.Base(): ", path.Base(filename))
-	f2, err := client.Create(fmt.Sprintf("%s/%s", folder, path.Base(filename)))
+	_, err = f.Write(meta.Encode())
+	return err
+}
+
+// file checks that key is a file
+func (s *SFTP) file(client *sftp.Client, key string) error {
+	info, err := client.Stat(s.path(key))
 	if err != nil {
 		return err
 	}
-	defer f2.Close()
-
-	// destination id f2
-	if _, err := io.Copy(f2, f); err != nil {
-		return err
+	if info.IsDir() {
+		return filesystems.ErrNotFound
 	}
-
 	return nil
 }
 
+// List returns the files and the folders directly within the folder prefix
 func (s *SFTP) List(prefix string) ([]filesystems.Listing, error) {
+	return filesystems.ListAll(context.Background(), s, prefix, false)
+}
+
+// ListPage returns a page of the listing of the folder prefix
+func (s *SFTP) ListPage(ctx context.Context, prefix string, opts filesystems.ListOptions) (filesystems.Page, error) {
 	var listing []filesystems.Listing
-	client, err := s.getCredentials()
+	folder := filesystems.FolderPrefix(prefix)
+	dir := s.path(prefix)
+
+	err := s.do(true, func(client *sftp.Client) error {
+		listing = nil
+
+		if !opts.Recursive {
+			files, err := client.ReadDir(dir)
+			if err != nil {
+				return err
+			}
+
+			for _, x := range files {
+				// we don't want any file startting with a .(like .env)
+				if !filesystems.Hidden(x.Name()) {
+					listing = append(listing, item(folder+x.Name(), x))
+				}
+			}
+			return nil
+		}
+
+		walker := client.Walk(dir)
+		for walker.Step() {
+			if err := walker.Err(); err != nil {
+				return err
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			info := walker.Stat()
+			if walker.Path() == dir {
+				continue
+			}
+			if filesystems.Hidden(info.Name()) {
+				if info.IsDir() {
+					walker.SkipDir()
+				}
+				continue
+			}
+			if info.IsDir() {
+				continue
+			}
+
+			rel := strings.TrimPrefix(walker.Path(), dir+"/")
+			listing = append(listing, item(folder+rel, info))
+		}
+		return nil
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		return filesystems.Page{}, nil
+	}
 	if err != nil {
-		return listing, err
+		return filesystems.Page{}, filesystems.NewError("list", prefix, err, nil)
 	}
-	defer client.Close()
 
-	files, err := client.ReadDir(prefix)
-	if err != nil {
-		return listing, err
+	return filesystems.Paginate(listing, opts), nil
+}
+
+func item(key string, info os.FileInfo) filesystems.Listing {
+	if info.IsDir() {
+		return filesystems.Listing{Key: key, LastModified: info.ModTime(), IsDir: true}
 	}
+	return filesystems.Listing{Key: key, LastModified: info.ModTime(), Size: info.Size()}
+}
 
-	for _, x := range files {
-		var item filesystems.Listing
+// Stat returns the size, type and metadata of key
+func (s *SFTP) Stat(ctx context.Context, key string) (filesystems.Listing, error) {
+	var stat filesystems.Listing
+	err := s.do(true, func(client *sftp.Client) error {
+		info, err := client.Stat(s.path(key))
+		if err != nil {
+			return err
+		}
 
-		// we don't want any file startting with a .(like .env)
-		// it s not call key in this package but Name()
-		if !strings.HasPrefix(x.Name(), ".") {
-			b := float64(x.Size())
-			kb := b / 1024
-			mb := kb / 1024
-			item.Key = x.Name()
-			item.Size = mb
-			item.LastModified = x.ModTime()
-			item.IsDir = x.IsDir()
-			listing = append(listing, item)
+		stat = item(filesystems.CleanKey(key), info)
+		if stat.IsDir {
+			return nil
 		}
-	}
 
-	return listing, nil
+		meta, err := s.readMeta(client, key)
+		if err != nil {
+			return err
+		}
+		meta.Apply(&stat)
+		return nil
+	})
+	return stat, filesystems.NewError("stat", key, err, nil)
 }
 
-func (s *SFTP) Delete(itemsToDelete []string) bool {
-	client, err := s.getCredentials()
-	if err != nil {
-		return false
+// Exists reports whether key is a file or a folder
+func (s *SFTP) Exists(ctx context.Context, key string) (bool, error) {
+	_, err := s.Stat(ctx, key)
+	if errors.Is(err, filesystems.ErrNotFound) {
+		return false, nil
 	}
-	defer client.Close()
+	return err == nil, err
+}
+
+// Copy copies the file src, with its metadata, to dst. The content goes through the
+// application, as sftp has no server side copy
+func (s *SFTP) Copy(ctx context.Context, src, dst string) error {
+	err := s.do(true, func(client *sftp.Client) error {
+		if err := s.file(client, src); err != nil {
+			return err
+		}
+		if filesystems.CleanKey(src) == filesystems.CleanKey(dst) {
+			return nil
+		}
+
+		meta, err := s.readMeta(client, src)
+		if err != nil {
+			return err
+		}
 
+		from, err := client.Open(s.path(src))
+		if err != nil {
+			return err
+		}
+		defer from.Close()
+
+		if err := s.mkdirParent(client, dst); err != nil {
+			return err
+		}
+		to, err := client.Create(s.path(dst))
+		if err != nil {
+			return err
+		}
+		defer to.Close()
+
+		if _, err := io.Copy(to, contextReader{ctx: ctx, r: from}); err != nil {
+			return err
+		}
+		return s.writeMeta(client, dst, meta)
+	})
+	return filesystems.NewError("copy", src, err, nil)
+}
+
+// Move renames the file src, with its metadata, to dst
+func (s *SFTP) Move(ctx context.Context, src, dst string) error {
+	err := s.do(true, func(client *sftp.Client) error {
+		if err := s.file(client, src); err != nil {
+			return err
+		}
+		if filesystems.CleanKey(src) == filesystems.CleanKey(dst) {
+			return nil
+		}
+
+		meta, err := s.readMeta(client, src)
+		if err != nil {
+			return err
+		}
+
+		if err := s.mkdirParent(client, dst); err != nil {
+			return err
+		}
+		if err := client.PosixRename(s.path(src), s.path(dst)); err != nil {
+			return err
+		}
+
+		if err := s.writeMeta(client, dst, meta); err != nil {
+			return err
+		}
+		return s.writeMeta(client, src, nil)
+	})
+	return filesystems.NewError("move", src, err, nil)
+}
+
+// MkdirAll creates the folder dir and its parents
+func (s *SFTP) MkdirAll(ctx context.Context, dir string) error {
+	err := s.do(true, func(client *sftp.Client) error {
+		return client.MkdirAll(s.path(dir))
+	})
+	return filesystems.NewError("mkdir", dir, err, nil)
+}
+
+// Delete removes the files with their metadata, stopping at the first error
+func (s *SFTP) Delete(itemsToDelete []string) error {
 	for _, x := range itemsToDelete {
-		deleteErr := client.Remove(x)
+		deleteErr := s.do(true, func(client *sftp.Client) error {
+			if err := s.file(client, x); err != nil {
+				return err
+			}
+			if err := client.Remove(s.path(x)); err != nil {
+				return err
+			}
+			return s.writeMeta(client, x, nil)
+		})
 		if deleteErr != nil {
-			return false
+			return filesystems.NewError("delete", x, deleteErr, nil)
 		}
 	}
 
-	return true
+	return nil
 }
 
 func (s *SFTP) Get(destination string, items ...string) error {
-	client, err := s.getCredentials()
-	if err != nil {
-		return err
-	}
-	defer client.Close()
-
 	for _, item := range items {
 		// !!! here we use the func() to make sure the defer()
 		// will depend of the func() and so be close after each func execution
 		// other wise it will depend of the Get() and make create some data leak
-		err := func() error {
+		err := s.do(true, func(client *sftp.Client) error {
+			if err := s.file(client, item); err != nil {
+				return err
+			}
+
 			// create a destination here on the app fs,
 			// where we gonna save the file coming from sftp server
 			dstFile, err := os.Create(fmt.Sprintf("%s/%s", destination, path.Base(item)))
@@ -146,7 +359,7 @@ func (s *SFTP) Get(destination string, items ...string) error {
 			defer dstFile.Close()
 
 			// open source file
-			srcFile, err := client.Open(item)
+			srcFile, err := client.Open(s.path(item))
 			if err != nil {
 				return err
 			}
@@ -165,12 +378,87 @@ func (s *SFTP) Get(destination string, items ...string) error {
 				return err
 			}
 			return nil
-		}()
+		})
 
 		if err != nil {
-			return err
+			return filesystems.NewError("get", item, err, nil)
 		}
 	}
 
 	return nil
 }
+
+// PutStream writes the content of r to key, creating its folders. It is not retried
+// on a new connection, as r is consumed
+func (s *SFTP) PutStream(ctx context.Context, key string, r io.Reader, opts filesystems.PutOptions) error {
+	err := s.do(false, func(client *sftp.Client) error {
+		if err := s.mkdirParent(client, key); err != nil {
+			return err
+		}
+
+		f, err := client.Create(s.path(key))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		if _, err := io.Copy(f, contextReader{ctx: ctx, r: r}); err != nil {
+			return err
+		}
+		return s.writeMeta(client, key, filesystems.NewMeta(opts))
+	})
+	return filesystems.NewError("put", key, err, nil)
+}
+
+// Open returns a reader of key
+func (s *SFTP) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var f *sftp.File
+	err := s.do(true, func(client *sftp.Client) error {
+		if err := s.file(client, key); err != nil {
+			return err
+		}
+
+		var err error
+		f, err = client.Open(s.path(key))
+		return err
+	})
+	if err != nil {
+		return nil, filesystems.NewError("open", key, err, nil)
+	}
+	return f, nil
+}
+
+// OpenRange returns a reader of length bytes of key from offset
+func (s *SFTP) OpenRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	f, err := s.Open(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	r, err := filesystems.SeekRange(f, offset, length)
+	if err != nil {
+		return nil, filesystems.NewError("open", key, err, nil)
+	}
+	return r, nil
+}
+
+// contextReader stops reading once ctx is done, as sftp transfers can not be cancelled
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+// TemporaryURL is not supported, the files are served by a signed Celeritas route instead
+func (s *SFTP) TemporaryURL(key string, expiry time.Duration, opts filesystems.URLOptions) (string, error) {
+	return "", filesystems.ErrTemporaryURLNotSupported
+}
@@ -0,0 +1,27 @@
+package sftpfilesystem
+
+import (
+	"os"
+	"testing"
+
+	"github.com/djedjethai/celeritas/filesystems/fstest"
+)
+
+// TestSFTP runs against the server set by the FSTEST_SFTP_* variables, in the folder
+// FSTEST_SFTP_ROOT. The host key is checked against FSTEST_SFTP_FINGERPRINT when set,
+// or else ~/.ssh/known_hosts
+func TestSFTP(t *testing.T) {
+	if os.Getenv("FSTEST_SFTP_HOST") == "" {
+		t.Skip("FSTEST_SFTP_HOST not set")
+	}
+
+	fstest.TestFS(t, &SFTP{
+		Host: os.Getenv("FSTEST_SFTP_HOST"),
+		User: os.Getenv("FSTEST_SFTP_USER"),
+		Pass: os.Getenv("FSTEST_SFTP_PASS"),
+		Port: os.Getenv("FSTEST_SFTP_PORT"),
+		Root: os.Getenv("FSTEST_SFTP_ROOT"),
+		// an empty fingerprint falls back to known_hosts
+		Fingerprint: os.Getenv("FSTEST_SFTP_FINGERPRINT"),
+	}, "")
+}
@@ -0,0 +1,312 @@
+package filesystems
+
+import (
+	"bufio"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Transfer copies the file srcKey of src, with its type and metadata, to dstKey of dst.
+// Within one file system it is the server side Copy of the driver
+func Transfer(ctx context.Context, src FS, srcKey string, dst FS, dstKey string) error {
+	if src == dst {
+		return src.Copy(ctx, srcKey, dstKey)
+	}
+
+	stat, err := src.Stat(ctx, srcKey)
+	if err != nil {
+		return err
+	}
+	if stat.IsDir {
+		return NewError("transfer", srcKey, ErrNotFound, nil)
+	}
+
+	r, err := src.Open(ctx, srcKey)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	return dst.PutStream(ctx, dstKey, r, PutOptions{
+		ContentType: stat.ContentType,
+		Size:        stat.Size,
+		Metadata:    stat.Metadata,
+	})
+}
+
+// Checksum returns the md5 of the content of key, in hex
+func Checksum(ctx context.Context, fs FS, key string) (string, error) {
+	r, err := fs.Open(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	h := md5.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", NewError("checksum", key, err, nil)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// etagMD5 returns the etag of item when it is the md5 of the content, as the single
+// part uploads of the object stores have, or an empty string
+func etagMD5(item Listing) string {
+	etag := strings.ToLower(strings.Trim(item.Etag, `"`))
+	if len(etag) != md5.Size*2 {
+		return ""
+	}
+	if _, err := hex.DecodeString(etag); err != nil {
+		return ""
+	}
+	return etag
+}
+
+// SyncOptions tune Sync
+type SyncOptions struct {
+	// DryRun reports the changes without making them
+	DryRun bool
+	// Delete removes the files of the destination missing from the source
+	Delete bool
+	// Workers is the number of parallel transfers, 4 by default
+	Workers int
+	// Journal is a file recording the files transferred, so an interrupted sync
+	// resumes without comparing them again. It is removed once the sync succeeds
+	Journal string
+	// Progress is called after each file, one call at a time
+	Progress func(SyncEvent)
+}
+
+// Sync actions
+const (
+	SyncCopy   = "copy"
+	SyncSkip   = "skip"
+	SyncDelete = "delete"
+)
+
+// SyncEvent reports what Sync did with one file
+type SyncEvent struct {
+	// Action is SyncCopy, SyncSkip or SyncDelete
+	Action string
+	// Key is relative to the synced folders
+	Key  string
+	Size int64
+	Err  error
+}
+
+// SyncResult counts the files of a sync
+type SyncResult struct {
+	Copied  int
+	Skipped int
+	Deleted int
+	Failed  int
+	Bytes   int64
+}
+
+// journalEntry is a line of the journal: a source file as it was when transferred
+type journalEntry struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	Etag         string    `json:"etag,omitempty"`
+	LastModified time.Time `json:"last_modified"`
+}
+
+func (e journalEntry) matches(item Listing) bool {
+	return e.Size == item.Size && e.Etag == item.Etag && e.LastModified.Equal(item.LastModified)
+}
+
+// Sync copies the files below srcPrefix of src which are missing or different below
+// dstPrefix of dst. Files are compared by size, then by md5: the etags of the object
+// stores when they are one, or else the checksum of the content. It keeps going when
+// a file fails, and returns an error counting the failures at the end
+func Sync(ctx context.Context, src FS, srcPrefix string, dst FS, dstPrefix string, opts SyncOptions) (SyncResult, error) {
+	var result SyncResult
+	if opts.Workers <= 0 {
+		opts.Workers = 4
+	}
+
+	sources, err := ListAll(ctx, src, srcPrefix, true)
+	if err != nil {
+		return result, err
+	}
+	targets, err := ListAll(ctx, dst, dstPrefix, true)
+	if err != nil {
+		return result, err
+	}
+
+	srcFolder, dstFolder := FolderPrefix(srcPrefix), FolderPrefix(dstPrefix)
+	existing := make(map[string]Listing, len(targets))
+	for _, item := range targets {
+		existing[strings.TrimPrefix(item.Key, dstFolder)] = item
+	}
+
+	done, journal, err := openJournal(opts.Journal, opts.DryRun)
+	if err != nil {
+		return result, err
+	}
+	if journal != nil {
+		defer journal.Close()
+	}
+
+	var mu sync.Mutex
+	report := func(event SyncEvent, entry *journalEntry) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch {
+		case event.Err != nil:
+			result.Failed++
+		case event.Action == SyncCopy:
+			result.Copied++
+			result.Bytes += event.Size
+		case event.Action == SyncSkip:
+			result.Skipped++
+		case event.Action == SyncDelete:
+			result.Deleted++
+		}
+
+		if entry != nil && event.Err == nil && journal != nil {
+			line, _ := json.Marshal(entry)
+			_, _ = journal.Write(append(line, '\n'))
+		}
+		if opts.Progress != nil {
+			opts.Progress(event)
+		}
+	}
+
+	tasks := make(chan Listing)
+	var wg sync.WaitGroup
+	for i := 0; i < opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range tasks {
+				rel := strings.TrimPrefix(item.Key, srcFolder)
+				entry := &journalEntry{Key: rel, Size: item.Size, Etag: item.Etag, LastModified: item.LastModified}
+				event := SyncEvent{Action: SyncSkip, Key: rel, Size: item.Size}
+
+				if e, ok := done[rel]; ok && e.matches(item) {
+					report(event, nil)
+					continue
+				}
+
+				target, ok := existing[rel]
+				same := false
+				if ok {
+					same, event.Err = sameContent(ctx, src, item, dst, target)
+				}
+				if event.Err == nil && !same {
+					event.Action = SyncCopy
+					if !opts.DryRun {
+						event.Err = Transfer(ctx, src, item.Key, dst, dstFolder+rel)
+					}
+				}
+				report(event, entry)
+			}
+		}()
+	}
+
+	for _, item := range sources {
+		if ctx.Err() != nil {
+			break
+		}
+		tasks <- item
+	}
+	close(tasks)
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return result, err
+	}
+
+	if opts.Delete {
+		kept := make(map[string]bool, len(sources))
+		for _, item := range sources {
+			kept[strings.TrimPrefix(item.Key, srcFolder)] = true
+		}
+		for rel, item := range existing {
+			if kept[rel] {
+				continue
+			}
+			event := SyncEvent{Action: SyncDelete, Key: rel, Size: item.Size}
+			if !opts.DryRun {
+				event.Err = dst.Delete([]string{item.Key})
+			}
+			report(event, nil)
+		}
+	}
+
+	if result.Failed > 0 {
+		return result, fmt.Errorf("filesystems: sync: %d files failed", result.Failed)
+	}
+	if journal != nil {
+		journal.Close()
+		_ = os.Remove(opts.Journal)
+	}
+	return result, nil
+}
+
+// sameContent reports whether the files a of src and b of dst have the same content
+func sameContent(ctx context.Context, src FS, a Listing, dst FS, b Listing) (bool, error) {
+	if a.Size != b.Size {
+		return false, nil
+	}
+
+	sumA, sumB := etagMD5(a), etagMD5(b)
+	var err error
+	if sumA == "" {
+		if sumA, err = Checksum(ctx, src, a.Key); err != nil {
+			return false, err
+		}
+	}
+	if sumB == "" {
+		if sumB, err = Checksum(ctx, dst, b.Key); err != nil {
+			return false, err
+		}
+	}
+	return sumA == sumB, nil
+}
+
+// openJournal reads the entries of the journal file, and opens it to append the next
+// ones. Without a file, or for a dry run, nothing is recorded
+func openJournal(name string, dryRun bool) (map[string]journalEntry, *os.File, error) {
+	done := make(map[string]journalEntry)
+	if name == "" {
+		return done, nil, nil
+	}
+
+	f, err := os.Open(name)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, nil, err
+	}
+	if err == nil {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var entry journalEntry
+			// a line cut by an interruption is ignored
+			if json.Unmarshal(scanner.Bytes(), &entry) == nil {
+				done[entry.Key] = entry
+			}
+		}
+		f.Close()
+	}
+
+	if dryRun {
+		return done, nil, nil
+	}
+
+	journal, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, nil, err
+	}
+	return done, journal, nil
+}
@@ -0,0 +1,166 @@
+package filesystems_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/djedjethai/celeritas/filesystems"
+	"github.com/djedjethai/celeritas/filesystems/localfilesystem"
+	"github.com/djedjethai/celeritas/filesystems/memfilesystem"
+)
+
+func put(t *testing.T, fs filesystems.FS, key, content string) {
+	t.Helper()
+	err := fs.PutStream(context.Background(), key, strings.NewReader(content), filesystems.PutOptions{
+		Metadata: map[string]string{"origin": "webdav"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func read(t *testing.T, fs filesystems.FS, key string) string {
+	t.Helper()
+	r, err := fs.Open(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	data, _ := ioutil.ReadAll(r)
+	return string(data)
+}
+
+func TestTransfer(t *testing.T) {
+	ctx := context.Background()
+	src := &memfilesystem.Mem{}
+	dst := &localfilesystem.Local{Root: t.TempDir()}
+	put(t, src, "a.txt", "alpha")
+
+	if err := filesystems.Transfer(ctx, src, "a.txt", dst, "copies/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if got := read(t, dst, "copies/a.txt"); got != "alpha" {
+		t.Errorf("expected alpha, got %q", got)
+	}
+	if stat, _ := dst.Stat(ctx, "copies/a.txt"); stat.Metadata["origin"] != "webdav" {
+		t.Errorf("metadata not transferred: %v", stat.Metadata)
+	}
+}
+
+func TestSync(t *testing.T) {
+	ctx := context.Background()
+	src := &memfilesystem.Mem{}
+	dst := &localfilesystem.Local{Root: t.TempDir()}
+
+	put(t, src, "assets/a.txt", "alpha")
+	put(t, src, "assets/img/b.png", "bravo")
+	put(t, src, "assets/c.txt", "charlie")
+	put(t, dst, "backup/a.txt", "alpha")
+	put(t, dst, "backup/c.txt", "CHARLIE")
+	put(t, dst, "backup/old.txt", "removed from the source")
+
+	events := make(map[string]string)
+	opts := filesystems.SyncOptions{
+		DryRun: true,
+		Delete: true,
+		Progress: func(e filesystems.SyncEvent) {
+			if e.Err != nil {
+				t.Errorf("%s: %s", e.Key, e.Err)
+			}
+			events[e.Key] = e.Action
+		},
+	}
+
+	result, err := filesystems.Sync(ctx, src, "assets", dst, "backup", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"a.txt":     filesystems.SyncSkip,
+		"img/b.png": filesystems.SyncCopy,
+		"c.txt":     filesystems.SyncCopy,
+		"old.txt":   filesystems.SyncDelete,
+	}
+	for key, action := range expected {
+		if events[key] != action {
+			t.Errorf("%s: expected %s, got %s", key, action, events[key])
+		}
+	}
+	if result.Copied != 2 || result.Skipped != 1 || result.Deleted != 1 {
+		t.Errorf("wrong counts %+v", result)
+	}
+	if got := read(t, dst, "backup/c.txt"); got != "CHARLIE" {
+		t.Error("dry run changed a file")
+	}
+
+	opts.DryRun = false
+	if _, err := filesystems.Sync(ctx, src, "assets", dst, "backup", opts); err != nil {
+		t.Fatal(err)
+	}
+	if got := read(t, dst, "backup/c.txt"); got != "charlie" {
+		t.Errorf("changed file not copied: %q", got)
+	}
+	if got := read(t, dst, "backup/img/b.png"); got != "bravo" {
+		t.Errorf("missing file not copied: %q", got)
+	}
+	if exists, _ := dst.Exists(ctx, "backup/old.txt"); exists {
+		t.Error("file missing from the source not deleted")
+	}
+
+	// everything is in sync now
+	result, err = filesystems.Sync(ctx, src, "assets", dst, "backup", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Copied != 0 || result.Skipped != 3 {
+		t.Errorf("expected every file skipped, got %+v", result)
+	}
+}
+
+func TestSync_journal(t *testing.T) {
+	ctx := context.Background()
+	src := &memfilesystem.Mem{}
+	dst := &memfilesystem.Mem{}
+	journal := filepath.Join(t.TempDir(), "sync.journal")
+
+	put(t, src, "a.txt", "alpha")
+	put(t, src, "b.txt", "bravo")
+
+	// a previous run copied a.txt then was interrupted, its last line cut
+	if _, err := filesystems.Sync(ctx, src, "", dst, "", filesystems.SyncOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	stat, _ := src.Stat(ctx, "a.txt")
+	line := `{"key":"a.txt","size":5,"etag":"` + stat.Etag + `","last_modified":"` + stat.LastModified.Format(time.RFC3339Nano) + `"}` + "\n" + `{"key":"b.t`
+	if err := ioutil.WriteFile(journal, []byte(line), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// changed in the destination since, but recorded as done so not compared again
+	put(t, dst, "a.txt", "ALPHA")
+
+	var compared []string
+	_, err := filesystems.Sync(ctx, src, "", dst, "", filesystems.SyncOptions{
+		Journal: journal,
+		Progress: func(e filesystems.SyncEvent) {
+			compared = append(compared, e.Key+":"+e.Action)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(compared, " ") != "a.txt:skip b.txt:skip" && strings.Join(compared, " ") != "b.txt:skip a.txt:skip" {
+		t.Errorf("unexpected events %v", compared)
+	}
+	if got := read(t, dst, "a.txt"); got != "ALPHA" {
+		t.Error("a file recorded in the journal was transferred again")
+	}
+	if _, err := os.Stat(journal); !os.IsNotExist(err) {
+		t.Error("journal kept after a successful sync")
+	}
+}
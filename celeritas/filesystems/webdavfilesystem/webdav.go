@@ -1,11 +1,16 @@
 package webdavfilesystem
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"net/http"
 	"os"
 	"path"
 	"strings"
+	"time"
 
 	"github.com/djedjethai/celeritas/filesystems"
 	"github.com/studio-b12/gowebdav"
@@ -22,8 +27,29 @@ func (w *WebDAV) getCredentials() *gowebdav.Client {
 	return c
 }
 
+// davPath returns the path on the server of key
+func davPath(key string) string {
+	return "/" + filesystems.CleanKey(key)
+}
+
+// kind returns the filesystems error matching the status of err
+func kind(err error) error {
+	switch {
+	case gowebdav.IsErrCode(err, http.StatusNotFound):
+		return filesystems.ErrNotFound
+	case gowebdav.IsErrCode(err, http.StatusForbidden), gowebdav.IsErrCode(err, http.StatusUnauthorized):
+		return filesystems.ErrPermission
+	}
+	return nil
+}
+
+func wrap(op, key string, err error) error {
+	return filesystems.NewError(op, key, err, kind(err))
+}
+
 func (w *WebDAV) Put(filename, folder string) error {
 	client := w.getCredentials()
+	key := path.Join(folder, path.Base(filename))
 
 	file, err := os.Open(filename)
 	if err != nil {
@@ -31,58 +57,223 @@ func (w *WebDAV) Put(filename, folder string) error {
 	}
 	defer file.Close()
 
-	err = client.WriteStream(fmt.Sprintf("%s/%s", folder, path.Base(filename)), file, 0664)
+	err = client.WriteStream(davPath(key), file, 0664)
 	if err != nil {
-		return err
+		return wrap("put", key, err)
+	}
+
+	return wrap("put", key, writeMeta(client, key, nil))
+}
+
+// readMeta returns the content of the sidecar of key, nil when there is none
+func readMeta(client *gowebdav.Client, key string) (*filesystems.Meta, error) {
+	data, err := client.Read(davPath(filesystems.MetaKey(key)))
+	if gowebdav.IsErrNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
 	}
+	return filesystems.DecodeMeta(data)
+}
 
+// writeMeta writes the sidecar of key, or removes it when meta is nil
+func writeMeta(client *gowebdav.Client, key string, meta *filesystems.Meta) error {
+	if meta == nil {
+		return client.Remove(davPath(filesystems.MetaKey(key)))
+	}
+	return client.Write(davPath(filesystems.MetaKey(key)), meta.Encode(), 0664)
+}
+
+// file checks that key is a file
+func file(client *gowebdav.Client, key string) error {
+	info, err := client.Stat(davPath(key))
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return &os.PathError{Op: "Stat", Path: davPath(key), Err: gowebdav.StatusError{Status: http.StatusNotFound}}
+	}
 	return nil
 }
 
+// List returns the files and the folders directly within the folder prefix
 func (w *WebDAV) List(prefix string) ([]filesystems.Listing, error) {
+	return filesystems.ListAll(context.Background(), w, prefix, false)
+}
+
+// ListPage returns a page of the listing of the folder prefix
+func (w *WebDAV) ListPage(ctx context.Context, prefix string, opts filesystems.ListOptions) (filesystems.Page, error) {
 	var listing []filesystems.Listing
-	client := w.getCredentials()
-	files, err := client.ReadDir(prefix)
-	if err != nil {
-		return listing, err
-	}
-
-	for _, file := range files {
-		if !strings.HasPrefix(file.Name(), ".") {
-			b := float64(file.Size())
-			kb := b / 1024
-			mb := kb / 1024
-			current := filesystems.Listing{
-				LastModified: file.ModTime(),
-				Key:          file.Name(),
-				Size:         mb,
-				IsDir:        file.IsDir(),
+	client := w.getContextClient(ctx)
+
+	var read func(folder string) error
+	read = func(folder string) error {
+		files, err := client.ReadDir(davPath(folder))
+		if err != nil {
+			return err
+		}
+
+		for _, file := range files {
+			if filesystems.Hidden(file.Name()) {
+				continue
+			}
+
+			current := item(filesystems.FolderPrefix(folder)+file.Name(), file)
+			if !opts.Recursive {
+				listing = append(listing, current)
+				continue
+			}
+
+			if file.IsDir() {
+				if err := read(current.Key); err != nil {
+					return err
+				}
+			} else {
+				listing = append(listing, current)
 			}
-			listing = append(listing, current)
 		}
+		return nil
+	}
+
+	err := read(prefix)
+	if gowebdav.IsErrNotFound(err) {
+		return filesystems.Page{}, nil
+	}
+	if err != nil {
+		return filesystems.Page{}, wrap("list", prefix, err)
+	}
+
+	return filesystems.Paginate(listing, opts), nil
+}
+
+func item(key string, info os.FileInfo) filesystems.Listing {
+	if info.IsDir() {
+		return filesystems.Listing{Key: key, LastModified: info.ModTime(), IsDir: true}
+	}
+
+	current := filesystems.Listing{Key: key, LastModified: info.ModTime(), Size: info.Size()}
+	if f, ok := info.(interface{ ETag() string }); ok {
+		current.Etag = f.ETag()
+	}
+	return current
+}
+
+// Stat returns the size, type and metadata of key
+func (w *WebDAV) Stat(ctx context.Context, key string) (filesystems.Listing, error) {
+	client := w.getContextClient(ctx)
+
+	info, err := client.Stat(davPath(key))
+	if err != nil {
+		return filesystems.Listing{}, wrap("stat", key, err)
+	}
+
+	stat := item(filesystems.CleanKey(key), info)
+	if stat.IsDir {
+		return stat, nil
+	}
+
+	meta, err := readMeta(client, key)
+	if err != nil {
+		return filesystems.Listing{}, wrap("stat", key, err)
+	}
+	meta.Apply(&stat)
+	return stat, nil
+}
+
+// Exists reports whether key is a file or a folder
+func (w *WebDAV) Exists(ctx context.Context, key string) (bool, error) {
+	_, err := w.Stat(ctx, key)
+	if errors.Is(err, filesystems.ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Copy copies the file src, with its metadata, to dst on the server
+func (w *WebDAV) Copy(ctx context.Context, src, dst string) error {
+	client := w.getContextClient(ctx)
+
+	if err := file(client, src); err != nil {
+		return wrap("copy", src, err)
+	}
+	if filesystems.CleanKey(src) == filesystems.CleanKey(dst) {
+		return nil
+	}
+
+	meta, err := readMeta(client, src)
+	if err != nil {
+		return wrap("copy", src, err)
 	}
 
-	return listing, nil
+	if err := client.Copy(davPath(src), davPath(dst), true); err != nil {
+		return wrap("copy", src, err)
+	}
+	return wrap("copy", dst, writeMeta(client, dst, meta))
 }
 
-func (w *WebDAV) Delete(itemsToDelete []string) bool {
+// Move renames the file src, with its metadata, to dst on the server
+func (w *WebDAV) Move(ctx context.Context, src, dst string) error {
+	client := w.getContextClient(ctx)
+
+	if err := file(client, src); err != nil {
+		return wrap("move", src, err)
+	}
+	if filesystems.CleanKey(src) == filesystems.CleanKey(dst) {
+		return nil
+	}
+
+	meta, err := readMeta(client, src)
+	if err != nil {
+		return wrap("move", src, err)
+	}
+
+	if err := client.Rename(davPath(src), davPath(dst), true); err != nil {
+		return wrap("move", src, err)
+	}
+	if err := writeMeta(client, dst, meta); err != nil {
+		return wrap("move", dst, err)
+	}
+	return wrap("move", src, writeMeta(client, src, nil))
+}
+
+// MkdirAll creates the folder dir and its parents
+func (w *WebDAV) MkdirAll(ctx context.Context, dir string) error {
+	if filesystems.CleanKey(dir) == "" {
+		return nil
+	}
+	return wrap("mkdir", dir, w.getContextClient(ctx).MkdirAll(davPath(dir), 0755))
+}
+
+// Delete removes the files with their metadata, stopping at the first error
+func (w *WebDAV) Delete(itemsToDelete []string) error {
 	client := w.getCredentials()
 
 	for _, item := range itemsToDelete {
-		err := client.Remove(item)
-		if err != nil {
-			return false
+		// Remove succeeds for a missing file
+		if err := file(client, item); err != nil {
+			return wrap("delete", item, err)
+		}
+		if err := client.Remove(davPath(item)); err != nil {
+			return wrap("delete", item, err)
+		}
+		if err := writeMeta(client, item, nil); err != nil {
+			return wrap("delete", item, err)
 		}
 	}
 
-	return true
+	return nil
 }
 
 func (w *WebDAV) Get(destination string, items ...string) error {
 	client := w.getCredentials()
 	for _, item := range items {
 		err := func() error {
-			webdavFilePath := item
+			if err := file(client, item); err != nil {
+				return err
+			}
+
+			webdavFilePath := davPath(item)
 			localFilePath := fmt.Sprintf("%s/%s", destination, path.Base(item))
 
 			// gives us a io.Reader or io.ReadCloser
@@ -90,6 +281,7 @@ func (w *WebDAV) Get(destination string, items ...string) error {
 			if err != nil {
 				return err
 			}
+			defer reader.Close()
 
 			// now need to write from the reader(stream)
 			file, err := os.Create(localFilePath)
@@ -107,8 +299,88 @@ func (w *WebDAV) Get(destination string, items ...string) error {
 		}()
 
 		if err != nil {
-			return err
+			return wrap("get", item, err)
 		}
 	}
 	return nil
 }
+
+// PutStream writes the content of r to key, creating its collections
+func (w *WebDAV) PutStream(ctx context.Context, key string, r io.Reader, opts filesystems.PutOptions) error {
+	client := w.getContextClient(ctx)
+	if opts.ContentType != "" {
+		client.SetInterceptor(func(method string, rq *http.Request) {
+			if method == http.MethodPut {
+				rq.Header.Set("Content-Type", opts.ContentType)
+			}
+		})
+	}
+
+	if err := client.WriteStream(davPath(key), r, 0664); err != nil {
+		return wrap("put", key, err)
+	}
+	return wrap("put", key, writeMeta(client, key, filesystems.NewMeta(opts)))
+}
+
+// Open returns a reader of key
+func (w *WebDAV) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	client := w.getContextClient(ctx)
+	if err := file(client, key); err != nil {
+		return nil, wrap("open", key, err)
+	}
+
+	r, err := client.ReadStream(davPath(key))
+	if err != nil {
+		return nil, wrap("open", key, err)
+	}
+	return r, nil
+}
+
+// OpenRange returns a reader of length bytes of key from offset, requested as a range
+func (w *WebDAV) OpenRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	if offset == 0 && length < 0 {
+		return w.Open(ctx, key)
+	}
+
+	client := w.getContextClient(ctx)
+	info, err := client.Stat(davPath(key))
+	if err != nil {
+		return nil, wrap("open", key, err)
+	}
+	if info.IsDir() {
+		return nil, filesystems.NewError("open", key, filesystems.ErrNotFound, nil)
+	}
+
+	if length < 0 || offset+length > info.Size() {
+		length = info.Size() - offset
+	}
+	if length <= 0 {
+		return ioutil.NopCloser(strings.NewReader("")), nil
+	}
+
+	r, err := client.ReadStreamRange(davPath(key), offset, length)
+	if err != nil {
+		return nil, wrap("open", key, err)
+	}
+	return r, nil
+}
+
+// getContextClient returns a client whose requests are cancelled with ctx
+func (w *WebDAV) getContextClient(ctx context.Context) *gowebdav.Client {
+	c := w.getCredentials()
+	c.SetTransport(contextTransport{ctx: ctx})
+	return c
+}
+
+type contextTransport struct {
+	ctx context.Context
+}
+
+func (t contextTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	return http.DefaultTransport.RoundTrip(r.WithContext(t.ctx))
+}
+
+// TemporaryURL is not supported, the files are served by a signed Celeritas route instead
+func (w *WebDAV) TemporaryURL(key string, expiry time.Duration, opts filesystems.URLOptions) (string, error) {
+	return "", filesystems.ErrTemporaryURLNotSupported
+}
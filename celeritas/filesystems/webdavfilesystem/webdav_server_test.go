@@ -0,0 +1,29 @@
+package webdavfilesystem
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/djedjethai/celeritas/filesystems/fstest"
+	"golang.org/x/net/webdav"
+)
+
+func TestWebDAV_conformance(t *testing.T) {
+	srv := httptest.NewServer(&webdav.Handler{
+		FileSystem: webdav.Dir(t.TempDir()),
+		LockSystem: webdav.NewMemLS(),
+	})
+	defer srv.Close()
+
+	fstest.TestFS(t, &WebDAV{Host: srv.URL}, "")
+}
+
+func TestWebDAV_streams(t *testing.T) {
+	srv := httptest.NewServer(&webdav.Handler{
+		FileSystem: webdav.Dir(t.TempDir()),
+		LockSystem: webdav.NewMemLS(),
+	})
+	defer srv.Close()
+
+	fstest.TestStreams(t, &WebDAV{Host: srv.URL}, "streams")
+}
@@ -0,0 +1,21 @@
+package webdavfilesystem
+
+import (
+	"os"
+	"testing"
+
+	"github.com/djedjethai/celeritas/filesystems/fstest"
+)
+
+// TestWebDAV runs against the server set by the FSTEST_WEBDAV_* variables
+func TestWebDAV(t *testing.T) {
+	if os.Getenv("FSTEST_WEBDAV_HOST") == "" {
+		t.Skip("FSTEST_WEBDAV_HOST not set")
+	}
+
+	fstest.TestFS(t, &WebDAV{
+		Host: os.Getenv("FSTEST_WEBDAV_HOST"),
+		User: os.Getenv("FSTEST_WEBDAV_USER"),
+		Pass: os.Getenv("FSTEST_WEBDAV_PASS"),
+	}, "")
+}
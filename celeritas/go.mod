@@ -3,8 +3,9 @@ module github.com/djedjethai/celeritas
 go 1.17
 
 require (
+	github.com/BurntSushi/toml v1.2.1
 	github.com/CloudyKit/jet/v6 v6.1.0
-	github.com/ainsleyclark/go-mail v1.0.3
+	github.com/ainsleyclark/go-mail v1.1.1
 	github.com/alexedwards/scs/mysqlstore v0.0.0-20210904201103-9ffa4cfa9323
 	github.com/alexedwards/scs/postgresstore v0.0.0-20210904201103-9ffa4cfa9323
 	github.com/alexedwards/scs/redisstore v0.0.0-20210904201103-9ffa4cfa9323
@@ -14,6 +15,7 @@ require (
 	github.com/aws/aws-sdk-go v1.43.25
 	github.com/bwmarrin/go-alone v0.0.0-20190806015146-742bb55d1631
 	github.com/dgraph-io/badger/v3 v3.2103.1
+	github.com/emersion/go-msgauth v0.6.6
 	github.com/fatih/color v1.13.0
 	github.com/gertd/go-pluralize v0.1.7
 	github.com/go-chi/chi/v5 v5.0.4
@@ -33,7 +35,10 @@ require (
 	github.com/studio-b12/gowebdav v0.0.0-20220128162035-c7b1ff8a5e62
 	github.com/vanng822/go-premailer v1.20.1
 	github.com/xhit/go-simple-mail/v2 v2.10.0
-	golang.org/x/crypto v0.0.0-20210711020723-a769d52b0f97
+	github.com/yuin/goldmark v1.4.0
+	golang.org/x/crypto v0.0.0-20220518034528-6f7dac969898
+	golang.org/x/image v0.18.0
+	gopkg.in/yaml.v2 v2.4.0
 )
 
 require (
@@ -133,14 +138,13 @@ require (
 	github.com/xeipuuv/gojsonschema v1.2.0 // indirect
 	github.com/yuin/gopher-lua v0.0.0-20200816102855-ee81675732da // indirect
 	go.opencensus.io v0.23.0 // indirect
-	golang.org/x/sync v0.0.0-20210220032951-036812b2e83c // indirect
+	golang.org/x/sync v0.7.0 // indirect
 	golang.org/x/sys v0.0.0-20211216021012-1d35b9e2eb4e // indirect
 	golang.org/x/term v0.0.0-20210927222741-03fcf44c2211 // indirect
-	golang.org/x/text v0.3.7 // indirect
+	golang.org/x/text v0.16.0 // indirect
 	google.golang.org/protobuf v1.26.0 // indirect
 	gopkg.in/ini.v1 v1.62.0 // indirect
 	gopkg.in/warnings.v0 v0.1.2 // indirect
-	gopkg.in/yaml.v2 v2.4.0 // indirect
 )
 
 require (
@@ -151,5 +155,5 @@ require (
 	github.com/minio/minio-go/v7 v7.0.23
 	github.com/sendgrid/rest v2.6.5+incompatible // indirect
 	github.com/sendgrid/sendgrid-go v3.10.1+incompatible // indirect
-	golang.org/x/net v0.0.0-20220127200216-cd36cc0744dd // indirect
+	golang.org/x/net v0.0.0-20220127200216-cd36cc0744dd
 )
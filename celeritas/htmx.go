@@ -0,0 +1,42 @@
+package celeritas
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/djedjethai/celeritas/render"
+)
+
+// IsHTMX reports whether the request was issued by htmx
+func (c *Celeritas) IsHTMX(r *http.Request) bool {
+	return render.IsHTMX(r)
+}
+
+// HXRedirect tells htmx to do a full client side redirect to url
+func (c *Celeritas) HXRedirect(w http.ResponseWriter, url string) {
+	w.Header().Set("HX-Redirect", url)
+}
+
+// HXTrigger triggers one or more client side events once the response is swapped in
+func (c *Celeritas) HXTrigger(w http.ResponseWriter, events ...string) {
+	w.Header().Set("HX-Trigger", strings.Join(events, ", "))
+}
+
+// HXTriggerWithData triggers client side events carrying a payload, e.g.
+// {"showMessage": {"level": "info", "message": "Saved"}}
+func (c *Celeritas) HXTriggerWithData(w http.ResponseWriter, events map[string]interface{}) error {
+	out, err := json.Marshal(events)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("HX-Trigger", string(out))
+	return nil
+}
+
+// HXRetarget swaps the response into the element matching the css selector,
+// instead of the target set on the requesting element
+func (c *Celeritas) HXRetarget(w http.ResponseWriter, selector string) {
+	w.Header().Set("HX-Retarget", selector)
+}
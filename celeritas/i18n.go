@@ -0,0 +1,26 @@
+package celeritas
+
+import (
+	"net/http"
+
+	"github.com/djedjethai/celeritas/i18n"
+)
+
+// T translates key into the locale of the request. See i18n.Translator.T for the arguments
+func (c *Celeritas) T(r *http.Request, key string, args ...interface{}) string {
+	if c.I18n == nil {
+		return key
+	}
+	return c.I18n.T(c.LocaleOf(r), key, args...)
+}
+
+// LocaleOf returns the locale of the request, as detected by the Locale middleware
+func (c *Celeritas) LocaleOf(r *http.Request) string {
+	if locale := i18n.Locale(r.Context()); locale != "" {
+		return locale
+	}
+	if c.I18n != nil {
+		return c.I18n.Default
+	}
+	return ""
+}
@@ -0,0 +1,271 @@
+package i18n
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// Translator holds the translations of an application, loaded from the lang folder.
+// Each file is named after its locale (en.json, fr.yaml, pt-BR.toml); nested keys
+// are flattened with dots, so {"auth": {"login": "Log in"}} is looked up as auth.login
+type Translator struct {
+	// Default is the locale used when a key is missing in the requested locale
+	Default string
+
+	mu       sync.RWMutex
+	messages map[string]map[string]interface{}
+	rules    map[string]PluralRule
+}
+
+// New returns a Translator falling back to defaultLocale
+func New(defaultLocale string) *Translator {
+	if defaultLocale == "" {
+		defaultLocale = "en"
+	}
+
+	return &Translator{
+		Default:  defaultLocale,
+		messages: make(map[string]map[string]interface{}),
+		rules:    make(map[string]PluralRule),
+	}
+}
+
+// LoadDir loads every json, yaml and toml file in dir
+func (t *Translator) LoadDir(dir string) error {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	for _, f := range files {
+		if f.IsDir() || strings.HasPrefix(f.Name(), ".") {
+			continue
+		}
+
+		err = t.LoadFile(filepath.Join(dir, f.Name()))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadFile loads a single translation file; the locale is the file name without extension
+func (t *Translator) LoadFile(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	ext := strings.ToLower(filepath.Ext(path))
+	locale := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+
+	messages := make(map[string]interface{})
+	switch ext {
+	case ".json":
+		err = json.Unmarshal(content, &messages)
+	case ".yaml", ".yml":
+		var raw map[interface{}]interface{}
+		err = yaml.Unmarshal(content, &raw)
+		if err == nil {
+			messages = stringKeys(raw)
+		}
+	case ".toml":
+		_, err = toml.Decode(string(content), &messages)
+	default:
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("i18n: could not parse %s: %w", path, err)
+	}
+
+	t.Add(locale, messages)
+	return nil
+}
+
+// Add adds messages to locale, replacing any existing key
+func (t *Translator) Add(locale string, messages map[string]interface{}) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.messages[locale] == nil {
+		t.messages[locale] = make(map[string]interface{})
+	}
+	flatten(t.messages[locale], "", messages)
+}
+
+// Locales returns the loaded locales
+func (t *Translator) Locales() []string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	var locales []string
+	for l := range t.messages {
+		locales = append(locales, l)
+	}
+	sort.Strings(locales)
+	return locales
+}
+
+// Has reports whether translations exist for locale
+func (t *Translator) Has(locale string) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	_, ok := t.messages[locale]
+	return ok
+}
+
+// T translates key into locale. Arguments are either a single map, or key/value
+// pairs, and replace the {name} placeholders of the message. The count argument
+// selects the plural form, e.g.
+//
+//	T("en", "inbox.messages", "count", 3)
+//
+// with "inbox.messages": {"one": "{count} message", "other": "{count} messages"}.
+// Missing keys fall back to the default locale, then to the key itself
+func (t *Translator) T(locale, key string, args ...interface{}) string {
+	params := toParams(args)
+
+	msg, ok := t.lookup(locale, key, params)
+	if !ok {
+		msg, ok = t.lookup(t.Default, key, params)
+	}
+	if !ok {
+		msg = key
+	}
+
+	return interpolate(msg, params)
+}
+
+// lookup finds the message for key, trying locale then its base language (pt-BR then pt)
+func (t *Translator) lookup(locale, key string, params map[string]interface{}) (string, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	for _, l := range []string{locale, baseLanguage(locale)} {
+		messages, ok := t.messages[l]
+		if !ok {
+			continue
+		}
+
+		if msg, ok := messages[key].(string); ok {
+			return msg, true
+		}
+
+		// plural forms are flattened as key.one, key.other...
+		if count, ok := countOf(params); ok {
+			forms := []string{}
+			if count == 0 {
+				forms = append(forms, "zero")
+			}
+			forms = append(forms, t.pluralRule(l)(count), "other")
+
+			for _, form := range forms {
+				if msg, ok := messages[key+"."+form].(string); ok {
+					return msg, true
+				}
+			}
+		}
+	}
+	return "", false
+}
+
+func flatten(dst map[string]interface{}, prefix string, src map[string]interface{}) {
+	for k, v := range src {
+		key := k
+		if prefix != "" {
+			key = prefix + "." + k
+		}
+
+		switch value := v.(type) {
+		case map[string]interface{}:
+			flatten(dst, key, value)
+		case map[interface{}]interface{}:
+			flatten(dst, key, stringKeys(value))
+		case string:
+			dst[key] = value
+		default:
+			dst[key] = fmt.Sprint(value)
+		}
+	}
+}
+
+// stringKeys converts the maps decoded by yaml to map[string]interface{}
+func stringKeys(m map[interface{}]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		out[fmt.Sprint(k)] = v
+	}
+	return out
+}
+
+func toParams(args []interface{}) map[string]interface{} {
+	params := make(map[string]interface{})
+
+	if len(args) == 1 {
+		if m, ok := args[0].(map[string]interface{}); ok {
+			return m
+		}
+		if m, ok := args[0].(map[string]string); ok {
+			for k, v := range m {
+				params[k] = v
+			}
+			return params
+		}
+	}
+
+	for i := 0; i+1 < len(args); i += 2 {
+		params[fmt.Sprint(args[i])] = args[i+1]
+	}
+	return params
+}
+
+func interpolate(msg string, params map[string]interface{}) string {
+	if len(params) == 0 || !strings.Contains(msg, "{") {
+		return msg
+	}
+
+	pairs := make([]string, 0, len(params)*2)
+	for k, v := range params {
+		pairs = append(pairs, "{"+k+"}", fmt.Sprint(v))
+	}
+	return strings.NewReplacer(pairs...).Replace(msg)
+}
+
+func countOf(params map[string]interface{}) (int, bool) {
+	switch n := params["count"].(type) {
+	case int:
+		return n, true
+	case int64:
+		return int(n), true
+	case int32:
+		return int(n), true
+	case uint:
+		return int(n), true
+	case float64:
+		return int(n), true
+	case float32:
+		return int(n), true
+	default:
+		return 0, false
+	}
+}
+
+func baseLanguage(locale string) string {
+	if i := strings.IndexAny(locale, "-_"); i > 0 {
+		return locale[:i]
+	}
+	return locale
+}
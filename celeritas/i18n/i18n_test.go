@@ -0,0 +1,102 @@
+package i18n
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+var translateTests = []struct {
+	name     string
+	locale   string
+	key      string
+	args     []interface{}
+	expected string
+}{
+	{"interpolation", "en", "welcome", []interface{}{"name", "Jane"}, "Welcome, Jane!"},
+	{"interpolation_map", "fr", "welcome", []interface{}{map[string]interface{}{"name": "Jane"}}, "Bienvenue, Jane !"},
+	{"yaml", "fr", "inbox.messages", []interface{}{"count", 2}, "2 messages"},
+	{"french_zero_is_singular", "fr", "inbox.messages", []interface{}{"count", 0}, "0 message"},
+	{"explicit_zero", "en", "inbox.messages", []interface{}{"count", 0}, "No messages"},
+	{"one", "en", "inbox.messages", []interface{}{"count", 1}, "1 message"},
+	{"other", "en", "inbox.messages", []interface{}{"count", 5}, "5 messages"},
+	{"toml_few", "ru", "inbox.messages", []interface{}{"count", 3}, "3 сообщения"},
+	{"toml_many", "ru", "inbox.messages", []interface{}{"count", 11}, "11 сообщений"},
+	{"toml_one", "ru", "inbox.messages", []interface{}{"count", 21}, "21 сообщение"},
+	{"region_falls_back_to_language", "fr-CA", "welcome", []interface{}{"name", "Jo"}, "Bienvenue, Jo !"},
+	{"missing_falls_back_to_default", "fr", "validation.required", nil, "This field cannot be blank"},
+	{"missing_everywhere", "fr", "nope.nothing", nil, "nope.nothing"},
+}
+
+func TestTranslator_T(t *testing.T) {
+	for _, e := range translateTests {
+		got := testTranslator.T(e.locale, e.key, e.args...)
+		if got != e.expected {
+			t.Errorf("%s: expected %q, got %q", e.name, e.expected, got)
+		}
+	}
+}
+
+func TestTranslator_Locales(t *testing.T) {
+	locales := testTranslator.Locales()
+	if len(locales) != 3 || locales[0] != "en" || locales[1] != "fr" || locales[2] != "ru" {
+		t.Errorf("unexpected locales %v", locales)
+	}
+}
+
+type fakeSession map[string]string
+
+func (s fakeSession) GetString(ctx context.Context, key string) string {
+	return s[key]
+}
+
+var detectTests = []struct {
+	name           string
+	path           string
+	cookie         string
+	session        string
+	acceptLanguage string
+	expected       string
+	expectedPath   string
+}{
+	{"default", "/about", "", "", "", "en", "/about"},
+	{"url_prefix", "/fr/about", "ru", "ru", "ru", "fr", "/about"},
+	{"url_prefix_root", "/fr", "", "", "", "fr", "/"},
+	{"unknown_prefix", "/de/about", "", "", "", "en", "/de/about"},
+	{"cookie", "/about", "ru", "fr", "fr", "ru", "/about"},
+	{"session", "/about", "", "fr", "ru", "fr", "/about"},
+	{"accept_language", "/about", "", "", "de-DE, ru;q=0.5, fr;q=0.8", "fr", "/about"},
+	{"accept_language_region", "/about", "", "", "ru-RU", "ru", "/about"},
+}
+
+func TestDetector_Middleware(t *testing.T) {
+	for _, e := range detectTests {
+		d := Detector{
+			Translator: testTranslator,
+			Session:    fakeSession{"locale": e.session},
+		}
+
+		var locale, path string
+		handler := d.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			locale = Locale(r.Context())
+			path = r.URL.Path
+		}))
+
+		r := httptest.NewRequest("GET", e.path, nil)
+		if e.cookie != "" {
+			r.AddCookie(&http.Cookie{Name: "lang", Value: e.cookie})
+		}
+		if e.acceptLanguage != "" {
+			r.Header.Set("Accept-Language", e.acceptLanguage)
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+
+		if locale != e.expected {
+			t.Errorf("%s: expected locale %s, got %s", e.name, e.expected, locale)
+		}
+		if path != e.expectedPath {
+			t.Errorf("%s: expected path %s, got %s", e.name, e.expectedPath, path)
+		}
+	}
+}
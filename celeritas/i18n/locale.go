@@ -0,0 +1,152 @@
+package i18n
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type contextKey string
+
+const localeKey contextKey = "locale"
+
+// SessionStore reads the locale saved in the session; *scs.SessionManager satisfies it
+type SessionStore interface {
+	GetString(ctx context.Context, key string) string
+}
+
+// Detector finds the locale of a request, trying in order the url prefix (/fr/about),
+// the locale cookie, the session, and the Accept-Language header
+type Detector struct {
+	Translator *Translator
+	// CookieName is the cookie holding the locale; defaults to lang
+	CookieName string
+	// SessionKey is the session key holding the locale; defaults to locale
+	SessionKey string
+	Session    SessionStore
+}
+
+// WithLocale returns a copy of ctx carrying locale
+func WithLocale(ctx context.Context, locale string) context.Context {
+	return context.WithValue(ctx, localeKey, locale)
+}
+
+// Locale returns the locale stored in ctx by the middleware, or an empty string
+func Locale(ctx context.Context) string {
+	locale, _ := ctx.Value(localeKey).(string)
+	return locale
+}
+
+// Middleware stores the locale of the request in its context. A locale url prefix
+// is stripped, so /fr/about is routed as /about
+func (d *Detector) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		locale, rest, ok := d.fromPath(r.URL.Path)
+		if ok {
+			r.URL.Path = rest
+			r.URL.RawPath = ""
+		} else {
+			locale = d.Detect(r)
+		}
+
+		next.ServeHTTP(w, r.WithContext(WithLocale(r.Context(), locale)))
+	})
+}
+
+// Detect returns the locale of r, without looking at the url
+func (d *Detector) Detect(r *http.Request) string {
+	cookieName := d.CookieName
+	if cookieName == "" {
+		cookieName = "lang"
+	}
+	if c, err := r.Cookie(cookieName); err == nil && d.Translator.Has(c.Value) {
+		return c.Value
+	}
+
+	if d.Session != nil {
+		sessionKey := d.SessionKey
+		if sessionKey == "" {
+			sessionKey = "locale"
+		}
+		if l := d.sessionLocale(r, sessionKey); d.Translator.Has(l) {
+			return l
+		}
+	}
+
+	for _, l := range parseAcceptLanguage(r.Header.Get("Accept-Language")) {
+		if d.Translator.Has(l) {
+			return l
+		}
+		if base := baseLanguage(l); d.Translator.Has(base) {
+			return base
+		}
+	}
+
+	return d.Translator.Default
+}
+
+// sessionLocale reads the session, which panics when the session is not loaded
+// into the request context
+func (d *Detector) sessionLocale(r *http.Request, key string) (locale string) {
+	defer func() {
+		if recover() != nil {
+			locale = ""
+		}
+	}()
+	return d.Session.GetString(r.Context(), key)
+}
+
+func (d *Detector) fromPath(p string) (string, string, bool) {
+	trimmed := strings.TrimPrefix(p, "/")
+	first := trimmed
+	rest := "/"
+	if i := strings.Index(trimmed, "/"); i >= 0 {
+		first = trimmed[:i]
+		rest = trimmed[i:]
+	}
+
+	if first == "" || !d.Translator.Has(first) {
+		return "", p, false
+	}
+	return first, rest, true
+}
+
+// parseAcceptLanguage returns the languages of an Accept-Language header, by preference
+func parseAcceptLanguage(header string) []string {
+	type lang struct {
+		tag string
+		q   float64
+	}
+
+	var langs []lang
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		tag := strings.TrimSpace(fields[0])
+		if tag == "" || tag == "*" {
+			continue
+		}
+
+		q := 1.0
+		for _, f := range fields[1:] {
+			f = strings.TrimSpace(f)
+			if strings.HasPrefix(f, "q=") {
+				if v, err := strconv.ParseFloat(f[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if q > 0 {
+			langs = append(langs, lang{tag, q})
+		}
+	}
+
+	sort.SliceStable(langs, func(i, j int) bool { return langs[i].q > langs[j].q })
+
+	tags := make([]string, 0, len(langs))
+	for _, l := range langs {
+		tags = append(tags, l.tag)
+	}
+	return tags
+}
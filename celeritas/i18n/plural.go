@@ -0,0 +1,94 @@
+package i18n
+
+// PluralRule returns the CLDR plural category (zero, one, two, few, many, other)
+// of n in a language
+type PluralRule func(n int) string
+
+// AddPluralRule sets the plural rule of a language, overriding the built-in one
+func (t *Translator) AddPluralRule(lang string, rule PluralRule) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rules[lang] = rule
+}
+
+// pluralRule returns the rule for locale; callers must hold the lock
+func (t *Translator) pluralRule(locale string) PluralRule {
+	if rule, ok := t.rules[locale]; ok {
+		return rule
+	}
+	if rule, ok := t.rules[baseLanguage(locale)]; ok {
+		return rule
+	}
+	if rule, ok := builtinRules[baseLanguage(locale)]; ok {
+		return rule
+	}
+	return oneOther
+}
+
+var builtinRules = map[string]PluralRule{
+	"fr": zeroOneOther,
+	"ja": otherOnly,
+	"ko": otherOnly,
+	"zh": otherOnly,
+	"th": otherOnly,
+	"vi": otherOnly,
+	"id": otherOnly,
+	"ru": slavic,
+	"uk": slavic,
+	"pl": polish,
+	"cs": czech,
+	"sk": czech,
+}
+
+// oneOther is used by English, German, Spanish, Italian, Dutch and most other languages
+func oneOther(n int) string {
+	if n == 1 {
+		return "one"
+	}
+	return "other"
+}
+
+// zeroOneOther treats 0 as singular, like French
+func zeroOneOther(n int) string {
+	if n == 0 || n == 1 {
+		return "one"
+	}
+	return "other"
+}
+
+func otherOnly(n int) string {
+	return "other"
+}
+
+func slavic(n int) string {
+	switch {
+	case n%10 == 1 && n%100 != 11:
+		return "one"
+	case n%10 >= 2 && n%10 <= 4 && (n%100 < 12 || n%100 > 14):
+		return "few"
+	default:
+		return "many"
+	}
+}
+
+func polish(n int) string {
+	switch {
+	case n == 1:
+		return "one"
+	case n%10 >= 2 && n%10 <= 4 && (n%100 < 12 || n%100 > 14):
+		return "few"
+	default:
+		return "many"
+	}
+}
+
+func czech(n int) string {
+	switch {
+	case n == 1:
+		return "one"
+	case n >= 2 && n <= 4:
+		return "few"
+	default:
+		return "other"
+	}
+}
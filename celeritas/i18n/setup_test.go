@@ -0,0 +1,17 @@
+package i18n
+
+import (
+	"log"
+	"os"
+	"testing"
+)
+
+var testTranslator = New("en")
+
+func TestMain(m *testing.M) {
+	if err := testTranslator.LoadDir("./testdata/lang"); err != nil {
+		log.Fatal(err)
+	}
+
+	os.Exit(m.Run())
+}
@@ -0,0 +1,179 @@
+package celeritas
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/djedjethai/celeritas/filesystems"
+	"github.com/djedjethai/celeritas/images"
+	"gopkg.in/yaml.v2"
+)
+
+// ImagesPath is where applications mount DerivedImages, which serves the derived images
+const ImagesPath = "/img"
+
+// imagesFile configures the image presets, relative to the root path
+const imagesFile = "config/images.yml"
+
+// imagesConfig is the content of config/images.yml, such as
+//
+//	cache_disk: local
+//	presets:
+//	  avatar:
+//	    width: 128
+//	    height: 128
+//	    fit: crop
+//	  thumbnail:
+//	    width: 400
+//	    format: jpeg
+//	    quality: 80
+//
+// The derived images are kept on the cache disk, the default disk when it is not set
+type imagesConfig struct {
+	CacheDisk string                   `yaml:"cache_disk"`
+	Presets   map[string]images.Preset `yaml:"presets"`
+}
+
+// ImageURL returns the url of the image derived with preset from key of disk, the
+// default disk when empty. It is signed, so only the presets of the application are
+// derived, and only from the images it links to
+func (c *Celeritas) ImageURL(preset, disk, key string) string {
+	if disk == "" && c.FileSystems != nil {
+		disk = c.FileSystems.Default()
+	}
+	key = filesystems.CleanKey(key)
+
+	params := url.Values{}
+	params.Set("disk", disk)
+	params.Set("s", c.imageSignature(preset, disk, key))
+
+	link := ImagesPath + "/" + url.PathEscape(preset) + "/" + (&url.URL{Path: key}).EscapedPath()
+	return strings.TrimSuffix(c.Server.URL, "/") + link + "?" + params.Encode()
+}
+
+// DeriveImage returns the image derived with preset from key of disk, the default disk
+// when empty, deriving it unless it is cached already
+func (c *Celeritas) DeriveImage(ctx context.Context, preset, disk, key string) (images.Derived, error) {
+	if c.Images == nil {
+		return images.Derived{}, fmt.Errorf("%w %q", images.ErrUnknownPreset, preset)
+	}
+	if disk == "" && c.FileSystems != nil {
+		disk = c.FileSystems.Default()
+	}
+
+	fs, err := c.Disk(disk)
+	if err != nil {
+		return images.Derived{}, err
+	}
+	return c.Images.Derive(ctx, preset, disk, fs, filesystems.CleanKey(key))
+}
+
+// DerivedImages serves the urls of ImageURL, /img/{preset}/{key}, deriving the images
+// on their first request. Mount it at ImagesPath
+func (c *Celeritas) DerivedImages() http.Handler {
+	return http.HandlerFunc(c.serveImage)
+}
+
+func (c *Celeritas) serveImage(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, ImagesPath+"/")
+	i := strings.Index(rest, "/")
+	if i <= 0 {
+		http.NotFound(w, r)
+		return
+	}
+	preset, key := rest[:i], filesystems.CleanKey(rest[i+1:])
+	disk := r.URL.Query().Get("disk")
+
+	if !hmac.Equal([]byte(r.URL.Query().Get("s")), []byte(c.imageSignature(preset, disk, key))) {
+		http.Error(w, "invalid signature", http.StatusForbidden)
+		return
+	}
+
+	derived, err := c.DeriveImage(r.Context(), preset, disk, key)
+	switch {
+	case err == nil:
+	case errors.Is(err, filesystems.ErrNotFound), errors.Is(err, images.ErrUnknownPreset), errors.Is(err, filesystems.ErrUnknownDisk):
+		http.NotFound(w, r)
+		return
+	case errors.Is(err, images.ErrUnsupported):
+		http.Error(w, "not an image", http.StatusUnsupportedMediaType)
+		return
+	case errors.Is(err, images.ErrTooLarge):
+		http.Error(w, "image too large", http.StatusRequestEntityTooLarge)
+		return
+	default:
+		c.ErrorLog.Println(err)
+		http.Error(w, "could not derive the image", http.StatusInternalServerError)
+		return
+	}
+
+	// the key of a derived image changes with its version
+	sum := sha256.Sum256([]byte(derived.Key))
+	etag := `"` + base64.RawURLEncoding.EncodeToString(sum[:12]) + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	file, err := c.Images.Cache.Open(r.Context(), derived.Key)
+	if err != nil {
+		c.ErrorLog.Println(err)
+		http.Error(w, "could not read the image", http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Type", derived.ContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(derived.Size, 10))
+	if r.Method == http.MethodHead {
+		return
+	}
+	_, _ = io.Copy(w, file)
+}
+
+// imageSignature is an hmac of the image, stable so the browsers cache its url
+func (c *Celeritas) imageSignature(preset, disk, key string) string {
+	mac := hmac.New(sha256.New, []byte(c.EncryptionKey))
+	mac.Write([]byte(preset + "\n" + disk + "\n" + key))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// createImages returns the image pipeline of config/images.yml, without presets when
+// the file does not exist
+func (c *Celeritas) createImages() (*images.Pipeline, error) {
+	var cfg imagesConfig
+	content, err := ioutil.ReadFile(filepath.Join(c.RootPath, imagesFile))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err := yaml.Unmarshal([]byte(os.ExpandEnv(string(content))), &cfg); err != nil {
+			return nil, fmt.Errorf("%s: %w", imagesFile, err)
+		}
+	}
+
+	pipeline := &images.Pipeline{Presets: cfg.Presets}
+	if pipeline.Presets == nil {
+		pipeline.Presets = make(map[string]images.Preset)
+	}
+
+	pipeline.Cache, err = c.Disk(cfg.CacheDisk)
+	if err != nil {
+		return nil, fmt.Errorf("%s: cache_disk: %w", imagesFile, err)
+	}
+	return pipeline, nil
+}
@@ -0,0 +1,226 @@
+// Package images derives images, like avatars and thumbnails, from the uploaded ones:
+// it decodes JPEG, PNG, GIF and WebP, fixes the EXIF orientation, resizes, crops and
+// encodes to JPEG or PNG, without their metadata. It is pure Go
+package images
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"math"
+
+	// decoders registered with image.Decode
+	_ "image/gif"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// Fit modes
+const (
+	// FitInside scales the image down to fit within the width and height, keeping its
+	// ratio. It is the default
+	FitInside = "fit"
+	// FitCrop scales the image to cover the width and height, keeping its ratio, and
+	// crops what goes past them around the center
+	FitCrop = "crop"
+	// FitStretch resizes the image to exactly the width and height
+	FitStretch = "resize"
+)
+
+const (
+	defaultQuality = 85
+	// DefaultMaxPixels is the largest image decoded when MaxPixels is not set, 40
+	// megapixels, against the images made to exhaust the memory
+	DefaultMaxPixels = 40000000
+	// DefaultMaxBytes is the largest file read when MaxBytes is not set, 64MB
+	DefaultMaxBytes = 64 << 20
+	// maxHeaderBytes is the most read to find the size of an image
+	maxHeaderBytes = 1 << 20
+)
+
+var (
+	// ErrUnsupported is returned for a file which is not an image of a supported format
+	ErrUnsupported = errors.New("images: unsupported image format")
+	// ErrTooLarge is returned for an image with more pixels than allowed
+	ErrTooLarge = errors.New("images: image too large")
+)
+
+// Preset describes a derived image. A width or height of 0 follows the ratio of the
+// image
+type Preset struct {
+	Width  int    `yaml:"width"`
+	Height int    `yaml:"height"`
+	Fit    string `yaml:"fit"`
+	// Format is jpeg or png. When empty, it is the format of the image for those two,
+	// and png for the others
+	Format string `yaml:"format"`
+	// Quality is the jpeg quality, 85 by default
+	Quality int `yaml:"quality"`
+	// MaxPixels is the largest image decoded, DefaultMaxPixels when 0
+	MaxPixels int `yaml:"max_pixels"`
+	// MaxBytes is the largest file read, DefaultMaxBytes when 0
+	MaxBytes int64 `yaml:"max_bytes"`
+}
+
+// Process decodes the image read from r, transforms it as p says, and writes it to w.
+// It returns the content type written. The size of the image is checked against
+// MaxPixels before it is read past its header
+func Process(r io.Reader, w io.Writer, p Preset) (string, error) {
+	cfg, format, r, err := DecodeConfig(r)
+	if err != nil {
+		return "", err
+	}
+	maxPixels := p.MaxPixels
+	if maxPixels <= 0 {
+		maxPixels = DefaultMaxPixels
+	}
+	if cfg.Width*cfg.Height > maxPixels {
+		return "", fmt.Errorf("%w: %dx%d", ErrTooLarge, cfg.Width, cfg.Height)
+	}
+
+	maxBytes := p.MaxBytes
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxBytes
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(r, maxBytes+1))
+	if err != nil {
+		return "", err
+	}
+	if int64(len(data)) > maxBytes {
+		return "", fmt.Errorf("%w: more than %d bytes", ErrTooLarge, maxBytes)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return "", fmt.Errorf("images: %w", err)
+	}
+	if format == "jpeg" {
+		img = orient(img, jpegOrientation(data))
+	}
+
+	img = transform(img, p)
+
+	switch outputFormat(p.Format, format) {
+	case "jpeg":
+		quality := p.Quality
+		if quality <= 0 || quality > 100 {
+			quality = defaultQuality
+		}
+		return "image/jpeg", jpeg.Encode(w, flatten(img), &jpeg.Options{Quality: quality})
+	case "png":
+		return "image/png", png.Encode(w, img)
+	default:
+		return "", fmt.Errorf("images: unsupported output format %s", p.Format)
+	}
+}
+
+// DecodeConfig reads the header of the image of r, at most 1MB, and returns its size
+// and its format, such as jpeg, along with a reader of the whole image, header
+// included. It fails with ErrUnsupported when r is not an image of a supported format
+func DecodeConfig(r io.Reader) (image.Config, string, io.Reader, error) {
+	var header bytes.Buffer
+	cfg, format, err := image.DecodeConfig(io.TeeReader(io.LimitReader(r, maxHeaderBytes), &header))
+	if err != nil {
+		return cfg, "", nil, ErrUnsupported
+	}
+	return cfg, format, io.MultiReader(&header, r), nil
+}
+
+// Extension returns the extension of the images of p derived from an image of the
+// format, as returned by DecodeConfig, like .jpg
+func (p Preset) Extension(format string) string {
+	if outputFormat(p.Format, format) == "jpeg" {
+		return ".jpg"
+	}
+	return ".png"
+}
+
+func outputFormat(format, source string) string {
+	switch format {
+	case "jpg", "jpeg":
+		return "jpeg"
+	case "":
+		if source == "jpeg" {
+			return "jpeg"
+		}
+		return "png"
+	default:
+		return format
+	}
+}
+
+// transform resizes img, and crops it, as p says
+func transform(img image.Image, p Preset) image.Image {
+	src := img.Bounds()
+	w, h := src.Dx(), src.Dy()
+	if p.Width <= 0 && p.Height <= 0 || w == 0 || h == 0 {
+		return img
+	}
+
+	scaleW, scaleH := float64(p.Width)/float64(w), float64(p.Height)/float64(h)
+	// a missing side follows the ratio
+	if p.Width <= 0 {
+		scaleW = scaleH
+	}
+	if p.Height <= 0 {
+		scaleH = scaleW
+	}
+
+	var dw, dh int
+	switch p.Fit {
+	case FitStretch:
+		dw, dh = size(w, scaleW), size(h, scaleH)
+
+	case FitCrop:
+		scale := math.Max(scaleW, scaleH)
+		dw, dh = size(w, scaleW), size(h, scaleH)
+		// the part of the image which covers the box, around its center
+		cw, ch := int(math.Round(float64(dw)/scale)), int(math.Round(float64(dh)/scale))
+		if cw > w {
+			cw = w
+		}
+		if ch > h {
+			ch = h
+		}
+		x, y := src.Min.X+(w-cw)/2, src.Min.Y+(h-ch)/2
+		src = image.Rect(x, y, x+cw, y+ch)
+
+	default:
+		// never enlarged
+		scale := math.Min(math.Min(scaleW, scaleH), 1)
+		dw, dh = size(w, scale), size(h, scale)
+	}
+
+	if dw == w && dh == h && src == img.Bounds() {
+		return img
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, src, draw.Src, nil)
+	return dst
+}
+
+func size(side int, scale float64) int {
+	if n := int(math.Round(float64(side) * scale)); n > 0 {
+		return n
+	}
+	return 1
+}
+
+// flatten draws img on white, as jpeg has no transparency
+func flatten(img image.Image) image.Image {
+	if opaque, ok := img.(interface{ Opaque() bool }); ok && opaque.Opaque() {
+		return img
+	}
+
+	dst := image.NewRGBA(img.Bounds())
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), img, img.Bounds().Min, draw.Over)
+	return dst
+}
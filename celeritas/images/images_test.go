@@ -0,0 +1,242 @@
+package images
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/djedjethai/celeritas/filesystems"
+	"github.com/djedjethai/celeritas/filesystems/memfilesystem"
+)
+
+// halves returns a w x h image, red on its left half and blue on its right one
+func halves(w, h int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.NRGBA{R: 255, A: 255}
+			if x >= w/2 {
+				c = color.NRGBA{B: 255, A: 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	if err := png.Encode(&b, img); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+// withOrientation returns a jpeg of img with an EXIF orientation
+func withOrientation(t *testing.T, img image.Image, orientation byte) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	if err := jpeg.Encode(&b, img, &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatal(err)
+	}
+
+	tiff := []byte{
+		'I', 'I', 0x2A, 0x00, 0x08, 0x00, 0x00, 0x00, // little endian, first IFD at 8
+		0x01, 0x00, // one entry
+		0x12, 0x01, 0x03, 0x00, 0x01, 0x00, 0x00, 0x00, orientation, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, // no next IFD
+	}
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	segment := append([]byte{0xFF, 0xE1, byte((len(payload) + 2) >> 8), byte(len(payload) + 2)}, payload...)
+
+	data := b.Bytes()
+	return append(append(append([]byte{}, data[:2]...), segment...), data[2:]...)
+}
+
+func decode(t *testing.T, data []byte) image.Image {
+	t.Helper()
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
+
+func TestProcess_fit(t *testing.T) {
+	source := encodePNG(t, halves(400, 200))
+
+	tests := []struct {
+		name          string
+		preset        Preset
+		width, height int
+	}{
+		{"fit", Preset{Width: 100, Height: 100}, 100, 50},
+		{"fit width", Preset{Width: 200}, 200, 100},
+		{"never enlarged", Preset{Width: 1000, Height: 1000}, 400, 200},
+		{"crop", Preset{Width: 100, Height: 100, Fit: FitCrop}, 100, 100},
+		{"resize", Preset{Width: 50, Height: 80, Fit: FitStretch}, 50, 80},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		contentType, err := Process(bytes.NewReader(source), &out, tt.preset)
+		if err != nil {
+			t.Fatalf("%s: %s", tt.name, err)
+		}
+		if contentType != "image/png" {
+			t.Errorf("%s: expected png, got %s", tt.name, contentType)
+		}
+		b := decode(t, out.Bytes()).Bounds()
+		if b.Dx() != tt.width || b.Dy() != tt.height {
+			t.Errorf("%s: expected %dx%d, got %dx%d", tt.name, tt.width, tt.height, b.Dx(), b.Dy())
+		}
+	}
+}
+
+func TestProcess_crop(t *testing.T) {
+	// the square of the center keeps both halves
+	var out bytes.Buffer
+	_, err := Process(bytes.NewReader(encodePNG(t, halves(400, 200))), &out, Preset{Width: 10, Height: 10, Fit: FitCrop})
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := decode(t, out.Bytes())
+	if r, _, b, _ := img.At(1, 5).RGBA(); r < b {
+		t.Error("expected red on the left")
+	}
+	if r, _, b, _ := img.At(8, 5).RGBA(); b < r {
+		t.Error("expected blue on the right")
+	}
+}
+
+func TestProcess_orientation(t *testing.T) {
+	// 6: the camera was turned, the left of the image is its top
+	var out bytes.Buffer
+	contentType, err := Process(bytes.NewReader(withOrientation(t, halves(40, 20), 6)), &out, Preset{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contentType != "image/jpeg" {
+		t.Errorf("expected the jpeg kept, got %s", contentType)
+	}
+	if bytes.Contains(out.Bytes(), []byte("Exif")) {
+		t.Error("EXIF not stripped")
+	}
+
+	img := decode(t, out.Bytes())
+	if b := img.Bounds(); b.Dx() != 20 || b.Dy() != 40 {
+		t.Fatalf("expected 20x40, got %dx%d", b.Dx(), b.Dy())
+	}
+	if r, _, b, _ := img.At(10, 5).RGBA(); r < b {
+		t.Error("expected red on top")
+	}
+	if r, _, b, _ := img.At(10, 35).RGBA(); b < r {
+		t.Error("expected blue below")
+	}
+}
+
+func TestJpegOrientation(t *testing.T) {
+	for orientation := byte(1); orientation <= 8; orientation++ {
+		if got := jpegOrientation(withOrientation(t, halves(4, 4), orientation)); got != int(orientation) {
+			t.Errorf("expected %d, got %d", orientation, got)
+		}
+	}
+	if got := jpegOrientation([]byte("\xFF\xD8\xFF\xE1\x00\x10Exif\x00\x00MM")); got != 1 {
+		t.Errorf("expected 1 for a truncated segment, got %d", got)
+	}
+}
+
+func TestProcess_rejected(t *testing.T) {
+	if _, err := Process(bytes.NewReader([]byte("not an image")), &bytes.Buffer{}, Preset{}); err != ErrUnsupported {
+		t.Errorf("expected ErrUnsupported, got %v", err)
+	}
+
+	source := encodePNG(t, halves(100, 100))
+	// refused from its header, the rest is never read
+	r := io.MultiReader(bytes.NewReader(source[:64]), iotest.ErrReader(errors.New("read past the header")))
+	if _, err := Process(r, &bytes.Buffer{}, Preset{MaxPixels: 5000}); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("expected an image past MaxPixels refused, got %v", err)
+	}
+
+	if _, err := Process(bytes.NewReader(source), &bytes.Buffer{}, Preset{MaxBytes: 64}); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("expected an image past MaxBytes refused, got %v", err)
+	}
+}
+
+func TestPipeline_Derive(t *testing.T) {
+	ctx := context.Background()
+	src, cache := &memfilesystem.Mem{}, &memfilesystem.Mem{}
+	put := func(img image.Image) {
+		err := src.PutStream(ctx, "avatars/me.png", bytes.NewReader(encodePNG(t, img)), filesystems.PutOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	put(halves(300, 300))
+
+	p := &Pipeline{
+		Presets: map[string]Preset{"thumb": {Width: 30, Height: 30, Format: "jpeg"}},
+		Cache:   cache,
+	}
+	derived, err := p.Derive(ctx, "thumb", "local", src, "avatars/me.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if derived.ContentType != "image/jpeg" || derived.Size == 0 {
+		t.Errorf("unexpected image %+v", derived)
+	}
+	if exists, _ := cache.Exists(ctx, derived.Key); !exists {
+		t.Fatal("derived image not cached")
+	}
+
+	again, _ := p.Derive(ctx, "thumb", "local", src, "avatars/me.png")
+	if again.Key != derived.Key {
+		t.Errorf("expected the cached image, got %s", again.Key)
+	}
+
+	// a new version of the image is derived again
+	put(halves(200, 200))
+	updated, _ := p.Derive(ctx, "thumb", "local", src, "avatars/me.png")
+	if updated.Key == derived.Key {
+		t.Error("new version served from the cache")
+	}
+
+	if _, err := p.Derive(ctx, "missing", "local", src, "avatars/me.png"); err == nil {
+		t.Error("expected an unknown preset refused")
+	}
+}
+
+func TestPipeline_DeriveFormat(t *testing.T) {
+	ctx := context.Background()
+	src := &memfilesystem.Mem{}
+	// a png, whatever its name says
+	err := src.PutStream(ctx, "avatars/me.jpg", bytes.NewReader(encodePNG(t, halves(300, 300))), filesystems.PutOptions{ContentType: "image/jpeg"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &Pipeline{
+		Presets: map[string]Preset{"thumb": {Width: 30, Height: 30}},
+		Cache:   &memfilesystem.Mem{},
+	}
+	derived, err := p.Derive(ctx, "thumb", "local", src, "avatars/me.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if derived.ContentType != "image/png" || !strings.HasSuffix(derived.Key, ".png") {
+		t.Errorf("expected a png, got %+v", derived)
+	}
+
+	again, _ := p.Derive(ctx, "thumb", "local", src, "avatars/me.jpg")
+	if again != derived {
+		t.Errorf("expected the cached image, got %+v", again)
+	}
+}
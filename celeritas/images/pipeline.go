@@ -0,0 +1,124 @@
+package images
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+
+	"github.com/djedjethai/celeritas/filesystems"
+)
+
+// ErrUnknownPreset is returned for a preset missing from the pipeline
+var ErrUnknownPreset = errors.New("images: unknown preset")
+
+// defaultFolder is hidden from the listings of the cache disk
+const defaultFolder = ".cache/images"
+
+// Pipeline derives the images of named presets, and keeps them on Cache
+type Pipeline struct {
+	Presets map[string]Preset
+	// Cache keeps the derived images, in Folder
+	Cache  filesystems.FS
+	Folder string
+}
+
+// Derived is an image derived by a Pipeline
+type Derived struct {
+	// Key is the key of the image on Cache
+	Key         string
+	ContentType string
+	Size        int64
+}
+
+// Derive returns the image of the preset name derived from key of src, the disk named
+// disk, from Cache when it has been derived already. Its key on Cache changes with the
+// preset and with the version of the image, so a new version is derived again. When
+// the preset keeps the format of the image, its header is read to find it, even when
+// the derived image is cached
+func (p *Pipeline) Derive(ctx context.Context, name, disk string, src filesystems.FS, key string) (Derived, error) {
+	var derived Derived
+
+	preset, ok := p.Presets[name]
+	if !ok {
+		return derived, fmt.Errorf("%w %q", ErrUnknownPreset, name)
+	}
+	if p.Cache == nil {
+		return derived, errors.New("images: no cache disk")
+	}
+
+	stat, err := src.Stat(ctx, key)
+	if err != nil {
+		return derived, err
+	}
+	if stat.IsDir {
+		return derived, filesystems.NewError("derive", key, filesystems.ErrNotFound, nil)
+	}
+
+	// the format of the derived image follows the one of the original, unless the
+	// preset sets it
+	var original io.Reader
+	format := preset.Format
+	if format == "" {
+		rc, err := src.Open(ctx, key)
+		if err != nil {
+			return derived, err
+		}
+		defer rc.Close()
+
+		_, format, original, err = DecodeConfig(rc)
+		if err != nil {
+			return derived, err
+		}
+	}
+
+	derived.Key = p.key(name, disk, preset, stat, format)
+	if cached, err := p.Cache.Stat(ctx, derived.Key); err == nil {
+		derived.ContentType, derived.Size = cached.ContentType, cached.Size
+		return derived, nil
+	}
+
+	if original == nil {
+		rc, err := src.Open(ctx, key)
+		if err != nil {
+			return derived, err
+		}
+		defer rc.Close()
+		original = rc
+	}
+
+	var image bytes.Buffer
+	derived.ContentType, err = Process(original, &image, preset)
+	if err != nil {
+		return derived, err
+	}
+	derived.Size = int64(image.Len())
+
+	err = p.Cache.PutStream(ctx, derived.Key, &image, filesystems.PutOptions{
+		ContentType: derived.ContentType,
+		Size:        derived.Size,
+	})
+	return derived, err
+}
+
+// key returns the key of the image derived from item with preset: the key of the
+// image in a folder of the preset and the disk, suffixed with a hash of the preset and
+// of the version of the image, with the extension of the preset for the format of the
+// image
+func (p *Pipeline) key(name, disk string, preset Preset, item filesystems.Listing, format string) string {
+	folder := p.Folder
+	if folder == "" {
+		folder = defaultFolder
+	}
+
+	version := fmt.Sprintf("%+v|%s|%d|%d", preset, item.Etag, item.Size, item.LastModified.UnixNano())
+	sum := sha256.Sum256([]byte(version))
+
+	ext := path.Ext(item.Key)
+	base := item.Key[:len(item.Key)-len(ext)]
+	return path.Join(folder, name, disk, base+"-"+hex.EncodeToString(sum[:6])+preset.Extension(format))
+}
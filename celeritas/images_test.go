@@ -0,0 +1,118 @@
+package celeritas
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/CloudyKit/jet/v6"
+	"github.com/djedjethai/celeritas/filesystems"
+	"github.com/djedjethai/celeritas/images"
+)
+
+func testPNG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	if err := png.Encode(&b, image.NewNRGBA(image.Rect(0, 0, w, h))); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestCeleritas_DerivedImages(t *testing.T) {
+	c, disk := newSignedFilesApp(t)
+	c.Images = &images.Pipeline{
+		Presets: map[string]images.Preset{"thumb": {Width: 16, Height: 16, Fit: images.FitCrop}},
+		Cache:   disk,
+	}
+	_ = disk.PutStream(context.Background(), "photos/cat one.png", bytes.NewReader(testPNG(t, 64, 32)), filesystems.PutOptions{})
+
+	link := c.ImageURL("thumb", "", "photos/cat one.png")
+	if !strings.HasPrefix(link, "http://localhost:4000/img/thumb/photos/cat%20one.png?") {
+		t.Fatalf("unexpected url %s", link)
+	}
+
+	serve := func(link string, header http.Header) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, strings.TrimPrefix(link, c.Server.URL), nil)
+		for k := range header {
+			r.Header.Set(k, header.Get(k))
+		}
+		w := httptest.NewRecorder()
+		c.DerivedImages().ServeHTTP(w, r)
+		return w
+	}
+
+	w := serve(link, nil)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/png" {
+		t.Fatalf("image not served: %d %s", w.Code, w.Body.String())
+	}
+	img, err := png.Decode(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != 16 || b.Dy() != 16 {
+		t.Errorf("expected 16x16, got %v", b)
+	}
+
+	if w := serve(link, http.Header{"If-None-Match": {w.Header().Get("ETag")}}); w.Code != http.StatusNotModified {
+		t.Errorf("expected not modified, got %d", w.Code)
+	}
+
+	// the signature covers the preset and the key
+	if w := serve(strings.Replace(link, "/thumb/", "/large/", 1), nil); w.Code != http.StatusForbidden {
+		t.Errorf("expected another preset refused, got %d", w.Code)
+	}
+	if w := serve(c.ImageURL("thumb", "", "photos/missing.png"), nil); w.Code != http.StatusNotFound {
+		t.Errorf("expected not found, got %d", w.Code)
+	}
+}
+
+func TestCeleritas_Upload_imagePresets(t *testing.T) {
+	c, disk := newUploadApp()
+	c.Images = &images.Pipeline{
+		Presets: map[string]images.Preset{"avatar": {Width: 8, Height: 8, Fit: images.FitCrop}},
+		Cache:   disk,
+	}
+
+	r := multipartRequest(t, nil, formFile{"avatar", "me.png", testPNG(t, 32, 32)})
+	files, err := c.Upload(r, UploadOptions{ImagePresets: []string{"avatar"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := files[0].Derived["avatar"]
+	if exists, _ := disk.Exists(context.Background(), key); key == "" || !exists {
+		t.Errorf("avatar not derived: %+v", files[0])
+	}
+
+	// an image which cannot be decoded is not kept
+	r = multipartRequest(t, nil, formFile{"avatar", "broken.png", pngHeader})
+	if _, err := c.Upload(r, UploadOptions{ImagePresets: []string{"avatar"}}); err == nil {
+		t.Error("expected a broken image refused")
+	}
+	listing, _ := filesystems.ListAll(context.Background(), disk, "", false)
+	if len(listing) != 1 {
+		t.Errorf("broken image kept: %v", listing)
+	}
+}
+
+func TestCeleritas_imageURLGlobal(t *testing.T) {
+	c, _ := newSignedFilesApp(t)
+	c.JetViews = jet.NewSet(jet.NewInMemLoader())
+	c.Mail.JetViews = jet.NewSet(jet.NewInMemLoader())
+	c.createRenderer()
+
+	if _, ok := c.Render.Funcs["imageURL"]; !ok {
+		t.Error("go templates: missing imageURL")
+	}
+	if _, ok := c.JetViews.LookupGlobal("imageURL"); !ok {
+		t.Error("views: missing imageURL")
+	}
+	if _, ok := c.Mail.JetViews.LookupGlobal("imageURL"); !ok {
+		t.Error("mail: missing imageURL")
+	}
+}
@@ -0,0 +1,128 @@
+package mailer
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// CapturedMessage describes a message written by the log or file api
+type CapturedMessage struct {
+	Name    string
+	Subject string
+	To      string
+	Size    int64
+	SentAt  time.Time
+}
+
+// SendToFile renders msg and writes it, with its headers and attachments, as an
+// .eml file in CaptureDir instead of sending it. When the log api is among the ones
+// of API, a line is also logged for each message
+func (m *Mail) SendToFile(msg Message) error {
+	env, err := m.envelope(msg)
+	if err != nil {
+		return err
+	}
+	return m.capture(env, m.usesAPI("log"))
+}
+
+// capture writes the raw message of env to CaptureDir, and logs a line when logged is set
+func (m *Mail) capture(env *Envelope, logged bool) error {
+	dir := m.captureDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	// workers write concurrently, the random part keeps names unique
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+	fileName := fmt.Sprintf("%s-%x-%s.eml", time.Now().Format("20060102-150405.000000000"), suffix, sanitize(env.Template))
+	err := ioutil.WriteFile(filepath.Join(dir, fileName), []byte(env.Raw), 0644)
+	if err != nil {
+		return err
+	}
+
+	if logged {
+		log.Printf("mail to %s, subject %q, written to %s", env.recipients(), env.Subject, filepath.Join(dir, fileName))
+	}
+
+	return nil
+}
+
+// Captured lists the messages written by the log or file api, newest first
+func (m *Mail) Captured() ([]CapturedMessage, error) {
+	var list []CapturedMessage
+
+	files, err := ioutil.ReadDir(m.captureDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return list, nil
+		}
+		return nil, err
+	}
+
+	for _, f := range files {
+		if f.IsDir() || filepath.Ext(f.Name()) != ".eml" {
+			continue
+		}
+
+		item := CapturedMessage{
+			Name:   f.Name(),
+			Size:   f.Size(),
+			SentAt: f.ModTime(),
+		}
+
+		if parsed, err := m.ReadCaptured(f.Name()); err == nil {
+			item.Subject = parsed.Header.Get("Subject")
+			item.To = parsed.Header.Get("To")
+		}
+
+		list = append(list, item)
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Name > list[j].Name })
+
+	return list, nil
+}
+
+// ReadCaptured reads and parses a captured message
+func (m *Mail) ReadCaptured(name string) (*ParsedMessage, error) {
+	if name != filepath.Base(name) || filepath.Ext(name) != ".eml" {
+		return nil, errors.New("invalid captured message name")
+	}
+
+	f, err := os.Open(filepath.Join(m.captureDir(), name))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ParseMessage(f)
+}
+
+func (m *Mail) captureDir() string {
+	if m.CaptureDir != "" {
+		return m.CaptureDir
+	}
+	return filepath.Join(m.Templates, "..", "tmp", "mail")
+}
+
+// sanitize keeps a template name safe to use in a file name
+func sanitize(s string) string {
+	if s == "" {
+		return "message"
+	}
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' {
+			return r
+		}
+		return '-'
+	}, s)
+}
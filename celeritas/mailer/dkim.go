@@ -0,0 +1,73 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"strings"
+
+	"github.com/emersion/go-msgauth/dkim"
+)
+
+// DKIM signs outgoing messages for Domain, with the private key whose public key is
+// published in the DNS TXT record <Selector>._domainkey.<Domain>
+type DKIM struct {
+	Domain   string
+	Selector string
+	signer   crypto.Signer
+}
+
+// NewDKIM reads the PEM encoded rsa or ed25519 private key in keyFile
+func NewDKIM(domain, selector, keyFile string) (*DKIM, error) {
+	if domain == "" || selector == "" {
+		return nil, errors.New("dkim: a domain and a selector are required")
+	}
+
+	content, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, errors.New("dkim: no PEM private key in " + keyFile)
+	}
+
+	var key interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("dkim: unsupported private key type in " + keyFile)
+	}
+
+	return &DKIM{Domain: domain, Selector: selector, signer: signer}, nil
+}
+
+// Sign returns the raw message with a DKIM-Signature header prepended
+func (d *DKIM) Sign(raw string) (string, error) {
+	options := &dkim.SignOptions{
+		Domain:                 d.Domain,
+		Selector:               d.Selector,
+		Signer:                 d.signer,
+		HeaderCanonicalization: dkim.CanonicalizationRelaxed,
+		BodyCanonicalization:   dkim.CanonicalizationRelaxed,
+	}
+
+	var signed bytes.Buffer
+	if err := dkim.Sign(&signed, strings.NewReader(raw), options); err != nil {
+		return "", err
+	}
+	return signed.String(), nil
+}
@@ -1,18 +1,22 @@
 package mailer
 
 import (
-	"bytes"
-	"fmt"
+	"errors"
 	"html/template"
-	"io/ioutil"
-	"path/filepath"
+	"log"
+	"sync"
 	"time"
 
-	apimail "github.com/ainsleyclark/go-mail"
+	"github.com/CloudyKit/jet/v6"
+	"github.com/djedjethai/celeritas/i18n"
+	"github.com/djedjethai/celeritas/urlsigner"
 	"github.com/vanng822/go-premailer/premailer"
 	mail "github.com/xhit/go-simple-mail/v2"
 )
 
+// ErrQueueFull is returned by SendAndForget when the jobs channel is full
+var ErrQueueFull = errors.New("mailer: the mail queue is full")
+
 // Mail holds the information necessary to connect to an SMTP server
 type Mail struct {
 	Domain      string
@@ -24,22 +28,79 @@ type Mail struct {
 	Encryption  string
 	FromAddress string
 	FromName    string
-	Jobs        chan Message
-	Results     chan Result
+	Jobs        chan Job
 	API         string
 	APIKey      string
 	APIUrl      string
+	// Workers is the number of goroutines sending queued mail; defaults to 1
+	Workers int
+	// OnResult, when set, is called with the result of every queued message.
+	// Otherwise failures are logged
+	OnResult func(msg Message, res Result)
+	// CaptureDir is where the log and file apis write messages as .eml files
+	CaptureDir string
+	// Translator, when set, translates subjects and provides the t helper to mail templates
+	Translator *i18n.Translator
+	// Queue, when set, persists the messages until they are sent, and retries the failed
+	// ones with an exponential backoff; see ProcessQueue
+	Queue Queue
+	// MaxAttempts is how many times a queued message is tried before it is moved to the
+	// failed messages; defaults to 5
+	MaxAttempts int
+	// RetryDelay is the delay before the first retry, doubled after each attempt; defaults to 30s
+	RetryDelay time.Duration
+	// PollInterval is how often the Queue is checked for messages to send or retry; defaults to 5s
+	PollInterval time.Duration
+	// DKIM, when set, signs the messages sent by smtp. The api providers sign with
+	// the domain configured on their side
+	DKIM *DKIM
+	// UnsubscribeURL is the page where recipients unsubscribe. With Signer, messages
+	// with Unsubscribe set get List-Unsubscribe headers linking to it
+	UnsubscribeURL string
+	Signer         *urlsigner.Signer
+	// Transport, when set, sends every message instead of the transport named by API
+	Transport Transport
+	// APISecret and Region are the secret access key and the region of the ses api
+	APISecret string
+	Region    string
+	// SendmailPath is the sendmail binary of the sendmail api; defaults to /usr/sbin/sendmail
+	SendmailPath string
+	// Layout is the default layout of the go and markdown templates, in
+	// Templates/layouts/<layout>.html.tmpl and the optional <layout>.plain.tmpl
+	Layout string
+	// JetViews, when set, renders the <template>.html.jet and <template>.plain.jet
+	// templates, so mail can share the template language and globals of the views
+	JetViews *jet.Set
+	// RateLimits are the most messages per second sent with each named transport,
+	// such as "ses", shared by every worker
+	RateLimits map[string]float64
 }
 
 // Message is the type for an email message
 type Message struct {
-	From        string
-	FromName    string
-	To          string
-	Subject     string
-	Template    string
+	From     string
+	FromName string
+	To       []string
+	Cc       []string
+	Bcc      []string
+	ReplyTo  string
+	Subject  string
+	Template string
+	// Layout overrides the layout of the mailer; "none" renders without one
+	Layout string
+	// Attachments are paths of local files
 	Attachments []string
+	// Files are attachments read from a reader or a file system, and inline images
+	Files []Attachment
+	// Headers are custom headers, such as X-Campaign
+	Headers  map[string]string
+	Priority Priority
+	// Unsubscribe identifies who unsubscribes, usually the recipient address. Set it on
+	// bulk mail to add the List-Unsubscribe headers
+	Unsubscribe string
 	Data        interface{}
+	// Locale is the language of the message; the default locale is used when empty
+	Locale string
 }
 
 // Result contains information regarding the status of the sent email message
@@ -48,169 +109,263 @@ type Result struct {
 	Error   error
 }
 
-// ListenForMail listens to the mail channel and sends mail
-// when it receives a payload. It runs continually in the background,
-// and sends error/success messages back on the Results channel.
-// Note that if api and api key are set, it will prefer using
-// an api to send mail
+// Job is a queued message, with the handle its result is reported to
+type Job struct {
+	Message Message
+	pending *Pending
+	queued  *QueuedMessage
+}
+
+// Pending is the handle of a queued message, resolved once it has been sent
+type Pending struct {
+	done   chan struct{}
+	result Result
+}
+
+// Wait blocks until the message has been sent, and returns its result
+func (p *Pending) Wait() Result {
+	<-p.done
+	return p.result
+}
+
+// Done is closed once the message has been sent
+func (p *Pending) Done() <-chan struct{} {
+	return p.done
+}
+
+func (p *Pending) resolve(res Result) {
+	p.result = res
+	close(p.done)
+}
+
+// ListenForMail starts the workers sending the queued mail, and blocks until the
+// Jobs channel is closed and every queued message has been sent. Note that if api
+// and api key are set, it will prefer using an api to send mail
 func (m *Mail) ListenForMail() {
-	for {
-		msg := <-m.Jobs
-		err := m.Send(msg)
-		if err != nil {
-			m.Results <- Result{false, err}
-		} else {
-			m.Results <- Result{true, nil}
-		}
+	workers := m.Workers
+	if workers < 1 {
+		workers = 1
 	}
-}
 
-// Send sends an email message using correct method. If API values are set,
-// it will send using the appropriate api; otherwise, it sends via smtp
-func (m *Mail) Send(msg Message) error {
-	if len(m.API) > 0 && len(m.APIKey) > 0 && len(m.APIUrl) > 0 && m.API != "smtp" {
-		return m.ChooseAPI(msg)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for job := range m.Jobs {
+				m.process(job)
+			}
+		}()
 	}
-	return m.SendSMTPMessage(msg)
+	wg.Wait()
 }
 
-// ChooseAPI chooses api to use (specified in .env)
-func (m *Mail) ChooseAPI(msg Message) error {
-	switch m.API {
-	case "mailgun", "sparkpost", "sendgrid":
-		return m.SendUsingAPI(msg, m.API)
-	default:
-		return fmt.Errorf("unknown api %s; only mailgun, sparkpost or sendgrid accepted", m.API)
+// process sends a queued message and reports its result
+func (m *Mail) process(job Job) {
+	res := Result{Success: true}
+	err := m.Deliver(job.Message)
+	if err != nil {
+		res = Result{Success: false, Error: err}
+	}
+
+	if job.queued != nil {
+		m.settle(job.queued, err)
+	}
+
+	if job.pending != nil {
+		job.pending.resolve(res)
+	}
+
+	if m.OnResult != nil {
+		m.OnResult(job.Message, res)
+	} else if res.Error != nil {
+		log.Printf("could not send mail to %s: %s", job.Message.recipients(), res.Error)
 	}
 }
 
-// SendUsingAPI sends a message using the appropriate API. It can be called directly, if necessary.
-// transport can be one of sparkpost, sendgrid, or mailgun
-func (m *Mail) SendUsingAPI(msg Message, transport string) error {
-	if msg.From == "" {
-		msg.From = m.FromAddress
+// Send queues msg, and returns a handle to wait for its result. It blocks while the
+// queue is full. With a Queue, msg is persisted first, and the result is the one of
+// the first attempt; a failed message is then retried in the background
+func (m *Mail) Send(msg Message) *Pending {
+	p := &Pending{done: make(chan struct{})}
+	job := Job{Message: msg, pending: p}
+
+	if m.Queue != nil {
+		qm, err := m.enqueue(msg)
+		if err != nil {
+			p.resolve(Result{Success: false, Error: err})
+			return p
+		}
+		job.queued = qm
 	}
 
-	if msg.FromName == "" {
-		msg.FromName = m.FromName
+	m.Jobs <- job
+	return p
+}
+
+// SendAndForget queues msg without ever blocking the caller. The result is only
+// reported to OnResult, or logged. When the workers are busy and Jobs is full, it
+// fails with ErrQueueFull; with a Queue the message is persisted instead, and sent
+// once ProcessQueue sees it has not been
+func (m *Mail) SendAndForget(msg Message) error {
+	job := Job{Message: msg}
+
+	if m.Queue != nil {
+		qm, err := m.enqueue(msg)
+		if err != nil {
+			return err
+		}
+		job.queued = qm
 	}
 
-	cfg := apimail.Config{
-		URL:         m.APIUrl,
-		APIKey:      m.APIKey,
-		Domain:      m.Domain,
-		FromAddress: msg.From,
-		FromName:    msg.FromName,
+	select {
+	case m.Jobs <- job:
+	default:
+		if m.Queue == nil {
+			return ErrQueueFull
+		}
 	}
+	return nil
+}
 
-	driver, err := apimail.NewClient(transport, cfg)
+// Deliver sends an email message right away, with Transport when it is set, or else with
+// the transport registered under the API name; smtp when API is empty. A comma separated
+// list of names, such as "postmark,smtp", fails over from one transport to the next.
+// The log and file apis write the message to CaptureDir instead, for development
+func (m *Mail) Deliver(msg Message) error {
+	transport, err := m.transport()
 	if err != nil {
 		return err
 	}
 
-	formattedMessage, err := m.buildHTMLMessage(msg)
+	env, err := m.envelope(msg)
 	if err != nil {
 		return err
 	}
+	return transport.Send(env)
+}
 
-	plainMessage, err := m.buildPlainTextMessage(msg)
+// ChooseAPI sends msg with the transport named by API (specified in .env)
+func (m *Mail) ChooseAPI(msg Message) error {
+	transport, err := m.namedTransport(m.API)
 	if err != nil {
 		return err
 	}
 
-	tx := &apimail.Transmission{
-		Recipients: []string{msg.To},
-		Subject:    msg.Subject,
-		HTML:       formattedMessage,
-		PlainText:  plainMessage,
+	env, err := m.envelope(msg)
+	if err != nil {
+		return err
 	}
+	return transport.Send(env)
+}
 
-	// add attachments
-	err = m.addAPIAttachments(msg, tx)
+// SendUsingAPI sends a message using the appropriate API. It can be called directly, if necessary.
+// transport can be one of sparkpost, sendgrid, mailgun, postmark or postal
+func (m *Mail) SendUsingAPI(msg Message, transport string) error {
+	t, err := newAPITransport(m, transport)
 	if err != nil {
 		return err
 	}
 
-	_, err = driver.Send(tx)
+	env, err := m.envelope(msg)
 	if err != nil {
 		return err
 	}
-
-	return nil
+	return t.Send(env)
 }
 
-// addAPIAttachments adds attachments, if any, to mail being sent via api
-func (m *Mail) addAPIAttachments(msg Message, tx *apimail.Transmission) error {
-	if len(msg.Attachments) > 0 {
-		var attachments []apimail.Attachment
-
-		for _, x := range msg.Attachments {
-			var attach apimail.Attachment
-			content, err := ioutil.ReadFile(x)
-			if err != nil {
-				return err
-			}
-
-			fileName := filepath.Base(x)
-			attach.Bytes = content
-			attach.Filename = fileName
-			attachments = append(attachments, attach)
-		}
+// SendSMTPMessage builds and sends an email message using SMTP. It can be called
+// directly when necessary
+func (m *Mail) SendSMTPMessage(msg Message) error {
+	env, err := m.envelope(msg)
+	if err != nil {
+		return err
+	}
+	return (&smtpTransport{m: m}).Send(env)
+}
 
-		tx.Attachments = attachments
+// envelope renders msg, and builds its raw MIME message for the transports
+func (m *Mail) envelope(msg Message) (*Envelope, error) {
+	env, err := m.render(msg)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	env.Raw, err = m.rawMessage(env.email())
+	if err != nil {
+		return nil, err
+	}
+	return env, nil
 }
 
-// SendSMTPMessage builds and sends an email message using SMTP. This is called by ListenForMail,
-// and can also be called directly when necessary
-func (m *Mail) SendSMTPMessage(msg Message) error {
+// render applies the defaults of the mailer to msg, renders its templates, translates
+// its subject and loads its attachments
+func (m *Mail) render(msg Message) (*Envelope, error) {
+	msg = m.withDefaults(msg)
+	if err := msg.validate(); err != nil {
+		return nil, err
+	}
+
 	formattedMessage, err := m.buildHTMLMessage(msg)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	plainMessage, err := m.buildPlainTextMessage(msg)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	server := mail.NewSMTPClient()
-	server.Host = m.Host
-	server.Port = m.Port
-	server.Username = m.Username
-	server.Password = m.Password
-	server.Encryption = m.getEncryption(m.Encryption)
-	server.KeepAlive = false
-	server.ConnectTimeout = 10 * time.Second
-	server.SendTimeout = 10 * time.Second
-
-	smtpClient, err := server.Connect()
+	files, err := msg.files()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	email := mail.NewMSG()
-	email.SetFrom(msg.From).
-		AddTo(msg.To).
-		SetSubject(msg.Subject)
+	msg.Subject = m.translateSubject(msg)
+	msg.Attachments = nil
+	msg.Files = files
 
-	email.SetBody(mail.TextHTML, formattedMessage)
-	email.AddAlternative(mail.TextPlain, plainMessage)
+	return &Envelope{Message: msg, HTML: formattedMessage, PlainText: plainMessage}, nil
+}
 
-	if len(msg.Attachments) > 0 {
-		for _, x := range msg.Attachments {
-			email.AddAttachment(x)
-		}
+// rawMessage returns the MIME message of email, signed when DKIM is set
+func (m *Mail) rawMessage(email *mail.Email) (string, error) {
+	raw := email.GetMessage()
+	if email.Error != nil {
+		return "", email.Error
+	}
+
+	if m.DKIM == nil {
+		return raw, nil
 	}
+	return m.DKIM.Sign(raw)
+}
 
-	err = email.Send(smtpClient)
+// buildEmail renders the templates of msg, and builds the MIME message
+func (m *Mail) buildEmail(msg Message) (*mail.Email, error) {
+	env, err := m.render(msg)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	email := env.email()
+	if email.Error != nil {
+		return nil, email.Error
+	}
+	return email, nil
+}
+
+// withDefaults fills the sender of msg from the mailer settings, and adds its
+// List-Unsubscribe headers
+func (m *Mail) withDefaults(msg Message) Message {
+	if msg.From == "" {
+		msg.From = m.FromAddress
+	}
+
+	if msg.FromName == "" {
+		msg.FromName = m.FromName
+	}
+	return m.unsubscribeHeaders(msg)
 }
 
 // getEncryption returns the appropriate encryption type based on a string value
@@ -227,46 +382,24 @@ func (m *Mail) getEncryption(e string) mail.Encryption {
 	}
 }
 
-// buildHTMLMessage creates the html version of the message
-func (m *Mail) buildHTMLMessage(msg Message) (string, error) {
-	templateToRender := fmt.Sprintf("%s/%s.html.tmpl", m.Templates, msg.Template)
-
-	t, err := template.New("email-html").ParseFiles(templateToRender)
-	if err != nil {
-		return "", err
-	}
-
-	var tpl bytes.Buffer
-	if err = t.ExecuteTemplate(&tpl, "body", msg.Data); err != nil {
-		return "", err
-	}
-
-	formattedMessage := tpl.String()
-	formattedMessage, err = m.inlineCSS(formattedMessage)
-	if err != nil {
-		return "", err
+// templateFuncs returns the helpers available in mail templates
+func (m *Mail) templateFuncs(msg Message) template.FuncMap {
+	return template.FuncMap{
+		"t": func(key string, args ...interface{}) string {
+			if m.Translator == nil {
+				return key
+			}
+			return m.Translator.T(msg.Locale, key, args...)
+		},
 	}
-
-	return formattedMessage, nil
 }
 
-// buildPlainTextMessage creates the plaintext version of the message
-func (m *Mail) buildPlainTextMessage(msg Message) (string, error) {
-	templateToRender := fmt.Sprintf("%s/%s.plain.tmpl", m.Templates, msg.Template)
-
-	t, err := template.New("email-html").ParseFiles(templateToRender)
-	if err != nil {
-		return "", err
-	}
-
-	var tpl bytes.Buffer
-	if err = t.ExecuteTemplate(&tpl, "body", msg.Data); err != nil {
-		return "", err
+// translateSubject translates the subject when it is a translation key
+func (m *Mail) translateSubject(msg Message) string {
+	if m.Translator == nil {
+		return msg.Subject
 	}
-
-	plainMessage := tpl.String()
-
-	return plainMessage, nil
+	return m.Translator.T(msg.Locale, msg.Subject)
 }
 
 // inlineCSS takes html input as a string, and inlines css where possible
@@ -1,8 +1,25 @@
 package mailer
 
 import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
 	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+
+	"github.com/djedjethai/celeritas/i18n"
+	"github.com/djedjethai/celeritas/urlsigner"
+	"github.com/emersion/go-msgauth/dkim"
 )
 
 
@@ -10,7 +27,7 @@ func TestMail_SendSMTPMessage(t *testing.T) {
 	msg := Message{
 		From: "me@here.com",
 		FromName: "Joe",
-		To: "you@there.com",
+		To: []string{"you@there.com"},
 		Subject: "test",
 		Template: "test",
 		Attachments: []string{"./testdata/mail/test.html.tmpl"},
@@ -26,21 +43,19 @@ func TestMail_SendUsingChan(t *testing.T) {
 	msg := Message{
 		From: "me@here.com",
 		FromName: "Joe",
-		To: "you@there.com",
+		To: []string{"you@there.com"},
 		Subject: "test",
 		Template: "test",
 		Attachments: []string{"./testdata/mail/test.html.tmpl"},
 	}
 
-	mailer.Jobs <-msg
-	res := <-mailer.Results
+	res := mailer.Send(msg).Wait()
 	if res.Error != nil {
 		t.Error(errors.New("failed to send over channel"))
 	}
 
-	msg.To = "not_an_email_address"
-	mailer.Jobs <- msg
-	res = <-mailer.Results
+	msg.To = []string{"not_an_email_address"}
+	res = mailer.Send(msg).Wait()
 	if res.Error == nil {
 		t.Error(errors.New("no error received with invalid to address"))
 	}
@@ -48,7 +63,7 @@ func TestMail_SendUsingChan(t *testing.T) {
 
 func TestMail_SendUsingAPI(t *testing.T) {
 	msg := Message{
-		To: "you@there.com",
+		To: []string{"you@there.com"},
 		Subject: "test",
 		Template: "test",
 		Attachments: []string{"./testdata/mail/test.html.tmpl"},
@@ -71,7 +86,7 @@ func TestMail_buildHTMLMessage(t *testing.T) {
 	msg := Message{
 		From: "me@here.com",
 		FromName: "Joe",
-		To: "you@there.com",
+		To: []string{"you@there.com"},
 		Subject: "test",
 		Template: "test",
 		Attachments: []string{"./testdata/mail/test.html.tmpl"},
@@ -87,7 +102,7 @@ func TestMail_buildPlainMessage(t *testing.T) {
 	msg := Message{
 		From: "me@here.com",
 		FromName: "Joe",
-		To: "you@there.com",
+		To: []string{"you@there.com"},
 		Subject: "test",
 		Template: "test",
 		Attachments: []string{"./testdata/mail/test.html.tmpl"},
@@ -103,13 +118,13 @@ func TestMail_send(t *testing.T) {
 	msg := Message{
 		From: "me@here.com",
 		FromName: "Joe",
-		To: "you@there.com",
+		To: []string{"you@there.com"},
 		Subject: "test",
 		Template: "test",
 		Attachments: []string{"./testdata/mail/test.html.tmpl"},
 	}
 
-	err := mailer.Send(msg)
+	err := mailer.Deliver(msg)
 	if err != nil {
 		t.Error(err)
 	}
@@ -118,7 +133,7 @@ func TestMail_send(t *testing.T) {
 	mailer.APIKey = "abc123"
 	mailer.APIUrl = "https://www.fake.com"
 
-	err = mailer.Send(msg)
+	err = mailer.Deliver(msg)
 	if err == nil {
 		t.Error("did not not get an error when we should have")
 	}
@@ -132,7 +147,7 @@ func TestMail_ChooseAPI(t *testing.T) {
 	msg := Message{
 		From: "me@here.com",
 		FromName: "Joe",
-		To: "you@there.com",
+		To: []string{"you@there.com"},
 		Subject: "test",
 		Template: "test",
 		Attachments: []string{"./testdata/mail/test.html.tmpl"},
@@ -142,4 +157,360 @@ func TestMail_ChooseAPI(t *testing.T) {
 	if err == nil {
 		t.Error(err)
 	}
-}
\ No newline at end of file
+}
+func TestMail_buildTranslatedMessage(t *testing.T) {
+	tr := i18n.New("en")
+	tr.Add("fr", map[string]interface{}{
+		"mail": map[string]interface{}{"greeting": "Bonjour {name}", "subject": "Sujet"},
+	})
+
+	m := mailer
+	m.Translator = tr
+
+	msg := Message{
+		Subject:  "mail.subject",
+		Template: "translated",
+		Locale:   "fr",
+		Data:     map[string]string{"Name": "Jane"},
+	}
+
+	out, err := m.buildHTMLMessage(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "Bonjour Jane") {
+		t.Errorf("expected translated body, got %q", out)
+	}
+	if s := m.translateSubject(msg); s != "Sujet" {
+		t.Errorf("expected translated subject, got %q", s)
+	}
+}
+
+func TestMail_SendToFile(t *testing.T) {
+	m := mailer
+	m.API = "file"
+	m.CaptureDir = t.TempDir()
+
+	msg := Message{
+		From:        "me@here.com",
+		To:          []string{"you@there.com"},
+		Subject:     "test capture",
+		Template:    "test",
+		Attachments: []string{"./testdata/mail/test.plain.tmpl"},
+	}
+
+	err := m.Deliver(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	captured, err := m.Captured()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(captured) != 1 {
+		t.Fatalf("expected 1 captured message, got %d", len(captured))
+	}
+	if captured[0].Subject != "test capture" || captured[0].To != "<you@there.com>" {
+		t.Errorf("unexpected captured message %+v", captured[0])
+	}
+
+	parsed, err := m.ReadCaptured(captured[0].Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(parsed.HTML, "Enter your message content here") {
+		t.Errorf("expected html part, got %q", parsed.HTML)
+	}
+	if !strings.Contains(parsed.PlainText, "Enter your message content here") {
+		t.Errorf("expected plain text part, got %q", parsed.PlainText)
+	}
+	if len(parsed.Attachments) != 1 || parsed.Attachments[0].Filename != "test.plain.tmpl" {
+		t.Errorf("expected the attachment to be captured, got %+v", parsed.Attachments)
+	}
+
+	_, err = m.ReadCaptured("../setup_test.go")
+	if err == nil {
+		t.Error("expected an error reading outside of the capture dir")
+	}
+}
+
+func TestMail_LogInFailover(t *testing.T) {
+	m := mailer
+	m.API = "log,smtp"
+	m.CaptureDir = t.TempDir()
+
+	var b bytes.Buffer
+	log.SetOutput(&b)
+	defer log.SetOutput(os.Stderr)
+
+	err := m.Deliver(Message{From: "me@here.com", To: []string{"you@there.com"}, Subject: "logged", Template: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), `subject "logged"`) {
+		t.Errorf("expected the log transport of the failover to log the message, got %q", b.String())
+	}
+}
+
+func TestMail_PreviewRoutes(t *testing.T) {
+	m := mailer
+	m.API = "file"
+	m.CaptureDir = t.TempDir()
+
+	err := m.Deliver(Message{To: []string{"you@there.com"}, Subject: "preview me", Template: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	routes := m.PreviewRoutes()
+
+	w := httptest.NewRecorder()
+	routes.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if !strings.Contains(w.Body.String(), "preview me") || !strings.Contains(w.Body.String(), `href="templates/test"`) {
+		t.Errorf("expected captured message and templates in index, got %q", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	routes.ServeHTTP(w, httptest.NewRequest("GET", "/templates/test?format=plain", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Enter your message content here") {
+		t.Errorf("expected rendered plain template, got %d %q", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	routes.ServeHTTP(w, httptest.NewRequest("GET", "/messages/nope.eml", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for unknown message, got %d", w.Code)
+	}
+}
+
+func TestMail_WorkerPool(t *testing.T) {
+	m := mailer
+	m.API = "file"
+	m.CaptureDir = t.TempDir()
+	m.Workers = 3
+	m.Jobs = make(chan Job, 10)
+
+	var mu sync.Mutex
+	var reported []Result
+	m.OnResult = func(msg Message, res Result) {
+		mu.Lock()
+		defer mu.Unlock()
+		reported = append(reported, res)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		m.ListenForMail()
+		close(done)
+	}()
+
+	// every message gets its own result, even when sent concurrently
+	good := m.Send(Message{To: []string{"you@there.com"}, Subject: "good", Template: "test"})
+	bad := m.Send(Message{To: []string{"you@there.com"}, Subject: "bad", Template: "does-not-exist"})
+	if err := m.SendAndForget(Message{To: []string{"you@there.com"}, Subject: "forget", Template: "test"}); err != nil {
+		t.Error(err)
+	}
+
+	if res := good.Wait(); !res.Success || res.Error != nil {
+		t.Errorf("expected success, got %+v", res)
+	}
+	if res := bad.Wait(); res.Success || res.Error == nil {
+		t.Errorf("expected an error for a missing template, got %+v", res)
+	}
+
+	close(m.Jobs)
+	<-done
+
+	if len(reported) != 3 {
+		t.Errorf("expected 3 results reported to OnResult, got %d", len(reported))
+	}
+
+	captured, _ := m.Captured()
+	if len(captured) != 2 {
+		t.Errorf("expected 2 captured messages, got %d", len(captured))
+	}
+}
+
+func TestMail_SendAndForgetQueueFull(t *testing.T) {
+	m := mailer
+	m.Queue = nil
+	m.Jobs = make(chan Job, 1)
+
+	msg := Message{To: []string{"you@there.com"}, Subject: "forget", Template: "test"}
+	if err := m.SendAndForget(msg); err != nil {
+		t.Fatal(err)
+	}
+	// no worker is listening, so the second message does not fit
+	if err := m.SendAndForget(msg); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("expected ErrQueueFull, got %v", err)
+	}
+}
+
+func TestMail_buildEmailRecipientsAndParts(t *testing.T) {
+	logo, err := NewInline("logo.png", strings.NewReader("\x89PNG\r\n\x1a\nnot really"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, _ := NewAttachment("report.csv", strings.NewReader("a,b\n1,2\n"))
+
+	msg := Message{
+		To:       []string{"you@there.com", "them@there.com"},
+		Cc:       []string{"boss@there.com"},
+		Bcc:      []string{"archive@here.com"},
+		ReplyTo:  "support@here.com",
+		Subject:  "parts",
+		Template: "inline",
+		Files:    []Attachment{logo, report},
+		Headers:  map[string]string{"X-Campaign": "welcome"},
+		Priority: PriorityHigh,
+	}
+
+	email, err := mailer.buildEmail(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := ParseMessage(strings.NewReader(email.GetMessage()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		header   string
+		expected string
+	}{
+		{"From", `"Joe" <me@here.com>`},
+		{"To", "<you@there.com>, <them@there.com>"},
+		{"Cc", "<boss@there.com>"},
+		{"Reply-To", "<support@here.com>"},
+		{"X-Campaign", "welcome"},
+		{"X-Priority", "1 (Highest)"},
+	}
+	for _, e := range tests {
+		if got := parsed.Header.Get(e.header); got != e.expected {
+			t.Errorf("%s: expected %q but got %q", e.header, e.expected, got)
+		}
+	}
+	if parsed.Header.Get("Bcc") != "" {
+		t.Error("bcc recipients must not be in the headers")
+	}
+
+	if len(parsed.Attachments) != 2 {
+		t.Fatalf("expected 2 attachments, got %d", len(parsed.Attachments))
+	}
+	for _, a := range parsed.Attachments {
+		if a.Filename == "logo.png" && a.ContentID == "" {
+			t.Error("inline image without a content id")
+		}
+		if a.ContentID != "" && !strings.Contains(parsed.HTML, "cid:"+strings.Trim(a.ContentID, "<>")) {
+			t.Errorf("html does not reference the inline image %s: %s", a.ContentID, parsed.HTML)
+		}
+	}
+
+	msg.To = nil
+	msg.Cc = nil
+	msg.Bcc = []string{"not an address"}
+	if _, err := mailer.buildEmail(msg); err == nil {
+		t.Error("expected an error for an invalid recipient")
+	}
+}
+
+func TestMail_apiTransmission(t *testing.T) {
+	msg := Message{
+		To:          []string{"you@there.com"},
+		Bcc:         []string{"archive@here.com"},
+		Subject:     "api",
+		Template:    "test",
+		Attachments: []string{"./testdata/mail/test.plain.tmpl"},
+		Files:       []Attachment{{Name: "logo.png", Data: []byte("png"), Inline: true}},
+		ReplyTo:     "support@here.com",
+		Priority:    PriorityLow,
+	}
+
+	env, err := mailer.render(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx := apiTransmission(env)
+	if len(tx.Attachments) != 2 || tx.Attachments[0].Filename != "test.plain.tmpl" || tx.Attachments[1].Filename != "logo.png" {
+		t.Errorf("wrong api attachments: %+v", tx.Attachments)
+	}
+	if len(tx.BCC) != 1 || tx.Subject != "api" || tx.HTML == "" || tx.PlainText == "" {
+		t.Errorf("wrong api transmission: %+v", tx)
+	}
+	if tx.Headers["Reply-To"] != "support@here.com" || tx.Headers["X-Priority"] != "5 (Lowest)" {
+		t.Errorf("wrong api headers: %v", tx.Headers)
+	}
+}
+
+func TestMail_DKIMAndUnsubscribe(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "dkim.pem")
+	pemKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	if err := os.WriteFile(keyFile, pemKey, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	m := mailer
+	m.DKIM, err = NewDKIM("here.com", "mail", keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.UnsubscribeURL = "https://here.com/unsubscribe"
+	m.Signer = &urlsigner.Signer{Secret: []byte("abcdefghijklmnopqrstuvwxyz012345")}
+
+	email, err := m.buildEmail(Message{
+		To:          []string{"you@there.com"},
+		Subject:     "news",
+		Template:    "test",
+		Unsubscribe: "you@there.com",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := m.rawMessage(email)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publicKey, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	verifications, err := dkim.VerifyWithOptions(strings.NewReader(raw), &dkim.VerifyOptions{
+		LookupTXT: func(domain string) ([]string, error) {
+			if domain != "mail._domainkey.here.com" {
+				return nil, errors.New("unexpected domain " + domain)
+			}
+			return []string{"v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(publicKey)}, nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(verifications) != 1 || verifications[0].Err != nil {
+		t.Fatalf("dkim signature does not verify: %+v", verifications)
+	}
+
+	parsed, err := ParseMessage(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Header.Get("List-Unsubscribe-Post") != "List-Unsubscribe=One-Click" {
+		t.Error("missing List-Unsubscribe-Post header")
+	}
+
+	link := strings.Trim(parsed.Header.Get("List-Unsubscribe"), "<>")
+	req := httptest.NewRequest("POST", link, strings.NewReader("List-Unsubscribe=One-Click"))
+	who, err := m.Unsubscriber(req)
+	if err != nil || who != "you@there.com" {
+		t.Errorf("expected you@there.com from the unsubscribe link, got %q, %v", who, err)
+	}
+
+	req = httptest.NewRequest("POST", strings.Replace(link, "you%40there.com", "them%40there.com", 1), nil)
+	if _, err := m.Unsubscriber(req); err != ErrInvalidUnsubscribe {
+		t.Errorf("expected a tampered link to be rejected, got %v", err)
+	}
+}
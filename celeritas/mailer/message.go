@@ -0,0 +1,164 @@
+package mailer
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	netmail "net/mail"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/djedjethai/celeritas/filesystems"
+)
+
+// Priority is the importance of a message, as shown by the mail clients
+type Priority int
+
+const (
+	PriorityNormal Priority = iota
+	PriorityHigh
+	PriorityLow
+)
+
+// Attachment is a file sent with a message. An inline attachment is displayed in the
+// html body, where the template references it by name: <img src="cid:logo.png">
+type Attachment struct {
+	Name        string
+	ContentType string
+	Data        []byte
+	Inline      bool
+}
+
+// NewAttachment reads r into an attachment named name. Readers are read right away,
+// so the message can be queued
+func NewAttachment(name string, r io.Reader) (Attachment, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return Attachment{}, err
+	}
+	return Attachment{Name: name, Data: data}, nil
+}
+
+// NewInline reads r into an inline image, referenced as cid:name in the html template
+func NewInline(name string, r io.Reader) (Attachment, error) {
+	a, err := NewAttachment(name, r)
+	a.Inline = true
+	return a, err
+}
+
+// AttachmentFromFS downloads key from one of the Celeritas file systems into an attachment
+func AttachmentFromFS(fs filesystems.FS, key string) (Attachment, error) {
+	tmp, err := ioutil.TempDir("", "celeritas-mail")
+	if err != nil {
+		return Attachment{}, err
+	}
+	defer os.RemoveAll(tmp)
+
+	if err := fs.Get(tmp, key); err != nil {
+		return Attachment{}, err
+	}
+
+	f, err := os.Open(filepath.Join(tmp, path.Base(key)))
+	if err != nil {
+		return Attachment{}, err
+	}
+	defer f.Close()
+
+	return NewAttachment(path.Base(key), f)
+}
+
+// contentType returns the mime type of the attachment, from its name or its content
+func (a Attachment) contentType() string {
+	if a.ContentType != "" {
+		return a.ContentType
+	}
+	if t := mime.TypeByExtension(filepath.Ext(a.Name)); t != "" {
+		return t
+	}
+	return http.DetectContentType(a.Data)
+}
+
+// files returns every attachment of msg, loading the ones given as local paths
+func (msg Message) files() ([]Attachment, error) {
+	files := make([]Attachment, 0, len(msg.Attachments)+len(msg.Files))
+	for _, x := range msg.Attachments {
+		content, err := ioutil.ReadFile(x)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, Attachment{Name: filepath.Base(x), Data: content})
+	}
+	return append(files, msg.Files...), nil
+}
+
+// headers returns the custom headers of msg, with the ones set by ReplyTo and Priority
+func (msg Message) headers() map[string]string {
+	headers := make(map[string]string, len(msg.Headers)+3)
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+
+	if msg.ReplyTo != "" {
+		headers["Reply-To"] = msg.ReplyTo
+	}
+
+	switch msg.Priority {
+	case PriorityHigh:
+		headers["X-Priority"] = "1 (Highest)"
+		headers["Importance"] = "High"
+	case PriorityLow:
+		headers["X-Priority"] = "5 (Lowest)"
+		headers["Importance"] = "Low"
+	}
+	return headers
+}
+
+// sortedKeys returns the keys of headers in a stable order
+func sortedKeys(headers map[string]string) []string {
+	keys := make([]string, 0, len(headers))
+	for k := range headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// validate checks that msg has recipients, and that they are valid addresses
+func (msg Message) validate() error {
+	if len(msg.To)+len(msg.Cc)+len(msg.Bcc) == 0 {
+		return errors.New("mailer: message has no recipients")
+	}
+
+	for _, list := range [][]string{msg.To, msg.Cc, msg.Bcc} {
+		for _, address := range list {
+			if _, err := netmail.ParseAddress(address); err != nil {
+				return errors.New("mailer: invalid recipient " + address)
+			}
+		}
+	}
+
+	if msg.ReplyTo != "" {
+		if _, err := netmail.ParseAddress(msg.ReplyTo); err != nil {
+			return errors.New("mailer: invalid reply-to " + msg.ReplyTo)
+		}
+	}
+	return nil
+}
+
+// from returns the From header of msg, with its display name
+func (msg Message) from() string {
+	if msg.FromName == "" {
+		return msg.From
+	}
+	return (&netmail.Address{Name: msg.FromName, Address: msg.From}).String()
+}
+
+// recipients returns the To addresses of msg, for logging
+func (msg Message) recipients() string {
+	return strings.Join(msg.To, ", ")
+}
@@ -0,0 +1,115 @@
+package mailer
+
+import (
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+)
+
+// ParsedMessage is a MIME message split into its parts
+type ParsedMessage struct {
+	Header      mail.Header
+	HTML        string
+	PlainText   string
+	Attachments []ParsedAttachment
+}
+
+// ParsedAttachment is an attachment, or an inline part, of a parsed message
+type ParsedAttachment struct {
+	Filename    string
+	ContentType string
+	ContentID   string
+	Data        []byte
+}
+
+// ParseMessage parses a raw MIME message, as written by SendToFile
+func ParseMessage(r io.Reader) (*ParsedMessage, error) {
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed := &ParsedMessage{Header: msg.Header}
+
+	dec := new(mime.WordDecoder)
+	if subject, err := dec.DecodeHeader(msg.Header.Get("Subject")); err == nil {
+		parsed.Header["Subject"] = []string{subject}
+	}
+
+	err = parsed.readPart(msg.Header.Get("Content-Type"), msg.Header.Get("Content-Transfer-Encoding"), "", "", msg.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return parsed, nil
+}
+
+func (p *ParsedMessage) readPart(contentType, encoding, disposition, contentID string, body io.Reader) error {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = "text/plain"
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+
+			err = p.readPart(part.Header.Get("Content-Type"), part.Header.Get("Content-Transfer-Encoding"),
+				part.Header.Get("Content-Disposition"), part.Header.Get("Content-ID"), part)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	data, err := ioutil.ReadAll(decodeBody(encoding, body))
+	if err != nil {
+		return err
+	}
+
+	_, dispParams, _ := mime.ParseMediaType(disposition)
+	filename := dispParams["filename"]
+	if filename == "" {
+		filename = params["name"]
+	}
+
+	switch {
+	case filename == "" && contentID == "" && mediaType == "text/html":
+		p.HTML = string(data)
+	case filename == "" && contentID == "" && mediaType == "text/plain":
+		p.PlainText = string(data)
+	default:
+		p.Attachments = append(p.Attachments, ParsedAttachment{
+			Filename:    filename,
+			ContentType: mediaType,
+			ContentID:   strings.Trim(contentID, "<>"),
+			Data:        data,
+		})
+	}
+
+	return nil
+}
+
+func decodeBody(encoding string, body io.Reader) io.Reader {
+	switch strings.ToLower(encoding) {
+	case "base64":
+		// line breaks are ignored by the decoder
+		return base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		return quotedprintable.NewReader(body)
+	default:
+		return body
+	}
+}
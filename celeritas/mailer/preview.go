@@ -0,0 +1,197 @@
+package mailer
+
+import (
+	"encoding/json"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+var previewIndex = template.Must(template.New("index").Parse(`<!doctype html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <title>Mail preview</title>
+    <style>
+        body { font-family: sans-serif; margin: 2em; }
+        table { border-collapse: collapse; width: 100%; }
+        td, th { border-bottom: 1px solid #ddd; padding: .4em; text-align: left; }
+    </style>
+</head>
+<body>
+<h1>Captured messages</h1>
+{{if .Messages}}
+<table>
+    <tr><th>Sent</th><th>To</th><th>Subject</th><th></th></tr>
+    {{range .Messages}}
+    <tr>
+        <td>{{.SentAt.Format "2006-01-02 15:04:05"}}</td>
+        <td>{{.To}}</td>
+        <td><a href="messages/{{.Name}}">{{.Subject}}</a></td>
+        <td><a href="messages/{{.Name}}?part=plain">plain</a> <a href="messages/{{.Name}}?raw=1">.eml</a></td>
+    </tr>
+    {{end}}
+</table>
+{{else}}
+<p>No message captured yet. Set MAILER_API=file or MAILER_API=log to capture outgoing mail.</p>
+{{end}}
+
+<h1>Templates</h1>
+<ul>
+    {{range .Templates}}
+    <li><a href="templates/{{.}}">{{.}}</a> (<a href="templates/{{.}}?format=plain">plain</a>)</li>
+    {{end}}
+</ul>
+</body>
+</html>`))
+
+// PreviewRoutes returns the routes of the mail preview. They list the captured
+// messages, and render any mail template with the sample data found next to it
+// in <name>.sample.json. It is meant for development only
+func (m *Mail) PreviewRoutes() http.Handler {
+	r := chi.NewRouter()
+
+	r.Get("/", m.previewIndex)
+	r.Get("/messages/{name}", m.previewMessage)
+	r.Get("/templates/{name}", m.previewTemplate)
+
+	return r
+}
+
+func (m *Mail) previewIndex(w http.ResponseWriter, r *http.Request) {
+	messages, err := m.Captured()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	templates, err := m.templateNames()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		Messages  []CapturedMessage
+		Templates []string
+	}{messages, templates}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_ = previewIndex.Execute(w, data)
+}
+
+func (m *Mail) previewMessage(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+
+	if r.URL.Query().Get("raw") != "" {
+		if name != filepath.Base(name) || filepath.Ext(name) != ".eml" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "message/rfc822")
+		w.Header().Set("Content-Disposition", "attachment; filename=\""+name+"\"")
+		http.ServeFile(w, r, filepath.Join(m.captureDir(), name))
+		return
+	}
+
+	parsed, err := m.ReadCaptured(name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.URL.Query().Get("part") == "plain" || parsed.HTML == "" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = w.Write([]byte(parsed.PlainText))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = w.Write([]byte(parsed.HTML))
+}
+
+func (m *Mail) previewTemplate(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	if name != filepath.Base(name) {
+		http.NotFound(w, r)
+		return
+	}
+
+	data, err := m.sampleData(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	msg := Message{
+		Template: name,
+		Data:     data,
+		Locale:   r.URL.Query().Get("locale"),
+	}
+
+	if r.URL.Query().Get("format") == "plain" {
+		out, err := m.buildPlainTextMessage(msg)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = w.Write([]byte(out))
+		return
+	}
+
+	out, err := m.buildHTMLMessage(msg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = w.Write([]byte(out))
+}
+
+// sampleData reads the sample data of a template from <name>.sample.json, if it exists
+func (m *Mail) sampleData(name string) (map[string]interface{}, error) {
+	data := make(map[string]interface{})
+
+	content, err := ioutil.ReadFile(filepath.Join(m.Templates, name+".sample.json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return data, nil
+		}
+		return nil, err
+	}
+
+	err = json.Unmarshal(content, &data)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// templateNames lists the mail templates having an html version, in go, jet or markdown
+func (m *Mail) templateNames() ([]string, error) {
+	seen := make(map[string]bool)
+	var names []string
+	for _, suffix := range []string{".html.tmpl", ".html.jet", ".md"} {
+		files, err := filepath.Glob(filepath.Join(m.Templates, "*"+suffix))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, f := range files {
+			name := strings.TrimSuffix(filepath.Base(f), suffix)
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
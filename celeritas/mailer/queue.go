@@ -0,0 +1,185 @@
+package mailer
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"time"
+)
+
+// ErrNotQueued is returned when a message id is not in the queue
+var ErrNotQueued = errors.New("mailer: message not found in queue")
+
+const (
+	defaultMaxAttempts  = 5
+	defaultRetryDelay   = 30 * time.Second
+	defaultPollInterval = 5 * time.Second
+	maxRetryDelay       = 6 * time.Hour
+	// inFlight is how long a message is hidden from the queue while it is being sent.
+	// If the application stops before it is sent, it is picked up again after that
+	inFlight = 5 * time.Minute
+	dueBatch = 100
+)
+
+// QueuedMessage is a message persisted in a Queue, with its delivery state
+type QueuedMessage struct {
+	ID          string    `json:"id"`
+	Message     Message   `json:"message"`
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"next_attempt"`
+	LastError   string    `json:"last_error"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Queue persists messages until they are sent, so they survive restarts and transient
+// errors. Messages which fail MaxAttempts times are moved to the failed messages
+type Queue interface {
+	// Push saves qm, replacing the pending message with the same id
+	Push(qm QueuedMessage) error
+	// Due returns up to limit pending messages whose next attempt is not after now
+	Due(now time.Time, limit int) ([]QueuedMessage, error)
+	// Claim moves the next attempt of qm, as returned by Due, to until, unless it was
+	// changed since, as another process claimed it. It reports whether qm was claimed
+	Claim(qm QueuedMessage, until time.Time) (bool, error)
+	// Remove deletes a pending message once it has been sent
+	Remove(id string) error
+	// Fail moves a pending message to the failed messages
+	Fail(qm QueuedMessage) error
+	// Failed lists the failed messages
+	Failed() ([]QueuedMessage, error)
+	// Retry moves a failed message back to the pending messages, with its attempts reset
+	Retry(id string) error
+	// Purge deletes a failed message
+	Purge(id string) error
+}
+
+// ProcessQueue polls Queue for messages due to be sent, or retried, and hands them to
+// the workers. It returns when stop is closed; a nil stop runs forever. Applications
+// with a scheduler can run DispatchDue from it instead
+func (m *Mail) ProcessQueue(stop <-chan struct{}) {
+	if m.Queue == nil {
+		return
+	}
+
+	interval := m.PollInterval
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := m.DispatchDue(); err != nil {
+			log.Printf("could not read mail queue: %s", err)
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// DispatchDue claims the messages of Queue which are due, scheduled ones included, for
+// inFlight, and queues them for the workers. The claims are atomic, so the instances of
+// an application sharing the queue send each message once
+func (m *Mail) DispatchDue() error {
+	if m.Queue == nil {
+		return nil
+	}
+
+	now := time.Now()
+	due, err := m.Queue.Due(now, dueBatch)
+	if err != nil {
+		return err
+	}
+
+	for i := range due {
+		qm := due[i]
+		claimed, err := m.Queue.Claim(qm, now.Add(inFlight))
+		if err != nil {
+			return err
+		}
+		if !claimed {
+			continue
+		}
+		qm.NextAttempt = now.Add(inFlight)
+		m.Jobs <- Job{Message: qm.Message, queued: &qm}
+	}
+	return nil
+}
+
+// enqueue persists msg before it is handed to the workers
+func (m *Mail) enqueue(msg Message) (*QueuedMessage, error) {
+	now := time.Now()
+	qm := &QueuedMessage{
+		ID:          newQueueID(),
+		Message:     msg,
+		NextAttempt: now.Add(inFlight),
+		CreatedAt:   now,
+	}
+	if err := m.Queue.Push(*qm); err != nil {
+		return nil, err
+	}
+	return qm, nil
+}
+
+// settle records the outcome of a delivery attempt: sent messages are removed, the
+// others are retried with an exponential backoff until MaxAttempts is reached
+func (m *Mail) settle(qm *QueuedMessage, sendErr error) {
+	var err error
+	if sendErr == nil {
+		err = m.Queue.Remove(qm.ID)
+	} else {
+		qm.Attempts++
+		qm.LastError = sendErr.Error()
+		if qm.Attempts >= m.maxAttempts() {
+			err = m.Queue.Fail(*qm)
+		} else {
+			qm.NextAttempt = time.Now().Add(m.backoff(qm.Attempts))
+			err = m.Queue.Push(*qm)
+		}
+	}
+
+	if err != nil {
+		log.Printf("could not update mail queue for message %s: %s", qm.ID, err)
+	}
+}
+
+func (m *Mail) maxAttempts() int {
+	if m.MaxAttempts < 1 {
+		return defaultMaxAttempts
+	}
+	return m.MaxAttempts
+}
+
+// backoff returns the delay before the next attempt: RetryDelay, doubled after each
+// failed attempt, up to maxRetryDelay
+func (m *Mail) backoff(attempts int) time.Duration {
+	delay := m.RetryDelay
+	if delay <= 0 {
+		delay = defaultRetryDelay
+	}
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= maxRetryDelay {
+			return maxRetryDelay
+		}
+	}
+	return delay
+}
+
+func newQueueID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// resetForRetry prepares a failed message to be sent again right away
+func resetForRetry(qm *QueuedMessage) {
+	qm.Attempts = 0
+	qm.LastError = ""
+	qm.NextAttempt = time.Now()
+}
@@ -0,0 +1,194 @@
+package mailer
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// BadgerQueue stores the queue in badger, with one key per pending or failed message
+type BadgerQueue struct {
+	Conn   *badger.DB
+	Prefix string
+}
+
+func (b *BadgerQueue) pendingKey(id string) []byte {
+	return []byte(b.Prefix + "mail:queue:" + id)
+}
+
+func (b *BadgerQueue) failedKey(id string) []byte {
+	return []byte(b.Prefix + "mail:failed:" + id)
+}
+
+// Push saves qm, replacing the pending message with the same id
+func (b *BadgerQueue) Push(qm QueuedMessage) error {
+	data, err := json.Marshal(qm)
+	if err != nil {
+		return err
+	}
+
+	return b.Conn.Update(func(txn *badger.Txn) error {
+		return txn.Set(b.pendingKey(qm.ID), data)
+	})
+}
+
+// Due returns up to limit pending messages whose next attempt is not after now
+func (b *BadgerQueue) Due(now time.Time, limit int) ([]QueuedMessage, error) {
+	pending, err := b.list(b.pendingKey(""))
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].NextAttempt.Before(pending[j].NextAttempt)
+	})
+
+	var due []QueuedMessage
+	for _, qm := range pending {
+		if qm.NextAttempt.After(now) || len(due) == limit {
+			break
+		}
+		due = append(due, qm)
+	}
+	return due, nil
+}
+
+// Claim moves the next attempt of qm to until, unless it was changed since
+func (b *BadgerQueue) Claim(qm QueuedMessage, until time.Time) (bool, error) {
+	claimed := false
+	err := b.Conn.Update(func(txn *badger.Txn) error {
+		item, err := txn.Get(b.pendingKey(qm.ID))
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var stored QueuedMessage
+		err = item.Value(func(val []byte) error {
+			return json.Unmarshal(val, &stored)
+		})
+		if err != nil || !stored.NextAttempt.Equal(qm.NextAttempt) {
+			return err
+		}
+
+		stored.NextAttempt = until
+		data, err := json.Marshal(stored)
+		if err != nil {
+			return err
+		}
+		claimed = true
+		return txn.Set(b.pendingKey(qm.ID), data)
+	})
+	if err == badger.ErrConflict {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return claimed, nil
+}
+
+// Remove deletes a pending message once it has been sent
+func (b *BadgerQueue) Remove(id string) error {
+	return b.Conn.Update(func(txn *badger.Txn) error {
+		return txn.Delete(b.pendingKey(id))
+	})
+}
+
+// Fail moves a pending message to the failed messages
+func (b *BadgerQueue) Fail(qm QueuedMessage) error {
+	data, err := json.Marshal(qm)
+	if err != nil {
+		return err
+	}
+
+	return b.Conn.Update(func(txn *badger.Txn) error {
+		if err := txn.Delete(b.pendingKey(qm.ID)); err != nil {
+			return err
+		}
+		return txn.Set(b.failedKey(qm.ID), data)
+	})
+}
+
+// Failed lists the failed messages, oldest first
+func (b *BadgerQueue) Failed() ([]QueuedMessage, error) {
+	failed, err := b.list(b.failedKey(""))
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(failed, func(i, j int) bool {
+		return failed[i].CreatedAt.Before(failed[j].CreatedAt)
+	})
+	return failed, nil
+}
+
+// Retry moves a failed message back to the pending messages, with its attempts reset
+func (b *BadgerQueue) Retry(id string) error {
+	return b.Conn.Update(func(txn *badger.Txn) error {
+		item, err := txn.Get(b.failedKey(id))
+		if err == badger.ErrKeyNotFound {
+			return ErrNotQueued
+		}
+		if err != nil {
+			return err
+		}
+
+		var qm QueuedMessage
+		err = item.Value(func(val []byte) error {
+			return json.Unmarshal(val, &qm)
+		})
+		if err != nil {
+			return err
+		}
+		resetForRetry(&qm)
+
+		data, err := json.Marshal(qm)
+		if err != nil {
+			return err
+		}
+		if err := txn.Delete(b.failedKey(id)); err != nil {
+			return err
+		}
+		return txn.Set(b.pendingKey(id), data)
+	})
+}
+
+// Purge deletes a failed message
+func (b *BadgerQueue) Purge(id string) error {
+	return b.Conn.Update(func(txn *badger.Txn) error {
+		if _, err := txn.Get(b.failedKey(id)); err == badger.ErrKeyNotFound {
+			return ErrNotQueued
+		} else if err != nil {
+			return err
+		}
+		return txn.Delete(b.failedKey(id))
+	})
+}
+
+// list decodes every message stored under prefix
+func (b *BadgerQueue) list(prefix []byte) ([]QueuedMessage, error) {
+	var messages []QueuedMessage
+
+	err := b.Conn.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			var qm QueuedMessage
+			err := it.Item().Value(func(val []byte) error {
+				return json.Unmarshal(val, &qm)
+			})
+			if err != nil {
+				return err
+			}
+			messages = append(messages, qm)
+		}
+		return nil
+	})
+	return messages, err
+}
@@ -0,0 +1,189 @@
+package mailer
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// RedisQueue stores the queue in redis: a sorted set of ids scored by next attempt,
+// and hashes of the pending and failed messages
+type RedisQueue struct {
+	Conn   *redis.Pool
+	Prefix string
+}
+
+// claimScript moves the score of ARGV[1] in the queue KEYS[1] from ARGV[2] to ARGV[3],
+// and saves its message ARGV[4] in KEYS[2], unless its score changed
+var claimScript = redis.NewScript(2, `
+if tonumber(redis.call("ZSCORE", KEYS[1], ARGV[1])) ~= tonumber(ARGV[2]) then
+	return 0
+end
+redis.call("ZADD", KEYS[1], ARGV[3], ARGV[1])
+redis.call("HSET", KEYS[2], ARGV[1], ARGV[4])
+return 1
+`)
+
+func (r *RedisQueue) key(name string) string {
+	return r.Prefix + ":mail:" + name
+}
+
+// Push saves qm, replacing the pending message with the same id
+func (r *RedisQueue) Push(qm QueuedMessage) error {
+	data, err := json.Marshal(qm)
+	if err != nil {
+		return err
+	}
+
+	conn := r.Conn.Get()
+	defer conn.Close()
+
+	_ = conn.Send("MULTI")
+	_ = conn.Send("HSET", r.key("messages"), qm.ID, data)
+	_ = conn.Send("ZADD", r.key("queue"), qm.NextAttempt.UnixMilli(), qm.ID)
+	_, err = conn.Do("EXEC")
+	return err
+}
+
+// Due returns up to limit pending messages whose next attempt is not after now
+func (r *RedisQueue) Due(now time.Time, limit int) ([]QueuedMessage, error) {
+	conn := r.Conn.Get()
+	defer conn.Close()
+
+	ids, err := redis.Strings(conn.Do("ZRANGEBYSCORE", r.key("queue"), "-inf", now.UnixMilli(), "LIMIT", 0, limit))
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+
+	args := redis.Args{}.Add(r.key("messages")).AddFlat(ids)
+	values, err := redis.ByteSlices(conn.Do("HMGET", args...))
+	if err != nil {
+		return nil, err
+	}
+	return decodeQueued(values)
+}
+
+// Claim moves the next attempt of qm to until, unless it was changed since
+func (r *RedisQueue) Claim(qm QueuedMessage, until time.Time) (bool, error) {
+	from := qm.NextAttempt.UnixMilli()
+	qm.NextAttempt = until
+	data, err := json.Marshal(qm)
+	if err != nil {
+		return false, err
+	}
+
+	conn := r.Conn.Get()
+	defer conn.Close()
+
+	claimed, err := redis.Bool(claimScript.Do(conn, r.key("queue"), r.key("messages"), qm.ID, from, until.UnixMilli(), data))
+	return claimed, err
+}
+
+// Remove deletes a pending message once it has been sent
+func (r *RedisQueue) Remove(id string) error {
+	conn := r.Conn.Get()
+	defer conn.Close()
+
+	_ = conn.Send("MULTI")
+	_ = conn.Send("ZREM", r.key("queue"), id)
+	_ = conn.Send("HDEL", r.key("messages"), id)
+	_, err := conn.Do("EXEC")
+	return err
+}
+
+// Fail moves a pending message to the failed messages
+func (r *RedisQueue) Fail(qm QueuedMessage) error {
+	data, err := json.Marshal(qm)
+	if err != nil {
+		return err
+	}
+
+	conn := r.Conn.Get()
+	defer conn.Close()
+
+	_ = conn.Send("MULTI")
+	_ = conn.Send("ZREM", r.key("queue"), qm.ID)
+	_ = conn.Send("HDEL", r.key("messages"), qm.ID)
+	_ = conn.Send("HSET", r.key("failed"), qm.ID, data)
+	_, err = conn.Do("EXEC")
+	return err
+}
+
+// Failed lists the failed messages, oldest first
+func (r *RedisQueue) Failed() ([]QueuedMessage, error) {
+	conn := r.Conn.Get()
+	defer conn.Close()
+
+	values, err := redis.ByteSlices(conn.Do("HVALS", r.key("failed")))
+	if err != nil {
+		return nil, err
+	}
+
+	messages, err := decodeQueued(values)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(messages, func(i, j int) bool {
+		return messages[i].CreatedAt.Before(messages[j].CreatedAt)
+	})
+	return messages, nil
+}
+
+// Retry moves a failed message back to the pending messages, with its attempts reset
+func (r *RedisQueue) Retry(id string) error {
+	conn := r.Conn.Get()
+	defer conn.Close()
+
+	data, err := redis.Bytes(conn.Do("HGET", r.key("failed"), id))
+	if err == redis.ErrNil {
+		return ErrNotQueued
+	}
+	if err != nil {
+		return err
+	}
+
+	var qm QueuedMessage
+	if err := json.Unmarshal(data, &qm); err != nil {
+		return err
+	}
+	resetForRetry(&qm)
+
+	if err := r.Push(qm); err != nil {
+		return err
+	}
+	_, err = conn.Do("HDEL", r.key("failed"), id)
+	return err
+}
+
+// Purge deletes a failed message
+func (r *RedisQueue) Purge(id string) error {
+	conn := r.Conn.Get()
+	defer conn.Close()
+
+	n, err := redis.Int(conn.Do("HDEL", r.key("failed"), id))
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotQueued
+	}
+	return nil
+}
+
+// decodeQueued decodes json messages, skipping the missing ones
+func decodeQueued(values [][]byte) ([]QueuedMessage, error) {
+	var messages []QueuedMessage
+	for _, v := range values {
+		if v == nil {
+			continue
+		}
+		var qm QueuedMessage
+		if err := json.Unmarshal(v, &qm); err != nil {
+			return nil, err
+		}
+		messages = append(messages, qm)
+	}
+	return messages, nil
+}
@@ -0,0 +1,184 @@
+package mailer
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SQLQueue stores the queue in the mail_queue and mail_failed tables, created by
+// "celeritas make mail-queue". DBType is the DATABASE_TYPE of the connection
+type SQLQueue struct {
+	DB     *sql.DB
+	DBType string
+}
+
+const queueColumns = "id, payload, attempts, next_attempt, last_error, created_at"
+
+// Push saves qm, replacing the pending message with the same id
+func (s *SQLQueue) Push(qm QueuedMessage) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	err = s.replace(tx, "mail_queue", qm)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Due returns up to limit pending messages whose next attempt is not after now
+func (s *SQLQueue) Due(now time.Time, limit int) ([]QueuedMessage, error) {
+	query := s.rebind(fmt.Sprintf(
+		"select %s from mail_queue where next_attempt <= ? order by next_attempt limit ?", queueColumns))
+	return s.query(query, now.UTC(), limit)
+}
+
+// Claim moves the next attempt of qm to until, unless it was changed since
+func (s *SQLQueue) Claim(qm QueuedMessage, until time.Time) (bool, error) {
+	res, err := s.DB.Exec(s.rebind("update mail_queue set next_attempt = ? where id = ? and next_attempt = ?"),
+		until.UTC(), qm.ID, qm.NextAttempt.UTC())
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// Remove deletes a pending message once it has been sent
+func (s *SQLQueue) Remove(id string) error {
+	_, err := s.DB.Exec(s.rebind("delete from mail_queue where id = ?"), id)
+	return err
+}
+
+// Fail moves a pending message to the mail_failed table
+func (s *SQLQueue) Fail(qm QueuedMessage) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(s.rebind("delete from mail_queue where id = ?"), qm.ID)
+	if err == nil {
+		err = s.replace(tx, "mail_failed", qm)
+	}
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Failed lists the failed messages, oldest first
+func (s *SQLQueue) Failed() ([]QueuedMessage, error) {
+	return s.query(fmt.Sprintf("select %s from mail_failed order by created_at", queueColumns))
+}
+
+// Retry moves a failed message back to the mail_queue table, with its attempts reset
+func (s *SQLQueue) Retry(id string) error {
+	found, err := s.query(s.rebind(fmt.Sprintf("select %s from mail_failed where id = ?", queueColumns)), id)
+	if err != nil {
+		return err
+	}
+	if len(found) == 0 {
+		return ErrNotQueued
+	}
+
+	qm := found[0]
+	resetForRetry(&qm)
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(s.rebind("delete from mail_failed where id = ?"), id)
+	if err == nil {
+		err = s.replace(tx, "mail_queue", qm)
+	}
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Purge deletes a failed message
+func (s *SQLQueue) Purge(id string) error {
+	res, err := s.DB.Exec(s.rebind("delete from mail_failed where id = ?"), id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotQueued
+	}
+	return nil
+}
+
+// replace writes qm to table, in place of the row with the same id
+func (s *SQLQueue) replace(tx *sql.Tx, table string, qm QueuedMessage) error {
+	payload, err := json.Marshal(qm.Message)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(s.rebind(fmt.Sprintf("delete from %s where id = ?", table)), qm.ID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(s.rebind(fmt.Sprintf("insert into %s (%s) values (?, ?, ?, ?, ?, ?)", table, queueColumns)),
+		qm.ID, string(payload), qm.Attempts, qm.NextAttempt.UTC(), qm.LastError, qm.CreatedAt.UTC())
+	return err
+}
+
+func (s *SQLQueue) query(query string, args ...interface{}) ([]QueuedMessage, error) {
+	rows, err := s.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []QueuedMessage
+	for rows.Next() {
+		var qm QueuedMessage
+		var payload string
+		var lastError sql.NullString
+		err := rows.Scan(&qm.ID, &payload, &qm.Attempts, &qm.NextAttempt, &lastError, &qm.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(payload), &qm.Message); err != nil {
+			return nil, err
+		}
+		qm.LastError = lastError.String
+		messages = append(messages, qm)
+	}
+	return messages, rows.Err()
+}
+
+// rebind turns the ? placeholders into $n ones for postgres
+func (s *SQLQueue) rebind(query string) string {
+	switch s.DBType {
+	case "postgres", "postgresql", "pgx":
+	default:
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			fmt.Fprintf(&b, "$%d", n)
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,153 @@
+package mailer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+func newTestQueue(t *testing.T) *BadgerQueue {
+	db, err := badger.Open(badger.DefaultOptions("").WithInMemory(true).WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	return &BadgerQueue{Conn: db}
+}
+
+func TestMail_backoff(t *testing.T) {
+	m := Mail{RetryDelay: time.Minute}
+
+	var tests = []struct {
+		attempts int
+		expected time.Duration
+	}{
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{4, 8 * time.Minute},
+		{20, maxRetryDelay},
+	}
+
+	for _, e := range tests {
+		if got := m.backoff(e.attempts); got != e.expected {
+			t.Errorf("backoff(%d): expected %s but got %s", e.attempts, e.expected, got)
+		}
+	}
+}
+
+func TestBadgerQueue(t *testing.T) {
+	q := newTestQueue(t)
+	now := time.Now()
+
+	_ = q.Push(QueuedMessage{ID: "later", NextAttempt: now.Add(time.Hour), CreatedAt: now})
+	_ = q.Push(QueuedMessage{ID: "second", NextAttempt: now.Add(-time.Minute), CreatedAt: now})
+	_ = q.Push(QueuedMessage{ID: "first", NextAttempt: now.Add(-time.Hour), CreatedAt: now, Message: Message{To: []string{"you@there.com"}}})
+
+	due, err := q.Due(now, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(due) != 2 || due[0].ID != "first" || due[1].ID != "second" {
+		t.Fatalf("wrong due messages: %+v", due)
+	}
+	if due[0].Message.To[0] != "you@there.com" {
+		t.Error("message not stored with the queue entry")
+	}
+
+	err = q.Fail(QueuedMessage{ID: "first", Attempts: 5, LastError: "boom", CreatedAt: now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	due, _ = q.Due(now, 10)
+	if len(due) != 1 {
+		t.Error("failed message is still pending")
+	}
+
+	failed, err := q.Failed()
+	if err != nil || len(failed) != 1 || failed[0].LastError != "boom" {
+		t.Fatalf("wrong failed messages: %+v %v", failed, err)
+	}
+
+	if err := q.Retry("first"); err != nil {
+		t.Fatal(err)
+	}
+	due, _ = q.Due(time.Now(), 10)
+	if len(due) != 2 || due[0].Attempts != 0 {
+		t.Errorf("retried message not pending with its attempts reset: %+v", due)
+	}
+
+	if err := q.Retry("first"); err != ErrNotQueued {
+		t.Errorf("expected ErrNotQueued but got %v", err)
+	}
+
+	_ = q.Fail(QueuedMessage{ID: "second"})
+	if err := q.Purge("second"); err != nil {
+		t.Error(err)
+	}
+	if failed, _ := q.Failed(); len(failed) != 0 {
+		t.Error("purged message still listed")
+	}
+
+	// a message is claimed once
+	due, _ = q.Due(time.Now(), 10)
+	if claimed, err := q.Claim(due[0], time.Now().Add(inFlight)); !claimed || err != nil {
+		t.Fatalf("message not claimed: %v", err)
+	}
+	if claimed, err := q.Claim(due[0], time.Now().Add(inFlight)); claimed || err != nil {
+		t.Errorf("message claimed twice: %v", err)
+	}
+	if due, _ := q.Due(time.Now(), 10); len(due) != 0 {
+		t.Errorf("claimed message still due: %+v", due)
+	}
+}
+
+func TestMail_QueueRetries(t *testing.T) {
+	m := mailer
+	m.API = "file"
+	m.CaptureDir = t.TempDir()
+	m.Jobs = make(chan Job, 10)
+	m.MaxAttempts = 2
+	m.Queue = newTestQueue(t)
+	go m.ListenForMail()
+	defer close(m.Jobs)
+
+	// a missing template fails every attempt
+	res := m.Send(Message{To: []string{"you@there.com"}, Subject: "test", Template: "missing"}).Wait()
+	if res.Error == nil {
+		t.Fatal("expected the first attempt to fail")
+	}
+
+	// the message waits for its retry
+	pending, _ := m.Queue.Due(time.Now().Add(time.Minute), 10)
+	if len(pending) != 1 || pending[0].Attempts != 1 || pending[0].LastError == "" {
+		t.Fatalf("message not queued for a retry: %+v", pending)
+	}
+
+	pending[0].NextAttempt = time.Now()
+	_ = m.Queue.Push(pending[0])
+	if err := m.DispatchDue(); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		failed, _ := m.Queue.Failed()
+		if len(failed) == 1 && failed[0].Attempts == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("message not moved to the failed messages after MaxAttempts")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// a sent message leaves the queue
+	res = m.Send(Message{To: []string{"you@there.com"}, Subject: "test", Template: "test"}).Wait()
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	if due, _ := m.Queue.Due(time.Now().Add(time.Hour), 10); len(due) != 0 {
+		t.Errorf("sent message still queued: %+v", due)
+	}
+}
@@ -0,0 +1,101 @@
+package mailer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// limiter spaces calls evenly, to stay under a number of calls per second
+type limiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+func newLimiter(perSecond float64) *limiter {
+	return &limiter{interval: time.Duration(float64(time.Second) / perSecond)}
+}
+
+// wait blocks until the next call is allowed
+func (l *limiter) wait() {
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	l.mu.Unlock()
+
+	time.Sleep(delay)
+}
+
+var (
+	limitersMu sync.Mutex
+	// limiters are shared by name, so every worker and every copy of the mailer
+	// counts towards the same limit of a transport
+	limiters = make(map[string]*limiter)
+)
+
+// transportLimiter returns the limiter shared by the transports named name
+func transportLimiter(name string, perSecond float64) *limiter {
+	limitersMu.Lock()
+	defer limitersMu.Unlock()
+
+	interval := time.Duration(float64(time.Second) / perSecond)
+	l, ok := limiters[name]
+	if !ok {
+		l = newLimiter(perSecond)
+		limiters[name] = l
+	}
+
+	l.mu.Lock()
+	l.interval = interval
+	l.mu.Unlock()
+	return l
+}
+
+type rateLimited struct {
+	t Transport
+	l *limiter
+}
+
+func (r *rateLimited) Send(env *Envelope) error {
+	r.l.wait()
+	return r.t.Send(env)
+}
+
+// RateLimit returns t sending at most perSecond messages per second, for transports
+// set directly in Transport; the named ones are limited with RateLimits
+func RateLimit(t Transport, perSecond float64) Transport {
+	if perSecond <= 0 {
+		return t
+	}
+	return &rateLimited{t: t, l: newLimiter(perSecond)}
+}
+
+// ParseRateLimits parses limits such as "ses:14,smtp:5", in messages per second
+// for each transport name
+func ParseRateLimits(s string) (map[string]float64, error) {
+	limits := make(map[string]float64)
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		exploded := strings.SplitN(entry, ":", 2)
+		if len(exploded) != 2 {
+			return nil, fmt.Errorf("mailer: invalid rate limit %q, expected <transport>:<messages per second>", entry)
+		}
+		rate, err := strconv.ParseFloat(strings.TrimSpace(exploded[1]), 64)
+		if err != nil || rate <= 0 {
+			return nil, fmt.Errorf("mailer: invalid rate limit %q, expected <transport>:<messages per second>", entry)
+		}
+		limits[strings.TrimSpace(exploded[0])] = rate
+	}
+	return limits, nil
+}
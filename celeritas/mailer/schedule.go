@@ -0,0 +1,106 @@
+package mailer
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrNoQueue is returned when scheduling mail without a Queue to persist it
+var ErrNoQueue = errors.New("mailer: scheduled mail requires a Queue")
+
+// Recipient is one recipient of a batch, with the template data of its message
+type Recipient struct {
+	To string
+	// Data is merged over the data of the batch message when both are
+	// map[string]interface{}, and replaces it otherwise
+	Data interface{}
+	// Locale overrides the locale of the batch message
+	Locale string
+}
+
+// SendAt persists msg in the Queue, to be sent once at is reached, and returns its
+// queue id. Scheduled messages survive restarts; see DispatchDue
+func (m *Mail) SendAt(msg Message, at time.Time) (string, error) {
+	if m.Queue == nil {
+		return "", ErrNoQueue
+	}
+	return m.schedule(msg, at)
+}
+
+// Cancel removes a scheduled message which has not been sent yet
+func (m *Mail) Cancel(id string) error {
+	if m.Queue == nil {
+		return ErrNoQueue
+	}
+	return m.Queue.Remove(id)
+}
+
+// SendBatch sends a copy of msg to each recipient, with their own template data, and
+// returns the queue ids of the copies. The copies are persisted in the Queue and sent
+// by DispatchDue, so SendBatch fails with ErrNoQueue without one
+func (m *Mail) SendBatch(msg Message, recipients []Recipient) ([]string, error) {
+	return m.SendBatchAt(msg, recipients, time.Now())
+}
+
+// SendBatchAt schedules a copy of msg to each recipient at the given time, and
+// returns the queue ids of the copies persisted before an error, if any
+func (m *Mail) SendBatchAt(msg Message, recipients []Recipient, at time.Time) ([]string, error) {
+	if m.Queue == nil {
+		return nil, ErrNoQueue
+	}
+
+	ids := make([]string, 0, len(recipients))
+	for _, r := range recipients {
+		id, err := m.schedule(batchMessage(msg, r), at)
+		if err != nil {
+			return ids, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// schedule persists msg, due at the given time
+func (m *Mail) schedule(msg Message, at time.Time) (string, error) {
+	qm := QueuedMessage{
+		ID:          newQueueID(),
+		Message:     msg,
+		NextAttempt: at,
+		CreatedAt:   time.Now(),
+	}
+	if err := m.Queue.Push(qm); err != nil {
+		return "", err
+	}
+	return qm.ID, nil
+}
+
+// batchMessage returns the copy of msg sent to r. Bulk mail with Unsubscribe set is
+// unsubscribed by the address of each recipient
+func batchMessage(msg Message, r Recipient) Message {
+	msg.To = []string{r.To}
+	msg.Cc = nil
+	msg.Bcc = nil
+	if msg.Unsubscribe != "" {
+		msg.Unsubscribe = r.To
+	}
+	if r.Locale != "" {
+		msg.Locale = r.Locale
+	}
+
+	base, ok := msg.Data.(map[string]interface{})
+	data, isMap := r.Data.(map[string]interface{})
+	switch {
+	case ok && isMap:
+		merged := make(map[string]interface{}, len(base)+len(data))
+		for k, v := range base {
+			merged[k] = v
+		}
+		for k, v := range data {
+			merged[k] = v
+		}
+		msg.Data = merged
+	case r.Data != nil:
+		msg.Data = r.Data
+	}
+	return msg
+}
@@ -0,0 +1,158 @@
+package mailer
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMail_SendAt(t *testing.T) {
+	fake := &FakeTransport{}
+	m := mailer
+	m.Transport = fake
+	m.Queue = newTestQueue(t)
+	m.Jobs = make(chan Job, 10)
+	go m.ListenForMail()
+	defer close(m.Jobs)
+
+	at := time.Now().Add(time.Hour)
+	id, err := m.SendAt(testMessage, at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancelled, _ := m.SendAt(testMessage, at)
+
+	// nothing is due yet
+	if err := m.DispatchDue(); err != nil {
+		t.Fatal(err)
+	}
+	due, _ := m.Queue.Due(at, 10)
+	if len(due) != 2 {
+		t.Fatalf("expected 2 scheduled messages, got %d", len(due))
+	}
+
+	if err := m.Cancel(cancelled); err != nil {
+		t.Fatal(err)
+	}
+
+	// the scheduled time is reached, as after a restart
+	due, _ = m.Queue.Due(at, 10)
+	due[0].NextAttempt = time.Now()
+	_ = m.Queue.Push(due[0])
+	if due[0].ID != id {
+		t.Fatalf("wrong message left after cancel: %s", due[0].ID)
+	}
+	if err := m.DispatchDue(); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(fake.Sent()) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("scheduled message not sent")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// the listener still reads m, so the queue is removed from a copy
+	noQueue := m
+	noQueue.Queue = nil
+	if _, err := noQueue.SendAt(testMessage, at); err != ErrNoQueue {
+		t.Errorf("expected ErrNoQueue, got %v", err)
+	}
+}
+
+func TestMail_SendBatch(t *testing.T) {
+	m := mailer
+	m.Queue = newTestQueue(t)
+
+	msg := Message{
+		To:          []string{"ignored@there.com"},
+		Subject:     "digest",
+		Template:    "digest",
+		Unsubscribe: "yes",
+		Data:        map[string]interface{}{"Link": "https://here.com", "Name": "you"},
+	}
+	recipients := []Recipient{
+		{To: "jane@there.com", Data: map[string]interface{}{"Name": "Jane"}, Locale: "fr"},
+		{To: "joe@there.com"},
+	}
+
+	ids, err := m.SendBatch(msg, recipients)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 2 {
+		t.Fatalf("expected 2 queued messages, got %d", len(ids))
+	}
+
+	due, _ := m.Queue.Due(time.Now(), 10)
+	if len(due) != 2 {
+		t.Fatalf("expected 2 due messages, got %d", len(due))
+	}
+
+	noQueue := m
+	noQueue.Queue = nil
+	if _, err := noQueue.SendBatch(msg, recipients); err != ErrNoQueue {
+		t.Errorf("expected ErrNoQueue, got %v", err)
+	}
+
+	for _, qm := range due {
+		data := qm.Message.Data.(map[string]interface{})
+		switch qm.Message.To[0] {
+		case "jane@there.com":
+			if data["Name"] != "Jane" || data["Link"] != "https://here.com" || qm.Message.Locale != "fr" {
+				t.Errorf("recipient data not merged: %+v", qm.Message)
+			}
+		case "joe@there.com":
+			if data["Name"] != "you" {
+				t.Errorf("batch data not kept: %+v", qm.Message)
+			}
+		default:
+			t.Errorf("wrong recipient %v", qm.Message.To)
+		}
+		if len(qm.Message.To) != 1 || qm.Message.Unsubscribe != qm.Message.To[0] {
+			t.Errorf("wrong recipient or unsubscribe: %+v", qm.Message)
+		}
+	}
+}
+
+func TestMail_RateLimits(t *testing.T) {
+	fake := &FakeTransport{}
+	RegisterTransport("test-limited", func(m *Mail) (Transport, error) {
+		return fake, nil
+	})
+
+	m := mailer
+	m.API = "test-limited"
+	m.RateLimits = map[string]float64{"test-limited": 20}
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		if err := m.Deliver(testMessage); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("5 messages at 20 per second sent in %s", elapsed)
+	}
+	if len(fake.Sent()) != 5 {
+		t.Errorf("expected 5 sent messages, got %d", len(fake.Sent()))
+	}
+}
+
+func TestParseRateLimits(t *testing.T) {
+	limits, err := ParseRateLimits("ses:14, smtp:0.5,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if limits["ses"] != 14 || limits["smtp"] != 0.5 {
+		t.Errorf("wrong limits: %v", limits)
+	}
+
+	for _, s := range []string{"ses", "ses:fast", "ses:-1"} {
+		if _, err := ParseRateLimits(s); err == nil || !strings.Contains(err.Error(), "invalid rate limit") {
+			t.Errorf("%s: expected an error", s)
+		}
+	}
+}
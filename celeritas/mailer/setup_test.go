@@ -22,8 +22,7 @@ var mailer = Mail{
 	Encryption: "none",
 	FromAddress: "me@here.com",
 	FromName: "Joe",
-	Jobs: make(chan Message, 1),
-	Results: make(chan Result, 1),
+	Jobs: make(chan Job, 1),
 }
 
 func TestMain(m *testing.M) {
@@ -0,0 +1,184 @@
+package mailer
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	texttemplate "text/template"
+
+	"github.com/CloudyKit/jet/v6"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+)
+
+var markdown = goldmark.New(goldmark.WithExtensions(extension.GFM))
+
+// buildHTMLMessage creates the html version of the message, from <template>.html.jet,
+// <template>.html.tmpl or <template>.md, the first one found
+func (m *Mail) buildHTMLMessage(msg Message) (string, error) {
+	var formattedMessage string
+	var err error
+
+	switch {
+	case m.JetViews != nil && m.templateExists(msg.Template+".html.jet"):
+		formattedMessage, err = m.renderJet(msg, msg.Template+".html.jet")
+	case m.templateExists(msg.Template + ".html.tmpl"):
+		formattedMessage, err = m.renderGo(msg, "html")
+	case m.templateExists(msg.Template + ".md"):
+		formattedMessage, err = m.renderMarkdownHTML(msg)
+	default:
+		return "", fmt.Errorf("mailer: no html template for %s in %s", msg.Template, m.Templates)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return m.inlineCSS(formattedMessage)
+}
+
+// buildPlainTextMessage creates the plaintext version of the message, from
+// <template>.plain.jet, <template>.plain.tmpl or the source of <template>.md
+func (m *Mail) buildPlainTextMessage(msg Message) (string, error) {
+	switch {
+	case m.JetViews != nil && m.templateExists(msg.Template+".plain.jet"):
+		return m.renderJet(msg, msg.Template+".plain.jet")
+	case m.templateExists(msg.Template + ".plain.tmpl"):
+		return m.renderGo(msg, "plain")
+	case m.templateExists(msg.Template + ".md"):
+		source, err := m.renderMarkdown(msg)
+		if err != nil {
+			return "", err
+		}
+		return m.wrapLayout(msg, "plain", source)
+	default:
+		return "", fmt.Errorf("mailer: no plain text template for %s in %s", msg.Template, m.Templates)
+	}
+}
+
+// renderGo executes the body block of <template>.<part>.tmpl, within the layout if any
+func (m *Mail) renderGo(msg Message, part string) (string, error) {
+	files := []string{filepath.Join(m.Templates, msg.Template+"."+part+".tmpl")}
+	entry := "body"
+	if layout := m.layoutFile(msg, part); layout != "" {
+		files = append([]string{layout}, files...)
+		entry = "layout"
+	}
+
+	t, err := template.New("email-" + part).Funcs(m.templateFuncs(msg)).ParseFiles(files...)
+	if err != nil {
+		return "", err
+	}
+
+	var tpl bytes.Buffer
+	if err = t.ExecuteTemplate(&tpl, entry, msg.Data); err != nil {
+		return "", err
+	}
+	return tpl.String(), nil
+}
+
+// renderMarkdown executes <template>.md, a text template written in markdown
+func (m *Mail) renderMarkdown(msg Message) (string, error) {
+	name := msg.Template + ".md"
+	t, err := texttemplate.New(name).
+		Funcs(texttemplate.FuncMap(m.templateFuncs(msg))).
+		ParseFiles(filepath.Join(m.Templates, name))
+	if err != nil {
+		return "", err
+	}
+
+	var tpl bytes.Buffer
+	if err = t.Execute(&tpl, msg.Data); err != nil {
+		return "", err
+	}
+	return tpl.String(), nil
+}
+
+// renderMarkdownHTML converts the executed markdown template to html
+func (m *Mail) renderMarkdownHTML(msg Message) (string, error) {
+	source, err := m.renderMarkdown(msg)
+	if err != nil {
+		return "", err
+	}
+
+	var body bytes.Buffer
+	if err = markdown.Convert([]byte(source), &body); err != nil {
+		return "", err
+	}
+
+	if m.layoutFile(msg, "html") == "" {
+		return "<!doctype html>\n<html>\n<body>\n" + body.String() + "</body>\n</html>\n", nil
+	}
+	return m.wrapLayout(msg, "html", body.String())
+}
+
+// wrapLayout renders content, already rendered, as the body block of the layout
+func (m *Mail) wrapLayout(msg Message, part, content string) (string, error) {
+	layout := m.layoutFile(msg, part)
+	if layout == "" {
+		return content, nil
+	}
+
+	t, err := template.New("email-" + part).Funcs(m.templateFuncs(msg)).ParseFiles(layout)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = t.New("body").Funcs(template.FuncMap{
+		"content": func() template.HTML { return template.HTML(content) },
+	}).Parse("{{content}}")
+	if err != nil {
+		return "", err
+	}
+
+	var tpl bytes.Buffer
+	if err = t.ExecuteTemplate(&tpl, "layout", msg.Data); err != nil {
+		return "", err
+	}
+	return tpl.String(), nil
+}
+
+// layoutFile returns the layout of msg for part, layouts/<layout>.<part>.tmpl. The html
+// layout is required once set, the plain text one is optional. A Layout of "none"
+// disables the default layout of the mailer
+func (m *Mail) layoutFile(msg Message, part string) string {
+	name := msg.Layout
+	if name == "" {
+		name = m.Layout
+	}
+	if name == "" || name == "none" {
+		return ""
+	}
+
+	file := filepath.Join("layouts", name+"."+part+".tmpl")
+	if part == "plain" && !m.templateExists(file) {
+		return ""
+	}
+	return filepath.Join(m.Templates, file)
+}
+
+// renderJet executes a Jet mail template, which can extend a layout and use the
+// globals of JetViews, such as the ones shared with the views
+func (m *Mail) renderJet(msg Message, name string) (string, error) {
+	t, err := m.JetViews.GetTemplate(name)
+	if err != nil {
+		return "", err
+	}
+
+	vars := make(jet.VarMap)
+	for k, fn := range m.templateFuncs(msg) {
+		vars.Set(k, fn)
+	}
+
+	var tpl bytes.Buffer
+	if err = t.Execute(&tpl, vars, msg.Data); err != nil {
+		return "", err
+	}
+	return tpl.String(), nil
+}
+
+func (m *Mail) templateExists(name string) bool {
+	_, err := os.Stat(filepath.Join(m.Templates, name))
+	return err == nil
+}
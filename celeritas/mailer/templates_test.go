@@ -0,0 +1,122 @@
+package mailer
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/CloudyKit/jet/v6"
+)
+
+func TestMail_markdownTemplate(t *testing.T) {
+	data := map[string]interface{}{
+		"Name":  "Jane",
+		"Items": []string{"first <item>", "second item"},
+		"Link":  "https://here.com/digest",
+	}
+
+	var tests = []struct {
+		name      string
+		layout    string
+		msgLayout string
+		html      []string
+		plain     []string
+	}{
+		{
+			"without layout", "", "",
+			[]string{"<h1>Hello Jane</h1>", "<li>second item</li>", `<a href="https://here.com/digest">Read more</a>`},
+			[]string{"# Hello Jane", "* second item", "[Read more](https://here.com/digest)"},
+		},
+		{
+			"with the default layout", "brand", "",
+			[]string{`<div class="header">Celeritas</div>`, "<h1>Hello Jane</h1>", "footer"},
+			[]string{"Celeritas\n", "# Hello Jane", "-- footer"},
+		},
+		{
+			"layout disabled by the message", "brand", "none",
+			[]string{"<h1>Hello Jane</h1>"},
+			[]string{"# Hello Jane"},
+		},
+	}
+
+	for _, e := range tests {
+		m := mailer
+		m.Layout = e.layout
+		msg := Message{Template: "digest", Layout: e.msgLayout, Data: data}
+
+		html, err := m.buildHTMLMessage(msg)
+		if err != nil {
+			t.Fatalf("%s: %s", e.name, err)
+		}
+		for _, s := range e.html {
+			if !strings.Contains(html, s) {
+				t.Errorf("%s: html does not contain %q: %s", e.name, s, html)
+			}
+		}
+		if strings.Contains(html, "<item>") {
+			t.Errorf("%s: raw html from the data rendered: %s", e.name, html)
+		}
+		if e.msgLayout == "none" && strings.Contains(html, "Celeritas") {
+			t.Errorf("%s: layout rendered", e.name)
+		}
+
+		plain, err := m.buildPlainTextMessage(msg)
+		if err != nil {
+			t.Fatalf("%s: %s", e.name, err)
+		}
+		for _, s := range e.plain {
+			if !strings.Contains(plain, s) {
+				t.Errorf("%s: plain text does not contain %q: %s", e.name, s, plain)
+			}
+		}
+	}
+}
+
+func TestMail_goTemplateLayout(t *testing.T) {
+	m := mailer
+	m.Layout = "brand"
+
+	html, err := m.buildHTMLMessage(Message{Template: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(html, `<div class="header">Celeritas</div>`) || !strings.Contains(html, "Enter your message content here") {
+		t.Errorf("template not rendered within the layout: %s", html)
+	}
+
+	m.Layout = "missing"
+	if _, err := m.buildHTMLMessage(Message{Template: "test"}); err == nil {
+		t.Error("expected an error for a missing html layout")
+	}
+}
+
+func TestMail_jetTemplate(t *testing.T) {
+	m := mailer
+	m.JetViews = jet.NewSet(jet.NewOSFileSystemLoader(m.Templates), jet.InDevelopmentMode())
+	m.JetViews.AddGlobal("code", func() string { return "42" })
+
+	msg := Message{Template: "welcome", Data: map[string]interface{}{"Name": "Jane"}}
+
+	html, err := m.buildHTMLMessage(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(html, `<div class="header">Celeritas</div>`) || !strings.Contains(html, "Welcome Jane, your code is 42.") {
+		t.Errorf("jet template not rendered within its layout: %s", html)
+	}
+
+	plain, err := m.buildPlainTextMessage(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(plain) != "Welcome Jane, your code is 42." {
+		t.Errorf("wrong jet plain text: %q", plain)
+	}
+
+	names, err := m.templateNames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(names, ","); !strings.Contains(got, "digest") || !strings.Contains(got, "welcome") || !strings.Contains(got, "test") {
+		t.Errorf("preview does not list every template: %s", got)
+	}
+}
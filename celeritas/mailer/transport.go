@@ -0,0 +1,237 @@
+package mailer
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	mail "github.com/xhit/go-simple-mail/v2"
+)
+
+// Envelope is a rendered message, handed to a Transport. The Message has the defaults
+// of the mailer applied, its subject translated, and every attachment loaded in Files
+type Envelope struct {
+	Message
+	HTML      string
+	PlainText string
+	// Raw is the MIME message, signed when DKIM is set
+	Raw string
+}
+
+// Recipients returns every To, Cc and Bcc address of the message
+func (e *Envelope) Recipients() []string {
+	recipients := make([]string, 0, len(e.To)+len(e.Cc)+len(e.Bcc))
+	recipients = append(recipients, e.To...)
+	recipients = append(recipients, e.Cc...)
+	return append(recipients, e.Bcc...)
+}
+
+// email builds the MIME message of the envelope
+func (e *Envelope) email() *mail.Email {
+	email := mail.NewMSG()
+	email.SetFrom(e.from()).
+		AddTo(e.To...).
+		AddCc(e.Cc...).
+		AddBcc(e.Bcc...).
+		SetSubject(e.Subject)
+
+	if e.ReplyTo != "" {
+		email.SetReplyTo(e.ReplyTo)
+	}
+
+	switch e.Priority {
+	case PriorityHigh:
+		email.SetPriority(mail.PriorityHigh)
+	case PriorityLow:
+		email.SetPriority(mail.PriorityLow)
+	}
+
+	for _, k := range sortedKeys(e.Headers) {
+		email.AddHeader(k, e.Headers[k])
+	}
+
+	email.SetBody(mail.TextHTML, e.HTML)
+	email.AddAlternative(mail.TextPlain, e.PlainText)
+
+	for _, f := range e.Files {
+		email.Attach(&mail.File{
+			Name:     f.Name,
+			MimeType: f.contentType(),
+			Data:     f.Data,
+			Inline:   f.Inline,
+		})
+	}
+
+	return email
+}
+
+// Transport sends rendered messages
+type Transport interface {
+	Send(env *Envelope) error
+}
+
+// TransportFunc is a function used as a Transport
+type TransportFunc func(env *Envelope) error
+
+// Send calls f(env)
+func (f TransportFunc) Send(env *Envelope) error {
+	return f(env)
+}
+
+// TransportFactory creates a transport from the mailer settings
+type TransportFactory func(m *Mail) (Transport, error)
+
+var (
+	transportsMu sync.RWMutex
+	transports   = make(map[string]TransportFactory)
+)
+
+// RegisterTransport makes a transport available under name, which API selects.
+// Registering a name again replaces the previous transport
+func RegisterTransport(name string, factory TransportFactory) {
+	transportsMu.Lock()
+	defer transportsMu.Unlock()
+	transports[name] = factory
+}
+
+// Transports returns the names of the registered transports, sorted
+func Transports() []string {
+	transportsMu.RLock()
+	defer transportsMu.RUnlock()
+
+	names := make([]string, 0, len(transports))
+	for name := range transports {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// transport returns Transport when it is set, or else the transport named by API
+func (m *Mail) transport() (Transport, error) {
+	if m.Transport != nil {
+		return m.Transport, nil
+	}
+
+	names := m.apiNames()
+	if len(names) == 1 {
+		return m.namedTransport(names[0])
+	}
+
+	var failover Failover
+	for _, name := range names {
+		t, err := m.namedTransport(name)
+		if err != nil {
+			return nil, err
+		}
+		failover = append(failover, t)
+	}
+	return failover, nil
+}
+
+// apiNames returns the names of the transports of API, smtp by default, in the
+// order they are tried
+func (m *Mail) apiNames() []string {
+	api := m.API
+	if api == "" {
+		api = "smtp"
+	}
+
+	var names []string
+	for _, name := range strings.Split(api, ",") {
+		names = append(names, strings.TrimSpace(name))
+	}
+	return names
+}
+
+// usesAPI reports whether name is one of the transports of API, when Transport is not
+// set
+func (m *Mail) usesAPI(name string) bool {
+	if m.Transport != nil {
+		return false
+	}
+	for _, api := range m.apiNames() {
+		if api == name {
+			return true
+		}
+	}
+	return false
+}
+
+// namedTransport creates the transport registered as name, limited to its rate in
+// RateLimits if any
+func (m *Mail) namedTransport(name string) (Transport, error) {
+	transportsMu.RLock()
+	factory, ok := transports[name]
+	transportsMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown api %s; available: %s", name, strings.Join(Transports(), ", "))
+	}
+
+	t, err := factory(m)
+	if err != nil {
+		return nil, err
+	}
+	if rate := m.RateLimits[name]; rate > 0 {
+		t = &rateLimited{t: t, l: transportLimiter(name, rate)}
+	}
+	return t, nil
+}
+
+// Failover sends with each transport in turn, until one succeeds
+type Failover []Transport
+
+// Send tries every transport, and returns their errors when none succeeds
+func (f Failover) Send(env *Envelope) error {
+	if len(f) == 0 {
+		return errors.New("mailer: no transport to fail over to")
+	}
+
+	var errs []string
+	for _, t := range f {
+		err := t.Send(env)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, err.Error())
+	}
+	return errors.New("mailer: every transport failed: " + strings.Join(errs, "; "))
+}
+
+// FakeTransport records the messages instead of sending them, for tests. When Err is
+// set, Send returns it and records nothing
+type FakeTransport struct {
+	Err error
+
+	mu   sync.Mutex
+	sent []Envelope
+}
+
+// Send records env
+func (f *FakeTransport) Send(env *Envelope) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.Err != nil {
+		return f.Err
+	}
+	f.sent = append(f.sent, *env)
+	return nil
+}
+
+// Sent returns the recorded messages, in the order they were sent
+func (f *FakeTransport) Sent() []Envelope {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Envelope(nil), f.sent...)
+}
+
+// Reset forgets the recorded messages
+func (f *FakeTransport) Reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sent = nil
+}
@@ -0,0 +1,155 @@
+package mailer
+
+import (
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+var testMessage = Message{
+	To:       []string{"you@there.com"},
+	Cc:       []string{"boss@there.com"},
+	Subject:  "transport",
+	Template: "test",
+}
+
+func TestMail_Transport(t *testing.T) {
+	fake := &FakeTransport{}
+	m := mailer
+	m.Transport = fake
+
+	if err := m.Deliver(testMessage); err != nil {
+		t.Fatal(err)
+	}
+
+	sent := fake.Sent()
+	if len(sent) != 1 {
+		t.Fatalf("expected 1 recorded message, got %d", len(sent))
+	}
+	if sent[0].From != "me@here.com" || sent[0].Subject != "transport" || !strings.Contains(sent[0].Raw, "Subject: transport") {
+		t.Errorf("wrong recorded message: %+v", sent[0].Message)
+	}
+	if got := sent[0].Recipients(); len(got) != 2 {
+		t.Errorf("expected to and cc recipients, got %v", got)
+	}
+
+	fake.Reset()
+	if len(fake.Sent()) != 0 {
+		t.Error("reset did not forget the messages")
+	}
+}
+
+func TestMail_RegisterTransport(t *testing.T) {
+	fake := &FakeTransport{}
+	RegisterTransport("test-fake", func(m *Mail) (Transport, error) {
+		return fake, nil
+	})
+
+	var tests = []struct {
+		name     string
+		api      string
+		fakeErr  error
+		expected int
+		wantErr  bool
+	}{
+		{"registered", "test-fake", nil, 1, false},
+		{"unknown", "pigeon", nil, 0, true},
+		{"failover to the second", "test-broken, test-fake", nil, 1, false},
+		{"every transport fails", "test-broken,test-fake", errors.New("down"), 0, true},
+	}
+
+	RegisterTransport("test-broken", func(m *Mail) (Transport, error) {
+		return TransportFunc(func(env *Envelope) error { return errors.New("broken") }), nil
+	})
+
+	for _, e := range tests {
+		fake.Reset()
+		fake.Err = e.fakeErr
+
+		m := mailer
+		m.API = e.api
+		err := m.Deliver(testMessage)
+		if e.wantErr && err == nil {
+			t.Errorf("%s: expected an error", e.name)
+		}
+		if !e.wantErr && err != nil {
+			t.Errorf("%s: unexpected error %s", e.name, err)
+		}
+		if len(fake.Sent()) != e.expected {
+			t.Errorf("%s: expected %d sent messages, got %d", e.name, e.expected, len(fake.Sent()))
+		}
+	}
+}
+
+func TestMail_SendmailTransport(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("sendmail stub is a shell script")
+	}
+
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out.eml")
+	stub := filepath.Join(dir, "sendmail")
+	script := "#!/bin/sh\necho \"$@\" > " + out + ".args\ncat > " + out + "\n"
+	if err := ioutil.WriteFile(stub, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	m := mailer
+	m.API = "sendmail"
+	m.SendmailPath = stub
+	if err := m.Deliver(testMessage); err != nil {
+		t.Fatal(err)
+	}
+
+	args, _ := ioutil.ReadFile(out + ".args")
+	if strings.TrimSpace(string(args)) != "-i -f me@here.com -- you@there.com boss@there.com" {
+		t.Errorf("wrong sendmail arguments: %s", args)
+	}
+	raw, _ := ioutil.ReadFile(out)
+	if !strings.Contains(string(raw), "Subject: transport") {
+		t.Errorf("raw message not piped to sendmail: %s", raw)
+	}
+}
+
+func TestMail_SESTransport(t *testing.T) {
+	var raw string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		if r.Form.Get("Action") != "SendRawEmail" || r.Form.Get("Destinations.member.2") != "boss@there.com" {
+			http.Error(w, "unexpected request "+r.Form.Encode(), http.StatusBadRequest)
+			return
+		}
+		data, _ := base64.StdEncoding.DecodeString(r.Form.Get("RawMessage.Data"))
+		raw = string(data)
+		_, _ = w.Write([]byte(`<SendRawEmailResponse><SendRawEmailResult><MessageId>1</MessageId></SendRawEmailResult></SendRawEmailResponse>`))
+	}))
+	defer srv.Close()
+
+	m := mailer
+	m.API = "ses"
+	m.APIKey = "key"
+	m.APISecret = "secret"
+	m.APIUrl = srv.URL
+	m.Region = "us-east-1"
+	if err := m.Deliver(testMessage); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(raw, "Subject: transport") {
+		t.Errorf("raw message not sent to ses: %q", raw)
+	}
+}
+
+func TestTransports(t *testing.T) {
+	names := strings.Join(Transports(), ",")
+	for _, name := range []string{"file", "log", "mailgun", "postmark", "sendgrid", "sendmail", "ses", "smtp", "sparkpost"} {
+		if !strings.Contains(names, name) {
+			t.Errorf("transport %s is not registered", name)
+		}
+	}
+}
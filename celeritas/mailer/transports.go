@@ -0,0 +1,195 @@
+package mailer
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/ainsleyclark/go-mail/drivers"
+	apimail "github.com/ainsleyclark/go-mail/mail"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ses"
+	mail "github.com/xhit/go-simple-mail/v2"
+)
+
+const defaultSendmailPath = "/usr/sbin/sendmail"
+
+func init() {
+	RegisterTransport("smtp", func(m *Mail) (Transport, error) {
+		return &smtpTransport{m: m}, nil
+	})
+
+	RegisterTransport("file", func(m *Mail) (Transport, error) {
+		return TransportFunc(func(env *Envelope) error {
+			return m.capture(env, false)
+		}), nil
+	})
+	RegisterTransport("log", func(m *Mail) (Transport, error) {
+		return TransportFunc(func(env *Envelope) error {
+			return m.capture(env, true)
+		}), nil
+	})
+
+	for _, name := range []string{"mailgun", "sparkpost", "sendgrid", "postmark", "postal"} {
+		name := name
+		RegisterTransport(name, func(m *Mail) (Transport, error) {
+			return newAPITransport(m, name)
+		})
+	}
+
+	RegisterTransport("ses", newSESTransport)
+
+	RegisterTransport("sendmail", func(m *Mail) (Transport, error) {
+		path := m.SendmailPath
+		if path == "" {
+			path = defaultSendmailPath
+		}
+		return &sendmailTransport{path: path}, nil
+	})
+}
+
+// smtpTransport sends the raw message to the smtp server of the mailer
+type smtpTransport struct {
+	m *Mail
+}
+
+func (s *smtpTransport) Send(env *Envelope) error {
+	server := mail.NewSMTPClient()
+	server.Host = s.m.Host
+	server.Port = s.m.Port
+	server.Username = s.m.Username
+	server.Password = s.m.Password
+	server.Encryption = s.m.getEncryption(s.m.Encryption)
+	server.KeepAlive = false
+	server.ConnectTimeout = 10 * time.Second
+	server.SendTimeout = 10 * time.Second
+
+	smtpClient, err := server.Connect()
+	if err != nil {
+		return err
+	}
+
+	return mail.SendMessage(env.From, env.Recipients(), env.Raw, smtpClient)
+}
+
+// apiTransport sends the message with the api of a provider. The api clients have no
+// inline parts, so inline images are sent as regular attachments, and the providers
+// sign with the domain configured on their side
+type apiTransport struct {
+	m        *Mail
+	provider string
+}
+
+func newAPITransport(m *Mail, provider string) (Transport, error) {
+	switch provider {
+	case "mailgun", "sparkpost", "sendgrid", "postmark", "postal":
+		return &apiTransport{m: m, provider: provider}, nil
+	default:
+		return nil, fmt.Errorf("unknown api %s", provider)
+	}
+}
+
+func (a *apiTransport) Send(env *Envelope) error {
+	// the api clients take the sender from their config
+	cfg := apimail.Config{
+		URL:         a.m.APIUrl,
+		APIKey:      a.m.APIKey,
+		Domain:      a.m.Domain,
+		FromAddress: env.From,
+		FromName:    env.FromName,
+	}
+
+	var driver apimail.Mailer
+	var err error
+	switch a.provider {
+	case "mailgun":
+		driver, err = drivers.NewMailgun(cfg)
+	case "sparkpost":
+		driver, err = drivers.NewSparkPost(cfg)
+	case "sendgrid":
+		driver, err = drivers.NewSendGrid(cfg)
+	case "postmark":
+		driver, err = drivers.NewPostmark(cfg)
+	case "postal":
+		driver, err = drivers.NewPostal(cfg)
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = driver.Send(apiTransmission(env))
+	return err
+}
+
+// apiTransmission converts env to the message of the api clients
+func apiTransmission(env *Envelope) *apimail.Transmission {
+	tx := &apimail.Transmission{
+		Recipients: env.To,
+		CC:         env.Cc,
+		BCC:        env.Bcc,
+		Subject:    env.Subject,
+		HTML:       env.HTML,
+		PlainText:  env.PlainText,
+		Headers:    env.headers(),
+	}
+
+	for _, f := range env.Files {
+		tx.Attachments = append(tx.Attachments, apimail.Attachment{
+			Filename: f.Name,
+			Bytes:    f.Data,
+		})
+	}
+	return tx
+}
+
+// sesTransport sends the raw message with the Amazon SES api. APIKey and APISecret
+// are the access keys, otherwise the default aws credentials are used. APIUrl
+// overrides the endpoint
+type sesTransport struct {
+	client *ses.SES
+}
+
+func newSESTransport(m *Mail) (Transport, error) {
+	cfg := &aws.Config{Region: aws.String(m.Region)}
+	if m.APIKey != "" {
+		cfg.Credentials = credentials.NewStaticCredentials(m.APIKey, m.APISecret, "")
+	}
+	if m.APIUrl != "" {
+		cfg.Endpoint = aws.String(m.APIUrl)
+	}
+
+	sess, err := session.NewSession(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &sesTransport{client: ses.New(sess)}, nil
+}
+
+func (s *sesTransport) Send(env *Envelope) error {
+	_, err := s.client.SendRawEmail(&ses.SendRawEmailInput{
+		Source:       aws.String(env.From),
+		Destinations: aws.StringSlice(env.Recipients()),
+		RawMessage:   &ses.RawMessage{Data: []byte(env.Raw)},
+	})
+	return err
+}
+
+// sendmailTransport pipes the raw message to a local sendmail binary
+type sendmailTransport struct {
+	path string
+}
+
+func (s *sendmailTransport) Send(env *Envelope) error {
+	args := append([]string{"-i", "-f", env.From, "--"}, env.Recipients()...)
+	cmd := exec.Command(s.path, args...)
+	cmd.Stdin = strings.NewReader(env.Raw)
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("sendmail: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package mailer
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ErrInvalidUnsubscribe is returned when an unsubscribe link is not signed by Signer
+var ErrInvalidUnsubscribe = errors.New("mailer: invalid unsubscribe link")
+
+// unsubscribeHeaders adds the List-Unsubscribe headers of RFC 8058 to msg, with a
+// signed link to UnsubscribeURL. Mail clients post to it for a one click unsubscribe
+func (m *Mail) unsubscribeHeaders(msg Message) Message {
+	if msg.Unsubscribe == "" || m.UnsubscribeURL == "" || m.Signer == nil {
+		return msg
+	}
+
+	link := m.UnsubscribeURL
+	if strings.Contains(link, "?") {
+		link += "&"
+	} else {
+		link += "?"
+	}
+	link += "email=" + url.QueryEscape(msg.Unsubscribe)
+
+	headers := make(map[string]string, len(msg.Headers)+2)
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+	headers["List-Unsubscribe"] = "<" + m.Signer.GenerateTokenFromString(link) + ">"
+	headers["List-Unsubscribe-Post"] = "List-Unsubscribe=One-Click"
+	msg.Headers = headers
+
+	return msg
+}
+
+// Unsubscriber returns the Unsubscribe value of the message whose unsubscribe link
+// r was sent to, once its signature is verified. The link does not expire
+func (m *Mail) Unsubscriber(r *http.Request) (string, error) {
+	if m.Signer == nil || m.UnsubscribeURL == "" {
+		return "", ErrInvalidUnsubscribe
+	}
+
+	base, err := url.Parse(m.UnsubscribeURL)
+	if err != nil {
+		return "", err
+	}
+
+	link := base.Scheme + "://" + base.Host + r.URL.RequestURI()
+	if !m.Signer.VerifyToken(link) {
+		return "", ErrInvalidUnsubscribe
+	}
+
+	return r.URL.Query().Get("email"), nil
+}
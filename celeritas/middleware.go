@@ -4,6 +4,7 @@ import (
 	"net/http"
 	"strconv"
 
+	"github.com/djedjethai/celeritas/i18n"
 	"github.com/justinas/nosurf"
 )
 
@@ -11,11 +12,29 @@ func (c *Celeritas) SessionLoad(next http.Handler) http.Handler {
 	return c.Session.LoadAndSave(next)
 }
 
+// Locale detects the locale of the request from the url prefix, the lang cookie,
+// the session or the Accept-Language header, and stores it in the request context
+func (c *Celeritas) Locale(next http.Handler) http.Handler {
+	if c.I18n == nil {
+		return next
+	}
+
+	detector := i18n.Detector{
+		Translator: c.I18n,
+	}
+	if c.Session != nil {
+		detector.Session = c.Session
+	}
+	return detector.Middleware(next)
+}
+
 func (c *Celeritas) NoSurf(next http.Handler) http.Handler {
 	csrfHandler := nosurf.New(next)
 	secure, _ := strconv.ParseBool(c.config.cookie.secure)
 
 	csrfHandler.ExemptGlob("/api/*")
+	// signed file urls are authorized by their signature
+	csrfHandler.ExemptPath(SignedFilesPath)
 
 	csrfHandler.SetBaseCookie(http.Cookie{
 		HttpOnly: true,
@@ -0,0 +1,83 @@
+package render
+
+import (
+	"html/template"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/CloudyKit/jet/v6"
+)
+
+// IsHTMX reports whether the request was issued by htmx
+func IsHTMX(r *http.Request) bool {
+	return strings.ToLower(r.Header.Get("HX-Request")) == "true"
+}
+
+// IsBoosted reports whether the request comes from an hx-boost element. Boosted
+// requests swap the whole body, so they get the full page
+func IsBoosted(r *http.Request) bool {
+	return strings.ToLower(r.Header.Get("HX-Boosted")) == "true"
+}
+
+// FlashOOB is the data of the partial rendering the flash swap
+type FlashOOB struct {
+	// Target is the id of the element to replace
+	Target string
+	Flash  string
+	Error  string
+}
+
+// flashOOBPartial is the name of the partial an application can add to its views to
+// render the flash swap, as partials/flash-oob.jet or partials/flash-oob.partial.tmpl
+const flashOOBPartial = "partials/flash-oob"
+
+// defaultFlashOOB renders the flash swap with the Bootstrap alerts
+var defaultFlashOOB = template.Must(template.New("flash-oob").Parse(`<div id="{{.Target}}" hx-swap-oob="true">
+{{- with .Flash}}<div class="alert alert-success mt-2" role="alert">{{.}}</div>{{end -}}
+{{- with .Error}}<div class="alert alert-danger mt-2" role="alert">{{.}}</div>{{end -}}
+</div>`))
+
+// writeFlashOOB appends the flash and error messages to an htmx response as an
+// out-of-band swap, so they show up even though the layout is not rendered
+func (c *Render) writeFlashOOB(w http.ResponseWriter, r *http.Request, td *TemplateData) error {
+	if td.Flash == "" && td.Error == "" {
+		return nil
+	}
+
+	data := FlashOOB{Target: c.FlashTarget, Flash: td.Flash, Error: td.Error}
+	if data.Target == "" {
+		data.Target = "flash"
+	}
+
+	switch strings.ToLower(c.Renderer) {
+	case "jet":
+		if c.hasPartial(flashOOBPartial + ".jet") {
+			t, err := c.JetViews.GetTemplate(flashOOBPartial + ".jet")
+			if err != nil {
+				return err
+			}
+			vars := make(jet.VarMap)
+			for _, p := range c.funcProviders {
+				vars.Set(p.name, p.fn(r))
+			}
+			return t.Execute(w, vars, data)
+		}
+	case "go":
+		if file := flashOOBPartial + ".partial.tmpl"; c.hasPartial(file) {
+			t, err := template.New(filepath.Base(file)).Funcs(c.goFuncs(r)).ParseFiles(filepath.Join(c.RootPath, "views", file))
+			if err != nil {
+				return err
+			}
+			return t.Execute(w, data)
+		}
+	}
+	return defaultFlashOOB.Execute(w, data)
+}
+
+// hasPartial reports whether the views folder holds the file
+func (c *Render) hasPartial(file string) bool {
+	_, err := os.Stat(filepath.Join(c.RootPath, "views", file))
+	return err == nil
+}
@@ -6,7 +6,10 @@ import (
 	"html/template"
 	"log"
 	"net/http"
+	"net/url"
+	"path/filepath"
 	"strings"
+	"sync"
 
 	"github.com/CloudyKit/jet/v6"
 	"github.com/alexedwards/scs/v2"
@@ -21,6 +24,19 @@ type Render struct {
 	ServerName string
 	JetViews   *jet.Set
 	Session    *scs.SessionManager
+	// Funcs are the helper functions available in Go templates
+	Funcs template.FuncMap
+	// FragmentBlock is the block rendered instead of the full layout when a
+	// request comes from htmx; it defaults to pageContent
+	FragmentBlock string
+	// FlashTarget is the id of the element replaced by the out-of-band swap
+	// carrying flash and error messages on htmx requests; it defaults to flash
+	FlashTarget string
+
+	providers     []dataProvider
+	funcProviders []funcProvider
+	// fragments caches the jet fragment wrappers, by view and block
+	fragments sync.Map
 }
 
 type TemplateData struct {
@@ -35,6 +51,12 @@ type TemplateData struct {
 	Secure          bool
 	Error           string
 	Flash           string
+	// Model holds any value passed to Page which is not a TemplateData
+	Model interface{}
+	// Errors and OldInput hold the validation errors and the submitted form
+	// values of a failed form post, see PutForm
+	Errors   map[string]string
+	OldInput url.Values
 }
 
 func (c *Render) defaultData(td *TemplateData, r *http.Request) *TemplateData {
@@ -42,15 +64,44 @@ func (c *Render) defaultData(td *TemplateData, r *http.Request) *TemplateData {
 	td.ServerName = c.ServerName
 	td.CSRFToken = nosurf.Token(r)
 	td.Port = c.Port
+
+	// the providers must not write into a map the caller may share between requests
+	if len(c.providers) > 0 {
+		values := make(map[string]interface{}, len(td.Data)+len(c.providers))
+		for key, value := range td.Data {
+			values[key] = value
+		}
+		for _, p := range c.providers {
+			values[p.key] = p.fn(r)
+		}
+		td.Data = values
+	}
+
+	if c.Session == nil {
+		return td
+	}
 	if c.Session.Exists(r.Context(), "userID") {
 		td.IsAuthenticated = true
 	}
 	td.Error = c.Session.PopString(r.Context(), "error")
 	td.Flash = c.Session.PopString(r.Context(), "flash")
+
+	if errs, ok := c.Session.Pop(r.Context(), FormErrorsKey).(map[string]string); ok {
+		td.Errors = errs
+	}
+	if old, ok := c.Session.Pop(r.Context(), OldInputKey).(url.Values); ok {
+		td.OldInput = old
+	}
 	return td
 }
 
+// Page renders the view with the configured engine. When the request comes from
+// htmx (and is not a boosted navigation), only the fragment block is rendered
 func (c *Render) Page(w http.ResponseWriter, r *http.Request, view string, variables, data interface{}) error {
+	if IsHTMX(r) && !IsBoosted(r) {
+		return c.Fragment(w, r, view, c.fragmentBlock(), variables, data)
+	}
+
 	switch strings.ToLower(c.Renderer) {
 	case "go":
 		return c.GoPage(w, r, view, data)
@@ -64,15 +115,12 @@ func (c *Render) Page(w http.ResponseWriter, r *http.Request, view string, varia
 
 // GoPage renders a standard Go template
 func (c *Render) GoPage(w http.ResponseWriter, r *http.Request, view string, data interface{}) error {
-	tmpl, err := template.ParseFiles(fmt.Sprintf("%s/views/%s.page.tmpl", c.RootPath, view))
+	tmpl, err := c.parseGoTemplate(r, view)
 	if err != nil {
 		return err
 	}
 
-	td := &TemplateData{}
-	if data != nil {
-		td = data.(*TemplateData)
-	}
+	td := c.defaultData(NewTemplateData(data), r)
 
 	err = tmpl.Execute(w, &td)
 	if err != nil {
@@ -84,26 +132,101 @@ func (c *Render) GoPage(w http.ResponseWriter, r *http.Request, view string, dat
 
 // JetPage renders a template using the Jet templating engine
 func (c *Render) JetPage(w http.ResponseWriter, r *http.Request, templateName string, variables, data interface{}) error {
-	var vars jet.VarMap
+	vars, err := jetVars(variables)
+	if err != nil {
+		return err
+	}
+	for _, p := range c.funcProviders {
+		vars.Set(p.name, p.fn(r))
+	}
+
+	td := c.defaultData(NewTemplateData(data), r)
 
-	if variables == nil {
-		vars = make(jet.VarMap)
-	} else {
-		vars = variables.(jet.VarMap)
+	t, err := c.JetViews.GetTemplate(fmt.Sprintf("%s.jet", templateName))
+	if err != nil {
+		log.Println(err)
+		return err
 	}
 
-	td := &TemplateData{}
-	if data != nil {
-		td = data.(*TemplateData)
+	if err = t.Execute(w, vars, td); err != nil {
+		log.Println(err)
+		return err
 	}
+	return nil
+}
 
-	td = c.defaultData(td, r)
+// Fragment renders a single named block of a view, without its layout. It is used
+// for htmx requests, and can be called directly to render any block of a template
+func (c *Render) Fragment(w http.ResponseWriter, r *http.Request, view, block string, variables, data interface{}) error {
+	var err error
 
-	t, err := c.JetViews.GetTemplate(fmt.Sprintf("%s.jet", templateName))
+	// make sure default data set while rendering ends up in the flash swap below
+	td := NewTemplateData(data)
+	data = td
+
+	switch strings.ToLower(c.Renderer) {
+	case "go":
+		err = c.GoFragment(w, r, view, block, data)
+	case "jet":
+		err = c.JetFragment(w, r, view, block, variables, data)
+	default:
+		return errors.New("no rendering engine specified")
+	}
+	if err != nil {
+		return err
+	}
+
+	if IsHTMX(r) {
+		return c.writeFlashOOB(w, r, td)
+	}
+	return nil
+}
+
+// GoFragment renders the named template (block) of a standard Go template. If the view
+// does not define the block, the whole view is rendered
+func (c *Render) GoFragment(w http.ResponseWriter, r *http.Request, view, block string, data interface{}) error {
+	tmpl, err := c.parseGoTemplate(r, view)
+	if err != nil {
+		return err
+	}
+
+	td := c.defaultData(NewTemplateData(data), r)
+
+	if tmpl.Lookup(block) == nil {
+		return tmpl.Execute(w, &td)
+	}
+
+	return tmpl.ExecuteTemplate(w, block, &td)
+}
+
+// JetFragment renders a block of a Jet template. The template is imported into a
+// small wrapper which yields the block, so any layout it extends is not rendered.
+// If the template does not define the block, the whole template is rendered
+func (c *Render) JetFragment(w http.ResponseWriter, r *http.Request, templateName, block string, variables, data interface{}) error {
+	vars, err := jetVars(variables)
+	if err != nil {
+		return err
+	}
+	for _, p := range c.funcProviders {
+		vars.Set(p.name, p.fn(r))
+	}
+
+	td := c.defaultData(NewTemplateData(data), r)
+
+	full, err := c.JetViews.GetTemplate(fmt.Sprintf("%s.jet", templateName))
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	t, err := c.jetFragment(full, templateName, block)
 	if err != nil {
 		log.Println(err)
 		return err
 	}
+	if t == nil {
+		t = full
+	}
 
 	if err = t.Execute(w, vars, td); err != nil {
 		log.Println(err)
@@ -111,3 +234,83 @@ func (c *Render) JetPage(w http.ResponseWriter, r *http.Request, templateName st
 	}
 	return nil
 }
+
+// jetFragment returns the wrapper yielding block of full, the template of view, or nil
+// when the view does not define the block. The wrappers are kept until the view is
+// parsed again, as it is on each request in development mode
+func (c *Render) jetFragment(full *jet.Template, view, block string) (*jet.Template, error) {
+	cacheKey := view + "\x00" + block
+	if cached, ok := c.fragments.Load(cacheKey); ok {
+		if f := cached.(jetFragment); f.full == full {
+			return f.wrapper, nil
+		}
+	}
+
+	f := jetFragment{full: full}
+	if hasBlock(full.Root, block) {
+		wrapper := fmt.Sprintf(`{{import "/%s.jet"}}{{yield %s()}}`, strings.TrimPrefix(view, "/"), block)
+		t, err := c.JetViews.Parse(fmt.Sprintf("%s.%s.fragment.jet", view, block), wrapper)
+		if err != nil {
+			return nil, err
+		}
+		f.wrapper = t
+	}
+
+	c.fragments.Store(cacheKey, f)
+	return f.wrapper, nil
+}
+
+// jetFragment is a cached fragment wrapper, nil when the view has no such block
+type jetFragment struct {
+	full    *jet.Template
+	wrapper *jet.Template
+}
+
+// hasBlock reports whether the nodes of a template define the block name
+func hasBlock(list *jet.ListNode, name string) bool {
+	if list == nil {
+		return false
+	}
+	for _, node := range list.Nodes {
+		switch n := node.(type) {
+		case *jet.BlockNode:
+			if n.Name == name || hasBlock(n.List, name) || hasBlock(n.Content, name) {
+				return true
+			}
+		case *jet.IfNode:
+			if hasBlock(n.List, name) || hasBlock(n.ElseList, name) {
+				return true
+			}
+		case *jet.RangeNode:
+			if hasBlock(n.List, name) || hasBlock(n.ElseList, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parseGoTemplate parses a Go template from the views folder, with the helper funcs
+func (c *Render) parseGoTemplate(r *http.Request, view string) (*template.Template, error) {
+	file := fmt.Sprintf("%s/views/%s.page.tmpl", c.RootPath, view)
+	return template.New(filepath.Base(file)).Funcs(c.goFuncs(r)).ParseFiles(file)
+}
+
+// goFuncs returns the helper funcs of the Go templates rendered for r
+func (c *Render) goFuncs(r *http.Request) template.FuncMap {
+	funcs := template.FuncMap{}
+	for name, fn := range c.Funcs {
+		funcs[name] = fn
+	}
+	for _, p := range c.funcProviders {
+		funcs[p.name] = p.fn(r)
+	}
+	return funcs
+}
+
+func (c *Render) fragmentBlock() string {
+	if c.FragmentBlock == "" {
+		return "pageContent"
+	}
+	return c.FragmentBlock
+}
@@ -1,9 +1,18 @@
 package render
 
 import (
+	"context"
+	"html/template"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+
+	"github.com/CloudyKit/jet/v6"
+	"github.com/alexedwards/scs/v2"
 )
 
 var pageData = []struct {
@@ -77,3 +86,318 @@ func TestRender_JetPage(t *testing.T) {
 	}
 	
 }
+
+var fragmentData = []struct {
+	name       string
+	renderer   string
+	htmx       bool
+	boosted    bool
+	wantLayout bool
+}{
+	{"go_full_page", "go", false, false, true},
+	{"go_htmx_fragment", "go", true, false, false},
+	{"go_htmx_boosted", "go", true, true, true},
+	{"jet_full_page", "jet", false, false, true},
+	{"jet_htmx_fragment", "jet", true, false, false},
+	{"jet_htmx_boosted", "jet", true, true, true},
+}
+
+func TestRender_PageFragment(t *testing.T) {
+	for _, e := range fragmentData {
+		r, err := http.NewRequest("GET", "/some-url", nil)
+		if err != nil {
+			t.Error(err)
+		}
+		if e.htmx {
+			r.Header.Set("HX-Request", "true")
+		}
+		if e.boosted {
+			r.Header.Set("HX-Boosted", "true")
+		}
+
+		w := httptest.NewRecorder()
+
+		testRenderer.Renderer = e.renderer
+		testRenderer.RootPath = "./testdata"
+
+		err = testRenderer.Page(w, r, "fragment", nil, nil)
+		if err != nil {
+			t.Errorf("%s: error rendering page: %s", e.name, err)
+			continue
+		}
+
+		body := w.Body.String()
+		if !strings.Contains(body, "Hello, fragment.") {
+			t.Errorf("%s: expected fragment content, got %q", e.name, body)
+		}
+		if strings.Contains(body, "<html>") != e.wantLayout {
+			t.Errorf("%s: layout rendered = %t, expected %t", e.name, !e.wantLayout, e.wantLayout)
+		}
+	}
+}
+
+func TestRender_FragmentWithoutBlock(t *testing.T) {
+	r, err := http.NewRequest("GET", "/some-url", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	r.Header.Set("HX-Request", "true")
+
+	for _, renderer := range []string{"go", "jet"} {
+		w := httptest.NewRecorder()
+		testRenderer.Renderer = renderer
+		testRenderer.RootPath = "./testdata"
+
+		err = testRenderer.Page(w, r, "home", nil, nil)
+		if err != nil {
+			t.Errorf("%s: error rendering view without fragment block: %s", renderer, err)
+		}
+		if !strings.Contains(w.Body.String(), "Hello") {
+			t.Errorf("%s: expected the whole view to be rendered, got %q", renderer, w.Body.String())
+		}
+	}
+}
+
+func TestRender_FragmentFlashOOB(t *testing.T) {
+	r, err := http.NewRequest("GET", "/some-url", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	r.Header.Set("HX-Request", "true")
+
+	w := httptest.NewRecorder()
+	testRenderer.Renderer = "go"
+	testRenderer.RootPath = "./testdata"
+
+	td := &TemplateData{Flash: "Saved <ok>", Error: "oops"}
+	err = testRenderer.Page(w, r, "fragment", nil, td)
+	if err != nil {
+		t.Error(err)
+	}
+
+	body := w.Body.String()
+	if !strings.Contains(body, `<div id="flash" hx-swap-oob="true">`) {
+		t.Errorf("expected an out-of-band flash swap, got %q", body)
+	}
+	if !strings.Contains(body, "Saved &lt;ok&gt;") || !strings.Contains(body, "oops") {
+		t.Errorf("expected escaped flash and error messages, got %q", body)
+	}
+}
+
+func TestRender_FragmentFlashOOBPartial(t *testing.T) {
+	r, err := http.NewRequest("GET", "/some-url", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	r.Header.Set("HX-Request", "true")
+
+	w := httptest.NewRecorder()
+	testRenderer.Renderer = "jet"
+	testRenderer.RootPath = "./testdata"
+
+	td := &TemplateData{Flash: "Saved <ok>"}
+	err = testRenderer.Page(w, r, "fragment", nil, td)
+	if err != nil {
+		t.Error(err)
+	}
+
+	body := w.Body.String()
+	if !strings.Contains(body, `<div id="flash" hx-swap-oob="true" class="toast">Saved &lt;ok&gt;</div>`) {
+		t.Errorf("expected the flash swap of the views partial, got %q", body)
+	}
+}
+
+func TestRender_FragmentFlashOOBGoPartial(t *testing.T) {
+	root := t.TempDir()
+	_ = os.MkdirAll(filepath.Join(root, "views", "partials"), 0755)
+	page, _ := os.ReadFile("./testdata/views/fragment.page.tmpl")
+	_ = os.WriteFile(filepath.Join(root, "views", "fragment.page.tmpl"), page, 0644)
+	_ = os.WriteFile(filepath.Join(root, "views", "partials", "flash-oob.partial.tmpl"),
+		[]byte(`<div id="{{.Target}}" hx-swap-oob="true">{{shout .Flash}}</div>`), 0644)
+
+	testRenderer.Funcs = template.FuncMap{"shout": strings.ToUpper}
+	defer func() { testRenderer.Funcs = nil }()
+
+	r, _ := http.NewRequest("GET", "/some-url", nil)
+	r.Header.Set("HX-Request", "true")
+	w := httptest.NewRecorder()
+	testRenderer.Renderer = "go"
+	testRenderer.RootPath = root
+
+	if err := testRenderer.Page(w, r, "fragment", nil, &TemplateData{Flash: "saved"}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(w.Body.String(), `<div id="flash" hx-swap-oob="true">SAVED</div>`) {
+		t.Errorf("expected the flash swap of the partial with the helper funcs, got %q", w.Body.String())
+	}
+}
+
+func TestRender_JetVariablesNotChanged(t *testing.T) {
+	testRenderer.AddFuncProvider("t", func(r *http.Request) interface{} {
+		return func(key string) string { return key }
+	})
+	defer func() { testRenderer.funcProviders = nil }()
+
+	vars := make(jet.VarMap)
+	r, _ := http.NewRequest("GET", "/url", nil)
+	testRenderer.Renderer = "jet"
+	testRenderer.RootPath = "./testdata"
+	if err := testRenderer.Page(httptest.NewRecorder(), r, "funcs", vars, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(vars) != 0 {
+		t.Errorf("the variables of the caller were changed: %v", vars)
+	}
+}
+
+func TestRender_TemplateData(t *testing.T) {
+	sess := scs.New()
+	testRenderer.Session = sess
+	testRenderer.AddDataProvider("locale", func(r *http.Request) interface{} { return "fr" })
+	defer func() {
+		testRenderer.Session = nil
+		testRenderer.providers = nil
+	}()
+
+	model := struct{ Name string }{"Jane"}
+
+	for _, renderer := range []string{"go", "jet"} {
+		ctx, err := sess.Load(context.Background(), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// a failed form post
+		post := formRequest(url.Values{"email": {"not-an-email"}, "password": {"secret"}}).WithContext(ctx)
+		if err := testRenderer.PutForm(post, map[string]string{"email": "Invalid email address"}); err != nil {
+			t.Fatal(err)
+		}
+
+		// followed by the redirect back to the form
+		r, _ := http.NewRequest("GET", "/form", nil)
+		r = r.WithContext(ctx)
+		w := httptest.NewRecorder()
+
+		testRenderer.Renderer = renderer
+		testRenderer.RootPath = "./testdata"
+
+		err = testRenderer.Page(w, r, "form", nil, model)
+		if err != nil {
+			t.Errorf("%s: error rendering page: %s", renderer, err)
+			continue
+		}
+
+		body := w.Body.String()
+		for _, expected := range []string{`value="not-an-email"`, "<span>Invalid email address</span>", "fr Jane"} {
+			if !strings.Contains(body, expected) {
+				t.Errorf("%s: expected %q in %q", renderer, expected, body)
+			}
+		}
+
+		if sess.Exists(ctx, FormErrorsKey) || sess.Exists(ctx, OldInputKey) {
+			t.Errorf("%s: form state should only be shown once", renderer)
+		}
+	}
+}
+
+// formRequest returns the post of form, not parsed yet
+func formRequest(form url.Values) *http.Request {
+	r, _ := http.NewRequest("POST", "/form", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return r
+}
+
+func TestRender_PutFormHidesPasswords(t *testing.T) {
+	sess := scs.New()
+	testRenderer.Session = sess
+	defer func() { testRenderer.Session = nil }()
+
+	ctx, err := sess.Load(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := formRequest(url.Values{"email": {"me@here.com"}, "password": {"secret"}, "csrf_token": {"abc"}}).WithContext(ctx)
+	if err := testRenderer.PutForm(r, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	old := sess.Get(ctx, OldInputKey).(url.Values)
+	if old.Get("email") != "me@here.com" {
+		t.Error("expected email to be kept as old input")
+	}
+	if old.Get("password") != "" || old.Get("csrf_token") != "" {
+		t.Error("password and csrf token should not be kept as old input")
+	}
+}
+
+func TestRender_PutFormInvalidForm(t *testing.T) {
+	sess := scs.New()
+	testRenderer.Session = sess
+	defer func() { testRenderer.Session = nil }()
+
+	r, _ := http.NewRequest("POST", "/form", strings.NewReader("email=%zz"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if err := testRenderer.PutForm(r, nil); err == nil {
+		t.Error("expected the invalid form refused")
+	}
+}
+
+func TestRender_PutFormWithoutSessions(t *testing.T) {
+	r := formRequest(url.Values{"email": {"me@here.com"}})
+	if err := testRenderer.PutForm(r, nil); err == nil {
+		t.Error("expected an error without sessions")
+	}
+}
+
+func TestRender_DataProviderKeepsCallerData(t *testing.T) {
+	testRenderer.AddDataProvider("locale", func(r *http.Request) interface{} { return "fr" })
+	defer func() { testRenderer.providers = nil }()
+
+	data := map[string]interface{}{"title": "Home"}
+	r, _ := http.NewRequest("GET", "/url", nil)
+	td := testRenderer.defaultData(NewTemplateData(data), r)
+
+	if td.Data["locale"] != "fr" || td.Data["title"] != "Home" {
+		t.Errorf("unexpected template data %v", td.Data)
+	}
+	if _, ok := data["locale"]; ok {
+		t.Error("the provider wrote into the map of the caller")
+	}
+}
+
+func TestRender_InvalidVariables(t *testing.T) {
+	r, _ := http.NewRequest("GET", "/url", nil)
+	w := httptest.NewRecorder()
+	testRenderer.Renderer = "jet"
+
+	err := testRenderer.Page(w, r, "home", "not-variables", nil)
+	if err == nil {
+		t.Error("expected an error with invalid template variables")
+	}
+}
+
+func TestRender_FuncProvider(t *testing.T) {
+	testRenderer.AddFuncProvider("t", func(r *http.Request) interface{} {
+		lang := r.URL.Query().Get("lang")
+		return func(key string) string { return lang + ":" + key }
+	})
+	defer func() { testRenderer.funcProviders = nil }()
+
+	for _, renderer := range []string{"go", "jet"} {
+		r, _ := http.NewRequest("GET", "/url?lang=fr", nil)
+		w := httptest.NewRecorder()
+
+		testRenderer.Renderer = renderer
+		testRenderer.RootPath = "./testdata"
+
+		err := testRenderer.Page(w, r, "funcs", nil, nil)
+		if err != nil {
+			t.Errorf("%s: error rendering page: %s", renderer, err)
+			continue
+		}
+		if !strings.Contains(w.Body.String(), "<p>fr:hello</p>") {
+			t.Errorf("%s: unexpected body %q", renderer, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package render
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/CloudyKit/jet/v6"
+)
+
+const (
+	// FormErrorsKey is the session key holding the validation errors of a failed form post
+	FormErrorsKey = "form_errors"
+	// OldInputKey is the session key holding the submitted values of a failed form post
+	OldInputKey = "old_input"
+)
+
+// hiddenInputs are never kept as old input
+var hiddenInputs = []string{"password", "password_confirmation", "csrf_token"}
+
+func init() {
+	// session stores encode values with gob, which needs the concrete types
+	gob.Register(map[string]string{})
+	gob.Register(url.Values{})
+}
+
+// DataProvider returns a value added to the template data of every page, e.g. the
+// current user, the locale or feature flags
+type DataProvider func(r *http.Request) interface{}
+
+type dataProvider struct {
+	key string
+	fn  DataProvider
+}
+
+// AddDataProvider registers fn; its result is available in every template as
+// .Data[key]. Providers should be registered when the application starts
+func (c *Render) AddDataProvider(key string, fn DataProvider) {
+	c.providers = append(c.providers, dataProvider{key: key, fn: fn})
+}
+
+// FuncProvider returns a template function bound to the request, e.g. a translation
+// function using the locale of the request
+type FuncProvider func(r *http.Request) interface{}
+
+type funcProvider struct {
+	name string
+	fn   FuncProvider
+}
+
+// AddFuncProvider registers a per request template function, available as name in
+// Jet and Go templates. Providers should be registered when the application starts
+func (c *Render) AddFuncProvider(name string, fn FuncProvider) {
+	c.funcProviders = append(c.funcProviders, funcProvider{name: name, fn: fn})
+}
+
+// NewTemplateData returns the template data for data, which may be a *TemplateData,
+// a TemplateData, a map (copied into Data) or any other value (set as Model)
+func NewTemplateData(data interface{}) *TemplateData {
+	var td *TemplateData
+
+	switch d := data.(type) {
+	case *TemplateData:
+		td = d
+		if td == nil {
+			td = &TemplateData{}
+		}
+	case TemplateData:
+		td = &d
+	case map[string]interface{}:
+		td = &TemplateData{Data: d}
+	case nil:
+		td = &TemplateData{}
+	default:
+		td = &TemplateData{Model: data}
+	}
+
+	if td.Data == nil {
+		td.Data = make(map[string]interface{})
+	}
+	return td
+}
+
+// jetVars returns a copy of the Jet variables of a jet.VarMap or a map[string]interface{},
+// which the renderer can add to without changing the caller's
+func jetVars(variables interface{}) (jet.VarMap, error) {
+	switch v := variables.(type) {
+	case nil:
+		return make(jet.VarMap), nil
+	case jet.VarMap:
+		vars := make(jet.VarMap, len(v))
+		for key, value := range v {
+			vars[key] = value
+		}
+		return vars, nil
+	case map[string]interface{}:
+		vars := make(jet.VarMap)
+		for key, value := range v {
+			vars.Set(key, value)
+		}
+		return vars, nil
+	default:
+		return nil, fmt.Errorf("invalid template variables of type %T", variables)
+	}
+}
+
+// PutForm keeps the validation errors and the submitted form values in the session,
+// so they show up in the template data of the next page (usually after a redirect
+// back to the form). Passwords and the csrf token are never kept. It parses the form
+// when the handler has not, and fails when it cannot, or when the application has no
+// sessions
+func (c *Render) PutForm(r *http.Request, errs map[string]string) error {
+	if c.Session == nil {
+		return errors.New("render: PutForm needs sessions, which are not enabled")
+	}
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+
+	old := make(url.Values)
+	for key, values := range r.Form {
+		if inSlice(hiddenInputs, strings.ToLower(key)) {
+			continue
+		}
+		old[key] = values
+	}
+
+	c.Session.Put(r.Context(), FormErrorsKey, errs)
+	c.Session.Put(r.Context(), OldInputKey, old)
+	return nil
+}
+
+// Old returns the previously submitted value of field
+func (td *TemplateData) Old(field string) string {
+	return td.OldInput.Get(field)
+}
+
+// HasError reports whether field failed validation
+func (td *TemplateData) HasError(field string) bool {
+	_, ok := td.Errors[field]
+	return ok
+}
+
+// ErrorFor returns the validation error of field
+func (td *TemplateData) ErrorFor(field string) string {
+	return td.Errors[field]
+}
+
+func inSlice(slice []string, val string) bool {
+	for _, item := range slice {
+		if item == val {
+			return true
+		}
+	}
+	return false
+}
@@ -4,11 +4,12 @@ import (
 	"errors"
 	"encoding/json"
 	"encoding/xml"
-	"fmt"
 	"io"
 	"net/http"
 	"path"
 	"path/filepath"
+
+	"github.com/djedjethai/celeritas/filesystems"
 )
 
 func (c *Celeritas) ReadJSON(w http.ResponseWriter, r *http.Request, data interface{}) error {
@@ -74,11 +75,12 @@ func (c *Celeritas) WriteXML(w http.ResponseWriter, status int, data interface{}
 	return nil
 }
 
-// DownloadFile downloads a file
+// DownloadFile downloads fileName, a file of the local folder pathToFile. Use ServeFile
+// for the files of the disks
 func (c *Celeritas) DownloadFile(w http.ResponseWriter, r *http.Request, pathToFile, fileName string) error {
 	fp := path.Join(pathToFile, fileName)
 	fileToServe := filepath.Clean(fp)
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; file=\"%s\"", fileName))
+	w.Header().Set("Content-Disposition", filesystems.AttachmentDisposition(path.Base(fileName)))
 	http.ServeFile(w, r, fileToServe)
 	return nil
 }
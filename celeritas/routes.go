@@ -15,6 +15,7 @@ func (c *Celeritas) routes() http.Handler {
 	}
 	mux.Use(middleware.Recoverer)
 	mux.Use(c.SessionLoad)
+	mux.Use(c.Locale)
 	mux.Use(c.NoSurf)
 
 	return mux
@@ -30,3 +31,13 @@ func Routes() http.Handler {
 	})
 	return r
 }
+
+// MailPreview returns the mail preview routes, listing the messages captured with
+// MAILER_API=file or log and rendering mail templates with sample data. It only
+// answers in debug mode
+func (c *Celeritas) MailPreview() http.Handler {
+	if !c.Debug {
+		return http.NotFoundHandler()
+	}
+	return c.Mail.PreviewRoutes()
+}
@@ -0,0 +1,41 @@
+package celeritas
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/djedjethai/celeritas/scanner"
+)
+
+// createScanner returns the scanner of the uploads set by CLAMD_ADDRESS, none when it
+// is empty. SCAN_FAIL_OPEN accepts the files clamd could not check, and
+// SCAN_QUARANTINE_DISK keeps the infected ones
+func (c *Celeritas) createScanner() (*scanner.Policy, error) {
+	address := os.Getenv("CLAMD_ADDRESS")
+	if address == "" {
+		return nil, nil
+	}
+
+	policy := &scanner.Policy{
+		Scanner:  scanner.NewClamd(address),
+		ErrorLog: c.ErrorLog,
+	}
+
+	if failOpen := os.Getenv("SCAN_FAIL_OPEN"); failOpen != "" {
+		var err error
+		policy.FailOpen, err = strconv.ParseBool(failOpen)
+		if err != nil {
+			return nil, fmt.Errorf("SCAN_FAIL_OPEN: %w", err)
+		}
+	}
+
+	if disk := os.Getenv("SCAN_QUARANTINE_DISK"); disk != "" {
+		fs, err := c.Disk(disk)
+		if err != nil {
+			return nil, fmt.Errorf("SCAN_QUARANTINE_DISK: %w", err)
+		}
+		policy.Quarantine = fs
+	}
+	return policy, nil
+}
@@ -0,0 +1,182 @@
+package scanner
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	defaultChunkSize = 64 << 10
+	defaultTimeout   = 2 * time.Minute
+)
+
+// Clamd scans with the ClamAV daemon, streaming the content with its INSTREAM command
+type Clamd struct {
+	// Network is tcp or unix
+	Network string
+	// Address is host:port, or the path of the socket
+	Address string
+	// Timeout bounds a scan, 2 minutes by default
+	Timeout time.Duration
+	// ChunkSize is the size of the chunks sent, 64KB by default. The content is limited
+	// by the StreamMaxLength of clamd.conf
+	ChunkSize int
+}
+
+// NewClamd returns the client of the clamd at address: unix:///run/clamav/clamd.ctl,
+// tcp://localhost:3310, or localhost:3310
+func NewClamd(address string) *Clamd {
+	switch {
+	case strings.HasPrefix(address, "unix://"):
+		return &Clamd{Network: "unix", Address: strings.TrimPrefix(address, "unix://")}
+	case strings.HasPrefix(address, "tcp://"):
+		return &Clamd{Network: "tcp", Address: strings.TrimPrefix(address, "tcp://")}
+	case strings.HasPrefix(address, "/"):
+		return &Clamd{Network: "unix", Address: address}
+	default:
+		return &Clamd{Network: "tcp", Address: address}
+	}
+}
+
+// Scan streams content to clamd, and returns its verdict
+func (c *Clamd) Scan(ctx context.Context, content io.Reader) (Result, error) {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return Result{}, err
+	}
+	defer conn.Close()
+
+	// clamd closes the connection once the content is past its limit, and tells why
+	sendErr := c.send(conn, content)
+
+	reply, err := readReply(conn)
+	if err != nil {
+		if sendErr != nil {
+			return Result{}, fmt.Errorf("clamd: %w", sendErr)
+		}
+		return Result{}, fmt.Errorf("clamd: %w", err)
+	}
+	return parseReply(reply)
+}
+
+// Ping checks that clamd answers
+func (c *Clamd) Ping(ctx context.Context) error {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("zPING\x00")); err != nil {
+		return fmt.Errorf("clamd: %w", err)
+	}
+	reply, err := readReply(conn)
+	if err != nil {
+		return fmt.Errorf("clamd: %w", err)
+	}
+	if reply != "PONG" {
+		return fmt.Errorf("clamd: unexpected reply %q", reply)
+	}
+	return nil
+}
+
+func (c *Clamd) dial(ctx context.Context) (net.Conn, error) {
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	network := c.Network
+	if network == "" {
+		network = "tcp"
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, c.Address)
+	if err != nil {
+		return nil, fmt.Errorf("clamd: %w", err)
+	}
+
+	deadline, _ := ctx.Deadline()
+	if err := conn.SetDeadline(deadline); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// send writes the INSTREAM command, content in chunks prefixed by their length, and
+// the empty chunk which ends it
+func (c *Clamd) send(conn net.Conn, content io.Reader) error {
+	size := c.ChunkSize
+	if size <= 0 {
+		size = defaultChunkSize
+	}
+
+	w := bufio.NewWriterSize(conn, size+4)
+	if _, err := w.WriteString("zINSTREAM\x00"); err != nil {
+		return err
+	}
+
+	chunk := make([]byte, size)
+	length := make([]byte, 4)
+	for {
+		n, err := io.ReadFull(content, chunk)
+		if n > 0 {
+			binary.BigEndian.PutUint32(length, uint32(n))
+			if _, err := w.Write(length); err != nil {
+				return err
+			}
+			if _, err := w.Write(chunk[:n]); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if _, err := w.Write([]byte{0, 0, 0, 0}); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// readReply reads a reply of clamd, ended by a null byte with the z commands
+func readReply(conn net.Conn) (string, error) {
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && !(err == io.EOF && reply != "") {
+		return "", err
+	}
+	return strings.TrimSpace(strings.TrimSuffix(reply, "\x00")), nil
+}
+
+// parseReply reads the verdict of a reply, like "stream: OK" or
+// "stream: Eicar-Signature FOUND"
+func parseReply(reply string) (Result, error) {
+	switch {
+	case strings.HasSuffix(reply, " FOUND"):
+		signature := strings.TrimSuffix(reply, " FOUND")
+		if i := strings.Index(signature, ": "); i >= 0 {
+			signature = signature[i+2:]
+		}
+		return Result{Infected: true, Signature: signature}, nil
+	case strings.HasSuffix(reply, ": OK"):
+		return Result{}, nil
+	case strings.HasSuffix(reply, " ERROR"):
+		return Result{}, errors.New("clamd: " + strings.TrimSuffix(reply, " ERROR"))
+	default:
+		return Result{}, fmt.Errorf("clamd: unexpected reply %q", reply)
+	}
+}
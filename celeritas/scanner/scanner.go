@@ -0,0 +1,110 @@
+// Package scanner checks the uploaded files for malware before they are stored, with
+// a pluggable Scanner such as the ClamAV daemon client
+package scanner
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"time"
+
+	"github.com/djedjethai/celeritas/filesystems"
+)
+
+// ErrInfected is returned, wrapped with the name of the threat, for an infected file
+var ErrInfected = errors.New("scanner: infected file")
+
+// Result is the verdict of a Scanner
+type Result struct {
+	Infected bool
+	// Signature names the threat found
+	Signature string
+}
+
+// Scanner scans content for malware
+type Scanner interface {
+	Scan(ctx context.Context, content io.Reader) (Result, error)
+}
+
+// Policy decides what becomes of the scanned files: the infected ones are refused, and
+// kept in Quarantine when it is set. When the scanner fails, the files are refused too,
+// unless FailOpen accepts them
+type Policy struct {
+	Scanner Scanner
+	// FailOpen accepts the files the scanner could not check, rather than refusing them
+	FailOpen bool
+	// Quarantine keeps the infected files, in QuarantineFolder, "quarantine" by default
+	Quarantine       filesystems.FS
+	QuarantineFolder string
+	// ErrorLog logs the scanner failures and the infected files, to the standard logger
+	// when nil
+	ErrorLog *log.Logger
+}
+
+// Check scans content, spooled before it is stored, named name in the messages. It
+// returns an error wrapping ErrInfected for an infected file, once it is quarantined,
+// and the error of the scanner unless FailOpen. Content is rewound before and after
+func (p *Policy) Check(ctx context.Context, name string, content io.ReadSeeker) error {
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	result, err := p.Scanner.Scan(ctx, content)
+	if _, seekErr := content.Seek(0, io.SeekStart); seekErr != nil {
+		return seekErr
+	}
+
+	if err != nil {
+		if p.FailOpen {
+			p.logf("scanner: %s accepted unscanned: %s", name, err)
+			return nil
+		}
+		return fmt.Errorf("scanner: could not scan: %w", err)
+	}
+	if !result.Infected {
+		return nil
+	}
+
+	p.logf("scanner: %s infected by %s", name, result.Signature)
+	if p.Quarantine != nil {
+		if err := p.quarantine(ctx, name, content, result); err != nil {
+			p.logf("scanner: could not quarantine %s: %s", name, err)
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrInfected, result.Signature)
+}
+
+// quarantine stores an infected file in a folder of the day, with the threat found in
+// its metadata
+func (p *Policy) quarantine(ctx context.Context, name string, content io.ReadSeeker, result Result) error {
+	folder := p.QuarantineFolder
+	if folder == "" {
+		folder = "quarantine"
+	}
+
+	random := make([]byte, 8)
+	if _, err := rand.Read(random); err != nil {
+		return err
+	}
+	key := path.Join(folder, time.Now().UTC().Format("2006-01-02"), hex.EncodeToString(random)+".bin")
+
+	return p.Quarantine.PutStream(ctx, key, content, filesystems.PutOptions{
+		ContentType: "application/octet-stream",
+		Metadata: map[string]string{
+			"filename":  name,
+			"signature": result.Signature,
+		},
+	})
+}
+
+func (p *Policy) logf(format string, args ...interface{}) {
+	if p.ErrorLog != nil {
+		p.ErrorLog.Printf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
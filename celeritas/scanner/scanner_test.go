@@ -0,0 +1,225 @@
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/djedjethai/celeritas/filesystems"
+	"github.com/djedjethai/celeritas/filesystems/memfilesystem"
+)
+
+// virus is the signature found by fakeClamd. It is not the EICAR test file, which
+// would get the sources flagged by the antivirus programs
+const virus = "CELERITAS-FAKE-VIRUS-SIGNATURE"
+
+// fakeClamd serves the PING and INSTREAM commands of clamd on l, finding the virus
+// signature, and refusing streams past maxLength as clamd does
+func fakeClamd(t *testing.T, l net.Listener, maxLength int) {
+	t.Helper()
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveClamd(conn, maxLength)
+		}
+	}()
+}
+
+func serveClamd(conn net.Conn, maxLength int) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	command, err := r.ReadString(0)
+	if err != nil {
+		return
+	}
+	switch command {
+	case "zPING\x00":
+		_, _ = conn.Write([]byte("PONG\x00"))
+		return
+	case "zINSTREAM\x00":
+	default:
+		_, _ = conn.Write([]byte("UNKNOWN COMMAND\x00"))
+		return
+	}
+
+	var content bytes.Buffer
+	length := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(r, length); err != nil {
+			return
+		}
+		n := binary.BigEndian.Uint32(length)
+		if n == 0 {
+			break
+		}
+		if content.Len()+int(n) > maxLength {
+			_, _ = conn.Write([]byte("INSTREAM size limit exceeded. ERROR\x00"))
+			return
+		}
+		if _, err := io.CopyN(&content, r, int64(n)); err != nil {
+			return
+		}
+	}
+
+	if bytes.Contains(content.Bytes(), []byte(virus)) {
+		_, _ = conn.Write([]byte("stream: Fake-Virus FOUND\x00"))
+		return
+	}
+	_, _ = conn.Write([]byte("stream: OK\x00"))
+}
+
+func listenTCP(t *testing.T, maxLength int) *Clamd {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fakeClamd(t, l, maxLength)
+	return NewClamd("tcp://" + l.Addr().String())
+}
+
+func TestClamd_Scan(t *testing.T) {
+	ctx := context.Background()
+	c := listenTCP(t, 1<<20)
+	c.ChunkSize = 16
+
+	if err := c.Ping(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := c.Scan(ctx, strings.NewReader("a clean file, sent in many chunks"))
+	if err != nil || result.Infected {
+		t.Fatalf("expected a clean file, got %+v %v", result, err)
+	}
+
+	// the signature is found across the chunks
+	result, err = c.Scan(ctx, strings.NewReader("infected: "+virus))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Infected || result.Signature != "Fake-Virus" {
+		t.Errorf("expected the virus found, got %+v", result)
+	}
+
+	if _, err := c.Scan(ctx, strings.NewReader("")); err != nil {
+		t.Errorf("expected an empty file scanned, got %v", err)
+	}
+}
+
+func TestClamd_Scan_sizeLimit(t *testing.T) {
+	c := listenTCP(t, 64)
+	c.ChunkSize = 16
+
+	_, err := c.Scan(context.Background(), bytes.NewReader(make([]byte, 1<<20)))
+	if err == nil || !strings.Contains(err.Error(), "size limit exceeded") {
+		t.Errorf("expected the size limit of clamd, got %v", err)
+	}
+}
+
+func TestClamd_Scan_unix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clamd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "clamd.ctl")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Skip("no unix sockets:", err)
+	}
+	fakeClamd(t, l, 1<<20)
+
+	c := NewClamd("unix://" + socket)
+	if c.Network != "unix" || c.Address != socket {
+		t.Fatalf("unexpected client %+v", c)
+	}
+	result, err := c.Scan(context.Background(), strings.NewReader(virus))
+	if err != nil || !result.Infected {
+		t.Errorf("expected the virus found, got %+v %v", result, err)
+	}
+}
+
+func TestNewClamd(t *testing.T) {
+	tests := []struct {
+		address, network, want string
+	}{
+		{"tcp://clamav:3310", "tcp", "clamav:3310"},
+		{"localhost:3310", "tcp", "localhost:3310"},
+		{"unix:///run/clamav/clamd.ctl", "unix", "/run/clamav/clamd.ctl"},
+		{"/run/clamav/clamd.ctl", "unix", "/run/clamav/clamd.ctl"},
+	}
+	for _, tt := range tests {
+		if c := NewClamd(tt.address); c.Network != tt.network || c.Address != tt.want {
+			t.Errorf("%s: got %s %s", tt.address, c.Network, c.Address)
+		}
+	}
+}
+
+func TestPolicy_Check(t *testing.T) {
+	ctx := context.Background()
+	quarantine := &memfilesystem.Mem{}
+	p := &Policy{Scanner: listenTCP(t, 1<<20), Quarantine: quarantine}
+
+	content := strings.NewReader("a clean file")
+	if err := p.Check(ctx, "clean.txt", content); err != nil {
+		t.Fatal(err)
+	}
+	if rest, _ := ioutil.ReadAll(content); string(rest) != "a clean file" {
+		t.Errorf("content not rewound: %q", rest)
+	}
+
+	err := p.Check(ctx, "virus.exe", strings.NewReader(virus))
+	if !errors.Is(err, ErrInfected) || !strings.Contains(err.Error(), "Fake-Virus") {
+		t.Fatalf("expected ErrInfected, got %v", err)
+	}
+
+	listing, _ := filesystems.ListAll(ctx, quarantine, "quarantine", true)
+	if len(listing) != 1 {
+		t.Fatalf("expected the file quarantined, got %v", listing)
+	}
+	info, err := quarantine.Stat(ctx, listing[0].Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Metadata["filename"] != "virus.exe" || info.Metadata["signature"] != "Fake-Virus" {
+		t.Errorf("unexpected metadata %v", info.Metadata)
+	}
+}
+
+func TestPolicy_Check_failure(t *testing.T) {
+	ctx := context.Background()
+
+	// nothing listens there any more
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := l.Addr().String()
+	l.Close()
+
+	p := &Policy{Scanner: NewClamd(address), ErrorLog: log.New(ioutil.Discard, "", 0)}
+	if err := p.Check(ctx, "file.txt", strings.NewReader("content")); err == nil || errors.Is(err, ErrInfected) {
+		t.Errorf("expected the file refused when failing closed, got %v", err)
+	}
+
+	p.FailOpen = true
+	if err := p.Check(ctx, "file.txt", strings.NewReader("content")); err != nil {
+		t.Errorf("expected the file accepted when failing open, got %v", err)
+	}
+}
@@ -0,0 +1,275 @@
+package celeritas
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/djedjethai/celeritas/filesystems"
+)
+
+// ServeOptions tune ServeFile
+type ServeOptions struct {
+	// Filename is the name the file is saved as, the base of its key when empty
+	Filename string
+	// Inline has the browser show the file, such as a video or a pdf, rather than
+	// download it. Only show the types the application trusts: the browsers run the
+	// scripts of html and svg files
+	Inline bool
+	// ContentType is the type of the file when set, else its stored type or the one of
+	// its extension
+	ContentType string
+	// CacheControl is the Cache-Control header, none when empty
+	CacheControl string
+
+	// safeInline shows inline only the types of inlineSafe, and has the browser
+	// download the others
+	safeInline bool
+}
+
+// ServeFile streams key of disk, the default disk when empty, to w. It answers the
+// Range and If-Range requests with only the bytes asked, read as a range of the disk,
+// so a video can be sought on MinIO or S3, and the conditional requests against the
+// ETag and Last-Modified of the file.
+//
+// A missing file gets a 404 and the other errors a 500; the error is returned as well,
+// along with the ones met once the response is under way
+func (c *Celeritas) ServeFile(w http.ResponseWriter, r *http.Request, disk, key string, opts ServeOptions) error {
+	fs, err := c.Disk(disk)
+	if err != nil {
+		http.NotFound(w, r)
+		return err
+	}
+	return serveFile(w, r, fs, filesystems.CleanKey(key), opts)
+}
+
+func serveFile(w http.ResponseWriter, r *http.Request, fs filesystems.FS, key string, opts ServeOptions) error {
+	stat, err := fs.Stat(r.Context(), key)
+	if err == nil && stat.IsDir {
+		err = filesystems.NewError("open", key, filesystems.ErrNotFound, nil)
+	}
+	if err != nil {
+		if errors.Is(err, filesystems.ErrNotFound) {
+			http.NotFound(w, r)
+		} else {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}
+		return err
+	}
+
+	contentType := opts.ContentType
+	if contentType == "" {
+		contentType = filesystems.ContentType(key, stat.ContentType)
+	}
+
+	filename := opts.Filename
+	if filename == "" {
+		filename = path.Base(key)
+	}
+	disposition := "attachment"
+	if opts.Inline && (!opts.safeInline || inlineSafe(contentType)) {
+		disposition = "inline"
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", contentType)
+	h.Set("Content-Disposition", filesystems.ContentDisposition(disposition, filename))
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("ETag", etag(stat))
+	if opts.CacheControl != "" {
+		h.Set("Cache-Control", opts.CacheControl)
+	}
+
+	content := &rangeReader{ctx: r.Context(), fs: fs, key: key, size: stat.Size, ends: rangeEnds(r.Header.Get("Range"), stat.Size)}
+	defer content.Close()
+	http.ServeContent(w, r, filename, stat.LastModified, content)
+	return content.err
+}
+
+// inlineSafe reports whether the browsers show contentType without running scripts:
+// the raster images, the audios, the videos, the pdfs and the plain texts
+func inlineSafe(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	switch {
+	case mediaType == "image/svg+xml":
+		return false
+	case strings.HasPrefix(mediaType, "image/"), strings.HasPrefix(mediaType, "video/"), strings.HasPrefix(mediaType, "audio/"):
+		return true
+	}
+	return mediaType == "application/pdf" || mediaType == "text/plain"
+}
+
+// etag returns the entity tag of the file, the one of the disk when it has one, or
+// else one of its size and modification time
+func etag(stat filesystems.Listing) string {
+	tag := stat.Etag
+	if tag == "" {
+		return fmt.Sprintf(`"%x-%x"`, stat.Size, stat.LastModified.UnixNano())
+	}
+	if strings.HasPrefix(tag, `"`) || strings.HasPrefix(tag, `W/"`) {
+		return tag
+	}
+	return `"` + tag + `"`
+}
+
+// rangeReader is the io.ReadSeeker of http.ServeContent over a file of a disk. A seek
+// only moves its offset, and the first read after it opens the range from there up to
+// the end of the requested range starting at the offset, or else up to the end of the
+// file, so only the bytes sent are downloaded. A file shorter than its size fails the
+// read with io.ErrUnexpectedEOF
+type rangeReader struct {
+	ctx    context.Context
+	fs     filesystems.FS
+	key    string
+	size   int64
+	offset int64
+	// ends are the ends of the requested ranges, by their start
+	ends map[int64]int64
+	rc   io.ReadCloser
+	// end is the end of the range opened
+	end int64
+	// reopened is set once the rest of the file was opened since the last seek
+	reopened bool
+	// err is the first error met
+	err error
+}
+
+func (r *rangeReader) Read(p []byte) (int, error) {
+	for {
+		if r.offset >= r.size {
+			return 0, io.EOF
+		}
+		if r.rc == nil {
+			if err := r.open(); err != nil {
+				return 0, err
+			}
+		}
+
+		n, err := r.rc.Read(p)
+		r.offset += int64(n)
+		if err != nil && err != io.EOF {
+			return n, r.fail(err)
+		}
+		if err == nil || r.offset >= r.size {
+			return n, err
+		}
+
+		// the range ended before the file: ServeContent reads on, as it does when
+		// If-Range sends the whole file, and the rest is opened once. Else the file
+		// got shorter since its Stat
+		r.Close()
+		if r.offset < r.end || r.reopened {
+			return n, r.fail(io.ErrUnexpectedEOF)
+		}
+		r.reopened = true
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+// open opens the range from the offset up to the end of the requested range starting
+// there, or else up to the end of the file
+func (r *rangeReader) open() error {
+	r.end = r.size
+	if end, ok := r.ends[r.offset]; ok && !r.reopened {
+		r.end = end
+	}
+	rc, err := r.fs.OpenRange(r.ctx, r.key, r.offset, r.end-r.offset)
+	if err != nil {
+		return r.fail(err)
+	}
+	r.rc = rc
+	return nil
+}
+
+// rangeEnds returns the end, exclusive, of each range of the Range header value by its
+// start. The invalid ones are left out, ServeContent answers them
+func rangeEnds(header string, size int64) map[int64]int64 {
+	if !strings.HasPrefix(header, "bytes=") {
+		return nil
+	}
+
+	ends := make(map[int64]int64)
+	for _, spec := range strings.Split(strings.TrimPrefix(header, "bytes="), ",") {
+		exploded := strings.SplitN(strings.TrimSpace(spec), "-", 2)
+		if len(exploded) != 2 {
+			continue
+		}
+
+		start, end := int64(0), size
+		if exploded[0] == "" {
+			// the last bytes
+			n, err := strconv.ParseInt(exploded[1], 10, 64)
+			if err != nil || n <= 0 {
+				continue
+			}
+			if n < size {
+				start = size - n
+			}
+		} else {
+			var err error
+			if start, err = strconv.ParseInt(exploded[0], 10, 64); err != nil {
+				continue
+			}
+			if exploded[1] != "" {
+				last, err := strconv.ParseInt(exploded[1], 10, 64)
+				if err != nil || last < start {
+					continue
+				}
+				if last+1 < size {
+					end = last + 1
+				}
+			}
+		}
+
+		if end > ends[start] {
+			ends[start] = end
+		}
+	}
+	return ends
+}
+
+func (r *rangeReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	}
+	if offset < 0 {
+		return 0, errors.New("seek before the start of the file")
+	}
+
+	if offset != r.offset {
+		r.Close()
+		r.offset = offset
+		r.reopened = false
+	}
+	return offset, nil
+}
+
+func (r *rangeReader) Close() error {
+	if r.rc == nil {
+		return nil
+	}
+	err := r.rc.Close()
+	r.rc = nil
+	return err
+}
+
+func (r *rangeReader) fail(err error) error {
+	if r.err == nil {
+		r.err = err
+	}
+	return err
+}
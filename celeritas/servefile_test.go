@@ -0,0 +1,153 @@
+package celeritas
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/djedjethai/celeritas/filesystems"
+	"github.com/djedjethai/celeritas/filesystems/memfilesystem"
+)
+
+// rangeCounter records the ranges opened on its disk
+type rangeCounter struct {
+	*memfilesystem.Mem
+	opened []string
+}
+
+func (r *rangeCounter) OpenRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	r.opened = append(r.opened, fmt.Sprintf("%d+%d", offset, length))
+	return r.Mem.OpenRange(ctx, key, offset, length)
+}
+
+// shrunkFile reports its files longer than they are, as when one is replaced by a
+// smaller one between its Stat and its read
+type shrunkFile struct {
+	*memfilesystem.Mem
+}
+
+func (s shrunkFile) Stat(ctx context.Context, key string) (filesystems.Listing, error) {
+	stat, err := s.Mem.Stat(ctx, key)
+	stat.Size += 10
+	return stat, err
+}
+
+func TestCeleritas_ServeFile(t *testing.T) {
+	c, _ := newSignedFilesApp(t)
+	disk := &rangeCounter{Mem: &memfilesystem.Mem{}}
+	c.FileSystems.Add("videos", disk)
+
+	content := []byte("0123456789abcdefghij")
+	err := disk.PutStream(context.Background(), "clips/été.mp4", bytes.NewReader(content), filesystems.PutOptions{ContentType: "video/mp4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serve := func(method string, header http.Header, opts ServeOptions) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/watch", nil)
+		for k := range header {
+			r.Header.Set(k, header.Get(k))
+		}
+		w := httptest.NewRecorder()
+		_ = c.ServeFile(w, r, "videos", "clips/été.mp4", opts)
+		return w
+	}
+
+	w := serve(http.MethodGet, nil, ServeOptions{Inline: true})
+	if w.Code != http.StatusOK || w.Body.String() != string(content) {
+		t.Fatalf("file not served: %d %s", w.Code, w.Body.String())
+	}
+	h := w.Header()
+	if h.Get("Content-Type") != "video/mp4" || h.Get("Accept-Ranges") != "bytes" || h.Get("Last-Modified") == "" {
+		t.Errorf("unexpected headers %v", h)
+	}
+	if disposition := h.Get("Content-Disposition"); disposition != `inline; filename=_t_.mp4; filename*=UTF-8''%C3%A9t%C3%A9.mp4` {
+		t.Errorf("unexpected disposition %s", disposition)
+	}
+	etag := h.Get("ETag")
+
+	// only the range is read from the disk
+	disk.opened = nil
+	w = serve(http.MethodGet, http.Header{"Range": {"bytes=10-13"}}, ServeOptions{})
+	if w.Code != http.StatusPartialContent || w.Body.String() != "abcd" || w.Header().Get("Content-Range") != "bytes 10-13/20" {
+		t.Errorf("range not served: %d %s %v", w.Code, w.Body.String(), w.Header())
+	}
+	if strings.Join(disk.opened, ",") != "10+4" {
+		t.Errorf("unexpected reads %v", disk.opened)
+	}
+	if !strings.HasPrefix(w.Header().Get("Content-Disposition"), "attachment;") {
+		t.Errorf("expected an attachment, got %s", w.Header().Get("Content-Disposition"))
+	}
+
+	if w := serve(http.MethodGet, http.Header{"Range": {"bytes=-3"}}, ServeOptions{}); w.Body.String() != "hij" {
+		t.Errorf("suffix range not served: %d %s", w.Code, w.Body.String())
+	}
+	if w := serve(http.MethodGet, http.Header{"Range": {"bytes=30-"}}, ServeOptions{}); w.Code != http.StatusRequestedRangeNotSatisfiable {
+		t.Errorf("expected 416, got %d", w.Code)
+	}
+
+	// If-Range serves the range of the same version only
+	w = serve(http.MethodGet, http.Header{"Range": {"bytes=0-1"}, "If-Range": {etag}}, ServeOptions{})
+	if w.Code != http.StatusPartialContent {
+		t.Errorf("expected the range of the same version, got %d", w.Code)
+	}
+	w = serve(http.MethodGet, http.Header{"Range": {"bytes=0-1"}, "If-Range": {`"old"`}}, ServeOptions{})
+	if w.Code != http.StatusOK || w.Body.String() != string(content) {
+		t.Errorf("expected the whole new version, got %d %s", w.Code, w.Body.String())
+	}
+
+	// each part of a multipart range is read on its own
+	disk.opened = nil
+	w = serve(http.MethodGet, http.Header{"Range": {"bytes=0-1,15-"}}, ServeOptions{})
+	if w.Code != http.StatusPartialContent || strings.Join(disk.opened, ",") != "0+2,15+5" {
+		t.Errorf("unexpected multipart reads %d %v", w.Code, disk.opened)
+	}
+
+	if w := serve(http.MethodGet, http.Header{"If-None-Match": {etag}}, ServeOptions{}); w.Code != http.StatusNotModified {
+		t.Errorf("expected not modified, got %d", w.Code)
+	}
+	if w := serve(http.MethodGet, http.Header{"If-Match": {`"old"`}}, ServeOptions{}); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("expected precondition failed, got %d", w.Code)
+	}
+
+	disk.opened = nil
+	if w := serve(http.MethodHead, nil, ServeOptions{}); w.Code != http.StatusOK || w.Body.Len() != 0 || len(disk.opened) != 0 {
+		t.Errorf("HEAD read the file: %d, %v", w.Code, disk.opened)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/watch", nil)
+	w = httptest.NewRecorder()
+	if err := c.ServeFile(w, r, "videos", "clips/missing.mp4", ServeOptions{}); err == nil || w.Code != http.StatusNotFound {
+		t.Errorf("expected not found, got %d %v", w.Code, err)
+	}
+}
+
+func TestCeleritas_ServeFile_shrunk(t *testing.T) {
+	c, _ := newSignedFilesApp(t)
+	disk := shrunkFile{Mem: &memfilesystem.Mem{}}
+	c.FileSystems.Add("shrunk", disk)
+	if err := disk.PutStream(context.Background(), "report.txt", strings.NewReader("0123456789"), filesystems.PutOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, header := range []http.Header{
+		nil,
+		{"Range": {"bytes=5-"}},
+		{"Range": {"bytes=0-1"}, "If-Range": {`"old"`}},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/report", nil)
+		for k := range header {
+			r.Header.Set(k, header.Get(k))
+		}
+		w := httptest.NewRecorder()
+		if err := c.ServeFile(w, r, "shrunk", "report.txt", ServeOptions{}); !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("%v: expected io.ErrUnexpectedEOF, got %v", header, err)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package celeritas
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/djedjethai/celeritas/filesystems"
+	"github.com/djedjethai/celeritas/scanner"
+	"github.com/djedjethai/celeritas/urlsigner"
+)
+
+// SignedFilesPath is where applications mount SignedFiles, which serves the temporary
+// urls of the disks unable to presign them
+const SignedFilesPath = "/files/signed"
+
+// TemporaryURL returns a url to download, or upload with opts.Method PUT, key of disk
+// directly from the browser, valid for expiry. MinIO and S3 presign it; the other
+// disks get a signed url of SignedFiles
+func (c *Celeritas) TemporaryURL(disk, key string, expiry time.Duration, opts filesystems.URLOptions) (string, error) {
+	fs, err := c.Disk(disk)
+	if err != nil {
+		return "", err
+	}
+
+	link, err := fs.TemporaryURL(key, expiry, opts)
+	if !errors.Is(err, filesystems.ErrTemporaryURLNotSupported) {
+		return link, err
+	}
+
+	method := opts.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	if method != http.MethodGet && method != http.MethodPut {
+		return "", fmt.Errorf("unsupported method %s for a temporary url", method)
+	}
+	if disk == "" {
+		disk = c.FileSystems.Default()
+	}
+
+	params := url.Values{}
+	params.Set("disk", disk)
+	params.Set("key", key)
+	params.Set("method", method)
+	params.Set("expires", strconv.FormatInt(time.Now().Add(expiry).Unix(), 10))
+	if opts.ContentType != "" {
+		params.Set("type", opts.ContentType)
+	}
+	if opts.Filename != "" {
+		params.Set("filename", opts.Filename)
+	}
+
+	link = strings.TrimSuffix(c.Server.URL, "/") + SignedFilesPath + "?" + params.Encode()
+	return c.fileSigner().GenerateTokenFromString(link), nil
+}
+
+// SignedFiles serves the urls of TemporaryURL, downloading with GET and uploading
+// with PUT, once their signature and expiry are checked. Mount it at SignedFilesPath
+func (c *Celeritas) SignedFiles() http.Handler {
+	return http.HandlerFunc(c.serveSignedFile)
+}
+
+func (c *Celeritas) serveSignedFile(w http.ResponseWriter, r *http.Request) {
+	link := strings.TrimSuffix(c.Server.URL, "/") + r.URL.RequestURI()
+	if !c.fileSigner().VerifyToken(link) {
+		http.Error(w, "invalid signature", http.StatusForbidden)
+		return
+	}
+
+	q := r.URL.Query()
+	expires, err := strconv.ParseInt(q.Get("expires"), 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		http.Error(w, "link expired", http.StatusForbidden)
+		return
+	}
+
+	method := r.Method
+	if method == http.MethodHead {
+		method = http.MethodGet
+	}
+	if method != q.Get("method") {
+		w.Header().Set("Allow", q.Get("method"))
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	fs, err := c.Disk(q.Get("disk"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if method == http.MethodPut {
+		c.receiveSignedFile(w, r, fs, q)
+		return
+	}
+
+	// as the presigned urls, the file is shown unless a filename is asked, but from
+	// the origin of the application, so only when its type runs no scripts
+	err = serveFile(w, r, fs, filesystems.CleanKey(q.Get("key")), ServeOptions{
+		Filename:    q.Get("filename"),
+		Inline:      q.Get("filename") == "",
+		ContentType: q.Get("type"),
+		safeInline:  true,
+	})
+	if err != nil && !errors.Is(err, filesystems.ErrNotFound) {
+		c.ErrorLog.Println(err)
+	}
+}
+
+// receiveSignedFile writes the body of a signed PUT to the disk, up to MAX_UPLOAD_SIZE,
+// once the Scanner, if any, has checked it
+func (c *Celeritas) receiveSignedFile(w http.ResponseWriter, r *http.Request, fs filesystems.FS, q url.Values) {
+	contentType := r.Header.Get("Content-Type")
+	if q.Get("type") != "" {
+		mediaType, _, _ := mime.ParseMediaType(contentType)
+		if mediaType != q.Get("type") {
+			http.Error(w, "content type not allowed", http.StatusUnsupportedMediaType)
+			return
+		}
+	}
+
+	key := filesystems.CleanKey(q.Get("key"))
+	counter := &uploadReader{r: r.Body, hash: sha256.New(), max: c.config.uploads.maxUploadSize}
+	body, done, err := c.scanUpload(r.Context(), path.Base(key), counter)
+	defer done()
+	switch {
+	case counter.tooLarge:
+		http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+		return
+	case errors.Is(err, scanner.ErrInfected):
+		http.Error(w, "the file is infected", http.StatusUnprocessableEntity)
+		return
+	case err != nil:
+		c.ErrorLog.Println(err)
+		http.Error(w, "the file could not be scanned", http.StatusServiceUnavailable)
+		return
+	}
+
+	err = fs.PutStream(r.Context(), key, body, filesystems.PutOptions{
+		ContentType: contentType,
+		Size:        r.ContentLength,
+	})
+	if counter.tooLarge {
+		_ = fs.Delete([]string{key})
+		http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		c.ErrorLog.Println(err)
+		http.Error(w, "could not store the file", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (c *Celeritas) fileSigner() *urlsigner.Signer {
+	return &urlsigner.Signer{Secret: []byte(c.EncryptionKey)}
+}
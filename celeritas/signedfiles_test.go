@@ -0,0 +1,165 @@
+package celeritas
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/djedjethai/celeritas/filesystems"
+	"github.com/djedjethai/celeritas/filesystems/memfilesystem"
+	"github.com/djedjethai/celeritas/scanner"
+)
+
+func newSignedFilesApp(t *testing.T) (*Celeritas, *memfilesystem.Mem) {
+	disk := &memfilesystem.Mem{}
+	c := &Celeritas{
+		Server:        Server{URL: "http://localhost:4000"},
+		EncryptionKey: "abcdefghijklmnopqrstuvwxyz123456",
+		FileSystems:   filesystems.NewRegistry(),
+		ErrorLog:      log.New(ioutil.Discard, "", 0),
+	}
+	c.config.uploads.maxUploadSize = 1 << 20
+	c.FileSystems.Add("local", disk)
+	return c, disk
+}
+
+func signedRequest(t *testing.T, c *Celeritas, method, link string, body []byte) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(method, strings.TrimPrefix(link, c.Server.URL), bytes.NewReader(body))
+	w := httptest.NewRecorder()
+	c.SignedFiles().ServeHTTP(w, r)
+	return w
+}
+
+func TestCeleritas_TemporaryURL(t *testing.T) {
+	c, disk := newSignedFilesApp(t)
+	content := []byte("private report")
+	_ = disk.PutStream(context.Background(), "reports/q1.pdf", bytes.NewReader(content), filesystems.PutOptions{})
+
+	link, err := c.TemporaryURL("", "reports/q1.pdf", time.Minute, filesystems.URLOptions{Filename: "report.pdf"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(link, "http://localhost:4000"+SignedFilesPath+"?") {
+		t.Fatalf("not a signed files url: %s", link)
+	}
+
+	w := signedRequest(t, c, http.MethodGet, link, nil)
+	if w.Code != http.StatusOK || w.Body.String() != string(content) {
+		t.Fatalf("download failed: %d %s", w.Code, w.Body.String())
+	}
+	if w.Header().Get("Content-Type") != "application/pdf" || !strings.Contains(w.Header().Get("Content-Disposition"), `filename=report.pdf`) {
+		t.Errorf("wrong headers: %v", w.Header())
+	}
+
+	// the signature covers every parameter
+	tampered := strings.Replace(link, "q1.pdf", "q2.pdf", 1)
+	if w := signedRequest(t, c, http.MethodGet, tampered, nil); w.Code != http.StatusForbidden {
+		t.Errorf("tampered url: expected 403, got %d", w.Code)
+	}
+
+	// a download url does not upload
+	if w := signedRequest(t, c, http.MethodPut, link, []byte("x")); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("wrong method: expected 405, got %d", w.Code)
+	}
+
+	expired, _ := c.TemporaryURL("local", "reports/q1.pdf", -time.Minute, filesystems.URLOptions{})
+	if w := signedRequest(t, c, http.MethodGet, expired, nil); w.Code != http.StatusForbidden {
+		t.Errorf("expired url: expected 403, got %d", w.Code)
+	}
+
+	// the types running scripts are downloaded rather than shown
+	_ = disk.PutStream(context.Background(), "reports/q1.html", strings.NewReader("<script></script>"), filesystems.PutOptions{ContentType: "text/html"})
+	for key, disposition := range map[string]string{"reports/q1.pdf": "inline;", "reports/q1.html": "attachment;"} {
+		link, _ := c.TemporaryURL("local", key, time.Minute, filesystems.URLOptions{})
+		if w := signedRequest(t, c, http.MethodGet, link, nil); !strings.HasPrefix(w.Header().Get("Content-Disposition"), disposition) {
+			t.Errorf("%s: expected %s, got %s", key, disposition, w.Header().Get("Content-Disposition"))
+		}
+	}
+
+	if _, err := c.TemporaryURL("missing", "reports/q1.pdf", time.Minute, filesystems.URLOptions{}); err == nil {
+		t.Error("expected an error for an unknown disk")
+	}
+}
+
+func TestCeleritas_TemporaryURLUpload(t *testing.T) {
+	c, disk := newSignedFilesApp(t)
+
+	link, err := c.TemporaryURL("local", "avatars/jane.png", time.Minute, filesystems.URLOptions{
+		Method:      http.MethodPut,
+		ContentType: "image/png",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest(http.MethodPut, strings.TrimPrefix(link, c.Server.URL), strings.NewReader("not a png"))
+	r.Header.Set("Content-Type", "text/plain")
+	w := httptest.NewRecorder()
+	c.SignedFiles().ServeHTTP(w, r)
+	if w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("wrong content type: expected 415, got %d", w.Code)
+	}
+
+	r = httptest.NewRequest(http.MethodPut, strings.TrimPrefix(link, c.Server.URL), strings.NewReader("png data"))
+	r.Header.Set("Content-Type", "image/png")
+	w = httptest.NewRecorder()
+	c.SignedFiles().ServeHTTP(w, r)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("upload failed: %d %s", w.Code, w.Body.String())
+	}
+
+	f, err := disk.Open(context.Background(), "avatars/jane.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := ioutil.ReadAll(f)
+	if string(got) != "png data" {
+		t.Errorf("wrong uploaded content: %q", got)
+	}
+
+	// the key is cleaned as for the downloads
+	link, _ = c.TemporaryURL("local", "/avatars/../avatars/joe.png", time.Minute, filesystems.URLOptions{Method: http.MethodPut})
+	if w := signedRequest(t, c, http.MethodPut, link, []byte("joe")); w.Code != http.StatusCreated {
+		t.Fatalf("upload failed: %d %s", w.Code, w.Body.String())
+	}
+	if _, err := disk.Stat(context.Background(), "avatars/joe.png"); err != nil {
+		t.Errorf("upload not stored under its clean key: %v", err)
+	}
+}
+
+func TestCeleritas_TemporaryURLUpload_scanned(t *testing.T) {
+	c, disk := newSignedFilesApp(t)
+	c.RootPath = t.TempDir()
+	c.Scanner = &scanner.Policy{Scanner: markerScanner("VIRUS"), Quarantine: &memfilesystem.Mem{}, ErrorLog: c.ErrorLog}
+
+	put := func(key, content string) int {
+		link, err := c.TemporaryURL("local", key, time.Minute, filesystems.URLOptions{Method: http.MethodPut})
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := httptest.NewRequest(http.MethodPut, strings.TrimPrefix(link, c.Server.URL), strings.NewReader(content))
+		w := httptest.NewRecorder()
+		c.SignedFiles().ServeHTTP(w, r)
+		return w.Code
+	}
+
+	if code := put("notes/clean.txt", "clean notes"); code != http.StatusCreated {
+		t.Errorf("clean upload: expected 201, got %d", code)
+	}
+	if code := put("notes/virus.txt", "notes with a VIRUS"); code != http.StatusUnprocessableEntity {
+		t.Errorf("infected upload: expected 422, got %d", code)
+	}
+	if exists, _ := disk.Exists(context.Background(), "notes/virus.txt"); exists {
+		t.Error("the infected file was stored")
+	}
+	if code := put("notes/large.txt", strings.Repeat("x", 1<<20+1)); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("large upload: expected 413, got %d", code)
+	}
+}
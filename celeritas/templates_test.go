@@ -0,0 +1,36 @@
+package celeritas
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/CloudyKit/jet/v6"
+	"github.com/djedjethai/celeritas/assets"
+)
+
+func TestCeleritas_TemplateGlobals(t *testing.T) {
+	c, _ := newSignedFilesApp(t)
+	c.Assets = assets.New(t.TempDir(), "/public")
+	c.JetViews = jet.NewSet(jet.NewInMemLoader())
+	c.Mail.JetViews = jet.NewSet(jet.NewInMemLoader())
+	c.createRenderer()
+
+	if _, ok := c.JetViews.LookupGlobal("asset"); !ok {
+		t.Error("views: missing asset")
+	}
+	if _, ok := c.Mail.JetViews.LookupGlobal("asset"); !ok {
+		t.Error("mail: missing asset")
+	}
+
+	tmpl, err := c.Mail.JetViews.Parse("mail.jet", `{{ asset("css/app.css") }}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if b.String() != "http://localhost:4000/public/css/app.css" {
+		t.Errorf("expected an absolute asset url in mail, got %s", b.String())
+	}
+}
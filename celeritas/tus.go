@@ -0,0 +1,82 @@
+package celeritas
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/djedjethai/celeritas/tus"
+)
+
+// Tus returns a handler of the tus resumable upload protocol for the uploads below
+// basePath, which stores the finished files on disk, the default disk when empty. Its
+// chunks are kept in tmp/tus, and its state as TUS_STORE says: in the database, the
+// cache, or in memory by default. The finished files must be of the ALLOWED_FILETYPES,
+// detected from their content, the Scanner checks them, and the expired uploads are
+// cleaned up hourly. Mount it with
+//
+//	uploads, err := c.Tus("/uploads/", "S3")
+//	uploads.PostFinish = func(r *http.Request, info tus.Info) error { ... }
+//	c.Routes.Handle("/uploads/*", uploads)
+//
+// The CSRF middleware checks its POST, PATCH and DELETE requests, so clients send the
+// token in the X-CSRF-Token header
+func (c *Celeritas) Tus(basePath, disk string) (*tus.Handler, error) {
+	fs, err := c.Disk(disk)
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := c.createTusStore()
+	if err != nil {
+		return nil, err
+	}
+
+	h := &tus.Handler{
+		BasePath:     basePath,
+		Store:        store,
+		Dir:          filepath.Join(c.RootPath, "tmp", "tus"),
+		FS:           fs,
+		AllowedTypes: c.config.uploads.allowedMimeTypes,
+		Scan:         c.Scanner,
+		ErrorLog:     c.ErrorLog,
+	}
+
+	if c.Scheduler != nil {
+		_, err = c.Scheduler.AddFunc("@hourly", func() {
+			if err := h.Cleanup(); err != nil {
+				c.ErrorLog.Println("could not clean up tus uploads:", err)
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+		c.Scheduler.Start()
+	}
+	return h, nil
+}
+
+// createTusStore returns the store of the tus uploads set by TUS_STORE, which can be
+// database, cache or memory
+func (c *Celeritas) createTusStore() (tus.Store, error) {
+	switch os.Getenv("TUS_STORE") {
+	case "", "memory":
+		return &tus.MemoryStore{}, nil
+
+	case "database":
+		if c.DB.Pool == nil {
+			return nil, errors.New("TUS_STORE=database requires a DATABASE_TYPE")
+		}
+		return &tus.SQLStore{DB: c.DB.Pool, DBType: c.DB.DataType}, nil
+
+	case "cache":
+		if c.Cache == nil {
+			return nil, errors.New("TUS_STORE=cache requires a CACHE")
+		}
+		return &tus.CacheStore{Cache: c.Cache}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown TUS_STORE %s; only database, cache or memory accepted", os.Getenv("TUS_STORE"))
+	}
+}
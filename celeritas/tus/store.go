@@ -0,0 +1,165 @@
+package tus
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/djedjethai/celeritas/cache"
+)
+
+// ErrNotFound is returned by a Store for an unknown upload
+var ErrNotFound = errors.New("tus: upload not found")
+
+// Info is the state of an upload
+type Info struct {
+	ID string `json:"id"`
+	// Size is the length of the upload, unknown until it is set when SizeIsDeferred
+	Size           int64 `json:"size"`
+	SizeIsDeferred bool  `json:"size_is_deferred"`
+	// Offset is the number of bytes received
+	Offset int64 `json:"offset"`
+	// Metadata is the Upload-Metadata sent by the client, like its filename
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// Key is the key of the file on the file system once finished
+	Key       string    `json:"key"`
+	Finished  bool      `json:"finished"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Expired reports whether the upload expired at now
+func (i Info) Expired(now time.Time) bool {
+	return !i.ExpiresAt.IsZero() && now.After(i.ExpiresAt)
+}
+
+// Store keeps the state of the uploads, so they resume after a restart of the
+// application. The requests of an upload are serialised within one instance only, so
+// an upload must not be sent to several instances at once
+type Store interface {
+	// Get returns the upload id, or ErrNotFound
+	Get(id string) (Info, error)
+	// Save creates or replaces an upload
+	Save(info Info) error
+	// Delete removes an upload
+	Delete(id string) error
+	// DeleteExpired removes the uploads expired at now, finished or not
+	DeleteExpired(now time.Time) error
+}
+
+// MemoryStore keeps the uploads in memory, for a single instance which can lose them
+// on restart, and for tests
+type MemoryStore struct {
+	mu      sync.Mutex
+	uploads map[string]Info
+}
+
+// Get returns the upload id, or ErrNotFound
+func (m *MemoryStore) Get(id string) (Info, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	info, ok := m.uploads[id]
+	if !ok {
+		return info, ErrNotFound
+	}
+	return info, nil
+}
+
+// Save creates or replaces an upload
+func (m *MemoryStore) Save(info Info) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.uploads == nil {
+		m.uploads = make(map[string]Info)
+	}
+	m.uploads[info.ID] = info
+	return nil
+}
+
+// Delete removes an upload
+func (m *MemoryStore) Delete(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.uploads, id)
+	return nil
+}
+
+// DeleteExpired removes the uploads expired at now, finished or not
+func (m *MemoryStore) DeleteExpired(now time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, info := range m.uploads {
+		if info.Expired(now) {
+			delete(m.uploads, id)
+		}
+	}
+	return nil
+}
+
+// CacheStore keeps the uploads in the application cache, redis or badger, until they
+// expire
+type CacheStore struct {
+	Cache cache.Cache
+}
+
+func (c *CacheStore) key(id string) string {
+	return "tus:" + id
+}
+
+// Get returns the upload id, or ErrNotFound
+func (c *CacheStore) Get(id string) (Info, error) {
+	var info Info
+
+	// the caches do not share an error for a missing key
+	found, err := c.Cache.Has(c.key(id))
+	if err != nil {
+		return info, err
+	}
+	if !found {
+		return info, ErrNotFound
+	}
+
+	value, err := c.Cache.Get(c.key(id))
+	if err != nil {
+		return info, err
+	}
+	data, ok := value.(string)
+	if !ok {
+		return info, fmt.Errorf("tus: unexpected cache entry for %s", id)
+	}
+	err = json.Unmarshal([]byte(data), &info)
+	return info, err
+}
+
+// Save creates or replaces an upload, kept in the cache until it expires
+func (c *CacheStore) Save(info Info) error {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+
+	if info.ExpiresAt.IsZero() {
+		return c.Cache.Set(c.key(info.ID), string(data))
+	}
+	ttl := int(time.Until(info.ExpiresAt).Seconds()) + 1
+	if ttl < 1 {
+		ttl = 1
+	}
+	return c.Cache.Set(c.key(info.ID), string(data), ttl)
+}
+
+// Delete removes an upload
+func (c *CacheStore) Delete(id string) error {
+	return c.Cache.Forget(c.key(id))
+}
+
+// DeleteExpired does nothing: the cache drops the uploads once they expire
+func (c *CacheStore) DeleteExpired(now time.Time) error {
+	return nil
+}
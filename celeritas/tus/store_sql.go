@@ -0,0 +1,90 @@
+package tus
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SQLStore keeps the uploads in the tus_uploads table, created by
+// "celeritas make tus-table". DBType is the DATABASE_TYPE of the connection
+type SQLStore struct {
+	DB     *sql.DB
+	DBType string
+}
+
+// Get returns the upload id, or ErrNotFound
+func (s *SQLStore) Get(id string) (Info, error) {
+	var info Info
+	var payload string
+
+	err := s.DB.QueryRow(s.rebind("select payload from tus_uploads where id = ?"), id).Scan(&payload)
+	if errors.Is(err, sql.ErrNoRows) {
+		return info, ErrNotFound
+	}
+	if err != nil {
+		return info, err
+	}
+	err = json.Unmarshal([]byte(payload), &info)
+	return info, err
+}
+
+// Save creates or replaces an upload
+func (s *SQLStore) Save(info Info) error {
+	payload, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(s.rebind("delete from tus_uploads where id = ?"), info.ID)
+	if err == nil {
+		_, err = tx.Exec(s.rebind("insert into tus_uploads (id, payload, expires_at) values (?, ?, ?)"),
+			info.ID, string(payload), info.ExpiresAt.UTC())
+	}
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Delete removes an upload
+func (s *SQLStore) Delete(id string) error {
+	_, err := s.DB.Exec(s.rebind("delete from tus_uploads where id = ?"), id)
+	return err
+}
+
+// DeleteExpired removes the uploads expired at now, finished or not
+func (s *SQLStore) DeleteExpired(now time.Time) error {
+	_, err := s.DB.Exec(s.rebind("delete from tus_uploads where expires_at < ?"), now.UTC())
+	return err
+}
+
+// rebind turns the ? placeholders into $n ones for postgres
+func (s *SQLStore) rebind(query string) string {
+	switch s.DBType {
+	case "postgres", "postgresql", "pgx":
+	default:
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			fmt.Fprintf(&b, "$%d", n)
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,674 @@
+// Package tus is a server of the tus 1.0 resumable upload protocol (https://tus.io),
+// with the creation, creation-with-upload, creation-defer-length, termination,
+// expiration and checksum extensions. The chunks are appended to a file of a local
+// folder, and the finished uploads are handed to a filesystems.FS
+package tus
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/djedjethai/celeritas/filesystems"
+	"github.com/djedjethai/celeritas/scanner"
+	"github.com/gabriel-vasile/mimetype"
+)
+
+const (
+	// Version is the version of the protocol served
+	Version = "1.0.0"
+
+	extensions         = "creation,creation-with-upload,creation-defer-length,termination,expiration,checksum"
+	checksumAlgorithms = "sha1,md5,sha256"
+	offsetContentType  = "application/offset+octet-stream"
+
+	defaultExpiration = 24 * time.Hour
+	// statusChecksumMismatch is the status of a chunk which does not match its checksum
+	statusChecksumMismatch = 460
+	// partSuffix is the extension of the files of the chunks in Dir
+	partSuffix = ".part"
+	// sniffSize is the part of a finished file read to detect its type
+	sniffSize = 3072
+)
+
+// Rejection is the error a hook returns to reject a request with a status of its own,
+// other than 400 for PreCreate and 500 for PostFinish
+type Rejection struct {
+	Status  int
+	Message string
+}
+
+func (r *Rejection) Error() string {
+	return r.Message
+}
+
+// Handler serves the tus protocol below BasePath, like
+//
+//	h := &tus.Handler{BasePath: "/files/", Dir: "./tmp/tus", Store: &tus.MemoryStore{}, FS: disk}
+//	c.Routes.Handle("/files/*", h)
+type Handler struct {
+	// BasePath is the path of the uploads, ending with a slash; an upload is BasePath
+	// followed by its id
+	BasePath string
+	// Store keeps the state of the uploads
+	Store Store
+	// Dir is the local folder of the chunks
+	Dir string
+	// FS receives the files once finished, in Folder
+	FS     filesystems.FS
+	Folder string
+	// MaxSize is the size limit of an upload, none when 0
+	MaxSize int64
+	// AllowedTypes are the mime types accepted, as detected from the content of the
+	// finished files; a type like image/* accepts all of its subtypes. Any type is
+	// accepted when empty. A file of another type terminates its upload with a 415
+	AllowedTypes []string
+	// Expiration is how long an unfinished upload is kept after its last chunk, 24h
+	// when 0
+	Expiration time.Duration
+	// PreCreate validates an upload before it is created, from its size and metadata.
+	// An error rejects it with a 400, or the status of a *Rejection
+	PreCreate func(r *http.Request, info Info) error
+	// PostFinish processes a file once it is stored on FS. An error fails the last
+	// request with a 500, or the status of a *Rejection, and the client finishes the
+	// upload again by resuming it
+	PostFinish func(r *http.Request, info Info) error
+	// Scan checks the finished files before they are stored on FS, none when nil. An
+	// infected upload is terminated with a 422, and an upload which could not be
+	// scanned fails with a 503, so the client finishes it again later
+	Scan *scanner.Policy
+	// ErrorLog logs the internal errors, to the standard logger when nil
+	ErrorLog *log.Logger
+
+	// locks serialise the requests on one upload, by the hash of its id
+	locks [64]sync.Mutex
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", Version)
+
+	method := r.Method
+	if override := r.Header.Get("X-HTTP-Method-Override"); override != "" {
+		method = strings.ToUpper(override)
+	}
+
+	if method == http.MethodOptions {
+		h.options(w)
+		return
+	}
+	if r.Header.Get("Tus-Resumable") != Version {
+		w.Header().Set("Tus-Version", Version)
+		http.Error(w, "unsupported tus version", http.StatusPreconditionFailed)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, strings.TrimSuffix(h.BasePath, "/"))
+	id = strings.Trim(id, "/")
+	if id == "" {
+		if method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		h.create(w, r)
+		return
+	}
+	if strings.Contains(id, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	lock := h.lock(id)
+	lock.Lock()
+	defer lock.Unlock()
+
+	switch method {
+	case http.MethodHead:
+		h.head(w, r, id)
+	case http.MethodPatch:
+		h.patch(w, r, id)
+	case http.MethodDelete:
+		h.terminate(w, r, id)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *Handler) options(w http.ResponseWriter) {
+	w.Header().Set("Tus-Version", Version)
+	w.Header().Set("Tus-Extension", extensions)
+	w.Header().Set("Tus-Checksum-Algorithm", checksumAlgorithms)
+	if h.MaxSize > 0 {
+		w.Header().Set("Tus-Max-Size", strconv.FormatInt(h.MaxSize, 10))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// create starts an upload, with its first chunk when there is a body
+func (h *Handler) create(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	info := Info{
+		CreatedAt: now,
+		ExpiresAt: now.Add(h.expiration()),
+	}
+
+	if r.Header.Get("Upload-Defer-Length") == "1" {
+		info.SizeIsDeferred = true
+	} else {
+		size, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+		if err != nil || size < 0 {
+			http.Error(w, "invalid Upload-Length", http.StatusBadRequest)
+			return
+		}
+		info.Size = size
+	}
+	if h.MaxSize > 0 && info.Size > h.MaxSize {
+		http.Error(w, "upload too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	var err error
+	if info.Metadata, err = parseMetadata(r.Header.Get("Upload-Metadata")); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if h.PreCreate != nil {
+		if err := h.PreCreate(r, info); err != nil {
+			h.reject(w, err, http.StatusBadRequest)
+			return
+		}
+	}
+
+	if info.ID, err = newID(); err != nil {
+		h.serverError(w, err)
+		return
+	}
+
+	if err := os.MkdirAll(h.Dir, 0755); err != nil {
+		h.serverError(w, err)
+		return
+	}
+	if err := ioutil.WriteFile(h.part(info.ID), nil, 0644); err != nil {
+		h.serverError(w, err)
+		return
+	}
+	if err := h.Store.Save(info); err != nil {
+		h.serverError(w, err)
+		return
+	}
+
+	w.Header().Set("Location", strings.TrimSuffix(h.BasePath, "/")+"/"+info.ID)
+	w.Header().Set("Upload-Expires", info.ExpiresAt.UTC().Format(http.TimeFormat))
+
+	lock := h.lock(info.ID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	// an empty upload is complete already
+	if r.Header.Get("Content-Type") == offsetContentType || !info.SizeIsDeferred && info.Size == 0 {
+		status, err := h.write(r, &info)
+		if err != nil {
+			h.reject(w, err, status)
+			return
+		}
+		w.Header().Set("Upload-Offset", strconv.FormatInt(info.Offset, 10))
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+// head returns the offset of an upload, to resume it
+func (h *Handler) head(w http.ResponseWriter, r *http.Request, id string) {
+	info, ok := h.get(w, r, id)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Upload-Offset", strconv.FormatInt(info.Offset, 10))
+	if info.SizeIsDeferred {
+		w.Header().Set("Upload-Defer-Length", "1")
+	} else {
+		w.Header().Set("Upload-Length", strconv.FormatInt(info.Size, 10))
+	}
+	if len(info.Metadata) > 0 {
+		w.Header().Set("Upload-Metadata", formatMetadata(info.Metadata))
+	}
+	if !info.Finished {
+		w.Header().Set("Upload-Expires", info.ExpiresAt.UTC().Format(http.TimeFormat))
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// patch appends a chunk to an upload
+func (h *Handler) patch(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Header.Get("Content-Type") != offsetContentType {
+		http.Error(w, "Content-Type must be "+offsetContentType, http.StatusUnsupportedMediaType)
+		return
+	}
+
+	info, ok := h.get(w, r, id)
+	if !ok {
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		http.Error(w, "invalid Upload-Offset", http.StatusBadRequest)
+		return
+	}
+	if offset != info.Offset {
+		http.Error(w, "Upload-Offset does not match the upload", http.StatusConflict)
+		return
+	}
+
+	if info.SizeIsDeferred && r.Header.Get("Upload-Length") != "" {
+		size, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+		if err != nil || size < info.Offset {
+			http.Error(w, "invalid Upload-Length", http.StatusBadRequest)
+			return
+		}
+		if h.MaxSize > 0 && size > h.MaxSize {
+			http.Error(w, "upload too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		info.Size, info.SizeIsDeferred = size, false
+	}
+
+	if info.Finished {
+		http.Error(w, "upload finished already", http.StatusForbidden)
+		return
+	}
+
+	status, err := h.write(r, &info)
+	if err != nil {
+		h.reject(w, err, status)
+		return
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(info.Offset, 10))
+	w.Header().Set("Upload-Expires", info.ExpiresAt.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// terminate deletes an upload, and its chunks
+func (h *Handler) terminate(w http.ResponseWriter, r *http.Request, id string) {
+	if _, ok := h.get(w, r, id); !ok {
+		return
+	}
+
+	if err := os.Remove(h.part(id)); err != nil && !os.IsNotExist(err) {
+		h.serverError(w, err)
+		return
+	}
+	if err := h.Store.Delete(id); err != nil {
+		h.serverError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// write appends the body of r to the chunks of info, then finishes the upload when it
+// is complete. A chunk cut by the client is kept, unless it has a checksum. It returns
+// the status of the error
+func (h *Handler) write(r *http.Request, info *Info) (int, error) {
+	verify, expected, err := checksum(r.Header.Get("Upload-Checksum"))
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	f, err := os.OpenFile(h.part(info.ID), os.O_WRONLY, 0644)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	defer f.Close()
+
+	// bytes written past the recorded offset, by a request which failed to record them,
+	// are dropped
+	if err := f.Truncate(info.Offset); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if _, err := f.Seek(info.Offset, io.SeekStart); err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	limit := int64(-1)
+	switch {
+	case !info.SizeIsDeferred:
+		limit = info.Size - info.Offset
+	case h.MaxSize > 0:
+		limit = h.MaxSize - info.Offset
+	}
+
+	var body io.Reader = r.Body
+	if limit >= 0 {
+		body = io.LimitReader(r.Body, limit+1)
+	}
+	var dst io.Writer = f
+	if verify != nil {
+		dst = io.MultiWriter(f, verify)
+	}
+
+	n, copyErr := io.Copy(dst, body)
+	switch {
+	case limit >= 0 && n > limit:
+		_ = f.Truncate(info.Offset)
+		return http.StatusRequestEntityTooLarge, errors.New("chunk past the length of the upload")
+	case verify != nil && copyErr != nil:
+		_ = f.Truncate(info.Offset)
+		return http.StatusBadRequest, copyErr
+	case verify != nil && string(verify.Sum(nil)) != string(expected):
+		_ = f.Truncate(info.Offset)
+		return statusChecksumMismatch, errors.New("checksum mismatch")
+	}
+
+	info.Offset += n
+	info.ExpiresAt = time.Now().Add(h.expiration())
+	if err := h.Store.Save(*info); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if copyErr != nil {
+		// the client resumes from the offset saved
+		return http.StatusBadRequest, copyErr
+	}
+
+	if !info.SizeIsDeferred && info.Offset == info.Size {
+		return h.finish(r, info)
+	}
+	return 0, nil
+}
+
+// finish hands a complete upload to FS and runs PostFinish. The chunks are kept until
+// both succeed, so the client can finish the upload again. The type of the file, and
+// the extension of its key, are detected from its content, as the filename and the
+// filetype of the metadata are the client's
+func (h *Handler) finish(r *http.Request, info *Info) (int, error) {
+	part, err := os.Open(h.part(info.ID))
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	defer part.Close()
+
+	head := make([]byte, sniffSize)
+	n, err := io.ReadFull(part, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return http.StatusInternalServerError, err
+	}
+	if _, err := part.Seek(0, io.SeekStart); err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	mimeType := mimetype.Detect(head[:n])
+	if !allowedType(mimeType, h.AllowedTypes) {
+		_ = os.Remove(h.part(info.ID))
+		if err := h.Store.Delete(info.ID); err != nil {
+			return http.StatusInternalServerError, err
+		}
+		return http.StatusUnsupportedMediaType, errors.New("file type not allowed: " + mimeType.String())
+	}
+	info.Key = filesystems.FolderPrefix(h.Folder) + info.ID + mimeType.Extension()
+
+	if h.Scan != nil {
+		if err := h.Scan.Check(r.Context(), info.Metadata["filename"], part); err != nil {
+			return h.scanFailed(info, err)
+		}
+	}
+
+	err = h.FS.PutStream(r.Context(), info.Key, part, filesystems.PutOptions{
+		ContentType: mimeType.String(),
+		Size:        info.Size,
+	})
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	if h.PostFinish != nil {
+		if err := h.PostFinish(r, *info); err != nil {
+			return http.StatusInternalServerError, err
+		}
+	}
+
+	info.Finished = true
+	if err := h.Store.Save(*info); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	_ = os.Remove(h.part(info.ID))
+	return 0, nil
+}
+
+// scanFailed terminates an infected upload, and keeps the others to be finished again
+func (h *Handler) scanFailed(info *Info, err error) (int, error) {
+	if !errors.Is(err, scanner.ErrInfected) {
+		h.logError(err)
+		return 0, &Rejection{Status: http.StatusServiceUnavailable, Message: "the upload could not be scanned"}
+	}
+
+	_ = os.Remove(h.part(info.ID))
+	if err := h.Store.Delete(info.ID); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusUnprocessableEntity, err
+}
+
+// Cleanup deletes the chunks of the expired uploads, and of the ones missing from the
+// store, then the expired uploads of the store, the finished ones included. Celeritas
+// runs it hourly
+func (h *Handler) Cleanup() error {
+	now := time.Now()
+	if err := h.cleanupParts(now); err != nil {
+		return err
+	}
+	return h.Store.DeleteExpired(now)
+}
+
+// cleanupParts deletes the chunks of the uploads expired at now, with the uploads, and
+// of the ones missing from the store
+func (h *Handler) cleanupParts(now time.Time) error {
+	entries, err := ioutil.ReadDir(h.Dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), partSuffix) {
+			continue
+		}
+		id := strings.TrimSuffix(entry.Name(), partSuffix)
+
+		lock := h.lock(id)
+		lock.Lock()
+		info, err := h.Store.Get(id)
+		switch {
+		case errors.Is(err, ErrNotFound):
+			// a chunk written just before its upload was saved is spared
+			if now.Sub(entry.ModTime()) > time.Minute {
+				err = os.Remove(h.part(id))
+			} else {
+				err = nil
+			}
+		case err == nil && info.Expired(now):
+			if err = os.Remove(h.part(id)); err == nil {
+				err = h.Store.Delete(id)
+			}
+		}
+		lock.Unlock()
+
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// get returns the upload id, or writes a 404 when it is unknown or expired
+func (h *Handler) get(w http.ResponseWriter, r *http.Request, id string) (Info, bool) {
+	info, err := h.Store.Get(id)
+	if errors.Is(err, ErrNotFound) || err == nil && !info.Finished && info.Expired(time.Now()) {
+		http.NotFound(w, r)
+		return info, false
+	}
+	if err != nil {
+		h.serverError(w, err)
+		return info, false
+	}
+	return info, true
+}
+
+// reject writes err with the status of a *Rejection, or else status. The internal
+// errors are logged rather than shown
+func (h *Handler) reject(w http.ResponseWriter, err error, status int) {
+	var rejection *Rejection
+	if errors.As(err, &rejection) {
+		http.Error(w, rejection.Message, rejection.Status)
+		return
+	}
+	if status == http.StatusInternalServerError || errors.Is(err, context.Canceled) {
+		h.serverError(w, err)
+		return
+	}
+	http.Error(w, err.Error(), status)
+}
+
+func (h *Handler) serverError(w http.ResponseWriter, err error) {
+	h.logError(err)
+	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+}
+
+func (h *Handler) logError(err error) {
+	if h.ErrorLog != nil {
+		h.ErrorLog.Println("tus:", err)
+	} else {
+		log.Println("tus:", err)
+	}
+}
+
+func (h *Handler) expiration() time.Duration {
+	if h.Expiration > 0 {
+		return h.Expiration
+	}
+	return defaultExpiration
+}
+
+// part returns the file of the chunks of the upload id
+func (h *Handler) part(id string) string {
+	return filepath.Join(h.Dir, id+partSuffix)
+}
+
+func (h *Handler) lock(id string) *sync.Mutex {
+	f := fnv.New32a()
+	_, _ = f.Write([]byte(id))
+	return &h.locks[f.Sum32()%uint32(len(h.locks))]
+}
+
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// allowedType reports whether mimeType matches one of allowed, or allowed is empty
+func allowedType(mimeType *mimetype.MIME, allowed []string) bool {
+	empty := true
+	for _, a := range allowed {
+		a = strings.TrimSpace(a)
+		if a == "" {
+			continue
+		}
+		empty = false
+
+		if strings.HasSuffix(a, "/*") {
+			if strings.HasPrefix(mimeType.String(), strings.TrimSuffix(a, "*")) {
+				return true
+			}
+			continue
+		}
+		if mimeType.Is(a) {
+			return true
+		}
+	}
+	return empty
+}
+
+// checksum parses an Upload-Checksum header, "<algorithm> <base64 digest>"
+func checksum(header string) (hash.Hash, []byte, error) {
+	if header == "" {
+		return nil, nil, nil
+	}
+
+	parts := strings.SplitN(header, " ", 2)
+	if len(parts) != 2 {
+		return nil, nil, errors.New("invalid Upload-Checksum")
+	}
+	expected, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, nil, errors.New("invalid Upload-Checksum")
+	}
+
+	switch parts[0] {
+	case "sha1":
+		return sha1.New(), expected, nil
+	case "md5":
+		return md5.New(), expected, nil
+	case "sha256":
+		return sha256.New(), expected, nil
+	default:
+		return nil, nil, errors.New("unsupported checksum algorithm " + parts[0])
+	}
+}
+
+// parseMetadata parses an Upload-Metadata header, comma separated pairs of a key and
+// a base64 value, which may be missing
+func parseMetadata(header string) (map[string]string, error) {
+	if strings.TrimSpace(header) == "" {
+		return nil, nil
+	}
+
+	metadata := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		fields := strings.Fields(pair)
+		switch len(fields) {
+		case 1:
+			metadata[fields[0]] = ""
+		case 2:
+			value, err := base64.StdEncoding.DecodeString(fields[1])
+			if err != nil {
+				return nil, errors.New("invalid Upload-Metadata value for " + fields[0])
+			}
+			metadata[fields[0]] = string(value)
+		default:
+			return nil, errors.New("invalid Upload-Metadata")
+		}
+	}
+	return metadata, nil
+}
+
+func formatMetadata(metadata map[string]string) string {
+	pairs := make([]string, 0, len(metadata))
+	for k, v := range metadata {
+		pairs = append(pairs, k+" "+base64.StdEncoding.EncodeToString([]byte(v)))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
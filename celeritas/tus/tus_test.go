@@ -0,0 +1,356 @@
+package tus
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/djedjethai/celeritas/filesystems"
+	"github.com/djedjethai/celeritas/filesystems/memfilesystem"
+	"github.com/djedjethai/celeritas/scanner"
+)
+
+func newHandler(t *testing.T) (*Handler, *memfilesystem.Mem) {
+	disk := &memfilesystem.Mem{}
+	return &Handler{
+		BasePath: "/files/",
+		Store:    &MemoryStore{},
+		Dir:      t.TempDir(),
+		FS:       disk,
+		Folder:   "videos",
+		ErrorLog: log.New(ioutil.Discard, "", 0),
+	}, disk
+}
+
+func do(h *Handler, method, target string, headers map[string]string, body []byte) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, bytes.NewReader(body))
+	r.Header.Set("Tus-Resumable", Version)
+	for k, v := range headers {
+		r.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func patch(h *Handler, location string, offset int, chunk []byte, headers map[string]string) *httptest.ResponseRecorder {
+	all := map[string]string{
+		"Content-Type":  offsetContentType,
+		"Upload-Offset": strconv.Itoa(offset),
+	}
+	for k, v := range headers {
+		all[k] = v
+	}
+	return do(h, http.MethodPatch, location, all, chunk)
+}
+
+func TestHandler_upload(t *testing.T) {
+	h, disk := newHandler(t)
+	var finished Info
+	h.PostFinish = func(r *http.Request, info Info) error {
+		finished = info
+		return nil
+	}
+	content := []byte("\x00\x00\x00\x18ftypmp42\x00\x00\x00\x00mp42isom, a video sent in three chunks")
+
+	w := do(h, http.MethodPost, "/files/", map[string]string{
+		"Upload-Length":   strconv.Itoa(len(content)),
+		"Upload-Metadata": "filename " + base64.StdEncoding.EncodeToString([]byte("holidays.txt")) + ",filetype " + base64.StdEncoding.EncodeToString([]byte("text/html")),
+	}, nil)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create failed: %d %s", w.Code, w.Body.String())
+	}
+	location := w.Header().Get("Location")
+
+	if w := patch(h, location, 0, content[:10], nil); w.Code != http.StatusNoContent || w.Header().Get("Upload-Offset") != "10" {
+		t.Fatalf("first chunk failed: %d %s", w.Code, w.Body.String())
+	}
+
+	// a client resuming with a stale offset is refused
+	if w := patch(h, location, 5, content[5:], nil); w.Code != http.StatusConflict {
+		t.Errorf("expected a conflict, got %d", w.Code)
+	}
+
+	w = do(h, http.MethodHead, location, nil, nil)
+	if w.Header().Get("Upload-Offset") != "10" || w.Header().Get("Upload-Length") != strconv.Itoa(len(content)) {
+		t.Errorf("unexpected head %v", w.Header())
+	}
+
+	_ = patch(h, location, 10, content[10:20], nil)
+	if w := patch(h, location, 20, content[20:], nil); w.Code != http.StatusNoContent {
+		t.Fatalf("last chunk failed: %d %s", w.Code, w.Body.String())
+	}
+
+	if finished.Key == "" {
+		t.Fatal("PostFinish not called")
+	}
+	// the key and the type come from the content, whatever the client says
+	if finished.Key != "videos/"+finished.ID+".mp4" {
+		t.Errorf("unexpected key %s", finished.Key)
+	}
+	r, err := disk.Open(context.Background(), finished.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	stored, _ := ioutil.ReadAll(r)
+	if !bytes.Equal(stored, content) {
+		t.Errorf("expected %q, got %q", content, stored)
+	}
+	if stat, _ := disk.Stat(context.Background(), finished.Key); stat.ContentType != "video/mp4" {
+		t.Errorf("expected video/mp4, got %s", stat.ContentType)
+	}
+	if _, err := os.Stat(h.part(finished.ID)); !os.IsNotExist(err) {
+		t.Error("chunks kept after the upload finished")
+	}
+}
+
+func TestHandler_deferLength(t *testing.T) {
+	h, _ := newHandler(t)
+	w := do(h, http.MethodPost, "/files/", map[string]string{
+		"Upload-Defer-Length": "1",
+		"Content-Type":        offsetContentType,
+	}, []byte("hello"))
+	if w.Code != http.StatusCreated || w.Header().Get("Upload-Offset") != "5" {
+		t.Fatalf("create with upload failed: %d %v", w.Code, w.Header())
+	}
+	location := w.Header().Get("Location")
+
+	if w := do(h, http.MethodHead, location, nil, nil); w.Header().Get("Upload-Defer-Length") != "1" {
+		t.Errorf("expected a deferred length, got %v", w.Header())
+	}
+
+	w = patch(h, location, 5, []byte(" world"), map[string]string{"Upload-Length": "11"})
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("last chunk failed: %d %s", w.Code, w.Body.String())
+	}
+	info, _ := h.Store.Get(location[len("/files/"):])
+	if !info.Finished || info.Size != 11 {
+		t.Errorf("upload not finished %+v", info)
+	}
+}
+
+func TestHandler_allowedTypes(t *testing.T) {
+	h, disk := newHandler(t)
+	h.AllowedTypes = []string{"video/*"}
+	page := []byte("<html><script>alert(1)</script></html>")
+
+	w := do(h, http.MethodPost, "/files/", map[string]string{
+		"Upload-Length":   strconv.Itoa(len(page)),
+		"Upload-Metadata": "filename " + base64.StdEncoding.EncodeToString([]byte("clip.mp4")) + ",filetype " + base64.StdEncoding.EncodeToString([]byte("video/mp4")),
+	}, nil)
+	location := w.Header().Get("Location")
+	if w := patch(h, location, 0, page, nil); w.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("expected the html refused, got %d", w.Code)
+	}
+	if w := do(h, http.MethodHead, location, nil, nil); w.Code != http.StatusNotFound {
+		t.Errorf("expected the refused upload terminated, got %d", w.Code)
+	}
+	if listing, _ := filesystems.ListAll(context.Background(), disk, "videos", true); len(listing) != 0 {
+		t.Errorf("refused upload stored: %v", listing)
+	}
+}
+
+func TestHandler_checksum(t *testing.T) {
+	h, _ := newHandler(t)
+	w := do(h, http.MethodPost, "/files/", map[string]string{"Upload-Length": "10"}, nil)
+	location := w.Header().Get("Location")
+
+	sum := sha1.Sum([]byte("other"))
+	w = patch(h, location, 0, []byte("chunk"), map[string]string{
+		"Upload-Checksum": "sha1 " + base64.StdEncoding.EncodeToString(sum[:]),
+	})
+	if w.Code != statusChecksumMismatch {
+		t.Errorf("expected a checksum mismatch, got %d", w.Code)
+	}
+
+	sum = sha1.Sum([]byte("chunk"))
+	w = patch(h, location, 0, []byte("chunk"), map[string]string{
+		"Upload-Checksum": "sha1 " + base64.StdEncoding.EncodeToString(sum[:]),
+	})
+	if w.Code != http.StatusNoContent || w.Header().Get("Upload-Offset") != "5" {
+		t.Errorf("valid chunk refused: %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestHandler_rejected(t *testing.T) {
+	h, _ := newHandler(t)
+	h.MaxSize = 100
+	h.PreCreate = func(r *http.Request, info Info) error {
+		if info.Metadata["filename"] == "" {
+			return &Rejection{Status: http.StatusForbidden, Message: "a filename is required"}
+		}
+		return nil
+	}
+	filename := "filename " + base64.StdEncoding.EncodeToString([]byte("a.mp4"))
+
+	tests := []struct {
+		name     string
+		headers  map[string]string
+		expected int
+	}{
+		{"no length", map[string]string{"Upload-Metadata": filename}, http.StatusBadRequest},
+		{"too large", map[string]string{"Upload-Length": "101", "Upload-Metadata": filename}, http.StatusRequestEntityTooLarge},
+		{"rejected by the hook", map[string]string{"Upload-Length": "10"}, http.StatusForbidden},
+		{"tus version", map[string]string{"Tus-Resumable": "0.2.2", "Upload-Length": "10"}, http.StatusPreconditionFailed},
+	}
+	for _, tt := range tests {
+		if w := do(h, http.MethodPost, "/files/", tt.headers, nil); w.Code != tt.expected {
+			t.Errorf("%s: expected %d, got %d", tt.name, tt.expected, w.Code)
+		}
+	}
+
+	w := do(h, http.MethodPost, "/files/", map[string]string{"Upload-Length": "4", "Upload-Metadata": filename}, nil)
+	location := w.Header().Get("Location")
+	if w := patch(h, location, 0, []byte("too long"), nil); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected a chunk past the length refused, got %d", w.Code)
+	}
+	if w := do(h, http.MethodHead, location, nil, nil); w.Header().Get("Upload-Offset") != "0" {
+		t.Errorf("refused chunk kept: %v", w.Header())
+	}
+}
+
+func TestHandler_postFinishRetry(t *testing.T) {
+	h, _ := newHandler(t)
+	calls := 0
+	h.PostFinish = func(r *http.Request, info Info) error {
+		calls++
+		if calls == 1 {
+			return errors.New("thumbnail failed")
+		}
+		return nil
+	}
+
+	w := do(h, http.MethodPost, "/files/", map[string]string{"Upload-Length": "4"}, nil)
+	location := w.Header().Get("Location")
+	if w := patch(h, location, 0, []byte("data"), nil); w.Code != http.StatusInternalServerError {
+		t.Fatalf("expected the hook error, got %d", w.Code)
+	}
+
+	// resuming from the offset finishes the upload again
+	if w := patch(h, location, 4, nil, nil); w.Code != http.StatusNoContent || calls != 2 {
+		t.Errorf("upload not finished again: %d, %d calls", w.Code, calls)
+	}
+}
+
+// verdicts is a scanner which returns its verdicts in turn
+type verdicts []error
+
+func (v *verdicts) Scan(ctx context.Context, content io.Reader) (scanner.Result, error) {
+	err := (*v)[0]
+	*v = (*v)[1:]
+	if err == scanner.ErrInfected {
+		return scanner.Result{Infected: true, Signature: "Test-Virus"}, nil
+	}
+	return scanner.Result{}, err
+}
+
+func TestHandler_scan(t *testing.T) {
+	h, disk := newHandler(t)
+	h.Scan = &scanner.Policy{
+		Scanner:  &verdicts{errors.New("clamd down"), scanner.ErrInfected, nil},
+		ErrorLog: h.ErrorLog,
+	}
+
+	w := do(h, http.MethodPost, "/files/", map[string]string{"Upload-Length": "4"}, nil)
+	location := w.Header().Get("Location")
+	if w := patch(h, location, 0, []byte("data"), nil); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected the upload kept for later, got %d", w.Code)
+	}
+
+	// the infected upload is terminated
+	if w := patch(h, location, 4, nil, nil); w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected the infected upload refused, got %d", w.Code)
+	}
+	if w := do(h, http.MethodHead, location, nil, nil); w.Code != http.StatusNotFound {
+		t.Errorf("expected the infected upload terminated, got %d", w.Code)
+	}
+	if _, err := os.Stat(h.part(location[len("/files/"):])); !os.IsNotExist(err) {
+		t.Error("chunks of the infected upload kept")
+	}
+
+	w = do(h, http.MethodPost, "/files/", map[string]string{
+		"Upload-Length": "4",
+		"Content-Type":  offsetContentType,
+	}, []byte("safe"))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected the clean upload stored, got %d", w.Code)
+	}
+	listing, _ := filesystems.ListAll(context.Background(), disk, "videos", true)
+	if len(listing) != 1 {
+		t.Errorf("expected only the clean upload stored, got %v", listing)
+	}
+}
+
+func TestHandler_terminateAndCleanup(t *testing.T) {
+	h, _ := newHandler(t)
+	h.Expiration = time.Millisecond
+
+	w := do(h, http.MethodPost, "/files/", map[string]string{"Upload-Length": "10"}, nil)
+	expired := w.Header().Get("Location")[len("/files/"):]
+	time.Sleep(5 * time.Millisecond)
+
+	if w := do(h, http.MethodHead, "/files/"+expired, nil, nil); w.Code != http.StatusNotFound {
+		t.Errorf("expected an expired upload not found, got %d", w.Code)
+	}
+	if err := h.Cleanup(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(h.part(expired)); !os.IsNotExist(err) {
+		t.Error("chunks of an expired upload kept")
+	}
+	if _, err := h.Store.Get(expired); !errors.Is(err, ErrNotFound) {
+		t.Error("expired upload kept in the store")
+	}
+
+	// a finished upload has no chunks, its record expires all the same
+	w = do(h, http.MethodPost, "/files/", map[string]string{
+		"Upload-Length": "4",
+		"Content-Type":  offsetContentType,
+	}, []byte("done"))
+	finished := w.Header().Get("Location")[len("/files/"):]
+	if info, _ := h.Store.Get(finished); !info.Finished {
+		t.Fatalf("upload not finished: %+v", info)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if err := h.Cleanup(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.Store.Get(finished); !errors.Is(err, ErrNotFound) {
+		t.Error("expired finished upload kept in the store")
+	}
+
+	h.Expiration = time.Hour
+	w = do(h, http.MethodPost, "/files/", map[string]string{"Upload-Length": "10"}, nil)
+	location := w.Header().Get("Location")
+	if w := do(h, http.MethodDelete, location, nil, nil); w.Code != http.StatusNoContent {
+		t.Fatalf("terminate failed: %d", w.Code)
+	}
+	if w := do(h, http.MethodHead, location, nil, nil); w.Code != http.StatusNotFound {
+		t.Errorf("expected a terminated upload not found, got %d", w.Code)
+	}
+}
+
+func TestParseMetadata(t *testing.T) {
+	metadata, err := parseMetadata("filename d29ybGRfZG9taW5hdGlvbl9wbGFuLnBkZg==,is_confidential")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata["filename"] != "world_domination_plan.pdf" || metadata["is_confidential"] != "" {
+		t.Errorf("unexpected metadata %v", metadata)
+	}
+	if _, err := parseMetadata("filename not-base64!"); err == nil {
+		t.Error("expected an invalid value refused")
+	}
+}
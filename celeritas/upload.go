@@ -1,94 +1,473 @@
 package celeritas
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
+	"io/ioutil"
+	"mime/multipart"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/djedjethai/celeritas/filesystems"
+	"github.com/djedjethai/celeritas/filesystems/localfilesystem"
 	"github.com/gabriel-vasile/mimetype"
 )
 
+// Upload errors
+var (
+	// ErrUploadTooLarge is returned for a file larger than the MaxSize of the upload
+	ErrUploadTooLarge = errors.New("upload: file too large")
+	// ErrUploadType is returned for a file of a type which is not allowed
+	ErrUploadType = errors.New("upload: file type not allowed")
+	// ErrUploadTooMany is returned when a request has more files than the MaxFiles of the upload
+	ErrUploadTooMany = errors.New("upload: too many files")
+)
+
+const (
+	// sniffSize is the part of a file read to detect its type
+	sniffSize = 3072
+	// maxFormValues is the size of the form values read along the files, as net/http
+	maxFormValues = 10 << 20
+	// maxFilename is the length of a sanitised file name
+	maxFilename = 200
+)
+
+// UploadOptions tune Upload. The zero value stores the files of every field on the
+// default disk, with the MAX_UPLOAD_SIZE and ALLOWED_FILETYPES of .env
+type UploadOptions struct {
+	// Fields are the form fields read, every field with files when empty
+	Fields []string
+	// Disk is the name of the disk the files are stored on, the default disk when empty
+	Disk string
+	// Folder is the folder of the stored files
+	Folder string
+	// MaxSize is the size limit of each file, MAX_UPLOAD_SIZE when 0
+	MaxSize int64
+	// MaxFiles is the number of files accepted, any number when 0
+	MaxFiles int
+	// AllowedTypes are the mime types accepted, as detected from the content; a type
+	// like image/* accepts all of its subtypes. It is ALLOWED_FILETYPES when empty, and
+	// any type is accepted when both are empty
+	AllowedTypes []string
+	// KeepName stores the files under their sanitised name rather than a random one,
+	// replacing the files of the same name
+	KeepName bool
+	// ImagePresets are derived from the uploaded images once they are stored, so they
+	// are ready before their first request. An image which cannot be derived fails the
+	// upload
+	ImagePresets []string
+}
+
+// UploadedFile describes a file stored by Upload
+type UploadedFile struct {
+	// Field is the form field of the file
+	Field string
+	// Filename is the name sent by the client, sanitised
+	Filename string
+	// Key is the key of the file on the disk
+	Key  string
+	Size int64
+	// Checksum is the sha256 of the content, in hex
+	Checksum string
+	// ContentType is detected from the content
+	ContentType string
+	// Derived are the keys of the images derived by ImagePresets, on the cache disk of
+	// the images
+	Derived map[string]string
+}
+
+// Upload stores the files of a multipart request on a disk. The request body is
+// streamed, part by part, so the files are never held in memory or in a temporary
+// file, unless the Scanner checks them first; the form values met along the way are
+// added to r.Form and r.PostForm. When the form has been parsed already, as the CSRF
+// middleware does for a token which is not sent in the X-CSRF-Token header, the parsed
+// files are stored instead.
+//
+// An infected file fails the upload with an error wrapping scanner.ErrInfected.
+// Either every file is stored, or none: the files stored before an error are deleted
+func (c *Celeritas) Upload(r *http.Request, opts UploadOptions) ([]UploadedFile, error) {
+	fs, err := c.Disk(opts.Disk)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := c.upload(r, fs, opts)
+	if err != nil || len(opts.ImagePresets) == 0 {
+		return files, err
+	}
+
+	if err := c.deriveUploads(r.Context(), files, opts); err != nil {
+		var keys []string
+		for _, file := range files {
+			keys = append(keys, file.Key)
+		}
+		_ = fs.Delete(keys)
+		return nil, err
+	}
+	return files, nil
+}
+
+// deriveUploads derives the ImagePresets of the uploaded images
+func (c *Celeritas) deriveUploads(ctx context.Context, files []UploadedFile, opts UploadOptions) error {
+	for i, file := range files {
+		if !strings.HasPrefix(file.ContentType, "image/") {
+			continue
+		}
+
+		files[i].Derived = make(map[string]string, len(opts.ImagePresets))
+		for _, preset := range opts.ImagePresets {
+			derived, err := c.DeriveImage(ctx, preset, opts.Disk, file.Key)
+			if err != nil {
+				return fmt.Errorf("%s: %w", file.Filename, err)
+			}
+			files[i].Derived[preset] = derived.Key
+		}
+	}
+	return nil
+}
+
+// UploadFile stores the file of field in destination, a folder of fs, or a local
+// folder when fs is nil.
+//
+// Deprecated: use Upload, which handles several files and randomises their names
 func (c *Celeritas) UploadFile(r *http.Request, destination, field string, fs filesystems.FS) error {
+	// fs fileSystems.FS is a pointer(but is an interface so i don't write it)
+	// if we are using a remote fs we pass a pointer to it
+	// or nil if we are uploading to a local folder
+	if fs == nil {
+		fs = &localfilesystem.Local{Root: destination}
+		destination = ""
+	}
 
-	// exploded := os.Getenv("ALLOWED_FILETYPES")
-	fileName, err := c.getFileToUpload(r, field)
+	files, err := c.upload(r, fs, UploadOptions{
+		Fields:   []string{field},
+		Folder:   destination,
+		MaxFiles: 1,
+		KeepName: true,
+	})
+	if err == nil && len(files) == 0 {
+		err = http.ErrMissingFile
+	}
 	if err != nil {
 		c.ErrorLog.Println(err)
 		return err
 	}
+	return nil
+}
 
-	// fs fileSystems.FS is a pointer(but is an interface so i don't write it)
-	// if we are using a remote fs we pass a pointer to it
-	// or nil if we are uploading to a local fs
-	if fs != nil {
-		err = fs.Put(fileName, destination)
+func (c *Celeritas) upload(r *http.Request, fs filesystems.FS, opts UploadOptions) ([]UploadedFile, error) {
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = c.config.uploads.maxUploadSize
+	}
+	if len(opts.AllowedTypes) == 0 {
+		opts.AllowedTypes = c.config.uploads.allowedMimeTypes
+	}
+
+	var files []UploadedFile
+	store := func(field, filename string, size int64, content io.Reader) error {
+		if len(opts.Fields) > 0 && !inSlice(opts.Fields, field) {
+			return nil
+		}
+		if opts.MaxFiles > 0 && len(files) == opts.MaxFiles {
+			return ErrUploadTooMany
+		}
+		if size > opts.MaxSize {
+			return fmt.Errorf("%s: %w", filename, ErrUploadTooLarge)
+		}
+
+		file, err := c.storeUpload(r.Context(), fs, field, filename, content, opts)
 		if err != nil {
-			c.ErrorLog.Println(err)
 			return err
 		}
+		files = append(files, file)
+		return nil
+	}
+
+	var err error
+	if r.MultipartForm != nil {
+		err = storeParsedFiles(r.MultipartForm, store)
 	} else {
-		err = os.Rename(fileName, fmt.Sprintf("%s/%s", destination, path.Base(fileName)))
+		err = streamFiles(r, store)
+	}
+
+	if err != nil {
+		var keys []string
+		for _, file := range files {
+			keys = append(keys, file.Key)
+		}
+		if len(keys) > 0 {
+			_ = fs.Delete(keys)
+		}
+		return nil, err
+	}
+	return files, nil
+}
+
+// storeFunc stores one file of the request
+type storeFunc func(field, filename string, size int64, content io.Reader) error
+
+// streamFiles reads the parts of the multipart body of r one after the other
+func streamFiles(r *http.Request, store storeFunc) error {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return err
+	}
+
+	if r.Form == nil {
+		r.Form = make(url.Values)
+	}
+	if r.PostForm == nil {
+		r.PostForm = make(url.Values)
+	}
+
+	valuesLeft := int64(maxFormValues)
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil
+		}
 		if err != nil {
-			c.ErrorLog.Println(err)
 			return err
 		}
 
+		if part.FileName() == "" {
+			var value bytes.Buffer
+			n, err := io.CopyN(&value, part, valuesLeft+1)
+			if err != nil && err != io.EOF {
+				return err
+			}
+			if valuesLeft -= n; valuesLeft < 0 {
+				return errors.New("upload: form values too large")
+			}
+			r.Form.Add(part.FormName(), value.String())
+			r.PostForm.Add(part.FormName(), value.String())
+			continue
+		}
+
+		err = store(part.FormName(), part.FileName(), -1, part)
+		part.Close()
+		if err != nil {
+			return err
+		}
 	}
+}
 
-	defer func() {
-		_ = os.Remove(fileName)
-	}()
+// storeParsedFiles stores the files of a form parsed already
+func storeParsedFiles(form *multipart.Form, store storeFunc) error {
+	fields := make([]string, 0, len(form.File))
+	for field := range form.File {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
 
+	for _, field := range fields {
+		for _, header := range form.File[field] {
+			file, err := header.Open()
+			if err != nil {
+				return err
+			}
+			err = store(field, header.Filename, header.Size, file)
+			file.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
 	return nil
 }
 
-func (c *Celeritas) getFileToUpload(r *http.Request, fieldName string) (string, error) {
+// storeUpload checks the type of the file from its first bytes, then streams it to fs
+// while counting and hashing it. With a Scanner, the file is spooled to a temporary
+// file and scanned before it is stored
+func (c *Celeritas) storeUpload(ctx context.Context, fs filesystems.FS, field, filename string, content io.Reader, opts UploadOptions) (UploadedFile, error) {
+	file := UploadedFile{Field: field, Filename: sanitizeFilename(filename)}
 
-	_ = r.ParseMultipartForm(c.config.uploads.maxUploadSize)
+	head := make([]byte, sniffSize)
+	n, err := io.ReadFull(content, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return file, err
+	}
+	head = head[:n]
 
-	file, header, err := r.FormFile(fieldName)
-	if err != nil {
-		return "", err
+	mimeType := mimetype.Detect(head)
+	if !allowedType(mimeType, opts.AllowedTypes) {
+		return file, fmt.Errorf("%s: %w: %s", file.Filename, ErrUploadType, mimeType.String())
+	}
+	file.ContentType = mimeType.String()
+
+	name := file.Filename
+	if !opts.KeepName || name == "" {
+		random := make([]byte, 16)
+		if _, err := rand.Read(random); err != nil {
+			return file, err
+		}
+		name = hex.EncodeToString(random) + mimeType.Extension()
 	}
-	defer file.Close()
+	file.Key = filesystems.FolderPrefix(opts.Folder) + name
 
-	// mimetype.DetectReader() read the 512bytes of the file to detect the mimetype
-	// so just after i need to reset the reader pointer to the beginning of the file
-	mimeType, err := mimetype.DetectReader(file)
+	counter := &uploadReader{
+		r:    io.MultiReader(bytes.NewReader(head), content),
+		hash: sha256.New(),
+		max:  opts.MaxSize,
+	}
+
+	body, done, err := c.scanUpload(ctx, file.Filename, counter)
+	defer done()
+	if counter.tooLarge {
+		return file, fmt.Errorf("%s: %w", file.Filename, ErrUploadTooLarge)
+	}
 	if err != nil {
-		return "", err
+		return file, fmt.Errorf("%s: %w", file.Filename, err)
 	}
 
-	// reset the reader pointer to the beginning of the file
-	// otherwise user won't be able to open the file(what ever it is, pic or doc)
-	_, err = file.Seek(0, 0)
+	err = fs.PutStream(ctx, file.Key, body, filesystems.PutOptions{
+		ContentType: file.ContentType,
+		Metadata:    map[string]string{"filename": file.Filename},
+	})
+	if counter.tooLarge {
+		// the drivers writing as they read may have kept a part of the file
+		_ = fs.Delete([]string{file.Key})
+		return file, fmt.Errorf("%s: %w", file.Filename, ErrUploadTooLarge)
+	}
 	if err != nil {
-		return "", err
+		return file, err
 	}
 
-	// see any kind of mimetype i can use
-	// https://developer.mozilla.org/en-US/docs/Web/HTTP/Basics_of_HTTP/MIME_types/Common_type
-	fmt.Println("see mime type err ooo: ", c.config.uploads.allowedMimeTypes)
-	if !inSlice(c.config.uploads.allowedMimeTypes, mimeType.String()) {
-		return "", errors.New("invalid file type uploaded")
+	file.Size = counter.size
+	file.Checksum = hex.EncodeToString(counter.hash.Sum(nil))
+	return file, nil
+}
+
+// scanUpload returns content as is without a Scanner. Otherwise it spools content to
+// a temporary file, has the Scanner check it, and returns the file to store; done
+// removes the file
+func (c *Celeritas) scanUpload(ctx context.Context, name string, content io.Reader) (io.Reader, func(), error) {
+	if c.Scanner == nil {
+		return content, func() {}, nil
 	}
 
-	dst, err := os.Create(fmt.Sprintf("./tmp/%s", header.Filename))
+	spool, err := c.spoolUpload(content)
+	done := func() {}
+	if spool != nil {
+		done = func() {
+			spool.Close()
+			_ = os.Remove(spool.Name())
+		}
+	}
 	if err != nil {
-		return "", err
+		return nil, done, err
 	}
-	defer dst.Close()
 
-	_, err = io.Copy(dst, file)
+	if err := c.Scanner.Check(ctx, name, spool); err != nil {
+		return nil, done, err
+	}
+	return spool, done, nil
+}
+
+// spoolUpload copies content to a temporary file of tmp, which the caller removes
+func (c *Celeritas) spoolUpload(content io.Reader) (*os.File, error) {
+	dir := os.TempDir()
+	if c.RootPath != "" {
+		dir = filepath.Join(c.RootPath, "tmp")
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	spool, err := ioutil.TempFile(dir, "upload-*")
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	_, err = io.Copy(spool, content)
+	return spool, err
+}
+
+// uploadReader counts and hashes the content read, and fails past max bytes
+type uploadReader struct {
+	r        io.Reader
+	hash     hash.Hash
+	size     int64
+	max      int64
+	tooLarge bool
+}
+
+func (u *uploadReader) Read(p []byte) (int, error) {
+	n, err := u.r.Read(p)
+	u.size += int64(n)
+	if u.max > 0 && u.size > u.max {
+		u.tooLarge = true
+		return 0, ErrUploadTooLarge
+	}
+	_, _ = u.hash.Write(p[:n])
+	return n, err
+}
+
+// allowedType reports whether mimeType matches one of allowed, or allowed is empty
+func allowedType(mimeType *mimetype.MIME, allowed []string) bool {
+	empty := true
+	for _, a := range allowed {
+		a = strings.TrimSpace(a)
+		if a == "" {
+			continue
+		}
+		empty = false
+
+		if strings.HasSuffix(a, "/*") {
+			if strings.HasPrefix(mimeType.String(), strings.TrimSuffix(a, "*")) {
+				return true
+			}
+			continue
+		}
+		if mimeType.Is(a) {
+			return true
+		}
 	}
+	return empty
+}
 
-	return fmt.Sprintf("./tmp/%s", header.Filename), nil
+// sanitizeFilename returns the base name of filename, keeping only the letters, digits,
+// spaces, dots, dashes and underscores of ASCII, so it is safe as a key and as metadata
+func sanitizeFilename(filename string) string {
+	filename = path.Base(strings.ReplaceAll(filename, `\`, "/"))
+	if filename == "/" {
+		return ""
+	}
+
+	var b strings.Builder
+	for _, r := range filename {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9',
+			r == '.', r == '-', r == '_', r == ' ':
+			b.WriteRune(r)
+		case r == utf8.RuneError:
+		default:
+			b.WriteRune('_')
+		}
+	}
+
+	// no dotfiles, which the listings hide
+	name := strings.TrimLeft(strings.TrimSpace(b.String()), ". ")
+	if len(name) > maxFilename {
+		ext := path.Ext(name)
+		if len(ext) > 16 {
+			ext = ""
+		}
+		name = name[:maxFilename-len(ext)] + ext
+	}
+	return name
 }
 
 func inSlice(slice []string, val string) bool {
@@ -0,0 +1,225 @@
+package celeritas
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/djedjethai/celeritas/filesystems"
+	"github.com/djedjethai/celeritas/filesystems/memfilesystem"
+	"github.com/djedjethai/celeritas/scanner"
+)
+
+// pngHeader is enough of a png for its type to be detected
+var pngHeader = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+type formFile struct {
+	field, filename string
+	content         []byte
+}
+
+func multipartRequest(t *testing.T, values map[string]string, files ...formFile) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	for name, value := range values {
+		_ = w.WriteField(name, value)
+	}
+	for _, f := range files {
+		part, err := w.CreateFormFile(f.field, f.filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = part.Write(f.content)
+	}
+	_ = w.Close()
+
+	r := httptest.NewRequest(http.MethodPost, "/upload", &body)
+	r.Header.Set("Content-Type", w.FormDataContentType())
+	return r
+}
+
+func newUploadApp() (*Celeritas, *memfilesystem.Mem) {
+	disk := &memfilesystem.Mem{}
+	c := &Celeritas{
+		FileSystems: filesystems.NewRegistry(),
+		ErrorLog:    log.New(ioutil.Discard, "", 0),
+	}
+	c.config.uploads.maxUploadSize = 1 << 10
+	c.config.uploads.allowedMimeTypes = []string{"image/png", "text/plain"}
+	c.FileSystems.Add("local", disk)
+	return c, disk
+}
+
+func TestCeleritas_Upload(t *testing.T) {
+	c, disk := newUploadApp()
+	text := []byte("some notes")
+	r := multipartRequest(t, map[string]string{"title": "holidays"},
+		formFile{"photos", "../../etc/beach.png", pngHeader},
+		formFile{"photos", "sea.png", pngHeader},
+		formFile{"notes", "notes.txt", text},
+	)
+
+	files, err := c.Upload(r, UploadOptions{Folder: "uploads"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 {
+		t.Fatalf("expected 3 files, got %d", len(files))
+	}
+	if r.PostForm.Get("title") != "holidays" {
+		t.Error("form value not kept")
+	}
+
+	first := files[0]
+	if first.Field != "photos" || first.Filename != "beach.png" || first.ContentType != "image/png" {
+		t.Errorf("unexpected file %+v", first)
+	}
+	if !strings.HasPrefix(first.Key, "uploads/") || !strings.HasSuffix(first.Key, ".png") || strings.Contains(first.Key, "beach") {
+		t.Errorf("expected a random key in uploads, got %s", first.Key)
+	}
+
+	notes := files[2]
+	sum := sha256.Sum256(text)
+	if notes.Size != int64(len(text)) || notes.Checksum != hex.EncodeToString(sum[:]) || !strings.HasPrefix(notes.ContentType, "text/plain") {
+		t.Errorf("unexpected file %+v", notes)
+	}
+	stat, err := disk.Stat(context.Background(), notes.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.Metadata["filename"] != "notes.txt" {
+		t.Errorf("original name not kept: %v", stat.Metadata)
+	}
+}
+
+func TestCeleritas_Upload_rejected(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    []formFile
+		opts     UploadOptions
+		expected error
+	}{
+		{"type", []formFile{{"f", "a.png", pngHeader}, {"f", "b.pdf", []byte("%PDF-1.4\n")}}, UploadOptions{}, ErrUploadType},
+		{"size", []formFile{{"f", "a.png", pngHeader}, {"f", "big.txt", bytes.Repeat([]byte("a"), 2<<10)}}, UploadOptions{}, ErrUploadTooLarge},
+		{"size of the call", []formFile{{"f", "a.png", pngHeader}}, UploadOptions{MaxSize: 4}, ErrUploadTooLarge},
+		{"types of the call", []formFile{{"f", "a.txt", []byte("text")}}, UploadOptions{AllowedTypes: []string{"image/*"}}, ErrUploadType},
+		{"count", []formFile{{"f", "a.png", pngHeader}, {"f", "b.png", pngHeader}}, UploadOptions{MaxFiles: 1}, ErrUploadTooMany},
+	}
+
+	for _, tt := range tests {
+		c, disk := newUploadApp()
+		_, err := c.Upload(multipartRequest(t, nil, tt.files...), tt.opts)
+		if !errors.Is(err, tt.expected) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.expected, err)
+		}
+
+		// nothing is kept of a rejected upload
+		listing, _ := filesystems.ListAll(context.Background(), disk, "", true)
+		if len(listing) > 0 {
+			t.Errorf("%s: files kept: %v", tt.name, listing)
+		}
+	}
+}
+
+func TestCeleritas_Upload_parsedForm(t *testing.T) {
+	c, disk := newUploadApp()
+	r := multipartRequest(t, map[string]string{"csrf_token": "token"},
+		formFile{"avatar", "me.png", pngHeader},
+		formFile{"other", "skipped.png", pngHeader},
+	)
+	// as the csrf middleware does
+	_ = r.ParseMultipartForm(32 << 20)
+
+	files, err := c.Upload(r, UploadOptions{Fields: []string{"avatar"}, KeepName: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Key != "me.png" {
+		t.Fatalf("unexpected files %+v", files)
+	}
+	if exists, _ := disk.Exists(context.Background(), "me.png"); !exists {
+		t.Error("file not stored")
+	}
+}
+
+// markerScanner finds the files containing its marker
+type markerScanner string
+
+func (m markerScanner) Scan(ctx context.Context, content io.Reader) (scanner.Result, error) {
+	data, err := ioutil.ReadAll(content)
+	if err != nil {
+		return scanner.Result{}, err
+	}
+	return scanner.Result{Infected: bytes.Contains(data, []byte(m)), Signature: "Test-Virus"}, nil
+}
+
+func TestCeleritas_Upload_scanned(t *testing.T) {
+	c, disk := newUploadApp()
+	c.RootPath = t.TempDir()
+	quarantine := &memfilesystem.Mem{}
+	c.Scanner = &scanner.Policy{Scanner: markerScanner("VIRUS"), Quarantine: quarantine, ErrorLog: c.ErrorLog}
+
+	r := multipartRequest(t, nil, formFile{"notes", "clean.txt", []byte("clean notes")})
+	files, err := c.Upload(r, UploadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte("clean notes"))
+	if files[0].Size != 11 || files[0].Checksum != hex.EncodeToString(sum[:]) {
+		t.Errorf("unexpected file %+v", files[0])
+	}
+
+	r = multipartRequest(t, nil,
+		formFile{"notes", "more.txt", []byte("more notes")},
+		formFile{"notes", "virus.txt", []byte("notes with a VIRUS")},
+	)
+	if _, err := c.Upload(r, UploadOptions{}); !errors.Is(err, scanner.ErrInfected) {
+		t.Fatalf("expected ErrInfected, got %v", err)
+	}
+
+	listing, _ := filesystems.ListAll(context.Background(), disk, "", true)
+	if len(listing) != 1 {
+		t.Errorf("expected only the clean file stored, got %v", listing)
+	}
+	if listing, _ := filesystems.ListAll(context.Background(), quarantine, "", true); len(listing) != 1 {
+		t.Errorf("expected the infected file quarantined, got %v", listing)
+	}
+	if spooled, _ := ioutil.ReadDir(filepath.Join(c.RootPath, "tmp")); len(spooled) != 0 {
+		t.Errorf("spooled files kept: %v", spooled)
+	}
+
+	// a file too large is refused before it is scanned
+	r = multipartRequest(t, nil, formFile{"notes", "large.txt", bytes.Repeat([]byte("a"), 2<<10)})
+	if _, err := c.Upload(r, UploadOptions{}); !errors.Is(err, ErrUploadTooLarge) {
+		t.Errorf("expected ErrUploadTooLarge, got %v", err)
+	}
+}
+
+func TestSanitizeFilename(t *testing.T) {
+	for filename, expected := range map[string]string{
+		"report.pdf":                      "report.pdf",
+		"../../etc/passwd":                "passwd",
+		`C:\Users\me\photo.jpg`:           "photo.jpg",
+		".env":                            "env",
+		"résumé 2021.pdf":                 "r_sum_ 2021.pdf",
+		"a\x00b<script>.html":             "a_b_script_.html",
+		"/":                               "",
+		strings.Repeat("a", 300) + ".txt": strings.Repeat("a", maxFilename-4) + ".txt",
+	} {
+		if got := sanitizeFilename(filename); got != expected {
+			t.Errorf("%q: expected %q, got %q", filename, expected, got)
+		}
+	}
+}
@@ -8,20 +8,47 @@ import (
 	"time"
 
 	"github.com/asaskevich/govalidator"
+	"github.com/djedjethai/celeritas/i18n"
 )
 
 type Validation struct {
 	Data url.Values
 	Errors map[string]string
+	// Locale is the language of the error messages; it is taken from the
+	// request by ValidatorFor and Required when empty
+	Locale string
+	translator *i18n.Translator
 }
 
 func (c *Celeritas) Validator(data url.Values) *Validation {
 	return &Validation{
 		Errors: make(map[string]string),
 		Data: data,
+		translator: c.I18n,
 	}
 }
 
+// ValidatorFor returns a validator for the form of r, with messages in the locale of r
+func (c *Celeritas) ValidatorFor(r *http.Request) *Validation {
+	v := c.Validator(r.Form)
+	v.Locale = i18n.Locale(r.Context())
+	return v
+}
+
+// message translates the validation message key (e.g. validation.required),
+// falling back to the english message when there is no translation
+func (v *Validation) message(key, fallback string) string {
+	if v.translator == nil {
+		return fallback
+	}
+
+	msg := v.translator.T(v.Locale, key)
+	if msg == key {
+		return fallback
+	}
+	return msg
+}
+
 func (v *Validation) Valid() bool {
 	return len(v.Errors) == 0
 }
@@ -41,10 +68,14 @@ func (v *Validation) Has(field string, r *http.Request) bool {
 }
 
 func (v *Validation) Required(r *http.Request, fields ...string) {
+	if v.Locale == "" {
+		v.Locale = i18n.Locale(r.Context())
+	}
+
 	for _, field := range fields {
 		value := r.Form.Get(field)
 		if strings.TrimSpace(value) == "" {
-			v.AddError(field, "This field cannot be blank")
+			v.AddError(field, v.message("validation.required", "This field cannot be blank"))
 		}
 	}
 }
@@ -57,33 +88,33 @@ func (v *Validation) Check(ok bool, key, message string) {
 
 func (v *Validation) IsEmail(field, value string) {
 	if !govalidator.IsEmail(value) {
-		v.AddError(field, "Invalid email address")
+		v.AddError(field, v.message("validation.email", "Invalid email address"))
 	}
 }
 
 func (v *Validation) IsInt(field, value string) {
 	_, err := strconv.Atoi(value)
 	if err != nil {
-		v.AddError(field, "This field must be an integer")
+		v.AddError(field, v.message("validation.int", "This field must be an integer"))
 	}
 }
 
 func (v *Validation) IsFloat(field, value string) {
 	_, err := strconv.ParseFloat(value, 64)
 	if err != nil {
-		v.AddError(field, "This field must be a floating point number")
+		v.AddError(field, v.message("validation.float", "This field must be a floating point number"))
 	}
 }
 
 func (v *Validation) IsDateISO(field, value string) {
 	_, err := time.Parse("2006-01-02", value)
 	if err != nil {
-		v.AddError(field, "This field must be a date in the form of YYYY-MM-DD")
+		v.AddError(field, v.message("validation.date_iso", "This field must be a date in the form of YYYY-MM-DD"))
 	}
 }
 
 func (v *Validation) NoSpaces(field, value string) {
 	if govalidator.HasWhitespace(value) {
-		v.AddError(field, "Spaces are not permitted")
+		v.AddError(field, v.message("validation.no_spaces", "Spaces are not permitted"))
 	}
 }
\ No newline at end of file
@@ -176,15 +176,14 @@ func (h *Handlers) PostForgot(w http.ResponseWriter, r *http.Request) {
 	data.Link = signedLink
 
 	msg := mailer.Message{
-		To:       u.Email,
+		To:       []string{u.Email},
 		Subject:  "Password reset",
 		Template: "password-reset",
 		Data:     data,
 		From:     "admin@example.com",
 	}
 
-	h.App.Mail.Jobs <- msg
-	res := <-h.App.Mail.Results
+	res := h.App.Mail.Send(msg).Wait()
 	if res.Error != nil {
 		h.App.ErrorStatus(w, http.StatusBadRequest)
 		return
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"myapp/data"
@@ -11,10 +12,6 @@ import (
 	"github.com/CloudyKit/jet/v6"
 	"github.com/djedjethai/celeritas"
 	"github.com/djedjethai/celeritas/filesystems"
-	"github.com/djedjethai/celeritas/filesystems/miniofilesystem"
-	"github.com/djedjethai/celeritas/filesystems/s3filesystem"
-	"github.com/djedjethai/celeritas/filesystems/sftpfilesystem"
-	"github.com/djedjethai/celeritas/filesystems/webdavfilesystem"
 )
 
 // Handlers is the type for handlers, and gives access to Celeritas and models
@@ -42,16 +39,17 @@ func (h *Handlers) CeleritasUpload(w http.ResponseWriter, r *http.Request) {
 // upload a file
 func (h *Handlers) PostCeleritasUpload(w http.ResponseWriter, r *http.Request) {
 
-	// like it it have export to the file-system
-	// err := h.App.UploadFile(r, "", "formFile", "")
-
-	// like this it will upload to minio
-	err := h.App.UploadFile(r, "", "formFile", &h.App.Minio)
+	// every file of the formFile field is streamed to minio
+	files, err := h.App.Upload(r, celeritas.UploadOptions{
+		Fields: []string{"formFile"},
+		Disk:   "MINIO",
+		Folder: "uploads",
+	})
 	if err != nil {
-		h.App.ErrorLog.Println("error rendering:", err)
+		h.App.ErrorLog.Println("error uploading:", err)
 		h.App.Session.Put(r.Context(), "error", err.Error())
 	} else {
-		h.App.Session.Put(r.Context(), "flash", "uploaded!")
+		h.App.Session.Put(r.Context(), "flash", fmt.Sprintf("%d file(s) uploaded!", len(files)))
 	}
 
 	http.Redirect(w, r, "/upload", http.StatusSeeOther)
@@ -68,7 +66,6 @@ func inSlice(slice []string, val string) bool {
 }
 
 func (h *Handlers) ListFS(w http.ResponseWriter, r *http.Request) {
-	var fs filesystems.FS
 	var list []filesystems.Listing
 
 	fsType := ""
@@ -84,29 +81,20 @@ func (h *Handlers) ListFS(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if fsType != "" {
-		switch fsType {
-		case "MINIO":
-			f := h.App.FileSystems["MINIO"].(miniofilesystem.Minio)
-			fs = &f
-			fsType = "MINIO"
-		case "SFTP":
-			f := h.App.FileSystems["SFTP"].(sftpfilesystem.SFTP)
-			fs = &f
-			fsType = "SFTP"
-		case "WEBDAV":
-			f := h.App.FileSystems["WEBDAV"].(webdavfilesystem.WebDAV)
-			fs = &f
-			fsType = "WEBDAV"
-		case "S3":
-			f := h.App.FileSystems["S3"].(s3filesystem.S3)
-			fs = &f
-			fsType = "S3"
-
+		fs, err := h.App.Disk(fsType)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
 
 		l, err := fs.List(curPath)
+		if errors.Is(err, filesystems.ErrNotFound) {
+			http.NotFound(w, r)
+			return
+		}
 		if err != nil {
 			h.App.ErrorLog.Println(err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 			return
 		}
 
@@ -145,35 +133,16 @@ func (h *Handlers) PostUploadToFS(w http.ResponseWriter, r *http.Request) {
 
 	uploadType := r.Form.Get("upload-type")
 
-	switch uploadType {
-	case "MINIO":
-		fs := h.App.FileSystems["MINIO"].(miniofilesystem.Minio)
-		err = fs.Put(fileName, "")
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-	case "SFTP":
-		fs := h.App.FileSystems["SFTP"].(sftpfilesystem.SFTP)
-		err = fs.Put(fileName, "")
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-	case "WEBDAV":
-		fs := h.App.FileSystems["WEBDAV"].(webdavfilesystem.WebDAV)
-		err = fs.Put(fileName, "")
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-	case "S3":
-		fs := h.App.FileSystems["S3"].(s3filesystem.S3)
-		err = fs.Put(fileName, "")
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+	fs, err := h.App.Disk(uploadType)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = fs.Put(fileName, "")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
 	h.App.Session.Put(r.Context(), "flash", "File uploaded!")
@@ -206,30 +175,43 @@ func getFileToUpload(r *http.Request, fieldName string) (string, error) {
 
 }
 
+// DownloadFromFS sends the files and folders selected in the listing as one zip
+func (h *Handlers) DownloadFromFS(w http.ResponseWriter, r *http.Request) {
+	keys := r.URL.Query()["key"]
+	if len(keys) == 0 {
+		http.Error(w, "no file selected", http.StatusBadRequest)
+		return
+	}
+
+	err := h.App.ServeArchive(w, r, r.URL.Query().Get("fs_type"), keys, celeritas.ArchiveOptions{
+		Filename: "files",
+		MaxSize:  1 << 30,
+	})
+	if err != nil && !errors.Is(err, filesystems.ErrNotFound) && !errors.Is(err, filesystems.ErrArchiveTooLarge) {
+		h.App.ErrorLog.Println(err)
+	}
+}
+
 func (h *Handlers) DeleteFromFS(w http.ResponseWriter, r *http.Request) {
-	var fs filesystems.FS
 	fsType := r.URL.Query().Get("fs_type")
 	file := r.URL.Query().Get("file")
 
-	switch fsType {
-	case "MINIO":
-		f := h.App.FileSystems["MINIO"].(miniofilesystem.Minio)
-		fs = &f
-	case "SFTP":
-		f := h.App.FileSystems["SFTP"].(sftpfilesystem.SFTP)
-		fs = &f
-	case "WEBDAV":
-		f := h.App.FileSystems["WEBDAV"].(webdavfilesystem.WebDAV)
-		fs = &f
-	case "S3":
-		f := h.App.FileSystems["S3"].(s3filesystem.S3)
-		fs = &f
-
+	fs, err := h.App.Disk(fsType)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	deleted := fs.Delete([]string{file})
-	if deleted {
-		h.App.Session.Put(r.Context(), "flash", fmt.Sprintf("%s was deleted", file))
-		http.Redirect(w, r, "/list-fs?fs-type="+fsType, http.StatusSeeOther)
+	err = fs.Delete([]string{file})
+	if errors.Is(err, filesystems.ErrNotFound) {
+		http.NotFound(w, r)
+		return
 	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.App.Session.Put(r.Context(), "flash", fmt.Sprintf("%s was deleted", file))
+	http.Redirect(w, r, "/list-fs?fs-type="+fsType, http.StatusSeeOther)
 }
@@ -33,6 +33,7 @@ func TestMain(m *testing.M) {
 		jet.NewOSFileSystemLoader("../views"),
 		jet.InDevelopmentMode(),
 	)
+	views.AddGlobal("asset", func(name string) string { return "/public/" + name })
 
 	myRenderer := render.Render{
 		Renderer: "jet",
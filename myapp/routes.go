@@ -28,15 +28,24 @@ func (a *application) routes() *chi.Mux {
 	a.get("/files/upload", a.Handlers.UploadToFS)
 	a.post("/files/upload", a.Handlers.PostUploadToFS)
 
+	a.get("/files/archive", a.Handlers.DownloadFromFS)
 	a.get("/delete-from-fs", a.Handlers.DeleteFromFS)
 
-	// static routes
-	fileServer := http.FileServer(http.Dir("./public"))
-	a.App.Routes.Handle("/public/*", http.StripPrefix("/public", fileServer))
+	// static routes, fingerprinted urls come from the asset() template helper
+	a.App.Routes.Handle("/public/*", http.StripPrefix("/public", a.App.Assets))
+
+	// temporary urls of the disks which can not presign them
+	a.App.Routes.Mount(celeritas.SignedFilesPath, a.App.SignedFiles())
+
+	// images derived from config/images.yml presets, linked with the imageURL of the views
+	a.App.Routes.Handle(celeritas.ImagesPath+"/*", a.App.DerivedImages())
 
 	// routes from celeritas
 	a.App.Routes.Mount("/celeritas", celeritas.Routes())
 	a.App.Routes.Mount("/api", a.ApiRoutes())
 
+	// captured mail and mail templates, in debug mode only
+	a.App.Routes.Mount("/debug/mail", a.App.MailPreview())
+
 	return a.App.Routes
 }